@@ -0,0 +1,64 @@
+package backplane
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/yaninyzwitty/messaging-service/events"
+)
+
+// Backplane carries events between the instances of the service. Every subscriber, on every
+// instance, receives each published event once, so clients see the same events wherever they connect.
+type Backplane interface {
+	Publish(ctx context.Context, event events.Event) error
+	// Subscribe registers a handler for events published by any instance until ctx is done.
+	Subscribe(ctx context.Context, handler events.Handler)
+	Close() error
+}
+
+// MemoryBackplane only reaches the subscribers of this process, for running a single instance.
+type MemoryBackplane struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]events.Handler
+}
+
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{handlers: make(map[int]events.Handler)}
+}
+
+// Publish runs every handler before returning, their errors are joined.
+func (b *MemoryBackplane) Publish(ctx context.Context, event events.Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var errs []error
+	for _, handler := range b.handlers {
+		if err := handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *MemoryBackplane) Subscribe(ctx context.Context, handler events.Handler) {
+	b.mu.Lock()
+	id := b.next
+	b.next++
+	b.handlers[id] = handler
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.handlers, id)
+		b.mu.Unlock()
+	}()
+}
+
+func (b *MemoryBackplane) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.handlers)
+	return nil
+}
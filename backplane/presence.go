@@ -0,0 +1,59 @@
+package backplane
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// presenceKeyPrefix namespaces the hashes holding the presence of a user, one field per instance
+// the user is connected to, valued "<expiry in unix milliseconds>:<status>".
+const presenceKeyPrefix = "presence:"
+
+// SharePresence records the status of users connected to this instance. It holds for ttl unless
+// shared again, so the users of an instance that dies go offline on their own.
+func (b *RedisBackplane) SharePresence(ctx context.Context, statuses map[gocql.UUID]string, ttl time.Duration) error {
+	if len(statuses) == 0 {
+		return nil
+	}
+	expiresAt := strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10)
+	pipe := b.client.Pipeline()
+	for userId, status := range statuses {
+		key := presenceKeyPrefix + userId.String()
+		pipe.HSet(ctx, key, b.origin, expiresAt+":"+status)
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ClearPresence records that the user has no connection left on this instance.
+func (b *RedisBackplane) ClearPresence(ctx context.Context, userId gocql.UUID) error {
+	return b.client.HDel(ctx, presenceKeyPrefix+userId.String(), b.origin).Err()
+}
+
+// PresenceStatuses lists the status of the user on every instance they are connected to.
+func (b *RedisBackplane) PresenceStatuses(ctx context.Context, userId gocql.UUID) ([]string, error) {
+	fields, err := b.client.HGetAll(ctx, presenceKeyPrefix+userId.String()).Result()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	var statuses []string
+	for _, value := range fields {
+		expiry, status, ok := strings.Cut(value, ":")
+		if !ok {
+			continue
+		}
+		// instances that stopped sharing leave their field behind until the whole key expires
+		expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil || expiresAt < now {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,122 @@
+package backplane
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yaninyzwitty/messaging-service/events"
+)
+
+// redisMessage is what goes over the channel, origin lets an instance skip its own events.
+type redisMessage struct {
+	Origin string          `json:"origin"`
+	Event  json.RawMessage `json:"event"`
+}
+
+// RedisBackplane fans events out over a Redis pub/sub channel. Events are handed to the local
+// subscribers directly and to the other instances through Redis, so a Redis outage only cuts
+// off the other instances. Pub/sub is fire and forget: instances that are disconnected from
+// Redis while an event is published never see it.
+type RedisBackplane struct {
+	client  redis.UniversalClient
+	pubsub  *redis.PubSub
+	channel string
+	origin  string
+	local   *MemoryBackplane
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// NewRedisBackplane subscribes to the channel and starts relaying the events of the other
+// instances. The backplane owns the client, Close closes it.
+func NewRedisBackplane(ctx context.Context, client redis.UniversalClient, channel string) (*RedisBackplane, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	pubsub := client.Subscribe(ctx, channel)
+	// wait for the confirmation so nothing published after we return is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("subscribing to redis channel %s: %w", channel, err)
+	}
+
+	listenCTX, cancel := context.WithCancel(context.Background())
+	b := &RedisBackplane{
+		client:  client,
+		pubsub:  pubsub,
+		channel: channel,
+		origin:  hex.EncodeToString(id[:]),
+		local:   NewMemoryBackplane(),
+		cancel:  cancel,
+	}
+	b.done.Add(1)
+	go b.listen(listenCTX)
+	return b, nil
+}
+
+// Publish delivers the event to the local subscribers and then to the other instances.
+func (b *RedisBackplane) Publish(ctx context.Context, event events.Event) error {
+	localErr := b.local.Publish(ctx, event)
+
+	encoded, err := events.Encode(event)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(redisMessage{Origin: b.origin, Event: encoded})
+	if err != nil {
+		return err
+	}
+	if err := b.client.Publish(ctx, b.channel, payload).Err(); err != nil {
+		return fmt.Errorf("publishing %s event to redis: %w", event.Type(), err)
+	}
+	return localErr
+}
+
+func (b *RedisBackplane) Subscribe(ctx context.Context, handler events.Handler) {
+	b.local.Subscribe(ctx, handler)
+}
+
+// Close stops relaying events and closes the client.
+func (b *RedisBackplane) Close() error {
+	b.cancel()
+	err := b.pubsub.Close()
+	b.done.Wait()
+	b.local.Close()
+	if closeErr := b.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// listen hands the events of the other instances to the local subscribers. The client
+// reconnects and resubscribes on its own when the connection to Redis drops.
+func (b *RedisBackplane) listen(ctx context.Context) {
+	defer b.done.Done()
+	for msg := range b.pubsub.Channel() {
+		var message redisMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+			slog.Error("Failed to decode backplane message", "channel", msg.Channel, "error", err)
+			continue
+		}
+		if message.Origin == b.origin {
+			continue
+		}
+		event, err := events.Decode(message.Event)
+		if err != nil {
+			slog.Error("Failed to decode backplane event", "channel", msg.Channel, "error", err)
+			continue
+		}
+		if err := b.local.Publish(ctx, event); err != nil {
+			slog.Error("Backplane subscriber failed", "type", event.Type(), "error", err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package backplane
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gocql/gocql"
+	"github.com/redis/go-redis/v9"
+	"github.com/yaninyzwitty/messaging-service/events"
+)
+
+// newTestBackplane connects a backplane to the shared miniredis, the way each instance connects to Redis.
+func newTestBackplane(t *testing.T, server *miniredis.Miniredis) *RedisBackplane {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	b, err := NewRedisBackplane(context.Background(), client, "messaging-events")
+	if err != nil {
+		t.Fatalf("NewRedisBackplane: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// recorder collects the events handed to a subscriber.
+type recorder struct {
+	mu       sync.Mutex
+	received []events.Event
+}
+
+func (r *recorder) handle(ctx context.Context, event events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, event)
+	return nil
+}
+
+func (r *recorder) events() []events.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]events.Event(nil), r.received...)
+}
+
+// waitFor polls until the recorder holds n events, pub/sub delivery is asynchronous.
+func (r *recorder) waitFor(t *testing.T, n int) []events.Event {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if received := r.events(); len(received) >= n {
+			return received
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("received %d events, want %d", len(r.events()), n)
+	return nil
+}
+
+func TestRedisBackplaneFansOutAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := newTestBackplane(t, server)
+	second := newTestBackplane(t, server)
+
+	var onFirst, onSecond recorder
+	first.Subscribe(context.Background(), onFirst.handle)
+	second.Subscribe(context.Background(), onSecond.handle)
+
+	event := events.TypingStarted{
+		UserID:         gocql.TimeUUID(),
+		ConversationID: gocql.TimeUUID(),
+		ExpiresAt:      time.Now().Add(5 * time.Second).UTC().Truncate(time.Millisecond),
+		At:             time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := first.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	received := onSecond.waitFor(t, 1)
+	got, ok := received[0].(events.TypingStarted)
+	if !ok {
+		t.Fatalf("other instance received %T, want events.TypingStarted", received[0])
+	}
+	if got.UserID != event.UserID || got.ConversationID != event.ConversationID || !got.ExpiresAt.Equal(event.ExpiresAt) {
+		t.Errorf("other instance received %+v, want %+v", got, event)
+	}
+
+	// the publishing instance delivers locally and skips its own message coming back from redis
+	time.Sleep(100 * time.Millisecond)
+	if n := len(onFirst.events()); n != 1 {
+		t.Errorf("publishing instance received the event %d times, want once", n)
+	}
+	if n := len(onSecond.events()); n != 1 {
+		t.Errorf("other instance received the event %d times, want once", n)
+	}
+}
+
+func TestRedisBackplaneSharesPresence(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	first := newTestBackplane(t, server)
+	second := newTestBackplane(t, server)
+	userId := gocql.TimeUUID()
+
+	if err := first.SharePresence(ctx, map[gocql.UUID]string{userId: "online"}, time.Minute); err != nil {
+		t.Fatalf("SharePresence: %v", err)
+	}
+	if err := second.SharePresence(ctx, map[gocql.UUID]string{userId: "away"}, time.Minute); err != nil {
+		t.Fatalf("SharePresence: %v", err)
+	}
+
+	statuses, err := first.PresenceStatuses(ctx, userId)
+	if err != nil {
+		t.Fatalf("PresenceStatuses: %v", err)
+	}
+	sort.Strings(statuses)
+	if len(statuses) != 2 || statuses[0] != "away" || statuses[1] != "online" {
+		t.Errorf("statuses = %v, want one per instance", statuses)
+	}
+
+	if err := second.ClearPresence(ctx, userId); err != nil {
+		t.Fatalf("ClearPresence: %v", err)
+	}
+	statuses, err = first.PresenceStatuses(ctx, userId)
+	if err != nil {
+		t.Fatalf("PresenceStatuses: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0] != "online" {
+		t.Errorf("statuses = %v, want only the instance still sharing", statuses)
+	}
+
+	// an instance that stops sharing takes its users offline once the ttl runs out
+	server.FastForward(2 * time.Minute)
+	statuses, err = first.PresenceStatuses(ctx, userId)
+	if err != nil {
+		t.Fatalf("PresenceStatuses: %v", err)
+	}
+	if len(statuses) != 0 {
+		t.Errorf("statuses = %v after the ttl, want none", statuses)
+	}
+}
@@ -2,6 +2,8 @@ package configuration
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -9,6 +11,116 @@ import (
 type Config struct {
 	PORT  string
 	HOSTS string
+
+	// attachment storage, BLOB_STORE is either "local" or "s3"
+	BLOB_STORE       string
+	BLOB_DIR         string
+	S3_ENDPOINT      string
+	S3_BUCKET        string
+	S3_REGION        string
+	S3_ACCESS_KEY    string
+	S3_SECRET_KEY    string
+	MAX_UPLOAD_BYTES int64
+
+	// background processing of attachments (content sniffing, thumbnails), attachments left pending
+	// are queued again every MEDIA_SWEEP_INTERVAL
+	MEDIA_WORKERS        int
+	MEDIA_QUEUE_SIZE     int
+	MEDIA_SWEEP_INTERVAL time.Duration
+
+	// resumable (tus) uploads
+	TUS_MAX_SIZE  int64
+	UPLOAD_EXPIRY time.Duration
+
+	// attachment scanning, SCANNERS is a comma separated list of "rules" and "clamav"
+	SCANNERS                string
+	SCAN_MAX_SIZE           int64
+	SCAN_BLOCKED_EXTENSIONS string
+	CLAMD_NETWORK           string
+	CLAMD_ADDRESS           string
+	CLAMD_TIMEOUT           time.Duration
+
+	// frames buffered per websocket connection before it is dropped as a slow consumer
+	WS_SEND_BUFFER int
+
+	// a connection is offline when it has been silent for PRESENCE_TTL, typing shows for TYPING_TTL unless repeated
+	PRESENCE_TTL time.Duration
+	TYPING_TTL   time.Duration
+
+	// events kept per device until acknowledged, for how long a device may stay away and how many devices a user keeps
+	DELIVERY_QUEUE_SIZE    int
+	DELIVERY_QUEUE_MAX_AGE time.Duration
+	DELIVERY_MAX_DEVICES   int
+
+	// long polling sync, the longest a request may block
+	SYNC_MAX_TIMEOUT time.Duration
+
+	// how realtime events reach the other instances, BACKPLANE is "memory" for a single instance or "redis"
+	BACKPLANE         string
+	REDIS_ADDR        string
+	REDIS_PASSWORD    string
+	REDIS_DB          int
+	BACKPLANE_CHANNEL string
+	// events waiting to be published to the backplane, more are dropped
+	BACKPLANE_QUEUE_SIZE int
+
+	// how often the per user change logs are compacted
+	CHANGE_LOG_COMPACT_INTERVAL time.Duration
+
+	// how often the reaction counters changed lately are checked against the reaction rows
+	REACTION_COUNT_REPAIR_INTERVAL time.Duration
+
+	// push notifications to offline participants, messages within PUSH_COLLAPSE_WINDOW are sent as one;
+	// FCM is enabled by FCM_CREDENTIALS_FILE (a service account key), APNs by APNS_KEY_FILE (a .p8 key)
+	PUSH_WORKERS         int
+	PUSH_QUEUE_SIZE      int
+	PUSH_TIMEOUT         time.Duration
+	PUSH_COLLAPSE_WINDOW time.Duration
+	FCM_CREDENTIALS_FILE string
+	FCM_ENDPOINT         string
+	APNS_KEY_FILE        string
+	APNS_KEY_ID          string
+	APNS_TEAM_ID         string
+	APNS_TOPIC           string
+	APNS_ENDPOINT        string
+
+	// email digests of unread messages, sent only when SMTP_HOST is set; due digests are looked for every DIGEST_INTERVAL
+	SMTP_HOST       string
+	SMTP_PORT       int
+	SMTP_USERNAME   string
+	SMTP_PASSWORD   string
+	SMTP_FROM       string
+	SMTP_TIMEOUT    time.Duration
+	DIGEST_INTERVAL time.Duration
+
+	// transactional outbox, OUTBOX_PUBLISHER is where the dispatcher delivers events ("log" or "kafka"),
+	// OUTBOX_LEASE_TTL is how long an instance keeps delivering a shard without renewing its lease
+	OUTBOX_PUBLISHER     string
+	OUTBOX_POLL_INTERVAL time.Duration
+	OUTBOX_BATCH_SIZE    int
+	OUTBOX_MAX_BACKOFF   time.Duration
+	OUTBOX_MAX_ATTEMPTS  int
+	OUTBOX_LEASE_TTL     time.Duration
+
+	// kafka publisher, KAFKA_BROKERS is comma separated and KAFKA_TOPICS maps event types
+	// to topics as "message.deleted=message-deletions,...", other types go to KAFKA_TOPIC
+	KAFKA_BROKERS       string
+	KAFKA_TOPIC         string
+	KAFKA_TOPICS        string
+	KAFKA_WRITE_TIMEOUT time.Duration
+
+	// outbound webhooks, a webhook is disabled after WEBHOOK_DISABLE_AFTER failed attempts in a row
+	WEBHOOK_WORKERS        int
+	WEBHOOK_QUEUE_SIZE     int
+	WEBHOOK_TIMEOUT        time.Duration
+	WEBHOOK_MAX_ATTEMPTS   int
+	WEBHOOK_DISABLE_AFTER  int
+	WEBHOOK_RETRY_INTERVAL time.Duration
+
+	// incoming webhooks, messages a minute per webhook and the burst allowed on top,
+	// counted in redis across the instances when BACKPLANE is "redis" and per instance otherwise
+	INCOMING_WEBHOOK_RATE  int
+	INCOMING_WEBHOOK_BURST int
 }
 
 func LoadConfig() (*Config, error) {
@@ -21,6 +133,93 @@ func LoadConfig() (*Config, error) {
 	return &Config{
 		PORT:  getEnv("PORT", "8080"),
 		HOSTS: getEnv("HOSTS", "localhost"),
+
+		BLOB_STORE:       getEnv("BLOB_STORE", "local"),
+		BLOB_DIR:         getEnv("BLOB_DIR", "./data/blobs"),
+		S3_ENDPOINT:      getEnv("S3_ENDPOINT", "http://localhost:9000"),
+		S3_BUCKET:        getEnv("S3_BUCKET", "attachments"),
+		S3_REGION:        getEnv("S3_REGION", "us-east-1"),
+		S3_ACCESS_KEY:    getEnv("S3_ACCESS_KEY", ""),
+		S3_SECRET_KEY:    getEnv("S3_SECRET_KEY", ""),
+		MAX_UPLOAD_BYTES: getEnvInt64("MAX_UPLOAD_BYTES", 25<<20),
+
+		MEDIA_WORKERS:        getEnvInt("MEDIA_WORKERS", 2),
+		MEDIA_QUEUE_SIZE:     getEnvInt("MEDIA_QUEUE_SIZE", 256),
+		MEDIA_SWEEP_INTERVAL: getEnvDuration("MEDIA_SWEEP_INTERVAL", 5*time.Minute),
+
+		TUS_MAX_SIZE:  getEnvInt64("TUS_MAX_SIZE", 1<<30),
+		UPLOAD_EXPIRY: getEnvDuration("UPLOAD_EXPIRY", 24*time.Hour),
+
+		SCANNERS:                getEnv("SCANNERS", "rules"),
+		SCAN_MAX_SIZE:           getEnvInt64("SCAN_MAX_SIZE", 1<<30),
+		SCAN_BLOCKED_EXTENSIONS: getEnv("SCAN_BLOCKED_EXTENSIONS", ".exe,.dll,.bat,.cmd,.com,.msi,.scr,.pif,.vbs,.js,.jar,.ps1,.sh,.apk"),
+		CLAMD_NETWORK:           getEnv("CLAMD_NETWORK", "tcp"),
+		CLAMD_ADDRESS:           getEnv("CLAMD_ADDRESS", "localhost:3310"),
+		CLAMD_TIMEOUT:           getEnvDuration("CLAMD_TIMEOUT", 2*time.Minute),
+
+		WS_SEND_BUFFER: getEnvInt("WS_SEND_BUFFER", 256),
+
+		PRESENCE_TTL: getEnvDuration("PRESENCE_TTL", 90*time.Second),
+		TYPING_TTL:   getEnvDuration("TYPING_TTL", 6*time.Second),
+
+		DELIVERY_QUEUE_SIZE:    getEnvInt("DELIVERY_QUEUE_SIZE", 1000),
+		DELIVERY_QUEUE_MAX_AGE: getEnvDuration("DELIVERY_QUEUE_MAX_AGE", 24*time.Hour),
+		DELIVERY_MAX_DEVICES:   getEnvInt("DELIVERY_MAX_DEVICES", 10),
+
+		SYNC_MAX_TIMEOUT: getEnvDuration("SYNC_MAX_TIMEOUT", 60*time.Second),
+
+		BACKPLANE:            getEnv("BACKPLANE", "memory"),
+		REDIS_ADDR:           getEnv("REDIS_ADDR", "localhost:6379"),
+		REDIS_PASSWORD:       getEnv("REDIS_PASSWORD", ""),
+		REDIS_DB:             getEnvInt("REDIS_DB", 0),
+		BACKPLANE_CHANNEL:    getEnv("BACKPLANE_CHANNEL", "messaging-events"),
+		BACKPLANE_QUEUE_SIZE: getEnvInt("BACKPLANE_QUEUE_SIZE", 1000),
+
+		CHANGE_LOG_COMPACT_INTERVAL: getEnvDuration("CHANGE_LOG_COMPACT_INTERVAL", 10*time.Minute),
+
+		REACTION_COUNT_REPAIR_INTERVAL: getEnvDuration("REACTION_COUNT_REPAIR_INTERVAL", 5*time.Minute),
+
+		PUSH_WORKERS:         getEnvInt("PUSH_WORKERS", 4),
+		PUSH_QUEUE_SIZE:      getEnvInt("PUSH_QUEUE_SIZE", 1000),
+		PUSH_TIMEOUT:         getEnvDuration("PUSH_TIMEOUT", 10*time.Second),
+		PUSH_COLLAPSE_WINDOW: getEnvDuration("PUSH_COLLAPSE_WINDOW", 5*time.Second),
+		FCM_CREDENTIALS_FILE: getEnv("FCM_CREDENTIALS_FILE", ""),
+		FCM_ENDPOINT:         getEnv("FCM_ENDPOINT", "https://fcm.googleapis.com"),
+		APNS_KEY_FILE:        getEnv("APNS_KEY_FILE", ""),
+		APNS_KEY_ID:          getEnv("APNS_KEY_ID", ""),
+		APNS_TEAM_ID:         getEnv("APNS_TEAM_ID", ""),
+		APNS_TOPIC:           getEnv("APNS_TOPIC", ""),
+		APNS_ENDPOINT:        getEnv("APNS_ENDPOINT", "https://api.push.apple.com"),
+
+		SMTP_HOST:       getEnv("SMTP_HOST", ""),
+		SMTP_PORT:       getEnvInt("SMTP_PORT", 587),
+		SMTP_USERNAME:   getEnv("SMTP_USERNAME", ""),
+		SMTP_PASSWORD:   getEnv("SMTP_PASSWORD", ""),
+		SMTP_FROM:       getEnv("SMTP_FROM", "no-reply@localhost"),
+		SMTP_TIMEOUT:    getEnvDuration("SMTP_TIMEOUT", 30*time.Second),
+		DIGEST_INTERVAL: getEnvDuration("DIGEST_INTERVAL", 5*time.Minute),
+
+		OUTBOX_PUBLISHER:     getEnv("OUTBOX_PUBLISHER", "log"),
+		OUTBOX_POLL_INTERVAL: getEnvDuration("OUTBOX_POLL_INTERVAL", 5*time.Second),
+		OUTBOX_BATCH_SIZE:    getEnvInt("OUTBOX_BATCH_SIZE", 100),
+		OUTBOX_MAX_BACKOFF:   getEnvDuration("OUTBOX_MAX_BACKOFF", 5*time.Minute),
+		OUTBOX_MAX_ATTEMPTS:  getEnvInt("OUTBOX_MAX_ATTEMPTS", 20),
+		OUTBOX_LEASE_TTL:     getEnvDuration("OUTBOX_LEASE_TTL", 30*time.Second),
+
+		KAFKA_BROKERS:       getEnv("KAFKA_BROKERS", "localhost:9092"),
+		KAFKA_TOPIC:         getEnv("KAFKA_TOPIC", "message-events"),
+		KAFKA_TOPICS:        getEnv("KAFKA_TOPICS", ""),
+		KAFKA_WRITE_TIMEOUT: getEnvDuration("KAFKA_WRITE_TIMEOUT", 10*time.Second),
+
+		WEBHOOK_WORKERS:        getEnvInt("WEBHOOK_WORKERS", 4),
+		WEBHOOK_QUEUE_SIZE:     getEnvInt("WEBHOOK_QUEUE_SIZE", 1024),
+		WEBHOOK_TIMEOUT:        getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WEBHOOK_MAX_ATTEMPTS:   getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WEBHOOK_DISABLE_AFTER:  getEnvInt("WEBHOOK_DISABLE_AFTER", 50),
+		WEBHOOK_RETRY_INTERVAL: getEnvDuration("WEBHOOK_RETRY_INTERVAL", 30*time.Second),
+
+		INCOMING_WEBHOOK_RATE:  getEnvInt("INCOMING_WEBHOOK_RATE", 60),
+		INCOMING_WEBHOOK_BURST: getEnvInt("INCOMING_WEBHOOK_BURST", 10),
 	}, nil
 }
 
@@ -31,3 +230,31 @@ func getEnv(key, fallback string) string {
 	}
 	return value
 }
+
+func getEnvInt64(key string, fallback int64) int64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvInt(key string, fallback int) int {
+	return int(getEnvInt64(key, int64(fallback)))
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/service"
+	"github.com/yaninyzwitty/messaging-service/storage"
+)
+
+// multipartMemory is how much of a multipart form is kept in memory before spilling files to disk.
+const multipartMemory = 8 << 20
+
+type AttachmentController struct {
+	service        service.AttachmentsService
+	maxUploadBytes int64
+}
+
+func NewAttachmentController(service service.AttachmentsService, maxUploadBytes int64) *AttachmentController {
+	return &AttachmentController{service: service, maxUploadBytes: maxUploadBytes}
+}
+
+// UploadAttachments accepts one or more files in the "file" field of a multipart form.
+// The attachments are not linked to a message until their ids are passed when creating one.
+func (c *AttachmentController) UploadAttachments(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to upload attachments", http.StatusUnauthorized)
+		return
+	}
+
+	c.upload(w, r, func(upload service.Upload) (models.Attachment, error) {
+		return c.service.UploadAttachment(ctx, userId, upload)
+	})
+}
+
+// UploadMessageAttachments accepts files like UploadAttachments and links them to an existing message.
+func (c *AttachmentController) UploadMessageAttachments(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to upload attachments", http.StatusUnauthorized)
+		return
+	}
+	messageId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	c.upload(w, r, func(upload service.Upload) (models.Attachment, error) {
+		return c.service.UploadMessageAttachment(ctx, messageId, userId, upload)
+	})
+}
+
+func (c *AttachmentController) upload(w http.ResponseWriter, r *http.Request, store func(upload service.Upload) (models.Attachment, error)) {
+	r.Body = http.MaxBytesReader(w, r.Body, c.maxUploadBytes)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Upload exceeds the limit of "+strconv.FormatInt(c.maxUploadBytes, 10)+" bytes", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		http.Error(w, "At least one file is required in the file field", http.StatusBadRequest)
+		return
+	}
+
+	attachments := make([]models.Attachment, 0, len(files))
+	for _, header := range files {
+		attachment, err := c.storeFile(header, store)
+		switch {
+		case errors.Is(err, gocql.ErrNotFound):
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrNotMessageSender):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, "Failed to store the attachment: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	err := helpers.NewResponseToJson(w, http.StatusCreated, attachments)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *AttachmentController) storeFile(header *multipart.FileHeader, store func(upload service.Upload) (models.Attachment, error)) (models.Attachment, error) {
+	file, err := header.Open()
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	defer file.Close()
+
+	return store(service.Upload{
+		Filename:    header.Filename,
+		ContentType: header.Header.Get("Content-Type"),
+		Size:        header.Size,
+		Content:     file,
+	})
+}
+
+func (c *AttachmentController) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	viewerId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to access attachments", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := c.service.GetAttachment(ctx, viewerId, id)
+	if errors.Is(err, gocql.ErrNotFound) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrAttachmentForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get the attachment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, attachment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// DownloadAttachment streams the attachment content back with its original name and media type.
+func (c *AttachmentController) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	viewerId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to access attachments", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	attachment, content, err := c.service.OpenAttachment(ctx, viewerId, id)
+	if errors.Is(err, gocql.ErrNotFound) || errors.Is(err, storage.ErrBlobNotFound) || errors.Is(err, service.ErrAttachmentQuarantined) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrAttachmentForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, service.ErrAttachmentPending) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to open the attachment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, content)
+}
+
+// DownloadThumbnail streams the JPEG thumbnail generated for image attachments.
+func (c *AttachmentController) DownloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	viewerId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to access attachments", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	thumbnail, err := c.service.OpenThumbnail(ctx, viewerId, id)
+	if errors.Is(err, gocql.ErrNotFound) || errors.Is(err, service.ErrNoThumbnail) || errors.Is(err, storage.ErrBlobNotFound) {
+		http.Error(w, "Thumbnail not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrAttachmentForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to open the thumbnail: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer thumbnail.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, thumbnail)
+}
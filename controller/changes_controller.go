@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+const (
+	// defaultChangesPageSize and maxChangesPageSize bound the changes returned per request.
+	defaultChangesPageSize = 100
+	maxChangesPageSize     = 1000
+)
+
+type ChangesController struct {
+	changesService service.ChangesService
+}
+
+func NewChangesController(changesService service.ChangesService) *ChangesController {
+	return &ChangesController{changesService: changesService}
+}
+
+// GetChanges returns what changed in the caller's conversations after ?since=, oldest first.
+// Clients keep calling with the returned cursor while has_more is set.
+func (c *ChangesController) GetChanges(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to sync changes", http.StatusUnauthorized)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultChangesPageSize
+	}
+	if limit > maxChangesPageSize {
+		limit = maxChangesPageSize
+	}
+
+	changeSet, err := c.changesService.GetChanges(ctx, userId, r.URL.Query().Get("since"), limit)
+	if errors.Is(err, service.ErrInvalidChangeCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get changes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	err = helpers.NewResponseToJson(w, http.StatusOK, changeSet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+type ConversationController struct {
+	service service.ConversationsService
+}
+
+func NewConversationController(service service.ConversationsService) *ConversationController {
+	return &ConversationController{service: service}
+}
+
+func (c *ConversationController) AddParticipant(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	invitedBy, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to add participants", http.StatusUnauthorized)
+		return
+	}
+	conversationId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the conversation id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+	userId, err := gocql.ParseUUID(r.PathValue("user_id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the user id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	participant, err := c.service.AddParticipant(ctx, conversationId, userId, invitedBy)
+	if errors.Is(err, service.ErrNotConversationMember) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to add the participant: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, participant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *ConversationController) GetParticipants(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	conversationId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the conversation id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	participants, err := c.service.GetParticipants(ctx, conversationId)
+	if err != nil {
+		http.Error(w, "Failed to get the participants: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, participants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
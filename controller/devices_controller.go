@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+type DeviceController struct {
+	service service.DevicesService
+}
+
+func NewDeviceController(service service.DevicesService) *DeviceController {
+	return &DeviceController{service: service}
+}
+
+// deviceRequest is the body registering a push token.
+type deviceRequest struct {
+	Token    string `json:"token"`
+	Platform string `json:"platform"`
+}
+
+// RegisterDevice stores a push token of the caller's device, so it is notified while offline.
+func (c *DeviceController) RegisterDevice(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to register a device", http.StatusUnauthorized)
+		return
+	}
+
+	var request deviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	device, err := c.service.RegisterDevice(ctx, userId, models.DeviceToken{Token: request.Token, Platform: request.Platform})
+	if errors.Is(err, service.ErrInvalidDeviceToken) || errors.Is(err, service.ErrInvalidPlatform) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to register the device: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusCreated, device)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *DeviceController) GetDevices(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to list devices", http.StatusUnauthorized)
+		return
+	}
+
+	devices, err := c.service.GetDevices(ctx, userId)
+	if err != nil {
+		http.Error(w, "Failed to get the devices: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, devices)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// UnregisterDevice stops notifications to a device, typically on sign out.
+func (c *DeviceController) UnregisterDevice(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to unregister a device", http.StatusUnauthorized)
+		return
+	}
+
+	if err := c.service.UnregisterDevice(ctx, userId, r.PathValue("token")); err != nil {
+		http.Error(w, "Failed to unregister the device: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err := helpers.NewResponseToJson(w, http.StatusOK, "Device unregistered successfully")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+type EmailDigestController struct {
+	service service.EmailDigestService
+}
+
+func NewEmailDigestController(service service.EmailDigestService) *EmailDigestController {
+	return &EmailDigestController{service: service}
+}
+
+// emailDigestConfirmRequest is the body confirming the address of email digests.
+type emailDigestConfirmRequest struct {
+	Token string `json:"token"`
+}
+
+// emailDigestRequest is the body opting in or out of email digests.
+type emailDigestRequest struct {
+	Email     string `json:"email"`
+	Enabled   bool   `json:"enabled"`
+	Frequency string `json:"frequency"`
+}
+
+func (c *EmailDigestController) GetDigestSettings(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to read email digest settings", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := c.service.GetDigestSettings(ctx, userId)
+	if err != nil {
+		http.Error(w, "Failed to get the email digest settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateDigestSettings opts the caller in or out of email digests of their unread messages.
+func (c *EmailDigestController) UpdateDigestSettings(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to update email digest settings", http.StatusUnauthorized)
+		return
+	}
+
+	var request emailDigestRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := c.service.UpdateDigestSettings(ctx, userId, models.EmailDigestSettings{
+		Email:     request.Email,
+		Enabled:   request.Enabled,
+		Frequency: request.Frequency,
+	})
+	if errors.Is(err, service.ErrInvalidEmail) || errors.Is(err, service.ErrInvalidDigestFrequency) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrDigestsUnavailable) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update the email digest settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ConfirmDigestEmail confirms the caller's digest address with the token mailed to it, digests start from here.
+func (c *EmailDigestController) ConfirmDigestEmail(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to confirm email digests", http.StatusUnauthorized)
+		return
+	}
+
+	var request emailDigestConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := c.service.ConfirmDigestEmail(ctx, userId, request.Token)
+	if errors.Is(err, service.ErrInvalidDigestToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to confirm email digests: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/realtime"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+const (
+	// replayPageSize bounds each read of missed messages when a client resumes.
+	replayPageSize = 100
+	// heartbeatInterval keeps idle streams alive through proxies that close quiet connections.
+	heartbeatInterval = 15 * time.Second
+	// reconnectDelay is the retry hint sent to EventSource clients, in milliseconds.
+	reconnectDelay = 3000
+)
+
+type EventsController struct {
+	hub                 *realtime.Hub
+	messageService      service.MessagesService
+	conversationService service.ConversationsService
+	sendBuffer          int
+}
+
+func NewEventsController(hub *realtime.Hub, messageService service.MessagesService, conversationService service.ConversationsService, sendBuffer int) *EventsController {
+	return &EventsController{
+		hub:                 hub,
+		messageService:      messageService,
+		conversationService: conversationService,
+		sendBuffer:          sendBuffer,
+	}
+}
+
+// StreamConversationEvents streams the events of a conversation as server-sent events.
+// Created messages carry their timeuuid as the event id, so a client reconnecting with
+// Last-Event-ID first receives the messages it missed.
+func (c *EventsController) StreamConversationEvents(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to stream events", http.StatusUnauthorized)
+		return
+	}
+
+	conversationId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid conversation ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var lastEventId gocql.UUID
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		// EventSource cannot set headers on the first connection
+		resume = r.URL.Query().Get("last_event_id")
+	}
+	if resume != "" {
+		lastEventId, err = gocql.ParseUUID(resume)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// replay compares message timeuuids, any other kind of id would replay the wrong messages
+		if lastEventId.Version() != 1 {
+			http.Error(w, "Invalid Last-Event-ID: must be the timeuuid of a message", http.StatusBadRequest)
+			return
+		}
+	}
+
+	member, err := c.conversationService.IsParticipant(ctx, userId, conversationId)
+	if err != nil {
+		http.Error(w, "Failed to check conversation membership: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		http.Error(w, "Not a participant of this conversation", http.StatusForbidden)
+		return
+	}
+
+	// subscribe before replaying so nothing published in between is lost
+	stream := c.hub.OpenStream(c.sendBuffer, conversationId)
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay); err != nil {
+		return
+	}
+
+	// replayed up to this message, live copies of those messages are skipped
+	replayedUpTo := lastEventId
+	if lastEventId != (gocql.UUID{}) {
+		for {
+			messages, err := c.messageService.GetMessagesAfter(ctx, conversationId, replayedUpTo, replayPageSize)
+			if err != nil {
+				slog.Error("Failed to replay conversation events", "conversation_id", conversationId, "error", err)
+				return
+			}
+			for _, message := range messages {
+				event := realtime.Event{Type: realtime.EventMessageCreated, ConversationID: conversationId, Data: message}
+				if err := writeServerSentEvent(w, message.ID.String(), event); err != nil {
+					return
+				}
+				replayedUpTo = message.ID
+			}
+			if len(messages) < replayPageSize {
+				break
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stream.Done():
+			// fell behind, the client reconnects with its Last-Event-ID and replays the gap
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event := <-stream.Events():
+			var id string
+			if event.Type == realtime.EventMessageCreated {
+				messageId, ok := event.MessageID()
+				if ok {
+					if replayedUpTo != (gocql.UUID{}) && !models.TimeUUIDAfter(messageId, replayedUpTo) {
+						continue
+					}
+					id = messageId.String()
+				}
+			}
+			if err := writeServerSentEvent(w, id, event); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeServerSentEvent writes one event frame, the id line is omitted when empty.
+func writeServerSentEvent(w http.ResponseWriter, id string, event realtime.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
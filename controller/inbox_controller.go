@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+type InboxController struct {
+	service service.InboxService
+}
+
+func NewInboxController(service service.InboxService) *InboxController {
+	return &InboxController{service: service}
+}
+
+func (c *InboxController) GetInbox(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the user id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+	// an inbox is private to its owner
+	callerId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to read an inbox", http.StatusUnauthorized)
+		return
+	}
+	if callerId != userId {
+		http.Error(w, "Cannot read another user's inbox", http.StatusForbidden)
+		return
+	}
+
+	// Parse limit, with a default fallback of 20
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+
+	entries, err := c.service.GetInbox(ctx, userId, limit)
+	if err != nil {
+		http.Error(w, "Failed to get the inbox: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
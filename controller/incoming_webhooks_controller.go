@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+// maxIncomingPayloadBytes bounds the body accepted from external tools.
+const maxIncomingPayloadBytes = 64 << 10
+
+type IncomingWebhookController struct {
+	service service.IncomingWebhooksService
+}
+
+func NewIncomingWebhookController(service service.IncomingWebhooksService) *IncomingWebhookController {
+	return &IncomingWebhookController{service: service}
+}
+
+func (c *IncomingWebhookController) CreateIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage incoming webhooks", http.StatusUnauthorized)
+		return
+	}
+	conversationId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the conversation id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := c.service.CreateIncomingWebhook(ctx, userId, conversationId, request.Name)
+	if err != nil {
+		writeIncomingWebhookError(w, err)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusCreated, webhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *IncomingWebhookController) GetIncomingWebhooks(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage incoming webhooks", http.StatusUnauthorized)
+		return
+	}
+	conversationId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the conversation id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	webhooks, err := c.service.GetIncomingWebhooks(ctx, userId, conversationId)
+	if err != nil {
+		writeIncomingWebhookError(w, err)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, webhooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *IncomingWebhookController) RevokeIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage incoming webhooks", http.StatusUnauthorized)
+		return
+	}
+	conversationId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the conversation id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+	webhookId, err := gocql.ParseUUID(r.PathValue("webhook_id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the webhook id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.service.RevokeIncomingWebhook(ctx, userId, conversationId, webhookId); err != nil {
+		writeIncomingWebhookError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PostMessage is called by external tools with the token in the token query parameter
+// or as a bearer token, and a body of {"text": "..."}.
+func (c *IncomingWebhookController) PostMessage(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	webhookId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the webhook id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	if token == "" {
+		http.Error(w, service.ErrInvalidWebhookToken.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Text string `json:"text"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxIncomingPayloadBytes)
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message, err := c.service.PostMessage(ctx, webhookId, token, payload.Text)
+	if err != nil {
+		writeIncomingWebhookError(w, err)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusCreated, message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeIncomingWebhookError(w http.ResponseWriter, err error) {
+	var rateLimitErr *service.RateLimitError
+	switch {
+	case errors.As(err, &rateLimitErr):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimitErr.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, gocql.ErrNotFound), errors.Is(err, service.ErrWebhookNotInConversation):
+		http.Error(w, "Incoming webhook not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidWebhookToken):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, service.ErrWebhookRevoked):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, service.ErrNotParticipant):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrInvalidBotName), errors.Is(err, service.ErrInvalidIncomingText), isBodyError(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to process the incoming webhook: "+err.Error(), http.StatusInternalServerError)
+	}
+}
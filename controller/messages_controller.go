@@ -3,50 +3,94 @@ package controller
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
 	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/richtext"
 	"github.com/yaninyzwitty/messaging-service/service"
 )
 
 type MessageController struct {
-	service service.MessagesService
+	service          service.MessagesService
+	reactionsService service.ReactionsService
+	receiptsService  service.ReadReceiptsService
 }
 
-func NewMessageController(service service.MessagesService) *MessageController {
-	return &MessageController{service: service}
+const (
+	// defaultSequencePageSize and maxSequencePageSize bound the messages returned per sequence range.
+	defaultSequencePageSize = 100
+	maxSequencePageSize     = 500
+	// defaultMentionsPageSize and maxMentionsPageSize bound the mentions returned per request.
+	defaultMentionsPageSize = 50
+	maxMentionsPageSize     = 200
+	// maxMessagePayloadBytes bounds the JSON of a created or edited message, well above the longest body.
+	maxMessagePayloadBytes = 128 << 10
+)
+
+func NewMessageController(service service.MessagesService, reactionsService service.ReactionsService, receiptsService service.ReadReceiptsService) *MessageController {
+	return &MessageController{service: service, reactionsService: reactionsService, receiptsService: receiptsService}
 }
 
 func (c *MessageController) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	var message models.Message
 	var ctx = r.Context()
+	if !validBodyFormat(r) {
+		http.Error(w, "format must be html or structured", http.StatusBadRequest)
+		return
+	}
+	senderId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to send a message", http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxMessagePayloadBytes)
 	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if message.Body == "" {
+	if message.Body == "" && len(message.AttachmentIDs) == 0 {
 		http.Error(w, "Message body cannot be empty", http.StatusBadRequest)
 		return
 	}
-	if message.ConversationID.String() == "" || message.SenderId.String() == "" {
-		http.Error(w, "Conversation ID and Sender ID are required", http.StatusBadRequest)
+	if message.ConversationID == (gocql.UUID{}) {
+		http.Error(w, "Conversation ID is required", http.StatusBadRequest)
 		return
 	}
 
-	// initialize the defaults
+	// initialize the defaults, the sender is always the caller whatever the body says
+	message.SenderId = senderId
 	message.ID = gocql.TimeUUID()
 	message.CreatedAt = time.Now()
 	message.IsSoftDeleted = false
 	createdMessage, err := c.service.CreateMessage(ctx, message)
+	if errors.Is(err, service.ErrNotParticipant) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, service.ErrAttachmentPending) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, service.ErrAttachmentUnavailable) || errors.Is(err, service.ErrInvalidReplyTarget) || isBodyError(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// nobody has seen a brand new message yet
+	createdMessage.DeliveryStatus = models.DeliveryStatusSent
+	formatBody(r, &createdMessage)
 	err = helpers.NewResponseToJson(w, http.StatusCreated, createdMessage)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -57,11 +101,20 @@ func (c *MessageController) CreateMessage(w http.ResponseWriter, r *http.Request
 
 func (c *MessageController) GetMessages(w http.ResponseWriter, r *http.Request) {
 	var ctx = r.Context()
+	if !validBodyFormat(r) {
+		http.Error(w, "format must be html or structured", http.StatusBadRequest)
+		return
+	}
 	messages, err := c.service.GetMessages(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := c.applyDeliveryStatus(r, messages); err != nil {
+		http.Error(w, "Failed to get the delivery status: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	formatBodies(r, messages)
 	err = helpers.NewResponseToJson(w, http.StatusOK, messages)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -72,6 +125,10 @@ func (c *MessageController) GetMessages(w http.ResponseWriter, r *http.Request)
 
 func (c *MessageController) GetMessagesByPagingState(w http.ResponseWriter, r *http.Request) {
 	var ctx = r.Context()
+	if !validBodyFormat(r) {
+		http.Error(w, "format must be html or structured", http.StatusBadRequest)
+		return
+	}
 
 	// Read query parameters for page size and paging state
 	pageSizeInStr := r.URL.Query().Get("page_size")
@@ -99,6 +156,11 @@ func (c *MessageController) GetMessagesByPagingState(w http.ResponseWriter, r *h
 		http.Error(w, "Error fetching paginated messages: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := c.applyDeliveryStatus(r, messages); err != nil {
+		http.Error(w, "Error fetching the delivery status: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	formatBodies(r, messages)
 
 	// Encode the new paging state for the response using URL-safe encoding
 	base64EncodedPagingState := ""
@@ -122,6 +184,10 @@ func (c *MessageController) GetMessagesByPagingState(w http.ResponseWriter, r *h
 
 func (c *MessageController) GetMessage(w http.ResponseWriter, r *http.Request) {
 	var ctx = r.Context()
+	if !validBodyFormat(r) {
+		http.Error(w, "format must be html or structured", http.StatusBadRequest)
+		return
+	}
 	idStr := r.PathValue("id")
 	id, err := gocql.ParseUUID(idStr)
 	if err != nil {
@@ -133,6 +199,24 @@ func (c *MessageController) GetMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to get the message: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	// embed reaction summaries when asked for, e.g. ?include=reactions
+	if includes(r, "reactions") {
+		userId, _ := middleware.UserIDFromContext(ctx)
+		message.Reactions, err = c.reactionsService.GetReactionSummaries(ctx, id, userId)
+		if err != nil {
+			http.Error(w, "Failed to get the reactions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	messages := []models.Message{message}
+	if err := c.applyDeliveryStatus(r, messages); err != nil {
+		http.Error(w, "Failed to get the delivery status: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	formatBodies(r, messages)
+	message = messages[0]
 	err = helpers.NewResponseToJson(w, http.StatusOK, message)
 	if err != nil {
 		http.Error(w, "Failed to fully decode the message"+err.Error(), http.StatusInternalServerError)
@@ -144,6 +228,15 @@ func (c *MessageController) GetMessage(w http.ResponseWriter, r *http.Request) {
 func (c *MessageController) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	var message models.Message
 	var ctx = r.Context()
+	if !validBodyFormat(r) {
+		http.Error(w, "format must be html or structured", http.StatusBadRequest)
+		return
+	}
+	editorId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to edit a message", http.StatusUnauthorized)
+		return
+	}
 	var idStr = r.PathValue("id")
 
 	// Parse UUID from the path
@@ -154,6 +247,7 @@ func (c *MessageController) UpdateMessage(w http.ResponseWriter, r *http.Request
 	}
 
 	// Parse the request body into the message object
+	r.Body = http.MaxBytesReader(w, r.Body, maxMessagePayloadBytes)
 	err = json.NewDecoder(r.Body).Decode(&message)
 	if err != nil {
 		http.Error(w, "Invalid request payload"+err.Error(), http.StatusBadRequest)
@@ -167,13 +261,26 @@ func (c *MessageController) UpdateMessage(w http.ResponseWriter, r *http.Request
 	message.ID = id
 
 	// Call the service to update the message
-	updatedMessage, err := c.service.UpdateMessage(ctx, id, message)
+	updatedMessage, err := c.service.UpdateMessage(ctx, editorId, id, message)
+	if errors.Is(err, gocql.ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrNotMessageAuthor) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if isBodyError(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "failed to update message"+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Send the response back
+	formatBody(r, &updatedMessage)
 	err = helpers.NewResponseToJson(w, http.StatusOK, updatedMessage)
 	if err != nil {
 		http.Error(w, "Error marshaling the response", http.StatusInternalServerError)
@@ -183,6 +290,11 @@ func (c *MessageController) UpdateMessage(w http.ResponseWriter, r *http.Request
 
 func (c *MessageController) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	var ctx = r.Context()
+	deleterId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to delete a message", http.StatusUnauthorized)
+		return
+	}
 	idStr := r.PathValue("id")
 	id, err := gocql.ParseUUID(idStr)
 	if err != nil {
@@ -190,7 +302,11 @@ func (c *MessageController) DeleteMessage(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = c.service.DeleteMessage(ctx, id)
+	err = c.service.DeleteMessage(ctx, deleterId, id)
+	if errors.Is(err, service.ErrNotMessageAuthor) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -202,3 +318,163 @@ func (c *MessageController) DeleteMessage(w http.ResponseWriter, r *http.Request
 	}
 
 }
+
+// GetConversationMessages returns the messages of a conversation by sequence number, starting at ?from_seq=
+// (1 by default), so clients can fill the gaps they notice in the numbers after reconnecting.
+// next_seq is where the following page starts. last_seq is the last number handed out, numbers up to it
+// that no page returns belong to deleted or failed messages and stay missing, clients skip over them.
+func (c *MessageController) GetConversationMessages(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	if !validBodyFormat(r) {
+		http.Error(w, "format must be html or structured", http.StatusBadRequest)
+		return
+	}
+
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to read conversation messages", http.StatusUnauthorized)
+		return
+	}
+
+	conversationId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the conversation id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	fromSeq := int64(1)
+	if value := r.URL.Query().Get("from_seq"); value != "" {
+		fromSeq, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || fromSeq < 1 {
+			http.Error(w, "from_seq must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultSequencePageSize
+	}
+	if limit > maxSequencePageSize {
+		limit = maxSequencePageSize
+	}
+
+	messages, lastSeq, err := c.service.GetMessagesFromSequence(ctx, userId, conversationId, fromSeq, limit)
+	if errors.Is(err, service.ErrNotParticipant) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get the conversation messages: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := c.applyDeliveryStatus(r, messages); err != nil {
+		http.Error(w, "Failed to get the delivery status: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	formatBodies(r, messages)
+
+	nextSeq := fromSeq
+	if len(messages) > 0 {
+		nextSeq = messages[len(messages)-1].Seq + 1
+	}
+	response := map[string]interface{}{
+		"messages": messages,
+		"next_seq": nextSeq,
+		"last_seq": lastSeq,
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetMentions returns the messages mentioning the caller, newest first. Older pages are read by passing
+// the message_id of the last mention as ?before=.
+func (c *MessageController) GetMentions(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := ownerFromPath(w, r, "read mentions")
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultMentionsPageSize
+	}
+	if limit > maxMentionsPageSize {
+		limit = maxMentionsPageSize
+	}
+
+	mentions, err := c.service.GetMentions(ctx, userId, r.URL.Query().Get("before"), limit)
+	if errors.Is(err, service.ErrInvalidMentionCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get the mentions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, mentions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// isBodyError reports whether the message was rejected for its body: its length, mentions or formatting.
+func isBodyError(err error) bool {
+	return errors.Is(err, service.ErrBodyTooLong) || errors.Is(err, service.ErrInvalidMention) || errors.Is(err, service.ErrTooManyMentions) || errors.Is(err, richtext.ErrDisallowed)
+}
+
+// Body formats clients can ask for with ?format=, the raw Markdown body is always returned.
+const (
+	formatHTML       = "html"
+	formatStructured = "structured"
+)
+
+func validBodyFormat(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "", formatHTML, formatStructured:
+		return true
+	}
+	return false
+}
+
+// formatBodies adds the bodies rendered in the requested format: sanitized HTML, or the parsed structure.
+func formatBodies(r *http.Request, messages []models.Message) {
+	for i := range messages {
+		formatBody(r, &messages[i])
+	}
+}
+
+func formatBody(r *http.Request, message *models.Message) {
+	switch r.URL.Query().Get("format") {
+	case formatHTML:
+		message.BodyHTML = richtext.ParseOrPlain(message.Body).HTML()
+	case formatStructured:
+		doc := richtext.ParseOrPlain(message.Body)
+		message.BodyStructured = &doc
+	}
+}
+
+// includes reports whether the comma separated include query parameter lists the given expansion.
+func includes(r *http.Request, expansion string) bool {
+	for _, value := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if strings.TrimSpace(value) == expansion {
+			return true
+		}
+	}
+	return false
+}
+
+// applyDeliveryStatus reports delivery progress on the messages the caller sent, anonymous callers get none.
+func (c *MessageController) applyDeliveryStatus(r *http.Request, messages []models.Message) error {
+	userId, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	return c.receiptsService.ApplyDeliveryStatus(r.Context(), userId, messages)
+}
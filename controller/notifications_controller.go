@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+const (
+	// defaultNotificationsPageSize and maxNotificationsPageSize bound the notifications returned per request.
+	defaultNotificationsPageSize = 50
+	maxNotificationsPageSize     = 200
+)
+
+type NotificationController struct {
+	service service.NotificationsService
+}
+
+func NewNotificationController(service service.NotificationsService) *NotificationController {
+	return &NotificationController{service: service}
+}
+
+// markReadRequest lists the notifications to mark read, or asks for all of them.
+type markReadRequest struct {
+	IDs []gocql.UUID `json:"ids"`
+	All bool         `json:"all"`
+}
+
+// ownerFromPath checks the user in the path is the caller, for feeds that are private to their owner.
+func ownerFromPath(w http.ResponseWriter, r *http.Request, action string) (gocql.UUID, bool) {
+	userId, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authentication required to "+action, http.StatusUnauthorized)
+		return gocql.UUID{}, false
+	}
+	pathUserId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the user id into gocql uuid format", http.StatusBadRequest)
+		return gocql.UUID{}, false
+	}
+	if pathUserId != userId {
+		http.Error(w, "Only the user themselves can "+action, http.StatusForbidden)
+		return gocql.UUID{}, false
+	}
+	return userId, true
+}
+
+// GetNotifications returns the caller's notifications, newest first. Older pages are read by passing
+// the returned next_cursor as ?before=.
+func (c *NotificationController) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := ownerFromPath(w, r, "read notifications")
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultNotificationsPageSize
+	}
+	if limit > maxNotificationsPageSize {
+		limit = maxNotificationsPageSize
+	}
+
+	page, err := c.service.GetNotifications(ctx, userId, r.URL.Query().Get("before"), limit)
+	if errors.Is(err, service.ErrInvalidNotificationCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get the notifications: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	err = helpers.NewResponseToJson(w, http.StatusOK, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// MarkNotificationsRead marks the listed notifications, or all of them, read.
+func (c *NotificationController) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := ownerFromPath(w, r, "mark notifications read")
+	if !ok {
+		return
+	}
+
+	var request markReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.All == (len(request.IDs) > 0) {
+		http.Error(w, "Either ids or all must be given", http.StatusBadRequest)
+		return
+	}
+
+	var marked int
+	var err error
+	if request.All {
+		marked, err = c.service.MarkAllRead(ctx, userId)
+	} else {
+		marked, err = c.service.MarkRead(ctx, userId, request.IDs)
+	}
+	if errors.Is(err, service.ErrTooManyNotificationIds) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to mark the notifications read: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, map[string]interface{}{"marked": marked})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+type ReactionController struct {
+	service service.ReactionsService
+}
+
+func NewReactionController(service service.ReactionsService) *ReactionController {
+	return &ReactionController{service: service}
+}
+
+func (c *ReactionController) AddReaction(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to react to a message", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+	emoji := r.PathValue("emoji")
+
+	err = c.service.AddReaction(ctx, id, emoji, userId)
+	switch {
+	case errors.Is(err, service.ErrInvalidEmoji):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, gocql.ErrNotFound):
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to add reaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.writeSummaries(w, r, id, userId)
+}
+
+func (c *ReactionController) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to remove a reaction", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+	emoji := r.PathValue("emoji")
+
+	err = c.service.RemoveReaction(ctx, id, emoji, userId)
+	if errors.Is(err, service.ErrInvalidEmoji) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to remove reaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.writeSummaries(w, r, id, userId)
+}
+
+// writeSummaries responds with the current reaction summaries so clients can refresh their counts.
+func (c *ReactionController) writeSummaries(w http.ResponseWriter, r *http.Request, messageId gocql.UUID, userId gocql.UUID) {
+	summaries, err := c.service.GetReactionSummaries(r.Context(), messageId, userId)
+	if err != nil {
+		http.Error(w, "Failed to get reactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, summaries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
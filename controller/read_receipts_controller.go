@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+type ReadReceiptController struct {
+	service service.ReadReceiptsService
+}
+
+func NewReadReceiptController(service service.ReadReceiptsService) *ReadReceiptController {
+	return &ReadReceiptController{service: service}
+}
+
+type receiptRequest struct {
+	MessageID gocql.UUID `json:"message_id"`
+}
+
+func (c *ReadReceiptController) MarkDelivered(w http.ResponseWriter, r *http.Request) {
+	c.advance(w, r, c.service.MarkDelivered)
+}
+
+func (c *ReadReceiptController) MarkRead(w http.ResponseWriter, r *http.Request) {
+	c.advance(w, r, c.service.MarkRead)
+}
+
+type advanceFunc func(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, messageId gocql.UUID) (models.ReadReceipt, error)
+
+func (c *ReadReceiptController) advance(w http.ResponseWriter, r *http.Request, mark advanceFunc) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to update read receipts", http.StatusUnauthorized)
+		return
+	}
+	conversationId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the conversation id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	var request receiptRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.MessageID.Version() != 1 {
+		http.Error(w, "message_id must be a message timeuuid", http.StatusBadRequest)
+		return
+	}
+
+	receipt, err := mark(ctx, conversationId, userId, request.MessageID)
+	switch {
+	case errors.Is(err, gocql.ErrNotFound):
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrMessageNotInConversation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrReceiptContention):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update read receipt: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = helpers.NewResponseToJson(w, http.StatusOK, receipt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *ReadReceiptController) GetReaders(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to see who read a message", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	readers, err := c.service.GetReaders(ctx, userId, id)
+	if errors.Is(err, gocql.ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrNotParticipant) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get the readers: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = helpers.NewResponseToJson(w, http.StatusOK, readers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/websocket"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/realtime"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+// maxDeviceIdLength bounds the device ids clients choose, they key the in-memory delivery queues.
+const maxDeviceIdLength = 128
+
+type RealtimeController struct {
+	hub                 *realtime.Hub
+	presence            *realtime.PresenceTracker
+	deliveries          *realtime.DeliveryQueues
+	conversationService service.ConversationsService
+	upgrader            websocket.Upgrader
+	sendBuffer          int
+}
+
+func NewRealtimeController(hub *realtime.Hub, presence *realtime.PresenceTracker, deliveries *realtime.DeliveryQueues, conversationService service.ConversationsService, sendBuffer int) *RealtimeController {
+	return &RealtimeController{
+		hub:                 hub,
+		presence:            presence,
+		deliveries:          deliveries,
+		conversationService: conversationService,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// same policy as the CORS middleware, any origin may connect
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		sendBuffer: sendBuffer,
+	}
+}
+
+// ServeWebSocket upgrades the connection and streams events of the conversations the client subscribes to.
+// Clients passing a device_id get numbered events they acknowledge, unacknowledged ones are sent
+// again when the device reconnects.
+func (c *RealtimeController) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	userId, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authentication required to open a realtime connection", http.StatusUnauthorized)
+		return
+	}
+
+	deviceId := r.URL.Query().Get("device_id")
+	if len(deviceId) > maxDeviceIdLength {
+		http.Error(w, "Invalid device ID: too long", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an error
+		slog.Warn("Failed to upgrade realtime connection", "error", err)
+		return
+	}
+
+	client := realtime.NewClient(c.hub, c.presence, c.deliveries, conn, userId, deviceId, c.conversationService.IsParticipant, c.sendBuffer)
+	client.Run(r.Context())
+}
+
+// GetPresence returns whether a user is online, away or offline and when they were last seen.
+func (c *RealtimeController) GetPresence(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+
+	if _, ok := middleware.UserIDFromContext(ctx); !ok {
+		http.Error(w, "Authentication required to view presence", http.StatusUnauthorized)
+		return
+	}
+
+	userId, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the user id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	presence, err := c.presence.Get(ctx, userId)
+	if err != nil {
+		http.Error(w, "Failed to get presence: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = helpers.NewResponseToJson(w, http.StatusOK, presence)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
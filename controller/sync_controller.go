@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/realtime"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+const (
+	// defaultSyncTimeout is how long a sync request blocks when the client does not say.
+	defaultSyncTimeout = 30 * time.Second
+	// syncSettleTime is how long after a live event the change log is looked at again, the event is
+	// recorded there once the outbox delivered it. syncRecheckInterval is how often it is looked at meanwhile.
+	syncSettleTime      = 10 * time.Second
+	syncRecheckInterval = 500 * time.Millisecond
+)
+
+type SyncController struct {
+	hub                 *realtime.Hub
+	conversationService service.ConversationsService
+	changesService      service.ChangesService
+	maxTimeout          time.Duration
+}
+
+func NewSyncController(hub *realtime.Hub, conversationService service.ConversationsService, changesService service.ChangesService, maxTimeout time.Duration) *SyncController {
+	return &SyncController{hub: hub, conversationService: conversationService, changesService: changesService, maxTimeout: maxTimeout}
+}
+
+// Sync long-polls the caller's change log. It returns as soon as changes newer than the cursor exist,
+// or with none once the timeout elapses; either way the returned cursor is passed to the next call.
+// Cursors are change log positions, so any instance can continue from them. Without a cursor the client
+// starts from now.
+func (c *SyncController) Sync(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to sync", http.StatusUnauthorized)
+		return
+	}
+
+	cursor := r.URL.Query().Get("cursor")
+	if cursor == "" {
+		cursor = gocql.MinTimeUUID(time.Now()).String()
+	}
+
+	timeout := defaultSyncTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid timeout, expected a duration such as 30s", http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+	if timeout > c.maxTimeout {
+		timeout = c.maxTimeout
+	}
+
+	conversationIds, err := c.conversationService.GetConversationIds(ctx, userId)
+	if err != nil {
+		http.Error(w, "Failed to list conversations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	changeSet, err := c.waitForChanges(ctx, userId, cursor, conversationIds, timeout)
+	if errors.Is(err, service.ErrInvalidChangeCursor) {
+		http.Error(w, "Invalid cursor: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ctx.Err() != nil {
+		// the client went away, nobody to answer
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get changes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	err = helpers.NewResponseToJson(w, http.StatusOK, changeSet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// waitForChanges reads the change log after the cursor until it has something or the timeout elapses.
+// Between reads it waits for live events of the conversations, which arrive through the backplane from
+// whichever instance made the change, and keeps looking for a while after one until it is recorded.
+func (c *SyncController) waitForChanges(ctx context.Context, userId gocql.UUID, cursor string, conversationIds []gocql.UUID, timeout time.Duration) (models.ChangeSet, error) {
+	// watch before the first read, so events arriving during the read still wake us up
+	activity, stop := c.hub.Watch(conversationIds)
+	defer stop()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	recheck := time.NewTicker(syncRecheckInterval)
+	defer recheck.Stop()
+
+	var lastActivity time.Time
+	for {
+		changeSet, err := c.changesService.GetChanges(ctx, userId, cursor, defaultChangesPageSize)
+		if err != nil || len(changeSet.Changes) > 0 || changeSet.Reset {
+			return changeSet, err
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-activity:
+				lastActivity = time.Now()
+				waiting = false
+			case <-recheck.C:
+				waiting = time.Since(lastActivity) > syncSettleTime
+			case <-timer.C:
+				return changeSet, nil
+			case <-ctx.Done():
+				return changeSet, ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	// attachmentIDHeader tells the client which attachment a finished upload became.
+	attachmentIDHeader = "X-Attachment-ID"
+)
+
+// UploadController speaks the tus 1.0 resumable upload protocol, see https://tus.io/protocols/resumable-upload.
+type UploadController struct {
+	service service.UploadsService
+}
+
+func NewUploadController(service service.UploadsService) *UploadController {
+	return &UploadController{service: service}
+}
+
+// Options advertises the protocol version and the supported extensions.
+func (c *UploadController) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(c.service.MaxSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload implements the creation extension.
+func (c *UploadController) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := c.begin(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Deferred upload length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length must be a non negative integer", http.StatusBadRequest)
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = metadata["content_type"]
+	}
+
+	upload, err := c.service.CreateUpload(ctx, userId, length, metadata["filename"], contentType)
+	if errors.Is(err, service.ErrUploadTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create the upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// a zero length upload is complete as soon as it exists
+	if upload.IsComplete() {
+		upload, err = c.service.AppendChunk(ctx, upload.ID, userId, 0, http.NoBody)
+		if err != nil {
+			http.Error(w, "Failed to finalize the upload: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeUploadHeaders(w, upload)
+	w.Header().Set("Location", "/uploads/"+upload.ID.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetUploadOffset answers HEAD requests with the offset to resume from.
+func (c *UploadController) GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := c.begin(w, r)
+	if !ok {
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := c.service.GetUpload(ctx, id, userId)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	writeUploadHeaders(w, upload)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload appends a chunk at the offset the client claims to resume from.
+func (c *UploadController) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := c.begin(w, r)
+	if !ok {
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset must be a non negative integer", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := c.service.AppendChunk(ctx, id, userId, offset, r.Body)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	writeUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TerminateUpload implements the termination extension.
+func (c *UploadController) TerminateUpload(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := c.begin(w, r)
+	if !ok {
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.service.TerminateUpload(ctx, id, userId); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// begin performs the checks shared by every tus request: the protocol version and the caller.
+func (c *UploadController) begin(w http.ResponseWriter, r *http.Request) (gocql.UUID, bool) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return gocql.UUID{}, false
+	}
+	userId, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Authentication required to upload", http.StatusUnauthorized)
+		return gocql.UUID{}, false
+	}
+	return userId, true
+}
+
+func writeUploadHeaders(w http.ResponseWriter, upload models.ResumableUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.IsFinalized() {
+		w.Header().Set(attachmentIDHeader, upload.AttachmentID.String())
+	}
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gocql.ErrNotFound):
+		http.Error(w, "Upload not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrUploadForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrUploadExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, service.ErrUploadOffset):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrUploadChunkTooLong):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, "Failed to process the upload: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, comma separated pairs of a key and a base64 value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty key")
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, errors.New("value of " + key + " is not valid base64")
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/middleware"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/service"
+)
+
+type WebhookController struct {
+	service service.WebhooksService
+}
+
+func NewWebhookController(service service.WebhooksService) *WebhookController {
+	return &WebhookController{service: service}
+}
+
+// webhookRequest is the subscription part of a webhook a client may set.
+type webhookRequest struct {
+	URL             string       `json:"url"`
+	EventTypes      []string     `json:"event_types"`
+	ConversationIDs []gocql.UUID `json:"conversation_ids"`
+	Secret          string       `json:"secret"`
+	Enabled         *bool        `json:"enabled"`
+}
+
+func (req webhookRequest) webhook() models.Webhook {
+	return models.Webhook{
+		URL:             req.URL,
+		EventTypes:      req.EventTypes,
+		ConversationIDs: req.ConversationIDs,
+		Secret:          req.Secret,
+		Enabled:         req.Enabled == nil || *req.Enabled,
+	}
+}
+
+func (c *WebhookController) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+
+	var request webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := c.service.CreateWebhook(ctx, userId, request.webhook())
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusCreated, webhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *WebhookController) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+
+	webhooks, err := c.service.GetWebhooks(ctx, userId)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, webhooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *WebhookController) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := c.service.GetWebhook(ctx, userId, id)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, webhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateWebhook replaces the subscription, leaving out the secret keeps the current one.
+func (c *WebhookController) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	var request webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := c.service.UpdateWebhook(ctx, userId, id, request.webhook())
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, webhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c *WebhookController) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.service.DeleteWebhook(ctx, userId, id); err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeliveries lists the latest deliveries of a webhook. ?status=dead_letter lists the dead letters.
+func (c *WebhookController) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	var ctx = r.Context()
+	userId, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		http.Error(w, "Authentication required to manage webhooks", http.StatusUnauthorized)
+		return
+	}
+	id, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to parse the id into gocql uuid format", http.StatusBadRequest)
+		return
+	}
+
+	// Parse limit, with a default fallback of 50
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	deliveries, err := c.service.GetDeliveries(ctx, userId, id, r.URL.Query().Get("status"), limit)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	err = helpers.NewResponseToJson(w, http.StatusOK, deliveries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeWebhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gocql.ErrNotFound):
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrWebhookForbidden), errors.Is(err, service.ErrNotParticipant):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrInvalidWebhookURL), errors.Is(err, service.ErrWebhookURLForbidden), errors.Is(err, service.ErrInvalidEventTypes), errors.Is(err, service.ErrWebhookSecretLength):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to process the webhook: "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -47,13 +48,497 @@ func NewDatabaseConnection(hosts string) (*gocqlx.Session, error) {
 		created_at TIMESTAMP,
 		updated_at TIMESTAMP,
 		body TEXT,
-		is_soft_deleted BOOLEAN
+		is_soft_deleted BOOLEAN,
+		seq BIGINT,
+		reply_to_id UUID,
+		mentions LIST<TEXT>
 	)`)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create messages table: %w", err)
 	}
 
+	// messages of a conversation in timeuuid order, kept in sync with messages through logged batches
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS messages_by_conversation (
+		conversation_id UUID,
+		id TIMEUUID,
+		sender_id UUID,
+		created_at TIMESTAMP,
+		updated_at TIMESTAMP,
+		body TEXT,
+		is_soft_deleted BOOLEAN,
+		seq BIGINT,
+		reply_to_id UUID,
+		mentions LIST<TEXT>,
+		PRIMARY KEY ((conversation_id), id)
+	) WITH CLUSTERING ORDER BY (id DESC)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create messages_by_conversation table: %w", err)
+	}
+
+	// tables created by older versions lack the seq, reply_to_id and mentions columns
+	for _, table := range []string{"messages", "messages_by_conversation"} {
+		if err := addColumnIfMissing(&session, table, "seq", "BIGINT"); err != nil {
+			return nil, fmt.Errorf("failed to add seq to %s table: %w", table, err)
+		}
+		if err := addColumnIfMissing(&session, table, "reply_to_id", "UUID"); err != nil {
+			return nil, fmt.Errorf("failed to add reply_to_id to %s table: %w", table, err)
+		}
+		if err := addColumnIfMissing(&session, table, "mentions", "LIST<TEXT>"); err != nil {
+			return nil, fmt.Errorf("failed to add mentions to %s table: %w", table, err)
+		}
+	}
+
+	// message ids by their position in the conversation, for reading ranges of sequence numbers
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS messages_by_sequence (
+		conversation_id UUID,
+		seq BIGINT,
+		id TIMEUUID,
+		PRIMARY KEY ((conversation_id), seq)
+	) WITH CLUSTERING ORDER BY (seq ASC)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create messages_by_sequence table: %w", err)
+	}
+
+	// the last sequence number of each conversation, advanced with LWT so no number is handed out twice
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS conversation_sequences (
+		conversation_id UUID PRIMARY KEY,
+		last_seq BIGINT
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation_sequences table: %w", err)
+	}
+
+	// one row per user and emoji, guarded by LWT so a user can only react once with the same emoji
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS reactions (
+		message_id UUID,
+		emoji TEXT,
+		user_id UUID,
+		created_at TIMESTAMP,
+		PRIMARY KEY ((message_id), emoji, user_id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reactions table: %w", err)
+	}
+
+	// aggregate counts per emoji, counters have to live in their own table
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS reaction_counts (
+		message_id UUID,
+		emoji TEXT,
+		count COUNTER,
+		PRIMARY KEY ((message_id), emoji)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reaction_counts table: %w", err)
+	}
+
+	// messages whose counters changed lately, for the repair to check them against the reaction rows
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS reaction_count_checks (
+		shard INT,
+		message_id UUID,
+		changed_at TIMESTAMP,
+		PRIMARY KEY ((shard), message_id)
+	) WITH default_time_to_live = 604800`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reaction_count_checks table: %w", err)
+	}
+
+	// high-water marks per user and conversation instead of one row per message
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS read_receipts (
+		conversation_id UUID,
+		user_id UUID,
+		delivered_up_to TIMEUUID,
+		delivered_at TIMESTAMP,
+		read_up_to TIMEUUID,
+		read_at TIMESTAMP,
+		PRIMARY KEY ((conversation_id), user_id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create read_receipts table: %w", err)
+	}
+
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS participants (
+		conversation_id UUID,
+		user_id UUID,
+		joined_at TIMESTAMP,
+		PRIMARY KEY ((conversation_id), user_id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create participants table: %w", err)
+	}
+
+	// the conversations of every user, written along with participants
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS participants_by_user (
+		user_id UUID,
+		conversation_id UUID,
+		joined_at TIMESTAMP,
+		PRIMARY KEY ((user_id), conversation_id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create participants_by_user table: %w", err)
+	}
+
+	// latest message per conversation for every participant, sorted by activity when read
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS inbox_by_user (
+		user_id UUID,
+		conversation_id UUID,
+		last_message_id TIMEUUID,
+		last_sender_id UUID,
+		last_message_preview TEXT,
+		last_activity_at TIMESTAMP,
+		PRIMARY KEY ((user_id), conversation_id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inbox_by_user table: %w", err)
+	}
+
+	// attachment metadata, the content itself lives in the blob store
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS attachments (
+		id UUID PRIMARY KEY,
+		message_id UUID,
+		uploader_id UUID,
+		filename TEXT,
+		content_type TEXT,
+		size BIGINT,
+		checksum TEXT,
+		storage_key TEXT,
+		created_at TIMESTAMP,
+		status TEXT,
+		width INT,
+		height INT,
+		thumbnail_key TEXT,
+		quarantine_reason TEXT
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachments table: %w", err)
+	}
+
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS attachments_by_message (
+		message_id UUID,
+		id UUID,
+		PRIMARY KEY ((message_id), id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachments_by_message table: %w", err)
+	}
+
+	// attachments waiting for the processing pipeline, rows are deleted once processed
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS pending_attachments (
+		shard INT,
+		id TIMEUUID,
+		PRIMARY KEY ((shard), id)
+	) WITH CLUSTERING ORDER BY (id ASC)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pending_attachments table: %w", err)
+	}
+
+	// bookkeeping of tus uploads, the chunks themselves live in the blob store
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS resumable_uploads (
+		id UUID PRIMARY KEY,
+		uploader_id UUID,
+		upload_length BIGINT,
+		upload_offset BIGINT,
+		filename TEXT,
+		content_type TEXT,
+		chunk_offsets LIST<BIGINT>,
+		attachment_id UUID,
+		created_at TIMESTAMP,
+		expires_at TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resumable_uploads table: %w", err)
+	}
+
+	// events waiting to be published, one partition per shard and hour; rows are deleted once delivered
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS outbox (
+		shard INT,
+		bucket BIGINT,
+		id TIMEUUID,
+		conversation_id UUID,
+		event_type TEXT,
+		payload BLOB,
+		created_at TIMESTAMP,
+		attempts INT,
+		next_attempt_at TIMESTAMP,
+		last_error TEXT,
+		delivered SET<TEXT>,
+		PRIMARY KEY ((shard, bucket), id)
+	) WITH CLUSTERING ORDER BY (id ASC)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox table: %w", err)
+	}
+
+	// where the dispatcher resumes each shard, so it never reads the settled entries again
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS outbox_cursors (
+		shard INT PRIMARY KEY,
+		position TIMEUUID
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox_cursors table: %w", err)
+	}
+
+	// which dispatcher delivers each shard, rows expire with the lease unless it is renewed
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS outbox_leases (
+		shard INT PRIMARY KEY,
+		owner TEXT
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox_leases table: %w", err)
+	}
+
+	// outbox entries that kept failing, kept for inspection and manual replay
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS outbox_dead_letters (
+		shard INT,
+		bucket BIGINT,
+		id TIMEUUID,
+		conversation_id UUID,
+		event_type TEXT,
+		payload BLOB,
+		created_at TIMESTAMP,
+		attempts INT,
+		next_attempt_at TIMESTAMP,
+		last_error TEXT,
+		delivered SET<TEXT>,
+		PRIMARY KEY ((shard), id)
+	) WITH CLUSTERING ORDER BY (id ASC)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox_dead_letters table: %w", err)
+	}
+
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS webhooks (
+		id UUID PRIMARY KEY,
+		owner_id UUID,
+		url TEXT,
+		event_types SET<TEXT>,
+		conversation_ids SET<UUID>,
+		secret TEXT,
+		enabled BOOLEAN,
+		consecutive_failures INT,
+		disabled_reason TEXT,
+		created_at TIMESTAMP,
+		updated_at TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhooks table: %w", err)
+	}
+
+	// delivery log of every webhook, newest first; rows expire after 30 days
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		webhook_id UUID,
+		id TIMEUUID,
+		event_id UUID,
+		event_type TEXT,
+		payload BLOB,
+		status TEXT,
+		attempts INT,
+		response_status INT,
+		last_error TEXT,
+		created_at TIMESTAMP,
+		updated_at TIMESTAMP,
+		next_attempt_at TIMESTAMP,
+		PRIMARY KEY ((webhook_id), id)
+	) WITH CLUSTERING ORDER BY (id DESC) AND default_time_to_live = 2592000`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook_deliveries table: %w", err)
+	}
+
+	// webhooks limited to some conversations, by conversation, for finding the ones an event matches
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS webhooks_by_conversation (
+		conversation_id UUID,
+		webhook_id UUID,
+		PRIMARY KEY ((conversation_id), webhook_id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhooks_by_conversation table: %w", err)
+	}
+
+	// webhooks of each owner, the ones covering all their conversations are matched through the participants
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS webhooks_by_owner (
+		owner_id UUID,
+		webhook_id UUID,
+		all_conversations BOOLEAN,
+		PRIMARY KEY ((owner_id), webhook_id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhooks_by_owner table: %w", err)
+	}
+
+	// delivery log of every webhook by status, newest first; expires with the log
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS webhook_deliveries_by_status (
+		webhook_id UUID,
+		status TEXT,
+		id TIMEUUID,
+		PRIMARY KEY ((webhook_id, status), id)
+	) WITH CLUSTERING ORDER BY (id DESC) AND default_time_to_live = 2592000`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook_deliveries_by_status table: %w", err)
+	}
+
+	// next attempt of every pending delivery, earliest first within a shard; expires with the log
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS webhook_retries (
+		shard INT,
+		next_attempt_at TIMESTAMP,
+		webhook_id UUID,
+		id TIMEUUID,
+		PRIMARY KEY ((shard), next_attempt_at, webhook_id, id)
+	) WITH CLUSTERING ORDER BY (next_attempt_at ASC, webhook_id ASC, id ASC) AND default_time_to_live = 2592000`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook_retries table: %w", err)
+	}
+
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS incoming_webhooks (
+		id UUID PRIMARY KEY,
+		conversation_id UUID,
+		creator_id UUID,
+		name TEXT,
+		bot_id UUID,
+		token_hash TEXT,
+		revoked BOOLEAN,
+		created_at TIMESTAMP,
+		revoked_at TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create incoming_webhooks table: %w", err)
+	}
+
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS incoming_webhooks_by_conversation (
+		conversation_id UUID,
+		id UUID,
+		creator_id UUID,
+		name TEXT,
+		bot_id UUID,
+		token_hash TEXT,
+		revoked BOOLEAN,
+		created_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		PRIMARY KEY ((conversation_id), id)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create incoming_webhooks_by_conversation table: %w", err)
+	}
+
+	// live presence is kept in memory, only the time users were last connected is stored
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS last_seen (
+		user_id UUID PRIMARY KEY,
+		last_seen TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create last_seen table: %w", err)
+	}
+
+	// what changed for each user, oldest first, for offline clients catching up; rows expire after 30 days
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS changes_by_user (
+		user_id UUID,
+		id TIMEUUID,
+		conversation_id UUID,
+		entity_type TEXT,
+		entity_id TEXT,
+		op TEXT,
+		data BLOB,
+		changed_at TIMESTAMP,
+		PRIMARY KEY ((user_id), id)
+	) WITH CLUSTERING ORDER BY (id ASC) AND default_time_to_live = 2592000`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create changes_by_user table: %w", err)
+	}
+
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS device_tokens (
+		user_id UUID,
+		token TEXT,
+		platform TEXT,
+		registered_at TIMESTAMP,
+		PRIMARY KEY ((user_id), token)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device_tokens table: %w", err)
+	}
+
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS device_token_owners (
+		token TEXT PRIMARY KEY,
+		user_id UUID
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device_token_owners table: %w", err)
+	}
+
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS email_digest_settings (
+		user_id UUID PRIMARY KEY,
+		email TEXT,
+		enabled BOOLEAN,
+		confirmed BOOLEAN,
+		confirmation_hash TEXT,
+		frequency TEXT,
+		last_sent_at TIMESTAMP,
+		updated_at TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create email_digest_settings table: %w", err)
+	}
+	// tables created by older versions lack the confirmation columns
+	if err := addColumnIfMissing(&session, "email_digest_settings", "confirmed", "BOOLEAN"); err != nil {
+		return nil, fmt.Errorf("failed to add confirmed to email_digest_settings table: %w", err)
+	}
+	if err := addColumnIfMissing(&session, "email_digest_settings", "confirmation_hash", "TEXT"); err != nil {
+		return nil, fmt.Errorf("failed to add confirmation_hash to email_digest_settings table: %w", err)
+	}
+
+	// the next digest of every user with confirmed digests, oldest due first within a shard
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS email_digest_schedule (
+		shard INT,
+		due_at TIMESTAMP,
+		user_id UUID,
+		PRIMARY KEY ((shard), due_at, user_id)
+	) WITH CLUSTERING ORDER BY (due_at ASC, user_id ASC)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create email_digest_schedule table: %w", err)
+	}
+
+	// in-app notifications of each user, newest first; rows expire after 30 days
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS notifications_by_user (
+		user_id UUID,
+		id TIMEUUID,
+		type TEXT,
+		conversation_id UUID,
+		message_id UUID,
+		actor_id UUID,
+		preview TEXT,
+		is_read BOOLEAN,
+		created_at TIMESTAMP,
+		PRIMARY KEY ((user_id), id)
+	) WITH CLUSTERING ORDER BY (id DESC) AND default_time_to_live = 2592000`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notifications_by_user table: %w", err)
+	}
+
+	// messages mentioning each user, newest first
+	err = session.ExecStmt(`CREATE TABLE IF NOT EXISTS mentions_by_user (
+		user_id UUID,
+		message_id TIMEUUID,
+		conversation_id UUID,
+		sender_id UUID,
+		kind TEXT,
+		created_at TIMESTAMP,
+		PRIMARY KEY ((user_id), message_id)
+	) WITH CLUSTERING ORDER BY (message_id DESC)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mentions_by_user table: %w", err)
+	}
+
 	return &session, nil
 
 }
+
+// addColumnIfMissing adds a column to a table created by an older version of the service.
+func addColumnIfMissing(session *gocqlx.Session, table string, column string, columnType string) error {
+	var name string
+	err := session.Query(`SELECT column_name FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ? AND column_name = ?`, nil).
+		Bind("messaging_keyspace", table, column).
+		Scan(&name)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, gocql.ErrNotFound) {
+		return err
+	}
+	return session.ExecStmt(fmt.Sprintf("ALTER TABLE %s ADD %s %s", table, column, columnType))
+}
@@ -0,0 +1,219 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+const (
+	// maxConversations and maxMessagesPerConversation keep digests readable, the rest is summed up as "more".
+	maxConversations           = 20
+	maxMessagesPerConversation = 10
+	// messagesScanned is how many messages of a conversation are read to find the ones shown,
+	// the user's own and deleted messages are skipped.
+	messagesScanned = 50
+	// maxCountedUnread bounds the count of unread messages per conversation.
+	maxCountedUnread = 1000
+	// schedulePageSize is how many due digests of a shard are read at a time.
+	schedulePageSize = 100
+)
+
+// Job emails each opted in user the messages they have not read since their previous digest.
+// Users are claimed before their digest is sent, so several instances can run the job side by side.
+type Job struct {
+	repo        repository.EmailDigestRepository
+	inboxRepo   repository.InboxRepository
+	receiptRepo repository.ReadReceiptsRepository
+	messageRepo repository.MessagesRepository
+	mailer      Mailer
+}
+
+func NewJob(repo repository.EmailDigestRepository, inboxRepo repository.InboxRepository, receiptRepo repository.ReadReceiptsRepository, messageRepo repository.MessagesRepository, mailer Mailer) *Job {
+	return &Job{repo: repo, inboxRepo: inboxRepo, receiptRepo: receiptRepo, messageRepo: messageRepo, mailer: mailer}
+}
+
+// Run sends the digests that are due every interval until ctx is cancelled.
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := j.RunOnce(ctx); err != nil {
+				slog.Error("Failed to send email digests", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunOnce sends the digests that are due now, going through the schedule rather than every user.
+// A user whose digest fails is retried on the next run.
+func (j *Job) RunOnce(ctx context.Context) error {
+	// timestamps are stored in milliseconds, keep now comparable with the stored ones
+	now := time.Now().Truncate(time.Millisecond)
+	var errs []error
+	for shard := 0; shard < models.DigestScheduleShards; shard++ {
+		if err := j.runShard(ctx, shard, now); err != nil {
+			errs = append(errs, fmt.Errorf("digest schedule shard %d: %w", shard, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runShard pages through the due digests of a shard. Digests due at the same millisecond as the
+// last of a page may be passed over, they are still due on the next run.
+func (j *Job) runShard(ctx context.Context, shard int, now time.Time) error {
+	var after time.Time
+	for {
+		due, err := j.repo.GetDueDigests(ctx, shard, after, now, schedulePageSize)
+		if err != nil {
+			return err
+		}
+		for _, schedule := range due {
+			if err := j.runScheduled(ctx, schedule, now); err != nil {
+				slog.Error("Failed to send an email digest", "user_id", schedule.UserID, "error", err)
+			}
+		}
+		if len(due) < schedulePageSize {
+			return nil
+		}
+		after = due[len(due)-1].DueAt
+	}
+}
+
+// runScheduled sends the scheduled digest, or drops the schedule row when the settings moved on since,
+// scheduling their actual next digest in case the row that should hold it was never written.
+func (j *Job) runScheduled(ctx context.Context, schedule models.EmailDigestSchedule, now time.Time) error {
+	settings, err := j.repo.GetDigestSettings(ctx, schedule.UserID)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return j.repo.UnscheduleDigest(ctx, schedule)
+	}
+	if err != nil {
+		return err
+	}
+	next, ok := settings.NextDigestAt()
+	if !ok || !next.Equal(schedule.DueAt) {
+		if err := j.repo.ScheduleDigest(ctx, settings); err != nil {
+			return err
+		}
+		return j.repo.UnscheduleDigest(ctx, schedule)
+	}
+	return j.send(ctx, settings, now)
+}
+
+// send claims the period from the previous digest up to now and mails what the user missed in it.
+// The claim is handed back when the digest cannot be sent.
+func (j *Job) send(ctx context.Context, settings models.EmailDigestSettings, now time.Time) error {
+	claimed, err := j.repo.ClaimDigest(ctx, settings, now)
+	if !claimed {
+		return err
+	}
+	if err != nil {
+		// only moving the schedule failed, the previous row is still there and gets the next digest scheduled
+		slog.Warn("Failed to schedule the next email digest", "user_id", settings.UserID, "error", err)
+	}
+
+	err = j.deliver(ctx, settings, now)
+	if err != nil {
+		sent := settings
+		sent.LastSentAt = now
+		if _, releaseErr := j.repo.ClaimDigest(ctx, sent, settings.LastSentAt); releaseErr != nil {
+			slog.Error("Failed to release an email digest", "user_id", settings.UserID, "error", releaseErr)
+		}
+	}
+	return err
+}
+
+func (j *Job) deliver(ctx context.Context, settings models.EmailDigestSettings, until time.Time) error {
+	data, err := j.gather(ctx, settings.UserID, settings.LastSentAt, until)
+	if err != nil {
+		return err
+	}
+	if data.Total == 0 {
+		return nil
+	}
+	data.Frequency = settings.Frequency
+
+	text, html, err := render(data)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("You have %d unread messages", data.Total)
+	if data.Total == 1 {
+		subject = "You have 1 unread message"
+	}
+	return j.mailer.Send(ctx, Email{To: settings.Email, Subject: subject, Text: text, HTML: html})
+}
+
+// gather collects the messages of others posted since the previous digest that the user has not read yet,
+// conversations with the latest activity first.
+func (j *Job) gather(ctx context.Context, userId gocql.UUID, since time.Time, until time.Time) (digestData, error) {
+	entries, err := j.inboxRepo.GetInbox(ctx, userId)
+	if err != nil {
+		return digestData{}, err
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].LastActivityAt.After(entries[b].LastActivityAt)
+	})
+
+	data := digestData{Since: since}
+	for _, entry := range entries {
+		if !entry.LastActivityAt.After(since) || entry.LastSenderID == userId {
+			// nothing new, or the user replied since, which means they have seen the conversation
+			continue
+		}
+
+		after := gocql.MinTimeUUID(since)
+		receipt, err := j.receiptRepo.GetReceipt(ctx, entry.ConversationID, userId)
+		if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+			return digestData{}, err
+		}
+		if receipt.ReadUpTo != (gocql.UUID{}) && models.TimeUUIDAfter(receipt.ReadUpTo, after) {
+			after = receipt.ReadUpTo
+		}
+
+		unread, err := j.messageRepo.CountMessagesAfter(ctx, entry.ConversationID, after, userId, maxCountedUnread)
+		if err != nil {
+			return digestData{}, err
+		}
+		if unread == 0 {
+			continue
+		}
+		data.Total += unread
+		if len(data.Conversations) == maxConversations {
+			continue
+		}
+
+		messages, err := j.messageRepo.GetMessagesAfter(ctx, entry.ConversationID, after, messagesScanned)
+		if err != nil {
+			return digestData{}, err
+		}
+		conversation := conversationDigest{ConversationID: entry.ConversationID}
+		for _, message := range messages {
+			if len(conversation.Messages) == maxMessagesPerConversation || message.CreatedAt.After(until) {
+				break
+			}
+			if message.SenderId == userId || message.IsSoftDeleted {
+				continue
+			}
+			conversation.Messages = append(conversation.Messages, digestMessage{
+				SenderID: message.SenderId,
+				Body:     message.Body,
+				SentAt:   message.CreatedAt,
+			})
+		}
+		conversation.More = max(unread-len(conversation.Messages), 0)
+		data.Conversations = append(data.Conversations, conversation)
+	}
+	return data, nil
+}
@@ -0,0 +1,153 @@
+package digest
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what the stub server received from the client.
+type smtpSession struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// startSMTPStub accepts a single SMTP session on a local port, without STARTTLS or AUTH, and hands
+// back what was sent once the client quits.
+func startSMTPStub(t *testing.T) (host string, port int, received <-chan smtpSession) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		text := textproto.NewConn(conn)
+		var session smtpSession
+		text.PrintfLine("220 localhost stub")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "MAIL":
+				session.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+				text.PrintfLine("250 OK")
+			case "RCPT":
+				session.to = append(session.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				if session.data, err = text.ReadDotBytes(); err != nil {
+					return
+				}
+				text.PrintfLine("250 OK")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				sessions <- session
+				return
+			default:
+				text.PrintfLine("502 Command not implemented")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, sessions
+}
+
+func TestSMTPMailerSendsMultipartEmail(t *testing.T) {
+	host, port, received := startSMTPStub(t)
+	mailer := NewSMTPMailer(host, port, "", "", "digests@example.com", 5*time.Second)
+
+	email := Email{
+		To:      "ada@example.com",
+		Subject: "You have 2 unread messages",
+		Text:    "You have 2 unread messages.\nA line long enough to be wrapped by the quoted-printable encoding, past its seventy six characters.\n",
+		HTML:    "<p>You have <strong>2</strong> unread messages from café = 2.</p>\n",
+	}
+	if err := mailer.Send(context.Background(), email); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var session smtpSession
+	select {
+	case session = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stub never saw the session end")
+	}
+	if session.from != "digests@example.com" {
+		t.Errorf("MAIL FROM = %q", session.from)
+	}
+	if len(session.to) != 1 || session.to[0] != email.To {
+		t.Errorf("RCPT TO = %v, want %q", session.to, email.To)
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(string(session.data)))
+	if err != nil {
+		t.Fatalf("parsing the message: %v", err)
+	}
+	if got := message.Header.Get("To"); got != email.To {
+		t.Errorf("To header = %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil || subject != email.Subject {
+		t.Errorf("Subject header = %q (%v), want %q", subject, err, email.Subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/alternative", message.Header.Get("Content-Type"), err)
+	}
+	parts := multipart.NewReader(message.Body, params["boundary"])
+
+	// plain text first, clients show the last part they can render
+	for _, want := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", email.Text},
+		{"text/html", email.HTML},
+	} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("reading the %s part: %v", want.contentType, err)
+		}
+		contentType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil || contentType != want.contentType || partParams["charset"] != "utf-8" {
+			t.Errorf("part Content-Type = %q, want %s in utf-8", part.Header.Get("Content-Type"), want.contentType)
+		}
+		// the reader undoes the quoted-printable encoding
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("reading the %s part: %v", want.contentType, err)
+		}
+		if string(body) != want.body {
+			t.Errorf("%s part = %q, want %q", want.contentType, body, want.body)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("expected two parts, next part error = %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package digest
+
+import (
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// digestData is what the templates render.
+type digestData struct {
+	Frequency     string
+	Since         time.Time
+	Total         int
+	Conversations []conversationDigest
+}
+
+// conversationDigest is the unread messages of one conversation, More counts those left out.
+type conversationDigest struct {
+	ConversationID gocql.UUID
+	Messages       []digestMessage
+	More           int
+}
+
+type digestMessage struct {
+	SenderID gocql.UUID
+	Body     string
+	SentAt   time.Time
+}
+
+var templateFuncs = map[string]interface{}{
+	"timestamp": func(t time.Time) string {
+		return t.UTC().Format("Jan 2, 15:04 MST")
+	},
+}
+
+var textTemplate = texttemplate.Must(texttemplate.New("digest.txt").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+You have {{.Total}} unread message{{if ne .Total 1}}s{{end}} since {{timestamp .Since}}.
+{{range .Conversations}}
+Conversation {{.ConversationID}}
+{{range .Messages}}  [{{timestamp .SentAt}}] {{.SenderID}}: {{.Body}}
+{{end}}{{if .More}}  and {{.More}} more
+{{end}}{{end}}
+You receive this {{.Frequency}} digest because you enabled email digests, turn them off in your settings.
+`) + "\n"))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(templateFuncs).Parse(strings.TrimSpace(`
+<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<p>You have {{.Total}} unread message{{if ne .Total 1}}s{{end}} since {{timestamp .Since}}.</p>
+{{range .Conversations}}
+<h3>Conversation {{.ConversationID}}</h3>
+<ul>
+{{range .Messages}}<li><small>{{timestamp .SentAt}}</small> <strong>{{.SenderID}}</strong>: {{.Body}}</li>
+{{end}}{{if .More}}<li>and {{.More}} more</li>
+{{end}}</ul>
+{{end}}
+<p><small>You receive this {{.Frequency}} digest because you enabled email digests, turn them off in your settings.</small></p>
+</body>
+</html>
+`) + "\n"))
+
+// render produces the plain text and HTML versions of a digest.
+func render(data digestData) (string, string, error) {
+	var text, html strings.Builder
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return "", "", err
+	}
+	if err := htmlTemplate.Execute(&html, data); err != nil {
+		return "", "", err
+	}
+	return text.String(), html.String(), nil
+}
+
+// confirmationData is what the confirmation templates render.
+type confirmationData struct {
+	Token string
+}
+
+var confirmationTextTemplate = texttemplate.Must(texttemplate.New("confirm.txt").Parse(strings.TrimSpace(`
+Someone asked for email digests of their unread messages to be sent to this address.
+To confirm it was you, enter this code in your email digest settings:
+
+  {{.Token}}
+
+If it was not you, ignore this email and no digests will be sent.
+`) + "\n"))
+
+var confirmationHTMLTemplate = htmltemplate.Must(htmltemplate.New("confirm.html").Parse(strings.TrimSpace(`
+<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<p>Someone asked for email digests of their unread messages to be sent to this address.
+To confirm it was you, enter this code in your email digest settings:</p>
+<p><code>{{.Token}}</code></p>
+<p><small>If it was not you, ignore this email and no digests will be sent.</small></p>
+</body>
+</html>
+`) + "\n"))
+
+// ConfirmationEmail asks the owner of an address to confirm they want digests sent to it.
+func ConfirmationEmail(to string, token string) (Email, error) {
+	data := confirmationData{Token: token}
+	var text, html strings.Builder
+	if err := confirmationTextTemplate.Execute(&text, data); err != nil {
+		return Email{}, err
+	}
+	if err := confirmationHTMLTemplate.Execute(&html, data); err != nil {
+		return Email{}, err
+	}
+	return Email{To: to, Subject: "Confirm your email digests", Text: text.String(), HTML: html.String()}, nil
+}
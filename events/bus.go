@@ -0,0 +1,154 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Handler reacts to a published event.
+type Handler func(ctx context.Context, event Event) error
+
+// Publisher is what producers of events depend on.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+type syncSubscription struct {
+	handler Handler
+	types   map[string]bool
+}
+
+type asyncSubscription struct {
+	name    string
+	handler Handler
+	types   map[string]bool
+	queue   chan queuedEvent
+}
+
+type queuedEvent struct {
+	ctx   context.Context
+	event Event
+}
+
+// Bus dispatches events in process. Synchronous subscribers run on the publisher's goroutine
+// before Publish returns, asynchronous ones get their own worker and a bounded queue.
+type Bus struct {
+	mu      sync.RWMutex
+	syncs   []syncSubscription
+	asyncs  []*asyncSubscription
+	closed  bool
+	workers sync.WaitGroup
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers a handler run synchronously for the given event types, or for every event when none are given.
+// Keep these cheap, they add to the latency of the write that published the event.
+func (b *Bus) Subscribe(handler Handler, eventTypes ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.syncs = append(b.syncs, syncSubscription{handler: handler, types: typeSet(eventTypes)})
+}
+
+// SubscribeAsync registers a handler run on its own goroutine, fed through a queue of queueSize events.
+// When the queue is full events for this subscriber are dropped and logged rather than slowing down writers.
+func (b *Bus) SubscribeAsync(name string, queueSize int, handler Handler, eventTypes ...string) {
+	sub := &asyncSubscription{
+		name:    name,
+		handler: handler,
+		types:   typeSet(eventTypes),
+		queue:   make(chan queuedEvent, queueSize),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.asyncs = append(b.asyncs, sub)
+	b.workers.Add(1)
+	go func() {
+		defer b.workers.Done()
+		for queued := range sub.queue {
+			if err := safeHandle(queued.ctx, sub.handler, queued.event); err != nil {
+				slog.Error("Event subscriber failed", "subscriber", sub.name, "type", queued.event.Type(), "error", err)
+			}
+		}
+	}()
+}
+
+// Publish hands the event to every interested subscriber. The errors of synchronous subscribers are
+// joined and returned, they do not stop the remaining subscribers from running.
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errors.New("event bus is closed")
+	}
+
+	var errs []error
+	for _, sub := range b.syncs {
+		if !sub.wants(event) {
+			continue
+		}
+		if err := safeHandle(ctx, sub.handler, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// async subscribers outlive the request that published the event
+	detached := context.WithoutCancel(ctx)
+	for _, sub := range b.asyncs {
+		if sub.types != nil && !sub.types[event.Type()] {
+			continue
+		}
+		select {
+		case sub.queue <- queuedEvent{ctx: detached, event: event}:
+		default:
+			slog.Warn("Dropping event for slow subscriber", "subscriber", sub.name, "type", event.Type())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops accepting events and waits for asynchronous subscribers to drain their queues.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	for _, sub := range b.asyncs {
+		close(sub.queue)
+	}
+	b.mu.Unlock()
+	b.workers.Wait()
+}
+
+func (s syncSubscription) wants(event Event) bool {
+	return s.types == nil || s.types[event.Type()]
+}
+
+// safeHandle keeps a panicking subscriber from taking down the publisher.
+func safeHandle(ctx context.Context, handler Handler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+	}()
+	return handler(ctx, event)
+}
+
+func typeSet(eventTypes []string) map[string]bool {
+	if len(eventTypes) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(eventTypes))
+	for _, eventType := range eventTypes {
+		set[eventType] = true
+	}
+	return set
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Envelope is the serialized form of an event, for events leaving the process.
+type Envelope struct {
+	Type           string          `json:"type"`
+	ConversationID gocql.UUID      `json:"conversation_id"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// Encode wraps the event in an envelope and serializes it.
+func Encode(event Event) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		Type:           event.Type(),
+		ConversationID: event.Conversation(),
+		OccurredAt:     event.OccurredAt(),
+		Data:           data,
+	})
+}
+
+// Decode turns the output of Encode back into the typed event.
+func Decode(payload []byte) (Event, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, err
+	}
+
+	var event Event
+	var err error
+	switch envelope.Type {
+	case TypeMessageCreated:
+		event, err = decodeData[MessageCreated](envelope.Data)
+	case TypeMessageUpdated:
+		event, err = decodeData[MessageUpdated](envelope.Data)
+	case TypeMessageDeleted:
+		event, err = decodeData[MessageDeleted](envelope.Data)
+	case TypeReactionAdded:
+		event, err = decodeData[ReactionAdded](envelope.Data)
+	case TypeReactionRemoved:
+		event, err = decodeData[ReactionRemoved](envelope.Data)
+	case TypeParticipantAdded:
+		event, err = decodeData[ParticipantAdded](envelope.Data)
+	case TypeReadReceiptAdvanced:
+		event, err = decodeData[ReadReceiptAdvanced](envelope.Data)
+	case TypePresenceChanged:
+		event, err = decodeData[PresenceChanged](envelope.Data)
+	case TypeTypingStarted:
+		event, err = decodeData[TypingStarted](envelope.Data)
+	case TypeTypingStopped:
+		event, err = decodeData[TypingStopped](envelope.Data)
+	default:
+		return nil, fmt.Errorf("unknown event type %q", envelope.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s event: %w", envelope.Type, err)
+	}
+	return event, nil
+}
+
+func decodeData[T Event](data json.RawMessage) (Event, error) {
+	var event T
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -0,0 +1,167 @@
+package events
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// Event types, also used as the type names on the wire.
+const (
+	TypeMessageCreated      = "message.created"
+	TypeMessageUpdated      = "message.updated"
+	TypeMessageDeleted      = "message.deleted"
+	TypeReactionAdded       = "reaction.added"
+	TypeReactionRemoved     = "reaction.removed"
+	TypeParticipantAdded    = "participant.added"
+	TypeReadReceiptAdvanced = "read_receipt.advanced"
+)
+
+// Realtime only event types. They are carried between instances to the clients connected right now,
+// but never go through the bus, get stored or leave the service, so they are not part of Types.
+const (
+	TypePresenceChanged = "presence.changed"
+	TypeTypingStarted   = "typing.started"
+	TypeTypingStopped   = "typing.stopped"
+)
+
+// Types lists every event type.
+var Types = []string{
+	TypeMessageCreated,
+	TypeMessageUpdated,
+	TypeMessageDeleted,
+	TypeReactionAdded,
+	TypeReactionRemoved,
+	TypeParticipantAdded,
+	TypeReadReceiptAdvanced,
+}
+
+// IsKnownType reports whether eventType is one of Types.
+func IsKnownType(eventType string) bool {
+	for _, known := range Types {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is a domain change that has been stored successfully.
+type Event interface {
+	// Type is one of the Type* constants.
+	Type() string
+	// Conversation is the conversation the change happened in, events are ordered per conversation.
+	Conversation() gocql.UUID
+	// OccurredAt is when the change was made.
+	OccurredAt() time.Time
+}
+
+type MessageCreated struct {
+	Message models.Message `json:"message"`
+	// Mentioned is everyone the message mentions, with @all and @here resolved, the sender excluded.
+	Mentioned []gocql.UUID `json:"mentioned,omitempty"`
+	At        time.Time    `json:"at"`
+}
+
+func (e MessageCreated) Type() string             { return TypeMessageCreated }
+func (e MessageCreated) Conversation() gocql.UUID { return e.Message.ConversationID }
+func (e MessageCreated) OccurredAt() time.Time    { return e.At }
+
+type MessageUpdated struct {
+	Message models.Message `json:"message"`
+	// Mentioned is who the edit mentions that the message did not mention before.
+	Mentioned []gocql.UUID `json:"mentioned,omitempty"`
+	At        time.Time    `json:"at"`
+}
+
+func (e MessageUpdated) Type() string             { return TypeMessageUpdated }
+func (e MessageUpdated) Conversation() gocql.UUID { return e.Message.ConversationID }
+func (e MessageUpdated) OccurredAt() time.Time    { return e.At }
+
+type MessageDeleted struct {
+	MessageID      gocql.UUID `json:"message_id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	SenderID       gocql.UUID `json:"sender_id"`
+	At             time.Time  `json:"at"`
+}
+
+func (e MessageDeleted) Type() string             { return TypeMessageDeleted }
+func (e MessageDeleted) Conversation() gocql.UUID { return e.ConversationID }
+func (e MessageDeleted) OccurredAt() time.Time    { return e.At }
+
+type ReactionAdded struct {
+	Reaction       models.Reaction `json:"reaction"`
+	ConversationID gocql.UUID      `json:"conversation_id"`
+	// MessageSenderID is the author of the message reacted to.
+	MessageSenderID gocql.UUID `json:"message_sender_id"`
+}
+
+func (e ReactionAdded) Type() string             { return TypeReactionAdded }
+func (e ReactionAdded) Conversation() gocql.UUID { return e.ConversationID }
+func (e ReactionAdded) OccurredAt() time.Time    { return e.Reaction.CreatedAt }
+
+type ReactionRemoved struct {
+	Reaction       models.Reaction `json:"reaction"`
+	ConversationID gocql.UUID      `json:"conversation_id"`
+	At             time.Time       `json:"at"`
+}
+
+func (e ReactionRemoved) Type() string             { return TypeReactionRemoved }
+func (e ReactionRemoved) Conversation() gocql.UUID { return e.ConversationID }
+func (e ReactionRemoved) OccurredAt() time.Time    { return e.At }
+
+type ParticipantAdded struct {
+	Participant models.Participant `json:"participant"`
+	// InvitedBy is the user who added the participant, zero when unknown or when they joined on their own.
+	InvitedBy gocql.UUID `json:"invited_by"`
+}
+
+func (e ParticipantAdded) Type() string             { return TypeParticipantAdded }
+func (e ParticipantAdded) Conversation() gocql.UUID { return e.Participant.ConversationID }
+func (e ParticipantAdded) OccurredAt() time.Time    { return e.Participant.JoinedAt }
+
+type ReadReceiptAdvanced struct {
+	Receipt models.ReadReceipt `json:"receipt"`
+	At      time.Time          `json:"at"`
+}
+
+func (e ReadReceiptAdvanced) Type() string             { return TypeReadReceiptAdvanced }
+func (e ReadReceiptAdvanced) Conversation() gocql.UUID { return e.Receipt.ConversationID }
+func (e ReadReceiptAdvanced) OccurredAt() time.Time    { return e.At }
+
+// PresenceChanged tells a conversation that one of its participants came online, went away or offline.
+type PresenceChanged struct {
+	UserID         gocql.UUID `json:"user_id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	Status         string     `json:"status"`
+	LastSeen       time.Time  `json:"last_seen"`
+}
+
+func (e PresenceChanged) Type() string             { return TypePresenceChanged }
+func (e PresenceChanged) Conversation() gocql.UUID { return e.ConversationID }
+func (e PresenceChanged) OccurredAt() time.Time    { return e.LastSeen }
+
+// TypingStarted tells a conversation that one of its participants is typing.
+type TypingStarted struct {
+	UserID         gocql.UUID `json:"user_id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	// ExpiresAt is when the indicator goes away unless typing is repeated.
+	ExpiresAt time.Time `json:"expires_at"`
+	At        time.Time `json:"at"`
+}
+
+func (e TypingStarted) Type() string             { return TypeTypingStarted }
+func (e TypingStarted) Conversation() gocql.UUID { return e.ConversationID }
+func (e TypingStarted) OccurredAt() time.Time    { return e.At }
+
+// TypingStopped tells a conversation that a participant stopped typing or their indicator expired.
+type TypingStopped struct {
+	UserID         gocql.UUID `json:"user_id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	At             time.Time  `json:"at"`
+}
+
+func (e TypingStopped) Type() string             { return TypeTypingStopped }
+func (e TypingStopped) Conversation() gocql.UUID { return e.ConversationID }
+func (e TypingStopped) OccurredAt() time.Time    { return e.At }
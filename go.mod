@@ -3,15 +3,25 @@ module github.com/yaninyzwitty/messaging-service
 go 1.22.1
 
 require (
+	github.com/alicebob/miniredis/v2 v2.35.0
 	github.com/gocql/gocql v0.0.0-20211015133455-b225f9b53fa1
+	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
+	github.com/redis/go-redis/v9 v9.7.3
 	github.com/scylladb/gocqlx v1.5.0
 	github.com/scylladb/gocqlx/v3 v3.0.1
+	github.com/segmentio/kafka-go v0.4.47
+	golang.org/x/image v0.18.0
 )
 
 require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
 	github.com/scylladb/go-reflectx v1.0.1 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	gopkg.in/inf.v0 v0.9.1 // indirect
 )
@@ -0,0 +1,13 @@
+package helpers
+
+import "time"
+
+// Backoff is the delay before the next attempt after the given number of failed ones:
+// base after the first failure, doubling with every further one, up to limit.
+func Backoff(attempts int, base time.Duration, limit time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
@@ -0,0 +1,25 @@
+package helpers
+
+import (
+	"crypto/sha256"
+
+	"github.com/gocql/gocql"
+)
+
+// DerivedTimeUUID returns a timeuuid with the time of base, whose clock sequence and node are hashed
+// from base and parts. The same inputs always give the same id, so rows keyed by it are written once
+// however often the write is replayed, and still sort by the time of base.
+func DerivedTimeUUID(base gocql.UUID, parts ...string) gocql.UUID {
+	h := sha256.New()
+	h.Write(base[:])
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	sum := h.Sum(nil)
+
+	id := base
+	copy(id[8:], sum)
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return id
+}
@@ -3,18 +3,33 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+	"github.com/yaninyzwitty/messaging-service/backplane"
 	"github.com/yaninyzwitty/messaging-service/configuration"
 	"github.com/yaninyzwitty/messaging-service/controller"
 	"github.com/yaninyzwitty/messaging-service/database"
+	"github.com/yaninyzwitty/messaging-service/digest"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/outbox"
+	"github.com/yaninyzwitty/messaging-service/processing"
+	"github.com/yaninyzwitty/messaging-service/push"
+	"github.com/yaninyzwitty/messaging-service/ratelimit"
+	"github.com/yaninyzwitty/messaging-service/realtime"
 	"github.com/yaninyzwitty/messaging-service/repository"
 	"github.com/yaninyzwitty/messaging-service/router"
+	"github.com/yaninyzwitty/messaging-service/scanning"
 	"github.com/yaninyzwitty/messaging-service/service"
+	"github.com/yaninyzwitty/messaging-service/storage"
+	"github.com/yaninyzwitty/messaging-service/webhooks"
 )
 
 func main() {
@@ -32,16 +47,146 @@ func main() {
 	defer session.Close()
 
 	messageRepo := repository.NewMessagesRepository(session)
-	messageService := service.NewMessagesService(messageRepo)
-	messageController := controller.NewMessageController(messageService)
+	reactionRepo := repository.NewReactionsRepository(session)
+	receiptRepo := repository.NewReadReceiptsRepository(session)
+	participantRepo := repository.NewParticipantsRepository(session)
+	inboxRepo := repository.NewInboxRepository(session)
+	attachmentRepo := repository.NewAttachmentsRepository(session)
+	uploadRepo := repository.NewUploadsRepository(session)
+	outboxRepo := repository.NewOutboxRepository(session)
+	webhookRepo := repository.NewWebhooksRepository(session)
+	incomingWebhookRepo := repository.NewIncomingWebhooksRepository(session)
+	presenceRepo := repository.NewPresenceRepository(session)
+	changeRepo := repository.NewChangesRepository(session)
+	deviceRepo := repository.NewDevicesRepository(session)
+	emailDigestRepo := repository.NewEmailDigestRepository(session)
+	notificationRepo := repository.NewNotificationsRepository(session)
+	mentionRepo := repository.NewMentionsRepository(session)
 
-	mux := router.NewRouter(messageController)
+	blobStore, err := newBlobStore(cfg)
+	if err != nil {
+		slog.Error("Error setting up the blob store", "error", err)
+		os.Exit(1)
+	}
+
+	hub := realtime.NewHub()
+	deliveryQueues := realtime.NewDeliveryQueues(hub, cfg.DELIVERY_QUEUE_SIZE, cfg.DELIVERY_QUEUE_MAX_AGE, cfg.DELIVERY_MAX_DEVICES)
+
+	publisher, err := newPublisher(cfg)
+	if err != nil {
+		slog.Error("Error setting up the event publisher", "error", err)
+		os.Exit(1)
+	}
+	dispatcher := outbox.NewDispatcher(outboxRepo, cfg.OUTBOX_POLL_INTERVAL, cfg.OUTBOX_BATCH_SIZE, cfg.OUTBOX_MAX_BACKOFF, cfg.OUTBOX_MAX_ATTEMPTS, cfg.OUTBOX_LEASE_TTL)
+	dispatcher.AddPublisher("publisher", publisher, events.TypeMessageCreated, events.TypeMessageUpdated, events.TypeMessageDeleted)
+
+	eventBackplane, err := newBackplane(cfg)
+	if err != nil {
+		slog.Error("Error setting up the realtime backplane", "error", err)
+		os.Exit(1)
+	}
+	// realtime clients may be connected to any instance, their events go through the backplane
+	eventBackplane.Subscribe(context.Background(), hub.HandleEvent)
+	// so may users, the backplane shares who is connected where when it spans instances
+	var presenceDirectory realtime.PresenceDirectory
+	if directory, ok := eventBackplane.(realtime.PresenceDirectory); ok {
+		presenceDirectory = directory
+	}
+	presenceTracker := realtime.NewPresenceTracker(eventBackplane, presenceRepo, presenceDirectory, cfg.PRESENCE_TTL, cfg.TYPING_TTL)
+
+	// services publish what they stored, other subsystems subscribe
+	bus := events.NewBus()
+	// a slow or unreachable redis must not hold up writes, the backplane gets its own queue
+	bus.SubscribeAsync("backplane", cfg.BACKPLANE_QUEUE_SIZE, eventBackplane.Publish)
+	// writes leave an outbox entry behind, deliver it without waiting for the next poll
+	bus.Subscribe(func(ctx context.Context, event events.Event) error {
+		dispatcher.Nudge()
+		return nil
+	}, events.TypeMessageCreated, events.TypeMessageUpdated, events.TypeMessageDeleted,
+		events.TypeReactionAdded, events.TypeReactionRemoved, events.TypeReadReceiptAdvanced, events.TypeParticipantAdded)
+	webhookDispatcher := webhooks.NewDispatcher(webhookRepo, participantRepo, cfg.WEBHOOK_WORKERS, cfg.WEBHOOK_QUEUE_SIZE, cfg.WEBHOOK_TIMEOUT, cfg.WEBHOOK_MAX_ATTEMPTS, cfg.WEBHOOK_DISABLE_AFTER, cfg.WEBHOOK_RETRY_INTERVAL)
+	// fed from the outbox too, so events are not lost when a delivery cannot be logged or the process stops
+	dispatcher.Subscribe("webhooks", webhookDispatcher.HandleEvent, events.Types...)
+	notifiers, err := newNotifiers(cfg)
+	if err != nil {
+		slog.Error("Error setting up push notifications", "error", err)
+		os.Exit(1)
+	}
+	pushDispatcher := push.NewDispatcher(deviceRepo, participantRepo, presenceTracker, notifiers, cfg.PUSH_COLLAPSE_WINDOW, cfg.PUSH_TIMEOUT, cfg.PUSH_WORKERS, cfg.PUSH_QUEUE_SIZE)
+	bus.SubscribeAsync("push", cfg.PUSH_QUEUE_SIZE, pushDispatcher.HandleEvent, events.TypeMessageCreated)
+	// fed from the outbox, which retries until the change is recorded, offline clients rely on the log being complete
+	changesService := service.NewChangesService(changeRepo, participantRepo)
+	dispatcher.Subscribe("changes", changesService.HandleEvent,
+		events.TypeMessageCreated, events.TypeMessageUpdated, events.TypeMessageDeleted,
+		events.TypeReactionAdded, events.TypeReactionRemoved, events.TypeReadReceiptAdvanced)
+	notificationService := service.NewNotificationsService(notificationRepo, messageRepo, participantRepo)
+	dispatcher.Subscribe("notifications", notificationService.HandleEvent,
+		events.TypeMessageCreated, events.TypeMessageUpdated, events.TypeReactionAdded, events.TypeParticipantAdded)
+
+	messageService := service.NewMessagesService(messageRepo, participantRepo, inboxRepo, attachmentRepo, mentionRepo, presenceTracker, bus)
+	reactionService := service.NewReactionsService(reactionRepo, messageRepo, bus)
+	receiptService := service.NewReadReceiptsService(receiptRepo, messageRepo, participantRepo, bus)
+	conversationService := service.NewConversationsService(participantRepo, inboxRepo, messageRepo, bus)
+	inboxService := service.NewInboxService(inboxRepo, receiptRepo, messageRepo)
+	webhookService := service.NewWebhooksService(webhookRepo, participantRepo)
+	deviceService := service.NewDevicesService(deviceRepo)
+	// digests and their confirmations are only mailed when an SMTP server is configured
+	var mailer digest.Mailer
+	if cfg.SMTP_HOST != "" {
+		mailer = digest.NewSMTPMailer(cfg.SMTP_HOST, cfg.SMTP_PORT, cfg.SMTP_USERNAME, cfg.SMTP_PASSWORD, cfg.SMTP_FROM, cfg.SMTP_TIMEOUT)
+	}
+	emailDigestService := service.NewEmailDigestService(emailDigestRepo, mailer)
+	incomingWebhookLimiter := newIncomingWebhookLimiter(cfg)
+	incomingWebhookService := service.NewIncomingWebhooksService(incomingWebhookRepo, participantRepo, messageService, incomingWebhookLimiter)
+	scanner, err := newScanner(cfg)
+	if err != nil {
+		slog.Error("Error setting up attachment scanning", "error", err)
+		os.Exit(1)
+	}
+
+	mediaPipeline := processing.NewPipeline(attachmentRepo, blobStore, scanner, cfg.MEDIA_WORKERS, cfg.MEDIA_QUEUE_SIZE, cfg.MEDIA_SWEEP_INTERVAL)
+	attachmentService := service.NewAttachmentsService(attachmentRepo, messageRepo, participantRepo, blobStore, mediaPipeline)
+	uploadService := service.NewUploadsService(uploadRepo, blobStore, attachmentService, cfg.TUS_MAX_SIZE, cfg.UPLOAD_EXPIRY)
+	messageController := controller.NewMessageController(messageService, reactionService, receiptService)
+	reactionController := controller.NewReactionController(reactionService)
+	receiptController := controller.NewReadReceiptController(receiptService)
+	conversationController := controller.NewConversationController(conversationService)
+	inboxController := controller.NewInboxController(inboxService)
+	attachmentController := controller.NewAttachmentController(attachmentService, cfg.MAX_UPLOAD_BYTES)
+	uploadController := controller.NewUploadController(uploadService)
+	realtimeController := controller.NewRealtimeController(hub, presenceTracker, deliveryQueues, conversationService, cfg.WS_SEND_BUFFER)
+	eventsController := controller.NewEventsController(hub, messageService, conversationService, cfg.WS_SEND_BUFFER)
+	syncController := controller.NewSyncController(hub, conversationService, changesService, cfg.SYNC_MAX_TIMEOUT)
+	webhookController := controller.NewWebhookController(webhookService)
+	incomingWebhookController := controller.NewIncomingWebhookController(incomingWebhookService)
+	changesController := controller.NewChangesController(changesService)
+	deviceController := controller.NewDeviceController(deviceService)
+	emailDigestController := controller.NewEmailDigestController(emailDigestService)
+	notificationController := controller.NewNotificationController(notificationService)
+
+	mux := router.NewRouter(messageController, reactionController, receiptController, conversationController, inboxController, attachmentController, uploadController, realtimeController, eventsController, syncController, webhookController, incomingWebhookController, changesController, deviceController, emailDigestController, notificationController)
 
 	server := &http.Server{
 		Addr:    ":" + cfg.PORT,
 		Handler: mux,
 	}
 
+	workerCTX, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	mediaPipeline.Start(workerCTX)
+	dispatcher.Start(workerCTX)
+	webhookDispatcher.Start(workerCTX)
+	pushDispatcher.Start(workerCTX)
+	go presenceTracker.Run(workerCTX)
+	go deliveryQueues.Run(workerCTX)
+	go service.RunUploadPurger(workerCTX, uploadService, time.Hour)
+	go service.RunChangeLogCompactor(workerCTX, changesService, cfg.CHANGE_LOG_COMPACT_INTERVAL)
+	go service.RunReactionCountRepair(workerCTX, reactionService, cfg.REACTION_COUNT_REPAIR_INTERVAL)
+	if mailer != nil {
+		digestJob := digest.NewJob(emailDigestRepo, inboxRepo, receiptRepo, messageRepo, mailer)
+		go digestJob.Run(workerCTX, cfg.DIGEST_INTERVAL)
+	}
+
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			slog.Error("Failed to start server: ", "error", err)
@@ -64,6 +209,134 @@ func main() {
 		slog.Error("Failed to gracefully shut down server", "error", err)
 
 	}
+	// no more uploads can come in, let the queued attachments finish
+	mediaPipeline.Stop()
+	// let asynchronous subscribers finish what was published before shutdown
+	bus.Close()
+	dispatcher.Stop()
+	webhookDispatcher.Stop()
+	pushDispatcher.Stop()
+	if err := eventBackplane.Close(); err != nil {
+		slog.Error("Failed to close the realtime backplane", "error", err)
+	}
+	if closer, ok := incomingWebhookLimiter.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error("Failed to close the incoming webhook rate limiter", "error", err)
+		}
+	}
+	if closer, ok := publisher.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error("Failed to close the event publisher", "error", err)
+		}
+	}
 	slog.Info("Server shutdown successful")
 
 }
+
+// newBlobStore picks the attachment storage backend from the configuration.
+func newBlobStore(cfg *configuration.Config) (storage.BlobStore, error) {
+	switch cfg.BLOB_STORE {
+	case "local":
+		return storage.NewLocalStore(cfg.BLOB_DIR)
+	case "s3":
+		return storage.NewS3Store(cfg.S3_ENDPOINT, cfg.S3_BUCKET, cfg.S3_REGION, cfg.S3_ACCESS_KEY, cfg.S3_SECRET_KEY)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.BLOB_STORE)
+	}
+}
+
+// newPublisher picks where the outbox dispatcher delivers events.
+func newPublisher(cfg *configuration.Config) (outbox.Publisher, error) {
+	switch cfg.OUTBOX_PUBLISHER {
+	case "log":
+		return outbox.LogPublisher{}, nil
+	case "kafka":
+		topics, err := outbox.ParseTopics(cfg.KAFKA_TOPICS)
+		if err != nil {
+			return nil, err
+		}
+		return outbox.NewKafkaPublisher(strings.Split(cfg.KAFKA_BROKERS, ","), cfg.KAFKA_TOPIC, topics, cfg.KAFKA_WRITE_TIMEOUT), nil
+	default:
+		return nil, fmt.Errorf("unknown outbox publisher %q", cfg.OUTBOX_PUBLISHER)
+	}
+}
+
+// newBackplane picks how realtime events are shared between instances.
+func newBackplane(cfg *configuration.Config) (backplane.Backplane, error) {
+	switch cfg.BACKPLANE {
+	case "memory":
+		return backplane.NewMemoryBackplane(), nil
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.REDIS_ADDR,
+			Password: cfg.REDIS_PASSWORD,
+			DB:       cfg.REDIS_DB,
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		b, err := backplane.NewRedisBackplane(ctx, client, cfg.BACKPLANE_CHANNEL)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unknown backplane %q", cfg.BACKPLANE)
+	}
+}
+
+// newIncomingWebhookLimiter keeps the buckets in redis when the instances share a redis
+// backplane, a single instance counts in memory.
+func newIncomingWebhookLimiter(cfg *configuration.Config) ratelimit.RateLimiter {
+	if cfg.BACKPLANE != "redis" {
+		return ratelimit.NewLimiter(cfg.INCOMING_WEBHOOK_RATE, cfg.INCOMING_WEBHOOK_BURST)
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.REDIS_ADDR,
+		Password: cfg.REDIS_PASSWORD,
+		DB:       cfg.REDIS_DB,
+	})
+	return ratelimit.NewRedisLimiter(client, "incoming-webhooks:", cfg.INCOMING_WEBHOOK_RATE, cfg.INCOMING_WEBHOOK_BURST)
+}
+
+// newNotifiers sets up the push platforms that have credentials configured.
+func newNotifiers(cfg *configuration.Config) (map[string]push.Notifier, error) {
+	notifiers := make(map[string]push.Notifier)
+	client := &http.Client{Timeout: cfg.PUSH_TIMEOUT}
+	if cfg.FCM_CREDENTIALS_FILE != "" {
+		account, err := push.LoadServiceAccount(cfg.FCM_CREDENTIALS_FILE)
+		if err != nil {
+			return nil, err
+		}
+		fcm, err := push.NewFCMNotifier(client, account, cfg.FCM_ENDPOINT)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[models.PlatformFCM] = fcm
+	}
+	if cfg.APNS_KEY_FILE != "" {
+		key, err := push.LoadAPNsKey(cfg.APNS_KEY_FILE)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[models.PlatformAPNs] = push.NewAPNsNotifier(client, key, cfg.APNS_KEY_ID, cfg.APNS_TEAM_ID, cfg.APNS_TOPIC, cfg.APNS_ENDPOINT)
+	}
+	return notifiers, nil
+}
+
+// newScanner chains the configured attachment scanners, in the order they are listed.
+func newScanner(cfg *configuration.Config) (scanning.Scanner, error) {
+	var chain scanning.Chain
+	for _, name := range strings.Split(cfg.SCANNERS, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "rules":
+			chain = append(chain, scanning.NewRulesScanner(cfg.SCAN_MAX_SIZE, strings.Split(cfg.SCAN_BLOCKED_EXTENSIONS, ",")))
+		case "clamav":
+			chain = append(chain, scanning.NewClamAVScanner(cfg.CLAMD_NETWORK, cfg.CLAMD_ADDRESS, cfg.CLAMD_TIMEOUT))
+		default:
+			return nil, fmt.Errorf("unknown scanner %q", name)
+		}
+	}
+	return chain, nil
+}
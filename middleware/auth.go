@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+type contextKey string
+
+const userIDKey contextKey = "user_id"
+
+// UserIDHeader carries the id of the user making the request.
+const UserIDHeader = "X-User-ID"
+
+// AuthMiddleware resolves the caller from the X-User-ID header and stores it on the request context.
+// Requests without the header pass through anonymously, a malformed header is rejected.
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userIdStr := r.Header.Get(UserIDHeader)
+		if userIdStr == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userId, err := gocql.ParseUUID(userIdStr)
+		if err != nil {
+			http.Error(w, "Invalid "+UserIDHeader+" header", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), userId)))
+	})
+}
+
+// WithUserID returns a copy of ctx carrying the given user id.
+func WithUserID(ctx context.Context, userId gocql.UUID) context.Context {
+	return context.WithValue(ctx, userIDKey, userId)
+}
+
+// UserIDFromContext returns the caller's user id, if the request was authenticated.
+func UserIDFromContext(ctx context.Context) (gocql.UUID, bool) {
+	userId, ok := ctx.Value(userIDKey).(gocql.UUID)
+	return userId, ok
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// Processing states of an attachment.
+const (
+	AttachmentStatusPending     = "pending"
+	AttachmentStatusReady       = "ready"
+	AttachmentStatusFailed      = "failed" //clean, but media extraction failed
+	AttachmentStatusQuarantined = "quarantined"
+)
+
+type Attachment struct {
+	ID          gocql.UUID `json:"id"`
+	MessageID   gocql.UUID `json:"message_id"`
+	UploaderID  gocql.UUID `json:"uploader_id"`
+	Filename    string     `json:"filename"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Checksum    string     `json:"checksum"` //hex encoded sha256 of the content
+	StorageKey  string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	Status       string `json:"status"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	ThumbnailKey string `json:"-"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty" db:"-"`
+
+	QuarantineReason string `json:"-"`
+}
+
+var attachmentMetadata = table.Metadata{
+	Name: "messaging_keyspace.attachments",
+	Columns: []string{
+		"id",                //id for the attachment
+		"message_id",        //id of the message the attachment is linked to, empty until linked
+		"uploader_id",       //id of the user who uploaded the attachment
+		"filename",          //original name of the uploaded file
+		"content_type",      //media type of the content
+		"size",              //size of the content in bytes
+		"checksum",          //sha256 of the content
+		"storage_key",       //key of the content in the blob store
+		"created_at",        //time when the attachment was uploaded
+		"status",            //processing state, see AttachmentStatus*
+		"width",             //width in pixels for images
+		"height",            //height in pixels for images
+		"thumbnail_key",     //key of the thumbnail in the blob store, empty when there is none
+		"quarantine_reason", //why the scanners rejected the attachment
+	},
+	PartKey: []string{"id"},
+}
+
+var AttachmentTable = table.New(attachmentMetadata)
+
+var attachmentByMessageMetadata = table.Metadata{
+	Name: "messaging_keyspace.attachments_by_message",
+	Columns: []string{
+		"message_id", //id of the message
+		"id",         //id of the attachment
+	},
+	PartKey: []string{"message_id"},
+	SortKey: []string{"id"},
+}
+
+var AttachmentByMessageTable = table.New(attachmentByMessageMetadata)
+
+// pending_attachments lists the attachments waiting to be processed, so the ones the pipeline
+// never got to can be picked up again
+var pendingAttachmentMetadata = table.Metadata{
+	Name: "messaging_keyspace.pending_attachments",
+	Columns: []string{
+		"shard", //always 0, pending attachments are few and short lived
+		"id",    //id of the attachment, a timeuuid
+	},
+	PartKey: []string{"shard"},
+	SortKey: []string{"id"},
+}
+
+var PendingAttachmentTable = table.New(pendingAttachmentMetadata)
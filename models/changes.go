@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// Entities tracked in the change log.
+const (
+	ChangeEntityMessage    = "message"
+	ChangeEntityReaction   = "reaction"
+	ChangeEntityReadMarker = "read_marker"
+)
+
+// Change operations, a delete is a tombstone without an entity.
+const (
+	ChangeOpUpsert = "upsert"
+	ChangeOpDelete = "delete"
+)
+
+// ChangeRetention is how long change log entries are kept, it matches the table's default_time_to_live.
+// Clients that have been offline for longer have to sync from scratch.
+const ChangeRetention = 30 * 24 * time.Hour
+
+// Change is one entry of a user's change log, the latest state of an entity or its tombstone.
+type Change struct {
+	UserID         gocql.UUID      `json:"-"`
+	ID             gocql.UUID      `json:"cursor"`
+	ConversationID gocql.UUID      `json:"conversation_id"`
+	EntityType     string          `json:"entity_type"`
+	EntityID       string          `json:"entity_id"`
+	Op             string          `json:"op"`
+	Data           []byte          `json:"-"`
+	ChangedAt      time.Time       `json:"changed_at"`
+	Entity         json.RawMessage `json:"entity,omitempty" db:"-"`
+}
+
+// ChangeKey identifies the entity a change is about, compaction keeps the newest change per key.
+func (c Change) ChangeKey() string {
+	return c.EntityType + "/" + c.EntityID
+}
+
+var changeMetadata = table.Metadata{
+	Name: "messaging_keyspace.changes_by_user",
+	Columns: []string{
+		"user_id",         //id of the user whose devices sync the change
+		"id",              //timeuuid of the change, doubles as the sync cursor
+		"conversation_id", //id of the conversation the entity belongs to
+		"entity_type",     //message, reaction or read_marker
+		"entity_id",       //id of the entity, composite for reactions and read markers
+		"op",              //upsert or delete
+		"data",            //JSON of the entity, empty for deletes
+		"changed_at",      //time of the change
+	},
+	PartKey: []string{"user_id"},
+	SortKey: []string{"id"},
+}
+
+var ChangeTable = table.New(changeMetadata)
+
+// ChangeSet is a page of a user's change log. Cursor is where the next request continues, Reset is set
+// when the requested cursor is older than the retained log and the client has to sync from scratch.
+type ChangeSet struct {
+	Changes []Change `json:"changes"`
+	Cursor  string   `json:"cursor"`
+	HasMore bool     `json:"has_more"`
+	Reset   bool     `json:"reset"`
+}
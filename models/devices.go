@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// Push platforms a device token belongs to.
+const (
+	PlatformFCM  = "fcm"
+	PlatformAPNs = "apns"
+)
+
+// DeviceToken is a push token of one of a user's devices.
+type DeviceToken struct {
+	UserID       gocql.UUID `json:"user_id"`
+	Token        string     `json:"token"`
+	Platform     string     `json:"platform"`
+	RegisteredAt time.Time  `json:"registered_at"`
+}
+
+var deviceTokenMetadata = table.Metadata{
+	Name: "messaging_keyspace.device_tokens",
+	Columns: []string{
+		"user_id",       //id of the user the device is signed in as
+		"token",         //push token issued by the platform
+		"platform",      //fcm or apns
+		"registered_at", //time the token was last registered
+	},
+	PartKey: []string{"user_id"},
+	SortKey: []string{"token"},
+}
+
+var DeviceTokenTable = table.New(deviceTokenMetadata)
+
+// device_token_owners finds who a token is registered to, a device signing in as someone else
+// must stop receiving the previous user's notifications
+var deviceTokenOwnerMetadata = table.Metadata{
+	Name: "messaging_keyspace.device_token_owners",
+	Columns: []string{
+		"token",   //push token issued by the platform
+		"user_id", //id of the user the token is registered to
+	},
+	PartKey: []string{"token"},
+}
+
+var DeviceTokenOwnerTable = table.New(deviceTokenOwnerMetadata)
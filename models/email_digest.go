@@ -0,0 +1,99 @@
+package models
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// How often a user receives the digest of their unread messages.
+const (
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestPeriods is the time between two digests of each frequency.
+var DigestPeriods = map[string]time.Duration{
+	DigestHourly: time.Hour,
+	DigestDaily:  24 * time.Hour,
+	DigestWeekly: 7 * 24 * time.Hour,
+}
+
+// EmailDigestSettings is a user's opt in to email digests of the messages they have not read.
+// Digests only go out once the user confirmed the address with the token mailed to it.
+type EmailDigestSettings struct {
+	UserID           gocql.UUID `json:"user_id"`
+	Email            string     `json:"email"`
+	Enabled          bool       `json:"enabled"`
+	Confirmed        bool       `json:"confirmed"`
+	ConfirmationHash string     `json:"-"` //hex encoded sha256 of the confirmation token
+	Frequency        string     `json:"frequency"`
+	LastSentAt       time.Time  `json:"last_sent_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// NextDigestAt is when the next digest goes out, ok is false when none will.
+func (s EmailDigestSettings) NextDigestAt() (next time.Time, ok bool) {
+	period, ok := DigestPeriods[s.Frequency]
+	if !s.Enabled || !s.Confirmed || !ok {
+		return time.Time{}, false
+	}
+	return s.LastSentAt.Add(period), true
+}
+
+// Due reports whether the next digest should go out at the given time.
+func (s EmailDigestSettings) Due(now time.Time) bool {
+	next, ok := s.NextDigestAt()
+	return ok && !next.After(now)
+}
+
+var emailDigestSettingsMetadata = table.Metadata{
+	Name: "messaging_keyspace.email_digest_settings",
+	Columns: []string{
+		"user_id",           //id of the user receiving the digest
+		"email",             //address the digest is sent to
+		"enabled",           //whether the user opted in
+		"confirmed",         //whether the user confirmed the address
+		"confirmation_hash", //sha256 of the token confirming the address, empty once confirmed
+		"frequency",         //hourly, daily or weekly
+		"last_sent_at",      //time covered by the previous digest, the next one starts there
+		"updated_at",        //time the settings were last changed
+	},
+	PartKey: []string{"user_id"},
+}
+
+var EmailDigestSettingsTable = table.New(emailDigestSettingsMetadata)
+
+// DigestScheduleShards is the number of schedule partitions, changing it strands the rows of the dropped shards.
+const DigestScheduleShards = 16
+
+// EmailDigestSchedule is the next digest of a user, indexed by when it is due so the digest job
+// only reads the users it has to mail.
+type EmailDigestSchedule struct {
+	Shard  int        `json:"shard"`
+	DueAt  time.Time  `json:"due_at"`
+	UserID gocql.UUID `json:"user_id"`
+}
+
+// DigestScheduleShard spreads users over the schedule partitions.
+func DigestScheduleShard(userId gocql.UUID) int {
+	h := fnv.New32a()
+	h.Write(userId[:])
+	return int(h.Sum32() % DigestScheduleShards)
+}
+
+var emailDigestScheduleMetadata = table.Metadata{
+	Name: "messaging_keyspace.email_digest_schedule",
+	Columns: []string{
+		"shard",   //partition of the schedule, derived from the user
+		"due_at",  //time the next digest goes out
+		"user_id", //id of the user receiving the digest
+	},
+	PartKey: []string{"shard"},
+	SortKey: []string{"due_at", "user_id"},
+}
+
+var EmailDigestScheduleTable = table.New(emailDigestScheduleMetadata)
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// InboxEntry is one conversation in a user's inbox, denormalized from the latest message.
+type InboxEntry struct {
+	UserID             gocql.UUID `json:"user_id"`
+	ConversationID     gocql.UUID `json:"conversation_id"`
+	LastMessageID      gocql.UUID `json:"last_message_id"`
+	LastSenderID       gocql.UUID `json:"last_sender_id"`
+	LastMessagePreview string     `json:"last_message_preview"`
+	LastActivityAt     time.Time  `json:"last_activity_at"`
+
+	UnreadCount int `json:"unread_count" db:"-"`
+}
+
+var inboxMetadata = table.Metadata{
+	Name: "messaging_keyspace.inbox_by_user",
+	Columns: []string{
+		"user_id",              //id of the inbox owner
+		"conversation_id",      //id of the conversation
+		"last_message_id",      //timeuuid of the latest message
+		"last_sender_id",       //id of the sender of the latest message
+		"last_message_preview", //truncated body of the latest message
+		"last_activity_at",     //time of the latest activity in the conversation
+	},
+	PartKey: []string{"user_id"},
+	SortKey: []string{"conversation_id"},
+}
+
+var InboxTable = table.New(inboxMetadata)
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// IncomingWebhook lets an external tool post messages into a conversation as a bot.
+type IncomingWebhook struct {
+	ID             gocql.UUID `json:"id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	CreatorID      gocql.UUID `json:"creator_id"`
+	Name           string     `json:"name"`
+	BotID          gocql.UUID `json:"bot_id"` //sender of the messages posted through the webhook
+	TokenHash      string     `json:"-"`      //hex encoded sha256 of the token, the token itself is not stored
+	Revoked        bool       `json:"revoked"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RevokedAt      time.Time  `json:"revoked_at"`
+
+	// Token and URL are only returned when the webhook is created.
+	Token string `json:"token,omitempty" db:"-"`
+	URL   string `json:"url,omitempty" db:"-"`
+}
+
+var incomingWebhookColumns = []string{
+	"id",              //id for the incoming webhook
+	"conversation_id", //conversation the messages are posted to
+	"creator_id",      //id of the user who created the webhook
+	"name",            //display name of the bot
+	"bot_id",          //sender id of the posted messages
+	"token_hash",      //sha256 of the secret token
+	"revoked",         //whether the webhook was revoked
+	"created_at",      //time when the webhook was created
+	"revoked_at",      //time when the webhook was revoked
+}
+
+var incomingWebhookMetadata = table.Metadata{
+	Name:    "messaging_keyspace.incoming_webhooks",
+	Columns: incomingWebhookColumns,
+	PartKey: []string{"id"},
+}
+
+var IncomingWebhookTable = table.New(incomingWebhookMetadata)
+
+// incoming_webhooks_by_conversation mirrors incoming_webhooks to list the webhooks of a conversation
+var incomingWebhookByConversationMetadata = table.Metadata{
+	Name:    "messaging_keyspace.incoming_webhooks_by_conversation",
+	Columns: incomingWebhookColumns,
+	PartKey: []string{"conversation_id"},
+	SortKey: []string{"id"},
+}
+
+var IncomingWebhookByConversationTable = table.New(incomingWebhookByConversationMetadata)
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// Mentions that address a group rather than a user, written as @all and @here.
+const (
+	MentionAll  = "all"
+	MentionHere = "here"
+)
+
+// How a user came to be mentioned.
+const (
+	MentionKindUser = "user"
+	MentionKindAll  = "all"
+	MentionKindHere = "here"
+)
+
+// Mention records that a message mentioned a user, directly or through @all or @here.
+type Mention struct {
+	UserID         gocql.UUID `json:"user_id"`
+	MessageID      gocql.UUID `json:"message_id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	SenderID       gocql.UUID `json:"sender_id"`
+	Kind           string     `json:"kind"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+var mentionMetadata = table.Metadata{
+	Name: "messaging_keyspace.mentions_by_user",
+	Columns: []string{
+		"user_id",         //id of the mentioned user
+		"message_id",      //timeuuid of the mentioning message, doubles as the pagination cursor
+		"conversation_id", //id of the conversation of the message
+		"sender_id",       //id of the user who wrote the mention
+		"kind",            //user, all or here
+		"created_at",      //time the message was posted
+	},
+	PartKey: []string{"user_id"},
+	SortKey: []string{"message_id"},
+}
+
+var MentionTable = table.New(mentionMetadata)
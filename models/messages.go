@@ -5,6 +5,7 @@ import (
 
 	"github.com/gocql/gocql"
 	"github.com/scylladb/gocqlx/table"
+	"github.com/yaninyzwitty/messaging-service/richtext"
 )
 
 type Message struct {
@@ -15,6 +16,19 @@ type Message struct {
 	UpdatedAt      time.Time  `json:"updated_at"`
 	Body           string     `json:"body"`
 	IsSoftDeleted  bool       `json:"is_soft_deleted"`
+	Seq            int64      `json:"seq"`
+	ReplyToID      gocql.UUID `json:"reply_to_id"`        //zero when the message is not a reply
+	Mentions       []string   `json:"mentions,omitempty"` //mentioned user ids, "all" and "here", as written in the body
+
+	AttachmentIDs  []gocql.UUID      `json:"attachment_ids,omitempty" db:"-"`
+	Attachments    []Attachment      `json:"attachments,omitempty" db:"-"`
+	Reactions      []ReactionSummary `json:"reactions,omitempty" db:"-"`
+	DeliveryStatus string            `json:"delivery_status,omitempty" db:"-"`
+	// set by the services posting as a bot, bots send into a conversation without taking part in it
+	SentByBot bool `json:"-" db:"-"`
+	// the body rendered on request, see ?format= on the message endpoints
+	BodyHTML       string             `json:"body_html,omitempty" db:"-"`
+	BodyStructured *richtext.Document `json:"body_structured,omitempty" db:"-"`
 }
 
 // CHECK IF THIS WILL WORK
@@ -33,9 +47,67 @@ var messageMetadata = table.Metadata{
 		"updated_at",      //time when the message
 		"body",            //body of the message
 		"is_soft_deleted", //whether the message is soft deleted or not
+		"seq",             //position of the message in its conversation, never reused; deleted messages and failed writes leave gaps
+		"reply_to_id",     //id of the message replied to, if any
+		"mentions",        //user ids, all and here mentioned in the body
 	},
 	PartKey: []string{"id"},
 	SortKey: []string{"conversation_id"},
 }
 
 var MessageTable = table.New(messageMetadata)
+
+// messages_by_conversation mirrors messages, clustered by timeuuid so a conversation can be read in order
+var messageByConversationMetadata = table.Metadata{
+	Name: "messaging_keyspace.messages_by_conversation",
+	Columns: []string{
+		"conversation_id", //id for the conversation
+		"id",              //id for the message, a timeuuid
+		"sender_id",       //id for the sender
+		"created_at",      //time when the message was created
+		"updated_at",      //time when the message was last updated
+		"body",            //body of the message
+		"is_soft_deleted", //whether the message is soft deleted or not
+		"seq",             //position of the message in its conversation
+		"reply_to_id",     //id of the message replied to, if any
+		"mentions",        //user ids, all and here mentioned in the body
+	},
+	PartKey: []string{"conversation_id"},
+	SortKey: []string{"id"},
+}
+
+var MessageByConversationTable = table.New(messageByConversationMetadata)
+
+// MessageSequence maps a position in a conversation to its message, for reading ranges of sequence numbers.
+type MessageSequence struct {
+	ConversationID gocql.UUID `json:"conversation_id"`
+	Seq            int64      `json:"seq"`
+	ReplyToID      gocql.UUID `json:"reply_to_id"`        //zero when the message is not a reply
+	Mentions       []string   `json:"mentions,omitempty"` //mentioned user ids, "all" and "here", as written in the body
+	ID             gocql.UUID `json:"id"`
+}
+
+var messageBySequenceMetadata = table.Metadata{
+	Name: "messaging_keyspace.messages_by_sequence",
+	Columns: []string{
+		"conversation_id", //id for the conversation
+		"seq",             //position of the message in the conversation
+		"id",              //id for the message
+	},
+	PartKey: []string{"conversation_id"},
+	SortKey: []string{"seq"},
+}
+
+var MessageBySequenceTable = table.New(messageBySequenceMetadata)
+
+// the last sequence number handed out per conversation, only ever advanced through LWT
+var conversationSequenceMetadata = table.Metadata{
+	Name: "messaging_keyspace.conversation_sequences",
+	Columns: []string{
+		"conversation_id", //id for the conversation
+		"last_seq",        //sequence number of the newest message
+	},
+	PartKey: []string{"conversation_id"},
+}
+
+var ConversationSequenceTable = table.New(conversationSequenceMetadata)
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// Kinds of in-app notifications.
+const (
+	NotificationMention  = "mention"
+	NotificationReply    = "reply"
+	NotificationReaction = "reaction"
+	NotificationInvite   = "invite"
+)
+
+// NotificationRetention is how long notifications are kept, it matches the table's default_time_to_live.
+const NotificationRetention = 30 * 24 * time.Hour
+
+// Notification tells a user that someone mentioned them, replied or reacted to their message, or added
+// them to a conversation.
+type Notification struct {
+	UserID         gocql.UUID `json:"user_id"`
+	ID             gocql.UUID `json:"id"`
+	Type           string     `json:"type"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	MessageID      gocql.UUID `json:"message_id"` //zero for invites
+	ActorID        gocql.UUID `json:"actor_id"`
+	Preview        string     `json:"preview"`
+	IsRead         bool       `json:"is_read"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+var notificationMetadata = table.Metadata{
+	Name: "messaging_keyspace.notifications_by_user",
+	Columns: []string{
+		"user_id",         //id of the user notified
+		"id",              //timeuuid of the notification, doubles as the pagination cursor
+		"type",            //mention, reply, reaction or invite
+		"conversation_id", //id of the conversation it happened in
+		"message_id",      //id of the message mentioning, replying or reacted to
+		"actor_id",        //id of the user who caused the notification
+		"preview",         //truncated message body, or the emoji of a reaction
+		"is_read",         //whether the user has seen it
+		"created_at",      //time of the notification
+	},
+	PartKey: []string{"user_id"},
+	SortKey: []string{"id"},
+}
+
+var NotificationTable = table.New(notificationMetadata)
+
+// NotificationPage is a page of a user's notifications, newest first. NextCursor is where the next
+// page starts, empty on the last page.
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor"`
+}
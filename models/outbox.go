@@ -0,0 +1,111 @@
+package models
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+const (
+	// OutboxShards is the number of outbox shards, changing it strands the rows of the dropped shards.
+	OutboxShards = 16
+	// OutboxBucketSize is the span of time whose entries share a partition of a shard. Dispatchers move on
+	// to the next bucket once the entries of one are settled, so they never read the deleted rows again.
+	OutboxBucketSize = time.Hour
+)
+
+// OutboxEntry is an event waiting to be published, written in the same batch as the change it describes,
+// or right after it for changes made by a lightweight transaction.
+type OutboxEntry struct {
+	Shard          int        `json:"shard"`
+	Bucket         int64      `json:"bucket"`
+	ID             gocql.UUID `json:"id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	EventType      string     `json:"event_type"`
+	Payload        []byte     `json:"payload"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastError      string     `json:"last_error"`
+	Delivered      []string   `json:"delivered"`
+}
+
+// OutboxShard spreads conversations over the outbox partitions, the events of one conversation
+// always land in the same partition so they are published in order.
+func OutboxShard(conversationId gocql.UUID) int {
+	h := fnv.New32a()
+	h.Write(conversationId[:])
+	return int(h.Sum32() % OutboxShards)
+}
+
+// OutboxBucket numbers the bucket holding the entries created at t.
+func OutboxBucket(t time.Time) int64 {
+	return t.Unix() / int64(OutboxBucketSize/time.Second)
+}
+
+// OutboxBucketStart is the time the given bucket starts at.
+func OutboxBucketStart(bucket int64) time.Time {
+	return time.Unix(bucket*int64(OutboxBucketSize/time.Second), 0)
+}
+
+var outboxMetadata = table.Metadata{
+	Name: "messaging_keyspace.outbox",
+	Columns: []string{
+		"shard",           //shard of the outbox, derived from the conversation
+		"bucket",          //time bucket of the entry within its shard, derived from the id
+		"id",              //timeuuid of the entry, publishing follows its order
+		"conversation_id", //conversation the event belongs to, used as the publishing key
+		"event_type",      //type of the event
+		"payload",         //encoded event envelope
+		"created_at",      //time the entry was written
+		"attempts",        //failed publishing attempts so far
+		"next_attempt_at", //earliest time of the next attempt
+		"last_error",      //error of the last failed attempt
+		"delivered",       //consumers that already handled the entry, skipped on retries
+	},
+	PartKey: []string{"shard", "bucket"},
+	SortKey: []string{"id"},
+}
+
+var OutboxTable = table.New(outboxMetadata)
+
+// OutboxDeadLetterTable holds the entries the dispatcher gave up on, with the same columns as the outbox.
+// They are only ever inserted, so one partition per shard is enough.
+var OutboxDeadLetterTable = table.New(table.Metadata{
+	Name:    "messaging_keyspace.outbox_dead_letters",
+	Columns: outboxMetadata.Columns,
+	PartKey: []string{"shard"},
+	SortKey: []string{"id"},
+})
+
+// OutboxCursor is where the dispatcher resumes reading a shard: every entry up to position is settled.
+type OutboxCursor struct {
+	Shard    int        `json:"shard"`
+	Position gocql.UUID `json:"position"`
+}
+
+var OutboxCursorTable = table.New(table.Metadata{
+	Name: "messaging_keyspace.outbox_cursors",
+	Columns: []string{
+		"shard",    //shard of the outbox
+		"position", //timeuuid up to which every entry of the shard is settled
+	},
+	PartKey: []string{"shard"},
+})
+
+// OutboxLease gives a single dispatcher the right to deliver the entries of a shard until it expires.
+type OutboxLease struct {
+	Shard int    `json:"shard"`
+	Owner string `json:"owner"`
+}
+
+var OutboxLeaseTable = table.New(table.Metadata{
+	Name: "messaging_keyspace.outbox_leases",
+	Columns: []string{
+		"shard", //shard of the outbox
+		"owner", //dispatcher holding the lease, the row expires with the lease
+	},
+	PartKey: []string{"shard"},
+})
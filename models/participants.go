@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+type Participant struct {
+	ConversationID gocql.UUID `json:"conversation_id"`
+	UserID         gocql.UUID `json:"user_id"`
+	JoinedAt       time.Time  `json:"joined_at"`
+}
+
+var participantMetadata = table.Metadata{
+	Name: "messaging_keyspace.participants",
+	Columns: []string{
+		"conversation_id", //id of the conversation
+		"user_id",         //id of the participating user
+		"joined_at",       //time when the user joined the conversation
+	},
+	PartKey: []string{"conversation_id"},
+	SortKey: []string{"user_id"},
+}
+
+var ParticipantTable = table.New(participantMetadata)
+
+// participants_by_user mirrors participants by user, listing the conversations a user takes part in
+var participantByUserMetadata = table.Metadata{
+	Name: "messaging_keyspace.participants_by_user",
+	Columns: []string{
+		"user_id",         //id of the participating user
+		"conversation_id", //id of the conversation
+		"joined_at",       //time when the user joined the conversation
+	},
+	PartKey: []string{"user_id"},
+	SortKey: []string{"conversation_id"},
+}
+
+var ParticipantByUserTable = table.New(participantByUserMetadata)
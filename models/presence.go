@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// LastSeen is when a user was last connected, live presence is kept in memory.
+type LastSeen struct {
+	UserID   gocql.UUID `json:"user_id"`
+	LastSeen time.Time  `json:"last_seen"`
+}
+
+var lastSeenMetadata = table.Metadata{
+	Name: "messaging_keyspace.last_seen",
+	Columns: []string{
+		"user_id",   //id of the user
+		"last_seen", //time the user was last connected
+	},
+	PartKey: []string{"user_id"},
+}
+
+var LastSeenTable = table.New(lastSeenMetadata)
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+type Reaction struct {
+	MessageID gocql.UUID `json:"message_id"`
+	Emoji     string     `json:"emoji"`
+	UserID    gocql.UUID `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ReactionSummary is the aggregate view of one emoji on a message.
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int64  `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+var reactionMetadata = table.Metadata{
+	Name: "messaging_keyspace.reactions",
+	Columns: []string{
+		"message_id", //id of the message being reacted to
+		"emoji",      //the reaction itself
+		"user_id",    //id of the user who reacted
+		"created_at", //time when the reaction was added
+	},
+	PartKey: []string{"message_id"},
+	SortKey: []string{"emoji", "user_id"},
+}
+
+var ReactionTable = table.New(reactionMetadata)
+
+var reactionCountMetadata = table.Metadata{
+	Name: "messaging_keyspace.reaction_counts",
+	Columns: []string{
+		"message_id", //id of the message being reacted to
+		"emoji",      //the reaction itself
+		"count",      //counter of users who reacted with the emoji
+	},
+	PartKey: []string{"message_id"},
+	SortKey: []string{"emoji"},
+}
+
+var ReactionCountTable = table.New(reactionCountMetadata)
+
+// ReactionCountCheckShards is the number of check partitions, changing it strands the rows of the dropped shards.
+const ReactionCountCheckShards = 16
+
+// ReactionCountCheck marks a message whose counters changed, so the repair compares them with the
+// reaction rows once the changes settled. Counter updates cannot be made conditional or idempotent,
+// a write failing or timing out after the reaction's LWT leaves them off by one.
+type ReactionCountCheck struct {
+	Shard     int        `json:"shard"`
+	MessageID gocql.UUID `json:"message_id"`
+	ChangedAt time.Time  `json:"changed_at"`
+}
+
+// NewReactionCountCheck marks the counters of a message as changed at the given time.
+func NewReactionCountCheck(messageId gocql.UUID, changedAt time.Time) ReactionCountCheck {
+	h := fnv.New32a()
+	h.Write(messageId[:])
+	return ReactionCountCheck{
+		Shard:     int(h.Sum32() % ReactionCountCheckShards),
+		MessageID: messageId,
+		ChangedAt: changedAt.Truncate(time.Millisecond),
+	}
+}
+
+var reactionCountCheckMetadata = table.Metadata{
+	Name: "messaging_keyspace.reaction_count_checks",
+	Columns: []string{
+		"shard",      //spreads the checks over a fixed number of partitions
+		"message_id", //id of the message whose counters changed
+		"changed_at", //time of the latest change, or of the repair claiming the check
+	},
+	PartKey: []string{"shard"},
+	SortKey: []string{"message_id"},
+}
+
+var ReactionCountCheckTable = table.New(reactionCountCheckMetadata)
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// Delivery states reported to the sender of a message.
+const (
+	DeliveryStatusSent      = "sent"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusRead      = "read"
+)
+
+// ReadReceipt holds a user's high-water marks in a conversation.
+// Every message up to and including DeliveredUpTo / ReadUpTo counts as delivered / read.
+type ReadReceipt struct {
+	ConversationID gocql.UUID `json:"conversation_id"`
+	UserID         gocql.UUID `json:"user_id"`
+	DeliveredUpTo  gocql.UUID `json:"delivered_up_to"`
+	DeliveredAt    time.Time  `json:"delivered_at"`
+	ReadUpTo       gocql.UUID `json:"read_up_to"`
+	ReadAt         time.Time  `json:"read_at"`
+}
+
+// HasDelivered reports whether the message with the given timeuuid was delivered to the user.
+func (r ReadReceipt) HasDelivered(messageId gocql.UUID) bool {
+	return r.DeliveredUpTo != (gocql.UUID{}) && !TimeUUIDAfter(messageId, r.DeliveredUpTo)
+}
+
+// HasRead reports whether the message with the given timeuuid was read by the user.
+func (r ReadReceipt) HasRead(messageId gocql.UUID) bool {
+	return r.ReadUpTo != (gocql.UUID{}) && !TimeUUIDAfter(messageId, r.ReadUpTo)
+}
+
+// Reader is a user who has read a message.
+type Reader struct {
+	UserID gocql.UUID `json:"user_id"`
+	ReadAt time.Time  `json:"read_at"`
+}
+
+// TimeUUIDAfter reports whether timeuuid a sorts after b, using the same ordering as scylla.
+func TimeUUIDAfter(a, b gocql.UUID) bool {
+	at, bt := a.Time(), b.Time()
+	if !at.Equal(bt) {
+		return at.After(bt)
+	}
+	return bytes.Compare(a[:], b[:]) > 0
+}
+
+var readReceiptMetadata = table.Metadata{
+	Name: "messaging_keyspace.read_receipts",
+	Columns: []string{
+		"conversation_id", //id of the conversation
+		"user_id",         //id of the user the marks belong to
+		"delivered_up_to", //timeuuid of the newest message delivered to the user
+		"delivered_at",    //time when the delivered mark last moved
+		"read_up_to",      //timeuuid of the newest message read by the user
+		"read_at",         //time when the read mark last moved
+	},
+	PartKey: []string{"conversation_id"},
+	SortKey: []string{"user_id"},
+}
+
+var ReadReceiptTable = table.New(readReceiptMetadata)
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// ResumableUpload tracks a tus upload whose chunks are stored in the blob store until it is complete.
+type ResumableUpload struct {
+	ID           gocql.UUID `json:"id"`
+	UploaderID   gocql.UUID `json:"uploader_id"`
+	Length       int64      `json:"length" db:"upload_length"`
+	Offset       int64      `json:"offset" db:"upload_offset"`
+	Filename     string     `json:"filename"`
+	ContentType  string     `json:"content_type"`
+	ChunkOffsets []int64    `json:"-"`
+	AttachmentID gocql.UUID `json:"attachment_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+}
+
+// IsComplete reports whether every byte of the upload has been received.
+func (u ResumableUpload) IsComplete() bool {
+	return u.Offset == u.Length
+}
+
+// IsFinalized reports whether the upload has been turned into an attachment.
+func (u ResumableUpload) IsFinalized() bool {
+	return u.AttachmentID != (gocql.UUID{})
+}
+
+var resumableUploadMetadata = table.Metadata{
+	Name: "messaging_keyspace.resumable_uploads",
+	Columns: []string{
+		"id",            //id for the upload
+		"uploader_id",   //id of the user uploading
+		"upload_length", //total size of the upload in bytes
+		"upload_offset", //number of bytes received so far
+		"filename",      //file name from the upload metadata
+		"content_type",  //media type from the upload metadata
+		"chunk_offsets", //start offset of every stored chunk, in order
+		"attachment_id", //id of the attachment created once the upload completed
+		"created_at",    //time when the upload was created
+		"expires_at",    //time after which an incomplete upload is discarded
+	},
+	PartKey: []string{"id"},
+}
+
+var ResumableUploadTable = table.New(resumableUploadMetadata)
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/table"
+)
+
+// Delivery states of a webhook delivery.
+const (
+	WebhookDeliveryPending    = "pending" //waiting for its first or next attempt
+	WebhookDeliverySucceeded  = "succeeded"
+	WebhookDeliveryDeadLetter = "dead_letter" //gave up after the last attempt
+)
+
+type Webhook struct {
+	ID              gocql.UUID   `json:"id"`
+	OwnerID         gocql.UUID   `json:"owner_id"`
+	URL             string       `json:"url"`
+	EventTypes      []string     `json:"event_types"`
+	ConversationIDs []gocql.UUID `json:"conversation_ids"` //empty for every conversation of the owner
+	Secret          string       `json:"secret,omitempty"` //only returned when the webhook is created
+	Enabled         bool         `json:"enabled"`
+	// ConsecutiveFailures counts failed attempts since the last success, the webhook is disabled past a threshold.
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	DisabledReason      string    `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// Matches reports whether the webhook subscribed to this kind of event in this conversation.
+func (w Webhook) Matches(eventType string, conversationId gocql.UUID) bool {
+	typeMatches := false
+	for _, subscribed := range w.EventTypes {
+		if subscribed == eventType {
+			typeMatches = true
+			break
+		}
+	}
+	if !typeMatches {
+		return false
+	}
+	if len(w.ConversationIDs) == 0 {
+		return true
+	}
+	for _, id := range w.ConversationIDs {
+		if id == conversationId {
+			return true
+		}
+	}
+	return false
+}
+
+var webhookMetadata = table.Metadata{
+	Name: "messaging_keyspace.webhooks",
+	Columns: []string{
+		"id",                   //id for the webhook
+		"owner_id",             //id of the user who registered the webhook
+		"url",                  //endpoint the events are posted to
+		"event_types",          //event types the webhook subscribed to
+		"conversation_ids",     //conversations the webhook is limited to, empty for all
+		"secret",               //key signing the deliveries
+		"enabled",              //whether events are delivered
+		"consecutive_failures", //failed attempts since the last success
+		"disabled_reason",      //why the webhook was disabled automatically
+		"created_at",           //time when the webhook was registered
+		"updated_at",           //time when the webhook was last changed
+	},
+	PartKey: []string{"id"},
+}
+
+var WebhookTable = table.New(webhookMetadata)
+
+type WebhookDelivery struct {
+	WebhookID      gocql.UUID `json:"webhook_id"`
+	ID             gocql.UUID `json:"id"`
+	EventID        gocql.UUID `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Payload        []byte     `json:"-"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status,omitempty"` //status code of the last attempt
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+}
+
+var webhookDeliveryMetadata = table.Metadata{
+	Name: "messaging_keyspace.webhook_deliveries",
+	Columns: []string{
+		"webhook_id",      //id of the webhook
+		"id",              //timeuuid of the delivery
+		"event_id",        //id of the delivered event, the same for every webhook
+		"event_type",      //type of the delivered event
+		"payload",         //signed body, kept to retry
+		"status",          //see WebhookDelivery*
+		"attempts",        //attempts made so far
+		"response_status", //status code of the last attempt
+		"last_error",      //error of the last failed attempt
+		"created_at",      //time when the event was queued
+		"updated_at",      //time of the last attempt
+		"next_attempt_at", //earliest time of the next attempt
+	},
+	PartKey: []string{"webhook_id"},
+	SortKey: []string{"id"},
+}
+
+var WebhookDeliveryTable = table.New(webhookDeliveryMetadata)
+
+// webhooks_by_conversation indexes the webhooks limited to some conversations by each of them
+var webhookByConversationMetadata = table.Metadata{
+	Name: "messaging_keyspace.webhooks_by_conversation",
+	Columns: []string{
+		"conversation_id", //conversation the webhook is limited to
+		"webhook_id",      //id of the webhook
+	},
+	PartKey: []string{"conversation_id"},
+	SortKey: []string{"webhook_id"},
+}
+
+var WebhookByConversationTable = table.New(webhookByConversationMetadata)
+
+// WebhookOwnership is a webhook as listed under its owner.
+type WebhookOwnership struct {
+	OwnerID          gocql.UUID `json:"owner_id"`
+	WebhookID        gocql.UUID `json:"webhook_id"`
+	AllConversations bool       `json:"all_conversations"`
+}
+
+var webhookByOwnerMetadata = table.Metadata{
+	Name: "messaging_keyspace.webhooks_by_owner",
+	Columns: []string{
+		"owner_id",          //id of the user who registered the webhook
+		"webhook_id",        //id of the webhook
+		"all_conversations", //whether the webhook covers every conversation of the owner
+	},
+	PartKey: []string{"owner_id"},
+	SortKey: []string{"webhook_id"},
+}
+
+var WebhookByOwnerTable = table.New(webhookByOwnerMetadata)
+
+// webhook_deliveries_by_status lists the deliveries of a webhook in each status, newest first
+var webhookDeliveryByStatusMetadata = table.Metadata{
+	Name: "messaging_keyspace.webhook_deliveries_by_status",
+	Columns: []string{
+		"webhook_id", //id of the webhook
+		"status",     //see WebhookDelivery*
+		"id",         //timeuuid of the delivery
+	},
+	PartKey: []string{"webhook_id", "status"},
+	SortKey: []string{"id"},
+}
+
+var WebhookDeliveryByStatusTable = table.New(webhookDeliveryByStatusMetadata)
+
+// WebhookRetryShards is the number of retry partitions, changing it strands the rows of the dropped shards.
+const WebhookRetryShards = 16
+
+// WebhookRetry is the next attempt of a pending delivery, indexed by when it is due so the retry
+// sweep only reads the deliveries it has to attempt.
+type WebhookRetry struct {
+	Shard         int        `json:"shard"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	WebhookID     gocql.UUID `json:"webhook_id"`
+	ID            gocql.UUID `json:"id"`
+}
+
+// NewWebhookRetry schedules the next attempt of a delivery.
+func NewWebhookRetry(delivery WebhookDelivery) WebhookRetry {
+	h := fnv.New32a()
+	h.Write(delivery.WebhookID[:])
+	return WebhookRetry{
+		Shard:         int(h.Sum32() % WebhookRetryShards),
+		NextAttemptAt: delivery.NextAttemptAt,
+		WebhookID:     delivery.WebhookID,
+		ID:            delivery.ID,
+	}
+}
+
+var webhookRetryMetadata = table.Metadata{
+	Name: "messaging_keyspace.webhook_retries",
+	Columns: []string{
+		"shard",           //partition of the retries, derived from the webhook
+		"next_attempt_at", //earliest time of the next attempt
+		"webhook_id",      //id of the webhook
+		"id",              //timeuuid of the delivery
+	},
+	PartKey: []string{"shard"},
+	SortKey: []string{"next_attempt_at", "webhook_id", "id"},
+}
+
+var WebhookRetryTable = table.New(webhookRetryMetadata)
@@ -0,0 +1,288 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+const (
+	// baseBackoff is the delay before retrying an entry that failed once, see helpers.Backoff.
+	baseBackoff = time.Second
+	// cursorLag keeps the cursor of a shard behind the clock, an entry is written a little after its id
+	// is generated and must not land behind a cursor that already moved past it.
+	cursorLag = time.Minute
+	// initialLookback is how far back a shard without a cursor is read, on the very first run.
+	initialLookback = 24 * time.Hour
+)
+
+// Dispatcher hands the outbox entries to its consumers in order of creation. It polls every shard on
+// an interval and right away when nudged after a write. Every instance runs a dispatcher, each shard is
+// delivered by the one holding its lease, so an entry normally reaches its consumers once. A dispatcher
+// stalled for longer than the lease can still overlap with the one taking over, consumers have to
+// tolerate the odd duplicate. A shard is read from its cursor on, which only moves past settled entries,
+// so the rows deleted once delivered are not read again.
+// An entry is retried until every interested consumer handled it, the ones that already did are
+// skipped. Entries that failed maxAttempts times are moved to the dead letters, so a single poison
+// entry holds back its conversation for a bounded time only.
+type Dispatcher struct {
+	repo        repository.OutboxRepository
+	consumers   []consumer
+	interval    time.Duration
+	batchSize   int
+	maxBackoff  time.Duration
+	maxAttempts int
+	owner       string
+	leaseTTL    time.Duration
+	leases      map[int]lease
+	nudge       chan struct{}
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// lease is what the dispatcher last learned about the lease of a shard.
+type lease struct {
+	held      bool
+	checkedAt time.Time
+}
+
+// consumer is a named destination of the outbox entries, the name records that it handled an entry.
+type consumer struct {
+	name       string
+	publisher  Publisher
+	eventTypes map[string]bool
+}
+
+func NewDispatcher(repo repository.OutboxRepository, interval time.Duration, batchSize int, maxBackoff time.Duration, maxAttempts int, leaseTTL time.Duration) *Dispatcher {
+	return &Dispatcher{
+		repo:        repo,
+		interval:    interval,
+		batchSize:   max(batchSize, 1),
+		maxBackoff:  maxBackoff,
+		maxAttempts: max(maxAttempts, 1),
+		owner:       gocql.TimeUUID().String(),
+		leaseTTL:    leaseTTL,
+		leases:      make(map[int]lease),
+		nudge:       make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+}
+
+// AddPublisher delivers the entries of the given event types, or of every type when none are given,
+// to publisher. The name has to stay the same across releases, it is stored with entries being retried.
+// Consumers are added before Start.
+func (d *Dispatcher) AddPublisher(name string, publisher Publisher, eventTypes ...string) {
+	var types map[string]bool
+	if len(eventTypes) > 0 {
+		types = make(map[string]bool, len(eventTypes))
+		for _, eventType := range eventTypes {
+			types[eventType] = true
+		}
+	}
+	d.consumers = append(d.consumers, consumer{name: name, publisher: publisher, eventTypes: types})
+}
+
+// Subscribe hands the decoded events of the given types to an in-process handler, for subscribers
+// that cannot afford to miss an event the way the best effort subscribers of the event bus can.
+func (d *Dispatcher) Subscribe(name string, handler events.Handler, eventTypes ...string) {
+	d.AddPublisher(name, handlerPublisher(handler), eventTypes...)
+}
+
+// Start launches the dispatcher, it runs until Stop is called or ctx is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			d.dispatch(ctx)
+			select {
+			case <-ticker.C:
+			case <-d.nudge:
+			case <-d.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the current round to finish and hands the shards over to the other instances,
+// entries left over are picked up by them or on the next start.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for shard, l := range d.leases {
+		if !l.held {
+			continue
+		}
+		if err := d.repo.ReleaseLease(ctx, shard, d.owner); err != nil {
+			slog.Warn("Failed to release outbox lease, it expires by itself", "shard", shard, "error", err)
+		}
+	}
+}
+
+// Nudge asks for a round without waiting for the interval, it never blocks.
+func (d *Dispatcher) Nudge() {
+	select {
+	case d.nudge <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	for shard := 0; shard < models.OutboxShards; shard++ {
+		if ctx.Err() != nil {
+			return
+		}
+		if !d.holdLease(ctx, shard) {
+			continue
+		}
+		if err := d.dispatchShard(ctx, shard); err != nil {
+			slog.Error("Failed to dispatch outbox", "shard", shard, "error", err)
+		}
+	}
+}
+
+// holdLease reports whether the dispatcher holds the lease on the shard, taking it when it is free.
+// The lease is only checked again once a third of its ttl has passed, to keep the transactions few.
+func (d *Dispatcher) holdLease(ctx context.Context, shard int) bool {
+	now := time.Now()
+	if l, ok := d.leases[shard]; ok && now.Sub(l.checkedAt) < d.leaseTTL/3 {
+		return l.held
+	}
+	held, err := d.repo.AcquireLease(ctx, shard, d.owner, d.leaseTTL)
+	if err != nil {
+		slog.Error("Failed to acquire outbox lease", "shard", shard, "error", err)
+		delete(d.leases, shard)
+		return false
+	}
+	d.leases[shard] = lease{held: held, checkedAt: now}
+	return held
+}
+
+// dispatchShard pages through the buckets of the shard from its cursor on. Entries waiting for a retry
+// are skipped rather than ending the round, so they only hold back the later entries of their own
+// conversation, and the cursor, which stops in front of the first entry that is not settled yet.
+func (d *Dispatcher) dispatchShard(ctx context.Context, shard int) error {
+	cursor, err := d.repo.GetCursor(ctx, shard)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	firstBucket := models.OutboxBucket(now.Add(-initialLookback))
+	if cursor != (gocql.UUID{}) {
+		firstBucket = models.OutboxBucket(cursor.Time())
+	}
+	lastBucket := models.OutboxBucket(now)
+
+	// once an entry of a conversation is held back, the later ones wait too so the order is kept
+	blocked := make(map[gocql.UUID]bool)
+	settledUntil := now.Add(-cursorLag)
+	position, settled := cursor, true
+	defer func() {
+		if position == cursor {
+			return
+		}
+		if err := d.repo.SaveCursor(ctx, shard, position); err != nil {
+			slog.Error("Failed to save outbox cursor", "shard", shard, "error", err)
+		}
+	}()
+
+	for bucket := firstBucket; bucket <= lastBucket; bucket++ {
+		var after gocql.UUID
+		if bucket == firstBucket {
+			after = cursor
+		}
+		for {
+			// a long round renews the lease, and stops when another dispatcher took the shard over
+			if !d.holdLease(ctx, shard) {
+				return nil
+			}
+			entries, err := d.repo.GetPending(ctx, shard, bucket, after, d.batchSize)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				done, err := d.dispatchEntry(ctx, entry, now, blocked)
+				if err != nil {
+					return err
+				}
+				settled = settled && done && entry.ID.Time().Before(settledUntil)
+				if settled {
+					position = entry.ID
+				}
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if len(entries) < d.batchSize {
+				break
+			}
+			after = entries[len(entries)-1].ID
+		}
+		// a bucket that is over and settled is not read again
+		next := models.OutboxBucketStart(bucket + 1)
+		if settled && !next.After(settledUntil) {
+			position = gocql.MinTimeUUID(next)
+		}
+	}
+	return nil
+}
+
+// dispatchEntry delivers a single entry, and reports whether the entry is settled: delivered to every
+// consumer or dead-lettered.
+func (d *Dispatcher) dispatchEntry(ctx context.Context, entry models.OutboxEntry, now time.Time, blocked map[gocql.UUID]bool) (bool, error) {
+	if blocked[entry.ConversationID] {
+		return false, nil
+	}
+	if entry.NextAttemptAt.After(now) {
+		blocked[entry.ConversationID] = true
+		return false, nil
+	}
+
+	err := d.deliver(ctx, &entry)
+	if err == nil {
+		return true, d.repo.MarkDone(ctx, entry)
+	}
+	blocked[entry.ConversationID] = true
+	if entry.Attempts+1 >= d.maxAttempts {
+		slog.Error("Giving up on outbox entry", "id", entry.ID, "type", entry.EventType, "attempts", entry.Attempts+1, "error", err)
+		return true, d.repo.DeadLetter(ctx, entry, err.Error())
+	}
+	next := now.Add(helpers.Backoff(entry.Attempts+1, baseBackoff, d.maxBackoff))
+	slog.Warn("Failed to publish outbox entry", "id", entry.ID, "type", entry.EventType, "attempts", entry.Attempts+1, "retry_at", next, "error", err)
+	return false, d.repo.ScheduleRetry(ctx, entry, next, err.Error())
+}
+
+// deliver hands the entry to every interested consumer that has not handled it yet, recording the
+// ones that succeed. A failing consumer does not keep the others from getting the entry.
+func (d *Dispatcher) deliver(ctx context.Context, entry *models.OutboxEntry) error {
+	var errs []error
+	for _, c := range d.consumers {
+		if (c.eventTypes != nil && !c.eventTypes[entry.EventType]) || slices.Contains(entry.Delivered, c.name) {
+			continue
+		}
+		if err := c.publisher.Publish(ctx, *entry); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+			continue
+		}
+		entry.Delivered = append(entry.Delivered, c.name)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,237 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// memoryRepository keeps the outbox in memory, with leases that behave like the lightweight transactions.
+type memoryRepository struct {
+	mu          sync.Mutex
+	entries     map[gocql.UUID]models.OutboxEntry
+	deadLetters []models.OutboxEntry
+	cursors     map[int]gocql.UUID
+	leases      map[int]models.OutboxLease
+	bucketReads map[int64]int
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		entries:     map[gocql.UUID]models.OutboxEntry{},
+		cursors:     map[int]gocql.UUID{},
+		leases:      map[int]models.OutboxLease{},
+		bucketReads: map[int64]int{},
+	}
+}
+
+// compareTimeUUID orders timeuuids by time first, the way Scylla does.
+func compareTimeUUID(a, b gocql.UUID) int {
+	if c := a.Time().Compare(b.Time()); c != 0 {
+		return c
+	}
+	return bytes.Compare(a[:], b[:])
+}
+
+func (r *memoryRepository) add(conversationId gocql.UUID, at time.Time) models.OutboxEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := gocql.UUIDFromTime(at)
+	entry := models.OutboxEntry{
+		Shard:          models.OutboxShard(conversationId),
+		Bucket:         models.OutboxBucket(at),
+		ID:             id,
+		ConversationID: conversationId,
+		EventType:      "test.event",
+		CreatedAt:      at,
+		NextAttemptAt:  at,
+	}
+	r.entries[id] = entry
+	return entry
+}
+
+func (r *memoryRepository) GetPending(ctx context.Context, shard int, bucket int64, after gocql.UUID, limit int) ([]models.OutboxEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bucketReads[bucket]++
+	var entries []models.OutboxEntry
+	for _, entry := range r.entries {
+		if entry.Shard == shard && entry.Bucket == bucket && (after == (gocql.UUID{}) || compareTimeUUID(entry.ID, after) > 0) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b models.OutboxEntry) int { return compareTimeUUID(a.ID, b.ID) })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (r *memoryRepository) MarkDone(ctx context.Context, entry models.OutboxEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, entry.ID)
+	return nil
+}
+
+func (r *memoryRepository) ScheduleRetry(ctx context.Context, entry models.OutboxEntry, nextAttemptAt time.Time, lastError string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.Attempts++
+	entry.NextAttemptAt = nextAttemptAt
+	entry.LastError = lastError
+	r.entries[entry.ID] = entry
+	return nil
+}
+
+func (r *memoryRepository) DeadLetter(ctx context.Context, entry models.OutboxEntry, lastError string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, entry.ID)
+	r.deadLetters = append(r.deadLetters, entry)
+	return nil
+}
+
+func (r *memoryRepository) GetCursor(ctx context.Context, shard int) (gocql.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cursors[shard], nil
+}
+
+func (r *memoryRepository) SaveCursor(ctx context.Context, shard int, position gocql.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cursors[shard] = position
+	return nil
+}
+
+func (r *memoryRepository) AcquireLease(ctx context.Context, shard int, owner string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if holder, ok := r.leases[shard]; ok && holder.Owner != owner {
+		return false, nil
+	}
+	r.leases[shard] = models.OutboxLease{Shard: shard, Owner: owner}
+	return true, nil
+}
+
+func (r *memoryRepository) ReleaseLease(ctx context.Context, shard int, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.leases[shard].Owner == owner {
+		delete(r.leases, shard)
+	}
+	return nil
+}
+
+// recordingPublisher counts the entries it got and fails the ones of the conversations in failing.
+type recordingPublisher struct {
+	mu        sync.Mutex
+	published map[gocql.UUID]int
+	failing   map[gocql.UUID]bool
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, entry models.OutboxEntry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing[entry.ConversationID] {
+		return errors.New("consumer unavailable")
+	}
+	p.published[entry.ID]++
+	return nil
+}
+
+func newTestDispatcher(repo *memoryRepository, publisher *recordingPublisher) *Dispatcher {
+	d := NewDispatcher(repo, time.Minute, 2, time.Minute, 5, 30*time.Second)
+	d.AddPublisher("test", publisher)
+	return d
+}
+
+func TestDispatcherDeliversEachShardFromOneInstance(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	publisher := &recordingPublisher{published: map[gocql.UUID]int{}}
+	var written []models.OutboxEntry
+	for i := 0; i < 20; i++ {
+		written = append(written, repo.add(gocql.TimeUUID(), time.Now().Add(-time.Duration(i)*time.Second)))
+	}
+
+	first := newTestDispatcher(repo, publisher)
+	second := newTestDispatcher(repo, publisher)
+	first.dispatch(ctx)
+	second.dispatch(ctx)
+
+	for _, entry := range written {
+		if n := publisher.published[entry.ID]; n != 1 {
+			t.Errorf("entry of shard %d was published %d times, want once", entry.Shard, n)
+		}
+	}
+
+	// once the first instance hands its shards over, the second one delivers them
+	first.Stop()
+	later := repo.add(gocql.TimeUUID(), time.Now())
+	second.leases = map[int]lease{}
+	second.dispatch(ctx)
+	if n := publisher.published[later.ID]; n != 1 {
+		t.Errorf("entry written after the hand over was published %d times, want once", n)
+	}
+}
+
+func TestDispatcherMovesTheCursorPastSettledEntries(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	failing := gocql.TimeUUID()
+	publisher := &recordingPublisher{published: map[gocql.UUID]int{}, failing: map[gocql.UUID]bool{failing: true}}
+
+	// entries of one shard, two hours old, the middle one keeps failing for now
+	delivered := gocql.TimeUUID()
+	for models.OutboxShard(delivered) != models.OutboxShard(failing) {
+		delivered = gocql.TimeUUID()
+	}
+	shard := models.OutboxShard(failing)
+	at := time.Now().Add(-2 * time.Hour)
+	first := repo.add(delivered, at)
+	held := repo.add(failing, at.Add(time.Second))
+	last := repo.add(delivered, at.Add(2*time.Second))
+
+	d := newTestDispatcher(repo, publisher)
+	if err := d.dispatchShard(ctx, shard); err != nil {
+		t.Fatalf("dispatchShard: %v", err)
+	}
+	if publisher.published[first.ID] != 1 || publisher.published[last.ID] != 1 {
+		t.Errorf("the entries of the other conversation were not delivered: %v", publisher.published)
+	}
+	if cursor := repo.cursors[shard]; cursor != first.ID {
+		t.Errorf("cursor = %v, want it to stop in front of the entry waiting for a retry", cursor.Time())
+	}
+
+	// once the held entry goes through, the cursor leaves its bucket behind
+	publisher.failing = nil
+	retry := repo.entries[held.ID]
+	retry.NextAttemptAt = time.Now().Add(-time.Second)
+	repo.entries[held.ID] = retry
+	if err := d.dispatchShard(ctx, shard); err != nil {
+		t.Fatalf("dispatchShard: %v", err)
+	}
+	if publisher.published[held.ID] != 1 {
+		t.Fatalf("the retried entry was published %d times, want once", publisher.published[held.ID])
+	}
+	if cursor := repo.cursors[shard]; models.OutboxBucket(cursor.Time()) <= held.Bucket {
+		t.Errorf("cursor = %v, want it past the bucket of the settled entries", cursor.Time())
+	}
+
+	reads := repo.bucketReads[held.Bucket]
+	if err := d.dispatchShard(ctx, shard); err != nil {
+		t.Fatalf("dispatchShard: %v", err)
+	}
+	if repo.bucketReads[held.Bucket] != reads {
+		t.Error("the settled bucket was read again")
+	}
+}
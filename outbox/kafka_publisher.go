@@ -0,0 +1,95 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// messageWriter is the part of kafka.Writer the publisher uses, tests swap in a fake.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaPublisher writes events to Kafka keyed by conversation id, so the events of a conversation
+// stay on one partition and in order.
+type KafkaPublisher struct {
+	writer messageWriter
+	// topic receives every event type without an entry in topics
+	topic  string
+	topics map[string]string
+}
+
+// NewKafkaPublisher creates a publisher for the given brokers. topics maps event types to
+// the topic they go to, everything else goes to defaultTopic.
+func NewKafkaPublisher(brokers []string, defaultTopic string, topics map[string]string, timeout time.Duration) *KafkaPublisher {
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// the dispatcher already publishes one entry at a time, don't wait to fill a batch
+			BatchTimeout: time.Millisecond,
+			WriteTimeout: timeout,
+			ReadTimeout:  timeout,
+		},
+		topic:  defaultTopic,
+		topics: topics,
+	}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, entry models.OutboxEntry) error {
+	record, err := NewRecord(entry)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	topic, ok := p.topics[record.Type]
+	if !ok {
+		topic = p.topic
+	}
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Topic: topic,
+		Key:   []byte(record.ConversationID.String()),
+		Value: value,
+		Time:  record.OccurredAt,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte("application/json")},
+			{Key: "event-type", Value: []byte(record.Type)},
+			{Key: "schema-version", Value: []byte(strconv.Itoa(record.SchemaVersion))},
+		},
+	})
+}
+
+// Close flushes and closes the connections to the brokers.
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// ParseTopics reads a comma separated list of event_type=topic pairs.
+func ParseTopics(value string) (map[string]string, error) {
+	topics := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		eventType, topic, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(eventType) == "" || strings.TrimSpace(topic) == "" {
+			return nil, fmt.Errorf("invalid topic mapping %q, expected event_type=topic", pair)
+		}
+		topics[strings.TrimSpace(eventType)] = strings.TrimSpace(topic)
+	}
+	return topics, nil
+}
@@ -0,0 +1,197 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/segmentio/kafka-go"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// fakeWriter records the messages instead of sending them to a broker.
+type fakeWriter struct {
+	messages []kafka.Message
+	err      error
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+func newTestPublisher(writer *fakeWriter) *KafkaPublisher {
+	return &KafkaPublisher{
+		writer: writer,
+		topic:  "message-events",
+		topics: map[string]string{events.TypeMessageDeleted: "message-deletions"},
+	}
+}
+
+func newTestEntry(t *testing.T, event events.Event) models.OutboxEntry {
+	t.Helper()
+	payload, err := events.Encode(event)
+	if err != nil {
+		t.Fatalf("encoding event: %v", err)
+	}
+	return models.OutboxEntry{
+		ID:             gocql.TimeUUID(),
+		ConversationID: event.Conversation(),
+		EventType:      event.Type(),
+		Payload:        payload,
+	}
+}
+
+func header(message kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestKafkaPublisherPublishesMessageCreated(t *testing.T) {
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	mentioned := gocql.TimeUUID()
+	message := models.Message{
+		ID:             gocql.TimeUUID(),
+		ConversationID: gocql.TimeUUID(),
+		SenderId:       gocql.TimeUUID(),
+		CreatedAt:      at,
+		UpdatedAt:      at,
+		Body:           "hello @" + mentioned.String(),
+		Seq:            7,
+		Mentions:       []string{mentioned.String()},
+	}
+	entry := newTestEntry(t, events.MessageCreated{Message: message, Mentioned: []gocql.UUID{mentioned}, At: at})
+
+	writer := &fakeWriter{}
+	if err := newTestPublisher(writer).Publish(context.Background(), entry); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if len(writer.messages) != 1 {
+		t.Fatalf("wrote %d messages, want 1", len(writer.messages))
+	}
+	written := writer.messages[0]
+
+	if written.Topic != "message-events" {
+		t.Errorf("topic = %q, want the default topic", written.Topic)
+	}
+	if string(written.Key) != message.ConversationID.String() {
+		t.Errorf("key = %q, want the conversation id", written.Key)
+	}
+	if !written.Time.Equal(at) {
+		t.Errorf("time = %v, want %v", written.Time, at)
+	}
+	if got := header(written, "event-type"); got != events.TypeMessageCreated {
+		t.Errorf("event-type header = %q", got)
+	}
+	if got := header(written, "schema-version"); got != "1" {
+		t.Errorf("schema-version header = %q", got)
+	}
+
+	var record struct {
+		SchemaVersion  int             `json:"schema_version"`
+		EventID        gocql.UUID      `json:"event_id"`
+		Type           string          `json:"type"`
+		ConversationID gocql.UUID      `json:"conversation_id"`
+		Data           json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(written.Value, &record); err != nil {
+		t.Fatalf("decoding record: %v", err)
+	}
+	if record.SchemaVersion != RecordSchemaVersion || record.EventID != entry.ID || record.Type != events.TypeMessageCreated || record.ConversationID != message.ConversationID {
+		t.Errorf("unexpected record envelope %+v", record)
+	}
+
+	// the data is the versioned payload, not the internal model
+	var data map[string]interface{}
+	if err := json.Unmarshal(record.Data, &data); err != nil {
+		t.Fatalf("decoding record data: %v", err)
+	}
+	want := map[string]interface{}{
+		"message_id":      message.ID.String(),
+		"conversation_id": message.ConversationID.String(),
+		"sender_id":       message.SenderId.String(),
+		"seq":             float64(7),
+		"body":            message.Body,
+		"reply_to_id":     nil,
+		"attachment_ids":  []interface{}{},
+		"mentioned":       []interface{}{mentioned.String()},
+		"created_at":      "2026-10-19T12:00:00Z",
+		"updated_at":      "2026-10-19T12:00:00Z",
+	}
+	if len(data) != len(want) {
+		t.Errorf("data has fields %v, want %v", data, want)
+	}
+	for key, value := range want {
+		got, _ := json.Marshal(data[key])
+		expected, _ := json.Marshal(value)
+		if string(got) != string(expected) {
+			t.Errorf("data[%q] = %s, want %s", key, got, expected)
+		}
+	}
+}
+
+func TestKafkaPublisherRoutesByEventType(t *testing.T) {
+	at := time.Now().UTC()
+	event := events.MessageDeleted{
+		MessageID:      gocql.TimeUUID(),
+		ConversationID: gocql.TimeUUID(),
+		SenderID:       gocql.TimeUUID(),
+		At:             at,
+	}
+
+	writer := &fakeWriter{}
+	if err := newTestPublisher(writer).Publish(context.Background(), newTestEntry(t, event)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if len(writer.messages) != 1 {
+		t.Fatalf("wrote %d messages, want 1", len(writer.messages))
+	}
+	if writer.messages[0].Topic != "message-deletions" {
+		t.Errorf("topic = %q, want the mapped topic", writer.messages[0].Topic)
+	}
+
+	var record struct {
+		Data MessageDeletedDataV1 `json:"data"`
+	}
+	if err := json.Unmarshal(writer.messages[0].Value, &record); err != nil {
+		t.Fatalf("decoding record: %v", err)
+	}
+	if record.Data.MessageID != event.MessageID || record.Data.SenderID != event.SenderID || !record.Data.DeletedAt.Equal(at) {
+		t.Errorf("data = %+v, want the deleted message", record.Data)
+	}
+}
+
+func TestKafkaPublisherReportsWriteErrors(t *testing.T) {
+	failure := errors.New("broker unavailable")
+	writer := &fakeWriter{err: failure}
+	entry := newTestEntry(t, events.MessageDeleted{MessageID: gocql.TimeUUID(), ConversationID: gocql.TimeUUID()})
+
+	if err := newTestPublisher(writer).Publish(context.Background(), entry); !errors.Is(err, failure) {
+		t.Errorf("Publish error = %v, want %v so the dispatcher retries", err, failure)
+	}
+}
+
+func TestKafkaPublisherRejectsUnpublishedEvents(t *testing.T) {
+	writer := &fakeWriter{}
+	entry := newTestEntry(t, events.ReactionAdded{ConversationID: gocql.TimeUUID()})
+
+	if err := newTestPublisher(writer).Publish(context.Background(), entry); err == nil {
+		t.Error("Publish accepted a reaction event")
+	}
+	if len(writer.messages) != 0 {
+		t.Errorf("wrote %d messages, want none", len(writer.messages))
+	}
+}
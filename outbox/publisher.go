@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// Publisher delivers outbox entries to the outside world, e.g. a message broker.
+// Entries are delivered at least once, the entry id lets consumers drop duplicates.
+type Publisher interface {
+	Publish(ctx context.Context, entry models.OutboxEntry) error
+}
+
+// LogPublisher only logs the entries, for running without a broker.
+type LogPublisher struct{}
+
+func (LogPublisher) Publish(ctx context.Context, entry models.OutboxEntry) error {
+	slog.Info("Published event", "id", entry.ID, "type", entry.EventType, "conversation_id", entry.ConversationID)
+	return nil
+}
+
+type contextKey string
+
+const entryIDKey contextKey = "outbox_entry_id"
+
+// EntryIDFromContext returns the id of the outbox entry an event handler was called for. It is the same
+// every time the entry is delivered again, handlers derive the ids of what they write from it so a
+// redelivery overwrites their earlier writes instead of adding to them.
+func EntryIDFromContext(ctx context.Context) (gocql.UUID, bool) {
+	entryId, ok := ctx.Value(entryIDKey).(gocql.UUID)
+	return entryId, ok
+}
+
+// handlerPublisher decodes the entries for an event handler running in process.
+type handlerPublisher events.Handler
+
+func (h handlerPublisher) Publish(ctx context.Context, entry models.OutboxEntry) error {
+	event, err := events.Decode(entry.Payload)
+	if err != nil {
+		return err
+	}
+	return h(context.WithValue(ctx, entryIDKey, entry.ID), event)
+}
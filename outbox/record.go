@@ -0,0 +1,111 @@
+package outbox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// RecordSchemaVersion is the version of Record and of the data types below. Adding fields keeps the
+// version, renaming, removing or changing the meaning of one bumps it. The data is spelled out here
+// rather than taken from the models, so changing a model cannot change the published records.
+const RecordSchemaVersion = 1
+
+// Record is the published form of an event, shared by every broker publisher.
+type Record struct {
+	SchemaVersion  int         `json:"schema_version"`
+	EventID        gocql.UUID  `json:"event_id"`
+	Type           string      `json:"type"`
+	ConversationID gocql.UUID  `json:"conversation_id"`
+	OccurredAt     time.Time   `json:"occurred_at"`
+	Data           interface{} `json:"data"`
+}
+
+// MessageDataV1 is the data of message.created and message.updated records.
+type MessageDataV1 struct {
+	MessageID      gocql.UUID `json:"message_id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	SenderID       gocql.UUID `json:"sender_id"`
+	Seq            int64      `json:"seq"`
+	Body           string     `json:"body"`
+	// ReplyToID is null when the message is not a reply.
+	ReplyToID *gocql.UUID `json:"reply_to_id"`
+	// AttachmentIDs lists the attachments sent with a new message, it is empty for updates.
+	AttachmentIDs []gocql.UUID `json:"attachment_ids"`
+	// Mentioned is everyone the message mentions, or for updates everyone the edit newly mentions.
+	Mentioned []gocql.UUID `json:"mentioned"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// MessageDeletedDataV1 is the data of message.deleted records.
+type MessageDeletedDataV1 struct {
+	MessageID      gocql.UUID `json:"message_id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	SenderID       gocql.UUID `json:"sender_id"`
+	DeletedAt      time.Time  `json:"deleted_at"`
+}
+
+// NewRecord builds the record of an outbox entry, its id doubles as the event id for deduplication.
+// Only message events have a published form.
+func NewRecord(entry models.OutboxEntry) (Record, error) {
+	event, err := events.Decode(entry.Payload)
+	if err != nil {
+		return Record{}, fmt.Errorf("decoding outbox entry %s: %w", entry.ID, err)
+	}
+
+	var data interface{}
+	switch e := event.(type) {
+	case events.MessageCreated:
+		data = newMessageData(e.Message, e.Mentioned)
+	case events.MessageUpdated:
+		data = newMessageData(e.Message, e.Mentioned)
+	case events.MessageDeleted:
+		data = MessageDeletedDataV1{
+			MessageID:      e.MessageID,
+			ConversationID: e.ConversationID,
+			SenderID:       e.SenderID,
+			DeletedAt:      e.At,
+		}
+	default:
+		return Record{}, fmt.Errorf("outbox entry %s: %s events are not published", entry.ID, event.Type())
+	}
+
+	return Record{
+		SchemaVersion:  RecordSchemaVersion,
+		EventID:        entry.ID,
+		Type:           event.Type(),
+		ConversationID: entry.ConversationID,
+		OccurredAt:     event.OccurredAt(),
+		Data:           data,
+	}, nil
+}
+
+func newMessageData(message models.Message, mentioned []gocql.UUID) MessageDataV1 {
+	data := MessageDataV1{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderId,
+		Seq:            message.Seq,
+		Body:           message.Body,
+		AttachmentIDs:  message.AttachmentIDs,
+		Mentioned:      mentioned,
+		CreatedAt:      message.CreatedAt,
+		UpdatedAt:      message.UpdatedAt,
+	}
+	if message.ReplyToID != (gocql.UUID{}) {
+		replyToId := message.ReplyToID
+		data.ReplyToID = &replyToId
+	}
+	// empty lists rather than null, so consumers need not tell the two apart
+	if data.AttachmentIDs == nil {
+		data.AttachmentIDs = []gocql.UUID{}
+	}
+	if data.Mentioned == nil {
+		data.Mentioned = []gocql.UUID{}
+	}
+	return data
+}
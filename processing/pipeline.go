@@ -0,0 +1,286 @@
+package processing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+	"github.com/yaninyzwitty/messaging-service/scanning"
+	"github.com/yaninyzwitty/messaging-service/storage"
+)
+
+const (
+	// maxImageBytes is the largest image we load into memory to thumbnail.
+	maxImageBytes = 32 << 20
+	// maxScanAttempts bounds how often a scan is retried while the scanners are unavailable.
+	maxScanAttempts = 4
+	// sweepBatchSize bounds how many pending attachments a sweep picks up.
+	sweepBatchSize = 100
+)
+
+var ErrQueueFull = errors.New("attachment processing queue is full")
+
+// Pipeline processes uploaded attachments in the background: it scans them, quarantining the ones
+// that fail, then sniffs the real content type, records image dimensions and generates thumbnails.
+// Attachments are queued on upload, a sweep picks up the ones still pending after sweepInterval,
+// whether the queue was full or the process stopped before getting to them.
+type Pipeline struct {
+	repo          repository.AttachmentsRepository
+	store         storage.BlobStore
+	scanner       scanning.Scanner
+	workers       int
+	sweepInterval time.Duration
+	jobs          chan models.Attachment
+	stop          chan struct{}
+	wg            sync.WaitGroup
+	sweepWG       sync.WaitGroup
+
+	mu     sync.Mutex
+	queued map[gocql.UUID]bool
+}
+
+func NewPipeline(repo repository.AttachmentsRepository, store storage.BlobStore, scanner scanning.Scanner, workers int, queueSize int, sweepInterval time.Duration) *Pipeline {
+	return &Pipeline{
+		repo:          repo,
+		store:         store,
+		scanner:       scanner,
+		workers:       max(workers, 1),
+		sweepInterval: sweepInterval,
+		jobs:          make(chan models.Attachment, queueSize),
+		stop:          make(chan struct{}),
+		queued:        make(map[gocql.UUID]bool),
+	}
+}
+
+// Start launches the workers, they run until Stop is called or ctx is cancelled.
+func (p *Pipeline) Start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				select {
+				case attachment, ok := <-p.jobs:
+					if !ok {
+						return
+					}
+					p.process(ctx, attachment)
+					p.done(attachment)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	p.sweepWG.Add(1)
+	go func() {
+		defer p.sweepWG.Done()
+		ticker := time.NewTicker(p.sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.sweep(ctx)
+			case <-p.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops accepting work and waits for the queued attachments to be processed.
+func (p *Pipeline) Stop() {
+	close(p.stop)
+	p.sweepWG.Wait()
+	p.mu.Lock()
+	close(p.jobs)
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// Enqueue schedules an attachment for processing without blocking the caller. An attachment that is
+// already queued is not queued twice. When the queue is full the attachment stays pending and is
+// picked up by a later sweep.
+func (p *Pipeline) Enqueue(attachment models.Attachment) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.queued[attachment.ID] {
+		return nil
+	}
+	select {
+	case p.jobs <- attachment:
+		p.queued[attachment.ID] = true
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *Pipeline) done(attachment models.Attachment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.queued, attachment.ID)
+}
+
+// sweep queues the attachments that have been pending for longer than a sweep interval.
+func (p *Pipeline) sweep(ctx context.Context) {
+	attachments, err := p.repo.GetPendingAttachments(ctx, time.Now().Add(-p.sweepInterval), sweepBatchSize)
+	if err != nil {
+		slog.Error("Failed to load pending attachments", "error", err)
+		return
+	}
+	for _, attachment := range attachments {
+		if attachment.Status != models.AttachmentStatusPending {
+			continue
+		}
+		if err := p.Enqueue(attachment); err != nil {
+			// the rest waits for the next sweep
+			slog.Warn("Failed to queue pending attachment", "attachment_id", attachment.ID, "error", err)
+			return
+		}
+	}
+}
+
+func (p *Pipeline) process(ctx context.Context, attachment models.Attachment) {
+	result, err := p.scan(ctx, attachment)
+	if err != nil {
+		// we fail closed, an attachment that could not be scanned is never shared
+		slog.Error("Failed to scan attachment", "attachment_id", attachment.ID, "error", err)
+		result = scanning.Result{Reason: "attachment could not be scanned"}
+	}
+	if !result.Clean {
+		p.quarantine(ctx, attachment, result.Reason)
+		return
+	}
+
+	err = p.extractMedia(ctx, &attachment)
+	if err != nil {
+		slog.Error("Failed to process attachment", "attachment_id", attachment.ID, "error", err)
+		attachment.Status = models.AttachmentStatusFailed
+	} else {
+		attachment.Status = models.AttachmentStatusReady
+	}
+
+	if err := p.repo.UpdateProcessingResult(ctx, attachment); err != nil {
+		slog.Error("Failed to save attachment processing result", "attachment_id", attachment.ID, "error", err)
+	}
+}
+
+// scan runs the scanners, retrying with backoff while they are unavailable.
+func (p *Pipeline) scan(ctx context.Context, attachment models.Attachment) (scanning.Result, error) {
+	file := scanning.File{
+		Name: attachment.Filename,
+		Size: attachment.Size,
+		Open: func() (io.ReadCloser, error) {
+			return p.store.Get(ctx, attachment.StorageKey)
+		},
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		result, err := p.scanner.Scan(ctx, file)
+		if err == nil || attempt == maxScanAttempts {
+			return result, err
+		}
+		slog.Warn("Attachment scan failed, retrying", "attachment_id", attachment.ID, "attempt", attempt, "error", err)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return scanning.Result{}, ctx.Err()
+		}
+	}
+}
+
+// quarantine moves the content out of reach of the download endpoints and flags the attachment.
+func (p *Pipeline) quarantine(ctx context.Context, attachment models.Attachment, reason string) {
+	slog.Warn("Quarantining attachment", "attachment_id", attachment.ID, "reason", reason)
+	attachment.Status = models.AttachmentStatusQuarantined
+	attachment.QuarantineReason = reason
+
+	quarantineKey := "quarantine/" + attachment.ID.String()
+	if err := p.move(ctx, attachment.StorageKey, quarantineKey, attachment.Size); err != nil {
+		// the status alone already keeps the content from being served
+		slog.Error("Failed to move attachment to quarantine", "attachment_id", attachment.ID, "error", err)
+	} else {
+		attachment.StorageKey = quarantineKey
+	}
+
+	if err := p.repo.UpdateProcessingResult(ctx, attachment); err != nil {
+		slog.Error("Failed to save attachment quarantine", "attachment_id", attachment.ID, "error", err)
+	}
+}
+
+func (p *Pipeline) move(ctx context.Context, from, to string, size int64) error {
+	content, err := p.store.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	if err := p.store.Put(ctx, to, content, size, "application/octet-stream"); err != nil {
+		return err
+	}
+	return p.store.Delete(ctx, from)
+}
+
+func (p *Pipeline) extractMedia(ctx context.Context, attachment *models.Attachment) error {
+	content, err := p.store.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	// clients are not trusted with the content type, the content speaks for itself when it can
+	reader := bufio.NewReaderSize(content, 512)
+	head, _ := reader.Peek(512)
+	attachment.ContentType = sniffContentType(head, attachment.ContentType)
+
+	if !thumbnailTypes[attachment.ContentType] || attachment.Size > maxImageBytes {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxImageBytes))
+	if err != nil {
+		return err
+	}
+	config, err := imageInfo(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	attachment.Width, attachment.Height = config.Width, config.Height
+
+	var thumbnail bytes.Buffer
+	if err := writeThumbnail(&thumbnail, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	thumbnailKey := "thumbnails/" + attachment.ID.String()
+	if err := p.store.Put(ctx, thumbnailKey, &thumbnail, int64(thumbnail.Len()), "image/jpeg"); err != nil {
+		return err
+	}
+	attachment.ThumbnailKey = thumbnailKey
+	return nil
+}
+
+// sniffContentType prefers the sniffed media type, falling back to the declared one when sniffing is inconclusive.
+func sniffContentType(head []byte, declared string) string {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if sniffed == "" || sniffed == "application/octet-stream" || sniffed == "text/plain" {
+		if declared != "" {
+			return declared
+		}
+	}
+	return sniffed
+}
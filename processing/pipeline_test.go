@@ -0,0 +1,132 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+	"github.com/yaninyzwitty/messaging-service/scanning"
+	"github.com/yaninyzwitty/messaging-service/storage"
+)
+
+// recordingRepository keeps the processing results, the pipeline needs nothing else from the repository.
+type recordingRepository struct {
+	repository.AttachmentsRepository
+
+	mu      sync.Mutex
+	results []models.Attachment
+}
+
+func (r *recordingRepository) UpdateProcessingResult(ctx context.Context, attachment models.Attachment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, attachment)
+	return nil
+}
+
+type scannerFunc func(ctx context.Context, file scanning.File) (scanning.Result, error)
+
+func (f scannerFunc) Scan(ctx context.Context, file scanning.File) (scanning.Result, error) {
+	return f(ctx, file)
+}
+
+func newTestPipeline(t *testing.T, scanner scanning.Scanner) (*Pipeline, *recordingRepository, storage.BlobStore, models.Attachment) {
+	t.Helper()
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	content := "meeting notes"
+	attachment := models.Attachment{
+		ID:          gocql.TimeUUID(),
+		Filename:    "notes.txt",
+		ContentType: "text/plain",
+		Size:        int64(len(content)),
+		StorageKey:  "attachments/notes.txt",
+		Status:      models.AttachmentStatusPending,
+	}
+	if err := store.Put(context.Background(), attachment.StorageKey, strings.NewReader(content), attachment.Size, "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	repo := &recordingRepository{}
+	return NewPipeline(repo, store, scanner, 1, 1, 0), repo, store, attachment
+}
+
+func (r *recordingRepository) only(t *testing.T) models.Attachment {
+	t.Helper()
+	if len(r.results) != 1 {
+		t.Fatalf("%d processing results recorded, want 1", len(r.results))
+	}
+	return r.results[0]
+}
+
+func TestProcessFailsClosedWhenTheFileCannotBeScanned(t *testing.T) {
+	attempts := 0
+	unavailable := scannerFunc(func(ctx context.Context, file scanning.File) (scanning.Result, error) {
+		attempts++
+		return scanning.Result{Clean: true}, errors.New("failed to connect to clamd")
+	})
+	pipeline, repo, store, attachment := newTestPipeline(t, unavailable)
+
+	// a cancelled context ends the retries right after the first attempt
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pipeline.process(ctx, attachment)
+
+	if attempts != 1 {
+		t.Errorf("scanner called %d times, want once", attempts)
+	}
+	result := repo.only(t)
+	if result.Status != models.AttachmentStatusQuarantined {
+		t.Errorf("status = %q, want %q", result.Status, models.AttachmentStatusQuarantined)
+	}
+	if result.QuarantineReason != "attachment could not be scanned" {
+		t.Errorf("quarantine reason = %q", result.QuarantineReason)
+	}
+	if _, err := store.Get(context.Background(), attachment.StorageKey); !errors.Is(err, storage.ErrBlobNotFound) {
+		t.Errorf("content still at its original key: %v", err)
+	}
+	if result.StorageKey != "quarantine/"+attachment.ID.String() {
+		t.Errorf("storage key = %q, want the quarantine", result.StorageKey)
+	}
+}
+
+func TestProcessQuarantinesRejectedFiles(t *testing.T) {
+	rejecting := scannerFunc(func(ctx context.Context, file scanning.File) (scanning.Result, error) {
+		return scanning.Result{Reason: "malware detected: Eicar-Signature"}, nil
+	})
+	pipeline, repo, store, attachment := newTestPipeline(t, rejecting)
+	pipeline.process(context.Background(), attachment)
+
+	result := repo.only(t)
+	if result.Status != models.AttachmentStatusQuarantined || result.QuarantineReason != "malware detected: Eicar-Signature" {
+		t.Errorf("result = %q %q, want quarantined for the scanner's reason", result.Status, result.QuarantineReason)
+	}
+	content, err := store.Get(context.Background(), result.StorageKey)
+	if err != nil {
+		t.Fatalf("Get quarantined content: %v", err)
+	}
+	defer content.Close()
+	if data, _ := io.ReadAll(content); string(data) != "meeting notes" {
+		t.Errorf("quarantined content = %q", data)
+	}
+}
+
+func TestProcessSharesCleanFiles(t *testing.T) {
+	clean := scannerFunc(func(ctx context.Context, file scanning.File) (scanning.Result, error) {
+		return scanning.Result{Clean: true}, nil
+	})
+	pipeline, repo, _, attachment := newTestPipeline(t, clean)
+	pipeline.process(context.Background(), attachment)
+
+	result := repo.only(t)
+	if result.Status != models.AttachmentStatusReady || result.StorageKey != attachment.StorageKey {
+		t.Errorf("result = %q at %q, want ready at its original key", result.Status, result.StorageKey)
+	}
+}
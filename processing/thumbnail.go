@@ -0,0 +1,72 @@
+package processing
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+
+	// decoders for the formats we thumbnail
+	_ "image/gif"
+	_ "image/png"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	// thumbnailSize bounds the longest side of a thumbnail.
+	thumbnailSize = 320
+	// maxImagePixels guards against decompression bombs, larger images are not decoded.
+	maxImagePixels = 50_000_000
+)
+
+var ErrImageTooLarge = errors.New("image is too large to thumbnail")
+
+// thumbnailTypes are the media types we generate thumbnails for.
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// imageInfo decodes just the header of an image to get its dimensions.
+func imageInfo(r io.Reader) (image.Config, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return image.Config{}, ErrImageTooLarge
+	}
+	return config, nil
+}
+
+// writeThumbnail scales the image down to fit thumbnailSize, keeping its aspect ratio, and encodes it as JPEG.
+// Transparent areas are flattened onto white since JPEG has no alpha channel, for GIFs only the first frame is used.
+func writeThumbnail(w io.Writer, r io.Reader) error {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return err
+	}
+
+	bounds := src.Bounds()
+	width, height := fit(bounds.Dx(), bounds.Dy(), thumbnailSize)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	return jpeg.Encode(w, dst, &jpeg.Options{Quality: 80})
+}
+
+// fit scales width and height so the longest side is at most size, images are never scaled up.
+func fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return max(width, 1), max(height, 1)
+	}
+	if width >= height {
+		return size, max(height*size/width, 1)
+	}
+	return max(width*size/height, 1), size
+}
@@ -0,0 +1,168 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// providerTokenLifetime renews the APNs provider token well within the hour Apple accepts it,
+	// and not more often than every 20 minutes, which Apple throttles.
+	providerTokenLifetime = 40 * time.Minute
+	// maxCollapseId is the longest apns-collapse-id Apple accepts.
+	maxCollapseId = 64
+)
+
+// APNsNotifier sends through the APNs HTTP/2 API with token based authentication.
+// endpoint is https://api.push.apple.com, or the sandbox, unless pointed at a fake server.
+type APNsNotifier struct {
+	client   *http.Client
+	endpoint string
+	key      *ecdsa.PrivateKey
+	keyId    string
+	teamId   string
+	topic    string
+
+	mu            sync.Mutex
+	providerToken string
+	issuedAt      time.Time
+}
+
+// LoadAPNsKey reads the .p8 signing key downloaded from the Apple developer account.
+func LoadAPNsKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apns key must be an ECDSA key")
+	}
+	return ecKey, nil
+}
+
+// NewAPNsNotifier creates a notifier for the app identified by topic, its bundle id. The client must
+// speak HTTP/2, the default transport negotiates it over TLS.
+func NewAPNsNotifier(client *http.Client, key *ecdsa.PrivateKey, keyId string, teamId string, topic string, endpoint string) *APNsNotifier {
+	return &APNsNotifier{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		key:      key,
+		keyId:    keyId,
+		teamId:   teamId,
+		topic:    topic,
+	}
+}
+
+type apnsAlert struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+type apnsAps struct {
+	Alert    apnsAlert `json:"alert"`
+	Sound    string    `json:"sound,omitempty"`
+	ThreadID string    `json:"thread-id,omitempty"`
+}
+
+func (n *APNsNotifier) Send(ctx context.Context, token string, notification Notification) error {
+	payload := map[string]interface{}{
+		"aps": apnsAps{
+			Alert:    apnsAlert{Title: notification.Title, Body: notification.Body},
+			Sound:    "default",
+			ThreadID: notification.CollapseKey,
+		},
+	}
+	// custom keys sit next to aps
+	for key, value := range notification.Data {
+		if key != "aps" {
+			payload[key] = value
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.endpoint+"/3/device/"+url.PathEscape(token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	providerToken, err := n.token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", n.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	if collapseId := notification.CollapseKey; collapseId != "" {
+		if len(collapseId) > maxCollapseId {
+			collapseId = collapseId[:maxCollapseId]
+		}
+		req.Header.Set("apns-collapse-id", collapseId)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&failure)
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		failure.Reason == "BadDeviceToken",
+		failure.Reason == "DeviceTokenNotForTopic",
+		failure.Reason == "Unregistered":
+		return ErrInvalidToken
+	case failure.Reason == "ExpiredProviderToken":
+		n.mu.Lock()
+		n.providerToken = ""
+		n.mu.Unlock()
+	}
+	return fmt.Errorf("apns responded %d: %s", resp.StatusCode, failure.Reason)
+}
+
+// token returns the provider token, signing a new one when the current one is getting old.
+func (n *APNsNotifier) token() (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.providerToken != "" && time.Since(n.issuedAt) < providerTokenLifetime {
+		return n.providerToken, nil
+	}
+	now := time.Now()
+	token, err := signJWT(map[string]string{"alg": "ES256", "kid": n.keyId}, map[string]interface{}{
+		"iss": n.teamId,
+		"iat": now.Unix(),
+	}, n.key)
+	if err != nil {
+		return "", err
+	}
+	n.providerToken = token
+	n.issuedAt = now
+	return token, nil
+}
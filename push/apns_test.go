@@ -0,0 +1,255 @@
+package push
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// apnsRejection is how the fake APNs answers for a device token.
+type apnsRejection struct {
+	status int
+	reason string
+}
+
+type apnsRequest struct {
+	proto   int
+	token   string
+	header  http.Header
+	payload map[string]json.RawMessage
+}
+
+// fakeAPNs speaks HTTP/2 over TLS like api.push.apple.com and accepts every token it has no rejection for.
+type fakeAPNs struct {
+	server     *httptest.Server
+	rejections map[string]apnsRejection
+
+	mu       sync.Mutex
+	requests []apnsRequest
+}
+
+func newFakeAPNs(t *testing.T, rejections map[string]apnsRejection) *fakeAPNs {
+	t.Helper()
+	fake := &fakeAPNs{rejections: rejections}
+	fake.server = httptest.NewUnstartedServer(http.HandlerFunc(fake.serve))
+	fake.server.EnableHTTP2 = true
+	fake.server.StartTLS()
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (f *fakeAPNs) serve(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, "/3/device/")
+	if r.Method != http.MethodPost || !ok {
+		http.Error(w, `{"reason":"BadPath"}`, http.StatusNotFound)
+		return
+	}
+	var payload map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, `{"reason":"PayloadEmpty"}`, http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, apnsRequest{proto: r.ProtoMajor, token: token, header: r.Header.Clone(), payload: payload})
+	f.mu.Unlock()
+
+	if rejection, ok := f.rejections[token]; ok {
+		w.WriteHeader(rejection.status)
+		json.NewEncoder(w).Encode(map[string]string{"reason": rejection.reason})
+		return
+	}
+	w.Header().Set("apns-id", "0e6a2f4c-5ab5-4c8a-9b0a-000000000000")
+}
+
+func (f *fakeAPNs) sent() []apnsRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]apnsRequest(nil), f.requests...)
+}
+
+func newTestAPNsNotifier(t *testing.T, fake *fakeAPNs) (*APNsNotifier, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return NewAPNsNotifier(fake.server.Client(), key, "KEY123", "TEAM456", "com.example.messaging", fake.server.URL+"/"), key
+}
+
+// verifyJWT checks the signature of a compact JWT against the public key and returns its header and claims.
+func verifyJWT(t *testing.T, token string, public interface{}) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %q is not a compact JWT", token)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatalf("decoding the signature: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := public.(type) {
+	case *ecdsa.PublicKey:
+		half := len(signature) / 2
+		r, s := new(big.Int).SetBytes(signature[:half]), new(big.Int).SetBytes(signature[half:])
+		if len(signature) != 64 || !ecdsa.Verify(key, digest[:], r, s) {
+			t.Fatal("ES256 signature does not verify")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			t.Fatalf("RS256 signature does not verify: %v", err)
+		}
+	}
+
+	decode := func(part string) map[string]interface{} {
+		raw, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			t.Fatalf("decoding %q: %v", part, err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			t.Fatalf("parsing %s: %v", raw, err)
+		}
+		return fields
+	}
+	return decode(parts[0]), decode(parts[1])
+}
+
+func TestAPNsNotifierSend(t *testing.T) {
+	fake := newFakeAPNs(t, nil)
+	notifier, key := newTestAPNsNotifier(t, fake)
+
+	conversation := "5b6f7c3e-1d2a-11ef-8c9f-0242ac120002"
+	err := notifier.Send(context.Background(), "device-token", Notification{
+		Title:       "New messages",
+		Body:        "3 new messages",
+		CollapseKey: conversation,
+		Data:        map[string]string{"conversation_id": conversation, "count": "3", "aps": "ignored"},
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	requests := fake.sent()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	if request.proto != 2 {
+		t.Errorf("sent over HTTP/%d, APNs only speaks HTTP/2", request.proto)
+	}
+	if request.token != "device-token" {
+		t.Errorf("token = %q", request.token)
+	}
+	for name, want := range map[string]string{
+		"apns-topic":       "com.example.messaging",
+		"apns-push-type":   "alert",
+		"apns-collapse-id": conversation,
+	} {
+		if got := request.header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	var aps apnsAps
+	if err := json.Unmarshal(request.payload["aps"], &aps); err != nil {
+		t.Fatalf("aps = %s: %v", request.payload["aps"], err)
+	}
+	if aps.Alert.Title != "New messages" || aps.Alert.Body != "3 new messages" || aps.ThreadID != conversation {
+		t.Errorf("aps = %+v", aps)
+	}
+	if string(request.payload["count"]) != `"3"` || string(request.payload["conversation_id"]) != `"`+conversation+`"` {
+		t.Errorf("custom keys = %v, want them next to aps", request.payload)
+	}
+
+	bearer, ok := strings.CutPrefix(request.header.Get("Authorization"), "bearer ")
+	if !ok {
+		t.Fatalf("Authorization = %q", request.header.Get("Authorization"))
+	}
+	header, claims := verifyJWT(t, bearer, &key.PublicKey)
+	if header["alg"] != "ES256" || header["kid"] != "KEY123" || claims["iss"] != "TEAM456" {
+		t.Errorf("provider token header %v claims %v", header, claims)
+	}
+}
+
+func TestAPNsNotifierTruncatesLongCollapseIds(t *testing.T) {
+	fake := newFakeAPNs(t, nil)
+	notifier, _ := newTestAPNsNotifier(t, fake)
+	if err := notifier.Send(context.Background(), "device-token", Notification{CollapseKey: strings.Repeat("k", 100)}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := fake.sent()[0].header.Get("apns-collapse-id"); len(got) != maxCollapseId {
+		t.Errorf("apns-collapse-id has %d bytes, want %d", len(got), maxCollapseId)
+	}
+}
+
+func TestAPNsNotifierRejections(t *testing.T) {
+	fake := newFakeAPNs(t, map[string]apnsRejection{
+		"bad":          {http.StatusBadRequest, "BadDeviceToken"},
+		"other-app":    {http.StatusBadRequest, "DeviceTokenNotForTopic"},
+		"unregistered": {http.StatusGone, "Unregistered"},
+		"throttled":    {http.StatusTooManyRequests, "TooManyRequests"},
+		"down":         {http.StatusServiceUnavailable, "ServiceUnavailable"},
+		"payload":      {http.StatusRequestEntityTooLarge, "PayloadTooLarge"},
+	})
+	notifier, _ := newTestAPNsNotifier(t, fake)
+
+	for token, invalid := range map[string]bool{
+		"bad":          true,
+		"other-app":    true,
+		"unregistered": true,
+		"throttled":    false,
+		"down":         false,
+		"payload":      false,
+	} {
+		err := notifier.Send(context.Background(), token, Notification{Title: "New message"})
+		if err == nil {
+			t.Errorf("%s: Send succeeded", token)
+			continue
+		}
+		if errors.Is(err, ErrInvalidToken) != invalid {
+			t.Errorf("%s: Send = %v, want the token invalidated %v", token, err, invalid)
+		}
+	}
+}
+
+func TestAPNsNotifierRenewsAnExpiredProviderToken(t *testing.T) {
+	fake := newFakeAPNs(t, map[string]apnsRejection{"expired": {http.StatusForbidden, "ExpiredProviderToken"}})
+	notifier, _ := newTestAPNsNotifier(t, fake)
+
+	if err := notifier.Send(context.Background(), "device-token", Notification{}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := notifier.Send(context.Background(), "device-token", Notification{}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	err := notifier.Send(context.Background(), "expired", Notification{})
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Send with an expired provider token = %v, want an error keeping the device token", err)
+	}
+	if err := notifier.Send(context.Background(), "device-token", Notification{}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	requests := fake.sent()
+	auth := func(i int) string { return requests[i].header.Get("Authorization") }
+	if auth(0) != auth(1) || auth(1) != auth(2) {
+		t.Error("the provider token was not reused while it was valid")
+	}
+	if auth(3) == auth(2) {
+		t.Error("the provider token was not renewed after APNs reported it expired")
+	}
+}
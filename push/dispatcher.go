@@ -0,0 +1,209 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+// maxPreviewLength bounds the message text shown in a notification, in runes.
+const maxPreviewLength = 120
+
+// Presence tells whether a user is connected and already sees new messages.
+type Presence interface {
+	IsOnline(ctx context.Context, userId gocql.UUID) bool
+}
+
+type collapseKey struct {
+	userId         gocql.UUID
+	conversationId gocql.UUID
+}
+
+// pendingPush gathers the messages of a conversation for one user until the collapse window ends.
+type pendingPush struct {
+	count  int
+	latest models.Message
+}
+
+// Dispatcher notifies the participants that are offline when a message is created. Messages arriving
+// for the same user and conversation within the collapse window are sent as a single notification,
+// and every notification of a conversation carries the same collapse key, so devices show one at a time.
+// Tokens the platforms reject are forgotten.
+type Dispatcher struct {
+	repo            repository.DevicesRepository
+	participantRepo repository.ParticipantsRepository
+	presence        Presence
+	notifiers       map[string]Notifier
+	collapseWindow  time.Duration
+	timeout         time.Duration
+	workers         int
+	jobs            chan collapseKey
+	stop            chan struct{}
+	wg              sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[collapseKey]*pendingPush
+}
+
+// NewDispatcher creates a dispatcher sending through the notifier of each platform, devices of
+// platforms without one are skipped.
+func NewDispatcher(repo repository.DevicesRepository, participantRepo repository.ParticipantsRepository, presence Presence, notifiers map[string]Notifier, collapseWindow time.Duration, timeout time.Duration, workers int, queueSize int) *Dispatcher {
+	return &Dispatcher{
+		repo:            repo,
+		participantRepo: participantRepo,
+		presence:        presence,
+		notifiers:       notifiers,
+		collapseWindow:  collapseWindow,
+		timeout:         timeout,
+		workers:         max(workers, 1),
+		jobs:            make(chan collapseKey, queueSize),
+		stop:            make(chan struct{}),
+		pending:         make(map[collapseKey]*pendingPush),
+	}
+}
+
+// Start launches the workers, they run until Stop is called or ctx is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for {
+				select {
+				case key := <-d.jobs:
+					d.send(ctx, key)
+				case <-d.stop:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Stop waits for the notifications being sent, the ones still collapsing are dropped.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// HandleEvent is the event bus subscriber collecting new messages for the participants that are offline.
+func (d *Dispatcher) HandleEvent(ctx context.Context, event events.Event) error {
+	created, ok := event.(events.MessageCreated)
+	if !ok {
+		return nil
+	}
+	message := created.Message
+
+	participants, err := d.participantRepo.GetParticipants(ctx, message.ConversationID)
+	if err != nil {
+		return err
+	}
+	for _, participant := range participants {
+		if participant.UserID == message.SenderId || d.presence.IsOnline(ctx, participant.UserID) {
+			continue
+		}
+		d.collect(collapseKey{userId: participant.UserID, conversationId: message.ConversationID}, message)
+	}
+	return nil
+}
+
+// collect adds the message to the pending notification, the first one of a window schedules the send.
+func (d *Dispatcher) collect(key collapseKey, message models.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if pending, ok := d.pending[key]; ok {
+		pending.count++
+		pending.latest = message
+		return
+	}
+	d.pending[key] = &pendingPush{count: 1, latest: message}
+	time.AfterFunc(d.collapseWindow, func() {
+		select {
+		case d.jobs <- key:
+		default:
+			d.mu.Lock()
+			delete(d.pending, key)
+			d.mu.Unlock()
+			slog.Warn("Dropping push notification, the queue is full", "user_id", key.userId, "conversation_id", key.conversationId)
+		}
+	})
+}
+
+func (d *Dispatcher) send(ctx context.Context, key collapseKey) {
+	d.mu.Lock()
+	pending, ok := d.pending[key]
+	delete(d.pending, key)
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	devices, err := d.repo.GetDeviceTokens(ctx, key.userId)
+	if err != nil {
+		slog.Error("Failed to load device tokens", "user_id", key.userId, "error", err)
+		return
+	}
+	notification := newNotification(pending)
+	for _, device := range devices {
+		notifier, ok := d.notifiers[device.Platform]
+		if !ok {
+			continue
+		}
+		sendCTX, cancel := context.WithTimeout(ctx, d.timeout)
+		err := notifier.Send(sendCTX, device.Token, notification)
+		cancel()
+		if errors.Is(err, ErrInvalidToken) {
+			slog.Info("Removing rejected device token", "user_id", key.userId, "platform", device.Platform)
+			if err := d.repo.DeleteDeviceToken(ctx, key.userId, device.Token); err != nil {
+				slog.Error("Failed to remove device token", "user_id", key.userId, "error", err)
+			}
+			continue
+		}
+		if err != nil {
+			slog.Error("Failed to send push notification", "user_id", key.userId, "platform", device.Platform, "error", err)
+		}
+	}
+}
+
+// newNotification previews a single message, or counts them when several were collapsed.
+func newNotification(pending *pendingPush) Notification {
+	message := pending.latest
+	notification := Notification{
+		Title:       "New message",
+		Body:        preview(message),
+		CollapseKey: message.ConversationID.String(),
+		Data: map[string]string{
+			"conversation_id": message.ConversationID.String(),
+			"message_id":      message.ID.String(),
+			"count":           strconv.Itoa(pending.count),
+		},
+	}
+	if pending.count > 1 {
+		notification.Title = "New messages"
+		notification.Body = fmt.Sprintf("%d new messages", pending.count)
+	}
+	return notification
+}
+
+func preview(message models.Message) string {
+	if message.Body == "" {
+		return "Sent an attachment"
+	}
+	if utf8.RuneCountInString(message.Body) <= maxPreviewLength {
+		return message.Body
+	}
+	runes := []rune(message.Body)
+	return string(runes[:maxPreviewLength]) + "…"
+}
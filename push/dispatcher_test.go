@@ -0,0 +1,166 @@
+package push
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+// memoryDevices keeps the device tokens of every user in memory.
+type memoryDevices struct {
+	mu      sync.Mutex
+	devices map[gocql.UUID][]models.DeviceToken
+}
+
+func (r *memoryDevices) SaveDeviceToken(ctx context.Context, device models.DeviceToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices[device.UserID] = append(r.devices[device.UserID], device)
+	return nil
+}
+
+func (r *memoryDevices) GetDeviceTokens(ctx context.Context, userId gocql.UUID) ([]models.DeviceToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.devices[userId]), nil
+}
+
+func (r *memoryDevices) DeleteDeviceToken(ctx context.Context, userId gocql.UUID, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices[userId] = slices.DeleteFunc(r.devices[userId], func(device models.DeviceToken) bool { return device.Token == token })
+	return nil
+}
+
+func (r *memoryDevices) tokens(userId gocql.UUID) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tokens []string
+	for _, device := range r.devices[userId] {
+		tokens = append(tokens, device.Token)
+	}
+	slices.Sort(tokens)
+	return tokens
+}
+
+// fixedParticipants answers GetParticipants from a fixed list, the dispatcher needs nothing else.
+type fixedParticipants struct {
+	repository.ParticipantsRepository
+	participants []models.Participant
+}
+
+func (r fixedParticipants) GetParticipants(ctx context.Context, conversationId gocql.UUID) ([]models.Participant, error) {
+	return r.participants, nil
+}
+
+type onlineUsers map[gocql.UUID]bool
+
+func (o onlineUsers) IsOnline(ctx context.Context, userId gocql.UUID) bool { return o[userId] }
+
+func TestDispatcherCollapsesMessagesAndForgetsRejectedTokens(t *testing.T) {
+	ctx := context.Background()
+	apns := newFakeAPNs(t, map[string]apnsRejection{
+		"ios-bad":       {http.StatusBadRequest, "BadDeviceToken"},
+		"ios-throttled": {http.StatusTooManyRequests, "TooManyRequests"},
+	})
+	fcm := newFakeFCM(t, map[string]fcmRejection{
+		"android-unregistered": {http.StatusNotFound, "UNREGISTERED"},
+		"android-down":         {http.StatusServiceUnavailable, "UNAVAILABLE"},
+	})
+	apnsNotifier, _ := newTestAPNsNotifier(t, apns)
+	notifiers := map[string]Notifier{
+		models.PlatformAPNs: apnsNotifier,
+		models.PlatformFCM:  newTestFCMNotifier(t, fcm),
+	}
+
+	conversationId := gocql.TimeUUID()
+	sender, offline, online := gocql.TimeUUID(), gocql.TimeUUID(), gocql.TimeUUID()
+	devices := &memoryDevices{devices: map[gocql.UUID][]models.DeviceToken{}}
+	for _, device := range []models.DeviceToken{
+		{UserID: offline, Token: "ios-good", Platform: models.PlatformAPNs},
+		{UserID: offline, Token: "ios-bad", Platform: models.PlatformAPNs},
+		{UserID: offline, Token: "ios-throttled", Platform: models.PlatformAPNs},
+		{UserID: offline, Token: "android-good", Platform: models.PlatformFCM},
+		{UserID: offline, Token: "android-unregistered", Platform: models.PlatformFCM},
+		{UserID: offline, Token: "android-down", Platform: models.PlatformFCM},
+		{UserID: online, Token: "online-phone", Platform: models.PlatformFCM},
+		{UserID: sender, Token: "sender-phone", Platform: models.PlatformFCM},
+	} {
+		devices.SaveDeviceToken(ctx, device)
+	}
+	participants := fixedParticipants{participants: []models.Participant{
+		{ConversationID: conversationId, UserID: sender},
+		{ConversationID: conversationId, UserID: offline},
+		{ConversationID: conversationId, UserID: online},
+	}}
+
+	// the collapse window outlasts the test, the collected messages are sent by hand
+	d := NewDispatcher(devices, participants, onlineUsers{online: true}, notifiers, time.Hour, time.Second, 1, 10)
+	var last models.Message
+	for _, body := range []string{"are you there?", "hello?", "call me"} {
+		last = models.Message{ID: gocql.TimeUUID(), ConversationID: conversationId, SenderId: sender, Body: body}
+		if err := d.HandleEvent(ctx, events.MessageCreated{Message: last}); err != nil {
+			t.Fatalf("HandleEvent: %v", err)
+		}
+	}
+	if len(d.pending) != 1 {
+		t.Fatalf("%d notifications pending, want the offline participant's only", len(d.pending))
+	}
+	d.send(ctx, collapseKey{userId: offline, conversationId: conversationId})
+
+	// one notification per device, standing in for the three messages
+	apnsSent := apns.sent()
+	if len(apnsSent) != 3 {
+		t.Fatalf("%d APNs requests, want one per iOS device", len(apnsSent))
+	}
+	for _, request := range apnsSent {
+		if got := request.header.Get("apns-collapse-id"); got != conversationId.String() {
+			t.Errorf("apns-collapse-id = %q, want the conversation", got)
+		}
+		if string(request.payload["count"]) != `"3"` || string(request.payload["message_id"]) != `"`+last.ID.String()+`"` {
+			t.Errorf("payload = %v, want the count and the latest message", request.payload)
+		}
+	}
+	fcmSent := fcm.sent()
+	if len(fcmSent) != 3 {
+		t.Fatalf("%d FCM messages, want one per Android device", len(fcmSent))
+	}
+	for _, message := range fcmSent {
+		if message.Notification.Title != "New messages" || message.Notification.Body != "3 new messages" {
+			t.Errorf("notification = %+v, want the messages collapsed", message.Notification)
+		}
+		if message.Android == nil || message.Android.CollapseKey != conversationId.String() {
+			t.Errorf("android = %+v, want the conversation as collapse key", message.Android)
+		}
+	}
+
+	// rejected tokens are forgotten, the ones that failed for other reasons are tried again next time
+	want := []string{"android-down", "android-good", "ios-good", "ios-throttled"}
+	if got := devices.tokens(offline); !slices.Equal(got, want) {
+		t.Errorf("tokens left = %v, want %v", got, want)
+	}
+	if got := devices.tokens(online); len(got) != 1 {
+		t.Errorf("tokens of the online participant = %v, want them untouched", got)
+	}
+}
+
+func TestNewNotificationPreviewsASingleMessage(t *testing.T) {
+	message := models.Message{ID: gocql.TimeUUID(), ConversationID: gocql.TimeUUID(), Body: "lunch?"}
+	notification := newNotification(&pendingPush{count: 1, latest: message})
+	if notification.Title != "New message" || notification.Body != "lunch?" || notification.Data["count"] != "1" {
+		t.Errorf("notification = %+v", notification)
+	}
+
+	message.Body = ""
+	if got := newNotification(&pendingPush{count: 1, latest: message}).Body; got != "Sent an attachment" {
+		t.Errorf("body of an attachment = %q", got)
+	}
+}
@@ -0,0 +1,220 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+	// accessTokenMargin renews access tokens a little before they expire.
+	accessTokenMargin = time.Minute
+	// maxErrorBody is how much of a failed response is read for the error.
+	maxErrorBody = 4 << 10
+)
+
+// ServiceAccount holds the fields of a Google service account key file used to authenticate to FCM.
+type ServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// LoadServiceAccount reads a service account key file as downloaded from the Firebase console.
+func LoadServiceAccount(path string) (ServiceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+	var account ServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return ServiceAccount{}, fmt.Errorf("parsing service account %s: %w", path, err)
+	}
+	return account, nil
+}
+
+// FCMNotifier sends through the FCM HTTP v1 API, authenticating with OAuth access tokens obtained
+// from the service account. endpoint is https://fcm.googleapis.com unless pointed at a fake server.
+type FCMNotifier struct {
+	client   *http.Client
+	endpoint string
+	account  ServiceAccount
+	key      crypto.Signer
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+func NewFCMNotifier(client *http.Client, account ServiceAccount, endpoint string) (*FCMNotifier, error) {
+	key, err := parsePrivateKey([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("parsing the service account key: %w", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, errors.New("service account needs project_id, client_email and token_uri")
+	}
+	return &FCMNotifier{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		account:  account,
+		key:      key,
+	}, nil
+}
+
+type fcmMessage struct {
+	Message fcmPayload `json:"message"`
+}
+
+type fcmPayload struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+	Android      *fcmAndroid       `json:"android,omitempty"`
+	APNs         *fcmAPNs          `json:"apns,omitempty"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+type fcmAndroid struct {
+	CollapseKey string `json:"collapse_key"`
+}
+
+type fcmAPNs struct {
+	Headers map[string]string `json:"headers"`
+}
+
+type fcmError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func (n *FCMNotifier) Send(ctx context.Context, token string, notification Notification) error {
+	payload := fcmPayload{
+		Token:        token,
+		Notification: fcmNotification{Title: notification.Title, Body: notification.Body},
+		Data:         notification.Data,
+	}
+	if notification.CollapseKey != "" {
+		// FCM also reaches iOS devices, the collapse key is set for both
+		payload.Android = &fcmAndroid{CollapseKey: notification.CollapseKey}
+		payload.APNs = &fcmAPNs{Headers: map[string]string{"apns-collapse-id": notification.CollapseKey}}
+	}
+	body, err := json.Marshal(fcmMessage{Message: payload})
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := n.token(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.endpoint+"/v1/projects/"+url.PathEscape(n.account.ProjectID)+"/messages:send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var failure fcmError
+	json.Unmarshal(raw, &failure)
+	if resp.StatusCode == http.StatusUnauthorized {
+		n.mu.Lock()
+		n.accessToken = ""
+		n.mu.Unlock()
+	}
+	// only the error codes name the token as the problem, a bare 404 may as well be a wrong project or endpoint.
+	// Our payloads always have the same shape, so INVALID_ARGUMENT comes down to a malformed token.
+	for _, detail := range failure.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" || detail.ErrorCode == "INVALID_ARGUMENT" {
+			return ErrInvalidToken
+		}
+	}
+	return fmt.Errorf("fcm responded %d: %s", resp.StatusCode, failure.Error.Message)
+}
+
+// token returns a cached access token, exchanging a freshly signed assertion when it is about to expire.
+func (n *FCMNotifier) token(ctx context.Context) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.accessToken != "" && time.Now().Before(n.expiresAt) {
+		return n.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(map[string]string{"alg": "RS256", "typ": "JWT"}, map[string]interface{}{
+		"iss":   n.account.ClientEmail,
+		"scope": fcmScope,
+		"aud":   n.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}, n.key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return "", fmt.Errorf("fetching an fcm access token: %d %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+
+	var grant struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&grant); err != nil {
+		return "", err
+	}
+	if grant.AccessToken == "" {
+		return "", errors.New("fetching an fcm access token: empty token in response")
+	}
+	n.accessToken = grant.AccessToken
+	n.expiresAt = now.Add(time.Duration(grant.ExpiresIn)*time.Second - accessTokenMargin)
+	return n.accessToken, nil
+}
@@ -0,0 +1,241 @@
+package push
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fcmRejection is how the fake FCM answers for a device token, errorCode goes into the error details.
+type fcmRejection struct {
+	status    int
+	errorCode string
+}
+
+// fakeFCM serves the OAuth token endpoint and the HTTP v1 send endpoint of one project.
+type fakeFCM struct {
+	server     *httptest.Server
+	key        *rsa.PrivateKey
+	rejections map[string]fcmRejection
+
+	mu           sync.Mutex
+	grants       int
+	assertions   []string
+	messages     []fcmPayload
+	expireTokens bool
+}
+
+func newFakeFCM(t *testing.T, rejections map[string]fcmRejection) *fakeFCM {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	fake := &fakeFCM{key: key, rejections: rejections}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", fake.grant)
+	mux.HandleFunc("POST /v1/projects/test-project/messages:send", fake.send)
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (f *fakeFCM) grant(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.grants++
+	f.assertions = append(f.assertions, r.FormValue("assertion"))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": fmt.Sprintf("access-%d", f.grants),
+		"expires_in":   3600,
+		"token_type":   "Bearer",
+	})
+}
+
+func (f *fakeFCM) send(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	current := fmt.Sprintf("Bearer access-%d", f.grants)
+	expired := f.expireTokens
+	f.expireTokens = false
+	f.mu.Unlock()
+	if r.Header.Get("Authorization") != current || expired {
+		writeFCMError(w, http.StatusUnauthorized, "UNAUTHENTICATED", "")
+		return
+	}
+
+	var message fcmMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		writeFCMError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "")
+		return
+	}
+	f.mu.Lock()
+	f.messages = append(f.messages, message.Message)
+	f.mu.Unlock()
+
+	if rejection, ok := f.rejections[message.Message.Token]; ok {
+		writeFCMError(w, rejection.status, strings.ToUpper(http.StatusText(rejection.status)), rejection.errorCode)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"name": "projects/test-project/messages/1"})
+}
+
+func writeFCMError(w http.ResponseWriter, status int, statusName string, errorCode string) {
+	var failure fcmError
+	failure.Error.Code = status
+	failure.Error.Message = "request failed"
+	failure.Error.Status = statusName
+	if errorCode != "" {
+		failure.Error.Details = append(failure.Error.Details, struct {
+			ErrorCode string `json:"errorCode"`
+		}{errorCode})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(failure)
+}
+
+func (f *fakeFCM) sent() []fcmPayload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fcmPayload(nil), f.messages...)
+}
+
+func newTestFCMNotifier(t *testing.T, fake *fakeFCM) *FCMNotifier {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(fake.key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	account := ServiceAccount{
+		ProjectID:   "test-project",
+		ClientEmail: "push@test-project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    fake.server.URL + "/token",
+	}
+	notifier, err := NewFCMNotifier(fake.server.Client(), account, fake.server.URL)
+	if err != nil {
+		t.Fatalf("NewFCMNotifier: %v", err)
+	}
+	return notifier
+}
+
+func TestFCMNotifierSend(t *testing.T) {
+	fake := newFakeFCM(t, nil)
+	notifier := newTestFCMNotifier(t, fake)
+
+	conversation := "5b6f7c3e-1d2a-11ef-8c9f-0242ac120002"
+	notification := Notification{
+		Title:       "New messages",
+		Body:        "3 new messages",
+		CollapseKey: conversation,
+		Data:        map[string]string{"conversation_id": conversation, "count": "3"},
+	}
+	for i := 0; i < 2; i++ {
+		if err := notifier.Send(context.Background(), "device-token", notification); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	if fake.grants != 1 {
+		t.Errorf("%d access tokens fetched, want the first one reused", fake.grants)
+	}
+	_, claims := verifyJWT(t, fake.assertions[0], &fake.key.PublicKey)
+	if claims["iss"] != "push@test-project.iam.gserviceaccount.com" || claims["scope"] != fcmScope || claims["aud"] != fake.server.URL+"/token" {
+		t.Errorf("assertion claims = %v", claims)
+	}
+
+	messages := fake.sent()
+	if len(messages) != 2 {
+		t.Fatalf("%d messages sent, want 2", len(messages))
+	}
+	message := messages[0]
+	if message.Token != "device-token" || message.Notification.Title != "New messages" || message.Notification.Body != "3 new messages" {
+		t.Errorf("message = %+v", message)
+	}
+	if message.Data["count"] != "3" || message.Data["conversation_id"] != conversation {
+		t.Errorf("data = %v", message.Data)
+	}
+	// the collapse key reaches Android and iOS devices alike
+	if message.Android == nil || message.Android.CollapseKey != conversation {
+		t.Errorf("android = %+v, want the collapse key", message.Android)
+	}
+	if message.APNs == nil || message.APNs.Headers["apns-collapse-id"] != conversation {
+		t.Errorf("apns = %+v, want the collapse id", message.APNs)
+	}
+
+	if err := notifier.Send(context.Background(), "device-token", Notification{Title: "New message"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if last := fake.sent()[2]; last.Android != nil || last.APNs != nil {
+		t.Errorf("message without a collapse key = %+v, want no platform overrides", last)
+	}
+}
+
+func TestFCMNotifierRejections(t *testing.T) {
+	fake := newFakeFCM(t, map[string]fcmRejection{
+		"unregistered": {http.StatusNotFound, "UNREGISTERED"},
+		"malformed":    {http.StatusBadRequest, "INVALID_ARGUMENT"},
+		"not-found":    {http.StatusNotFound, ""},
+		"quota":        {http.StatusTooManyRequests, "QUOTA_EXCEEDED"},
+		"unavailable":  {http.StatusServiceUnavailable, "UNAVAILABLE"},
+		"internal":     {http.StatusInternalServerError, "INTERNAL"},
+		"sender":       {http.StatusForbidden, "SENDER_ID_MISMATCH"},
+	})
+	notifier := newTestFCMNotifier(t, fake)
+
+	for token, invalid := range map[string]bool{
+		"unregistered": true,
+		"malformed":    true,
+		// a bare 404 may be a wrong project or endpoint, not the token's fault
+		"not-found":   false,
+		"quota":       false,
+		"unavailable": false,
+		"internal":    false,
+		"sender":      false,
+	} {
+		err := notifier.Send(context.Background(), token, Notification{Title: "New message"})
+		if err == nil {
+			t.Errorf("%s: Send succeeded", token)
+			continue
+		}
+		if errors.Is(err, ErrInvalidToken) != invalid {
+			t.Errorf("%s: Send = %v, want the token invalidated %v", token, err, invalid)
+		}
+	}
+}
+
+func TestFCMNotifierRefreshesARevokedAccessToken(t *testing.T) {
+	fake := newFakeFCM(t, nil)
+	notifier := newTestFCMNotifier(t, fake)
+	if err := notifier.Send(context.Background(), "device-token", Notification{}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	fake.mu.Lock()
+	fake.expireTokens = true
+	fake.mu.Unlock()
+	err := notifier.Send(context.Background(), "device-token", Notification{})
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Send with a revoked access token = %v, want an error keeping the device token", err)
+	}
+	if err := notifier.Send(context.Background(), "device-token", Notification{}); err != nil {
+		t.Fatalf("Send after the access token was revoked: %v", err)
+	}
+	if fake.grants != 2 {
+		t.Errorf("%d access tokens fetched, want a new one after the 401", fake.grants)
+	}
+}
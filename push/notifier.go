@@ -0,0 +1,23 @@
+package push
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidToken is returned when the platform no longer accepts a device token, it should be forgotten.
+var ErrInvalidToken = errors.New("device token is no longer valid")
+
+// Notification is what is shown on the device.
+type Notification struct {
+	Title string
+	Body  string
+	// CollapseKey groups notifications, a newer one replaces an older one with the same key on the device.
+	CollapseKey string
+	Data        map[string]string
+}
+
+// Notifier delivers notifications through a push platform.
+type Notifier interface {
+	Send(ctx context.Context, token string, notification Notification) error
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is how many keys are tracked before full buckets are forgotten.
+const maxIdleBuckets = 10000
+
+// RateLimiter hands out tokens per key.
+type RateLimiter interface {
+	// Allow takes a token for the key. When none is left it reports how long until one is.
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket per key: it allows bursts of up to burst requests
+// and refills at perMinute requests a minute. The buckets live in the process, so
+// with several instances each one allows the full rate, RedisLimiter shares them.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+func NewLimiter(perMinute int, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(max(perMinute, 1)) / 60,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token for the key. When none is left it reports how long until one is.
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.forgetFull(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait, nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// forgetFull drops the buckets that refilled completely, they are indistinguishable from new ones.
+func (l *Limiter) forgetFull(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
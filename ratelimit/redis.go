@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeToken refills the bucket in KEYS[1] for the time since it was last used and takes a
+// token from it. ARGV are the refill rate in tokens a millisecond and the burst. It uses the
+// clock of Redis, so the instances do not have to agree on the time. Returns whether a token
+// was taken and otherwise the milliseconds until one is.
+var takeToken = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+-- a bucket that refilled completely is the same as a missing one
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return {allowed, wait}
+`)
+
+// RedisLimiter is Limiter with the buckets kept in Redis, so every instance takes its
+// tokens from the same bucket.
+type RedisLimiter struct {
+	client redis.UniversalClient
+	prefix string
+	rate   float64 // tokens per millisecond
+	burst  int
+}
+
+// NewRedisLimiter creates a limiter that stores the bucket of a key under prefix+key.
+// The limiter owns the client, Close closes it.
+func NewRedisLimiter(client redis.UniversalClient, prefix string, perMinute int, burst int) *RedisLimiter {
+	return &RedisLimiter{
+		client: client,
+		prefix: prefix,
+		rate:   float64(max(perMinute, 1)) / float64(time.Minute/time.Millisecond),
+		burst:  max(burst, 1),
+	}
+}
+
+// Allow takes a token for the key. When none is left it reports how long until one is.
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	result, err := takeToken.Run(ctx, l.client, []string{l.prefix + key}, l.rate, l.burst).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("taking a rate limit token: %w", err)
+	}
+	if result[0] == 1 {
+		return true, 0, nil
+	}
+	return false, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (l *RedisLimiter) Close() error {
+	return l.client.Close()
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestLimiter connects a limiter to the shared miniredis, the way each instance connects to Redis.
+func newTestLimiter(t *testing.T, server *miniredis.Miniredis, perMinute int, burst int) *RedisLimiter {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	l := NewRedisLimiter(client, "test:", perMinute, burst)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestRedisLimiterSharesTheBucketAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+	first := newTestLimiter(t, server, 60, 3)
+	second := newTestLimiter(t, server, 60, 3)
+
+	for i, l := range []*RedisLimiter{first, second, first} {
+		ok, _, err := l.Allow(ctx, "webhook")
+		if err != nil {
+			t.Fatalf("Allow %d: %v", i, err)
+		}
+		if !ok {
+			t.Fatalf("request %d was limited within the burst", i)
+		}
+	}
+
+	ok, wait, err := second.Allow(ctx, "webhook")
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if ok {
+		t.Fatal("the other instance allowed a request past the shared burst")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %v, want up to the second one token takes at 60 a minute", wait)
+	}
+
+	// other keys have their own bucket
+	if ok, _, err := second.Allow(ctx, "other"); err != nil || !ok {
+		t.Errorf("Allow(other) = %v, %v, want allowed", ok, err)
+	}
+}
+
+func TestRedisLimiterRefills(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+	l := newTestLimiter(t, server, 60, 1)
+	start := time.Now()
+	server.SetTime(start)
+
+	if ok, _, err := l.Allow(ctx, "webhook"); err != nil || !ok {
+		t.Fatalf("Allow = %v, %v, want allowed", ok, err)
+	}
+	if ok, _, _ := l.Allow(ctx, "webhook"); ok {
+		t.Fatal("allowed a request past the burst")
+	}
+
+	server.SetTime(start.Add(time.Second))
+	if ok, _, err := l.Allow(ctx, "webhook"); err != nil || !ok {
+		t.Fatalf("Allow after a second = %v, %v, want the refilled token", ok, err)
+	}
+}
+
+func TestRedisLimiterFailsWhenRedisIsDown(t *testing.T) {
+	server := miniredis.RunT(t)
+	l := newTestLimiter(t, server, 60, 1)
+	server.Close()
+
+	if _, _, err := l.Allow(context.Background(), "webhook"); err == nil {
+		t.Fatal("Allow succeeded without redis")
+	}
+}
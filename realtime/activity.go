@@ -0,0 +1,59 @@
+package realtime
+
+import (
+	"sync"
+
+	"github.com/gocql/gocql"
+)
+
+// activity wakes the cursor based consumers, such as long polling, when an event of one of their
+// conversations is published. It only tells them to look, what they missed is read from the change log,
+// which any instance can serve.
+type activity struct {
+	mu       sync.Mutex
+	watchers map[gocql.UUID]map[chan struct{}]struct{}
+}
+
+func newActivity() *activity {
+	return &activity{watchers: make(map[gocql.UUID]map[chan struct{}]struct{})}
+}
+
+func (a *activity) notify(conversationId gocql.UUID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for watcher := range a.watchers[conversationId] {
+		// a pending wake up covers this one too
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Watch returns a channel receiving a value after events of the conversations are published, and the
+// function to stop watching. Wake ups coming faster than they are received are merged into one.
+func (h *Hub) Watch(conversationIds []gocql.UUID) (<-chan struct{}, func()) {
+	watcher := make(chan struct{}, 1)
+	h.activity.mu.Lock()
+	for _, conversationId := range conversationIds {
+		watchers, ok := h.activity.watchers[conversationId]
+		if !ok {
+			watchers = make(map[chan struct{}]struct{})
+			h.activity.watchers[conversationId] = watchers
+		}
+		watchers[watcher] = struct{}{}
+	}
+	h.activity.mu.Unlock()
+
+	return watcher, func() {
+		h.activity.mu.Lock()
+		defer h.activity.mu.Unlock()
+		for _, conversationId := range conversationIds {
+			watchers := h.activity.watchers[conversationId]
+			delete(watchers, watcher)
+			if len(watchers) == 0 {
+				delete(h.activity.watchers, conversationId)
+			}
+		}
+	}
+}
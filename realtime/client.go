@@ -0,0 +1,284 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is the time allowed to write a frame to the peer.
+	writeWait = 10 * time.Second
+	// pongWait is the time allowed to read the next pong from the peer.
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so a healthy peer always answers in time.
+	pingPeriod = pongWait * 9 / 10
+	// maxCommandSize bounds the frames clients send us, they only carry small commands.
+	maxCommandSize = 4 << 10
+	// closeReplaced closes a connection when its device connected again.
+	closeReplaced = 4000
+	// closeEvicted closes a connection when the user connected too many other devices.
+	closeEvicted = 4001
+)
+
+// Authorizer decides whether a user may subscribe to a conversation.
+type Authorizer func(ctx context.Context, userId gocql.UUID, conversationId gocql.UUID) (bool, error)
+
+// command is a frame sent by the client.
+type command struct {
+	Type           string     `json:"type"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	Typing         bool       `json:"typing"` //for "typing", false stops the indicator
+	Status         string     `json:"status"` //for "presence", online or away
+	Seq            uint64     `json:"seq"`    //for "ack", every event up to this one was received
+}
+
+// reply acknowledges a command, it is sent on the same stream as events.
+type reply struct {
+	Type           string     `json:"type"`
+	ConversationID gocql.UUID `json:"conversation_id,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// Client is one WebSocket connection. Outgoing frames go through a bounded buffer,
+// a client that lets it fill up is disconnected instead of slowing everybody down.
+// Connections naming a device get their events through the device's delivery queue instead,
+// numbered and retransmitted until acknowledged.
+type Client struct {
+	hub        *Hub
+	presence   *PresenceTracker
+	deliveries *DeliveryQueues
+	conn       *websocket.Conn
+	userId     gocql.UUID
+	deviceId   string
+	device     *deviceQueue
+	authorize  Authorizer
+	send       chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+
+	mu            sync.Mutex
+	subscriptions map[gocql.UUID]struct{}
+}
+
+// NewClient creates a client, deviceId may be empty for connections that do not need acknowledged delivery.
+func NewClient(hub *Hub, presence *PresenceTracker, deliveries *DeliveryQueues, conn *websocket.Conn, userId gocql.UUID, deviceId string, authorize Authorizer, bufferSize int) *Client {
+	return &Client{
+		hub:           hub,
+		presence:      presence,
+		deliveries:    deliveries,
+		conn:          conn,
+		userId:        userId,
+		deviceId:      deviceId,
+		authorize:     authorize,
+		send:          make(chan []byte, bufferSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[gocql.UUID]struct{}),
+	}
+}
+
+// Run serves the connection until either side closes it.
+func (c *Client) Run(ctx context.Context) {
+	if c.deviceId != "" {
+		c.device = c.deliveries.attach(c, c.deviceId)
+	}
+	c.presence.connect(c)
+	go c.writePump()
+	c.readPump(ctx)
+
+	c.close(websocket.CloseNormalClosure)
+	c.presence.disconnect(c)
+	if c.device != nil {
+		// the queue stays subscribed and holds on to events until the device is back
+		c.device.detach(c)
+		return
+	}
+	c.hub.unsubscribe(c, c.conversationIds()...)
+}
+
+// conversationIds lists the conversations the client subscribed to.
+func (c *Client) conversationIds() []gocql.UUID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conversationIds := make([]gocql.UUID, 0, len(c.subscriptions))
+	for conversationId := range c.subscriptions {
+		conversationIds = append(conversationIds, conversationId)
+	}
+	return conversationIds
+}
+
+// subscribed reports whether the client subscribed to the conversation.
+func (c *Client) subscribed(conversationId gocql.UUID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.subscriptions[conversationId]
+	return ok
+}
+
+func (c *Client) deliver(event Event, payload []byte) bool {
+	return c.enqueue(payload)
+}
+
+// enqueue queues a frame for the writer, reporting false when the buffer is full.
+func (c *Client) enqueue(payload []byte) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+	select {
+	case c.send <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) closeSlow() {
+	c.close(websocket.CloseTryAgainLater)
+}
+
+func (c *Client) close(code int) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		close(c.done)
+	})
+}
+
+func (c *Client) readPump(ctx context.Context) {
+	c.conn.SetReadLimit(maxCommandSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.presence.touch(c)
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var cmd command
+		if err := c.conn.ReadJSON(&cmd); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Info("Realtime connection closed", "user_id", c.userId, "error", err)
+			}
+			return
+		}
+		c.presence.touch(c)
+		c.handle(ctx, cmd)
+	}
+}
+
+func (c *Client) handle(ctx context.Context, cmd command) {
+	switch cmd.Type {
+	case "subscribe":
+		allowed, err := c.authorize(ctx, c.userId, cmd.ConversationID)
+		if err != nil {
+			c.reply(reply{Type: "error", ConversationID: cmd.ConversationID, Error: "failed to check membership"})
+			return
+		}
+		if !allowed {
+			c.reply(reply{Type: "error", ConversationID: cmd.ConversationID, Error: "not a participant of the conversation"})
+			return
+		}
+		c.mu.Lock()
+		c.subscriptions[cmd.ConversationID] = struct{}{}
+		c.mu.Unlock()
+		if c.device != nil {
+			c.device.subscribe(c.hub, cmd.ConversationID)
+		} else {
+			c.hub.subscribe(c, cmd.ConversationID)
+		}
+		c.reply(reply{Type: "subscribed", ConversationID: cmd.ConversationID})
+	case "unsubscribe":
+		c.mu.Lock()
+		delete(c.subscriptions, cmd.ConversationID)
+		c.mu.Unlock()
+		if c.device != nil {
+			c.device.unsubscribe(c.hub, cmd.ConversationID)
+		} else {
+			c.hub.unsubscribe(c, cmd.ConversationID)
+		}
+		c.reply(reply{Type: "unsubscribed", ConversationID: cmd.ConversationID})
+	case "typing":
+		// only members that are listening may type, subscribing already checked membership
+		if !c.subscribed(cmd.ConversationID) {
+			c.reply(reply{Type: "error", ConversationID: cmd.ConversationID, Error: "subscribe to the conversation first"})
+			return
+		}
+		c.presence.setTyping(c.userId, cmd.ConversationID, cmd.Typing)
+	case "presence":
+		if cmd.Status != PresenceOnline && cmd.Status != PresenceAway {
+			c.reply(reply{Type: "error", Error: "status must be online or away"})
+			return
+		}
+		c.presence.setStatus(c, cmd.Status)
+	case "ack":
+		if c.device == nil {
+			c.reply(reply{Type: "error", Error: "acknowledgements need a device_id"})
+			return
+		}
+		if err := c.device.ack(cmd.Seq); err != nil {
+			c.reply(reply{Type: "error", Error: err.Error()})
+		}
+	default:
+		c.reply(reply{Type: "error", Error: "unknown command " + cmd.Type})
+	}
+}
+
+// reply sends a frame that is not an event, such as a command acknowledgement.
+func (c *Client) reply(frame interface{}) {
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	if !c.enqueue(payload) {
+		c.closeSlow()
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case payload := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				c.close(websocket.CloseAbnormalClosure)
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.close(websocket.CloseAbnormalClosure)
+				return
+			}
+		case <-c.done:
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				message := websocket.FormatCloseMessage(c.closeCode, closeReason(c.closeCode))
+				c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+			}
+			return
+		}
+	}
+}
+
+func closeReason(code int) string {
+	switch code {
+	case websocket.CloseTryAgainLater:
+		return "slow consumer"
+	case closeReplaced:
+		return "replaced by a newer connection of the device"
+	case closeEvicted:
+		return "evicted by the other devices of the user"
+	}
+	return ""
+}
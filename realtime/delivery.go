@@ -0,0 +1,354 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var ErrInvalidAck = errors.New("acknowledged a sequence number that was not delivered")
+
+// sequenced is the frame of an event delivered to a device, the client acknowledges its seq.
+type sequenced struct {
+	Type  string          `json:"type"`
+	Seq   uint64          `json:"seq"`
+	Event json.RawMessage `json:"event"`
+}
+
+// session is sent first on connections that name a device. Resumed is false when the server
+// had no queue for the device, events may have been missed and the client should catch up over REST.
+// Otherwise every event after Acked is retransmitted.
+type session struct {
+	Type     string `json:"type"`
+	DeviceID string `json:"device_id"`
+	Resumed  bool   `json:"resumed"`
+	Acked    uint64 `json:"acked"`
+}
+
+type deviceKey struct {
+	userId   gocql.UUID
+	deviceId string
+}
+
+type pendingEvent struct {
+	seq      uint64
+	frame    []byte
+	queuedAt time.Time
+}
+
+// DeliveryQueues gives every device of a user at-least-once delivery: events are numbered per device
+// and kept until the device acknowledges them, across reconnects. While a device is offline its queue
+// stays subscribed and keeps collecting events, bounded by maxSize events and maxAge; a queue offline
+// for longer than maxAge is dropped. A user keeps at most maxDevices queues, connecting another device
+// evicts the one attached least recently. Queues live in the memory of the instance the device connects to.
+type DeliveryQueues struct {
+	hub        *Hub
+	maxSize    int
+	maxAge     time.Duration
+	maxDevices int
+
+	mu     sync.Mutex
+	queues map[gocql.UUID]map[string]*deviceQueue // by user, then device
+}
+
+func NewDeliveryQueues(hub *Hub, maxSize int, maxAge time.Duration, maxDevices int) *DeliveryQueues {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	if maxDevices < 1 {
+		maxDevices = 1
+	}
+	return &DeliveryQueues{
+		hub:        hub,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxDevices: maxDevices,
+		queues:     make(map[gocql.UUID]map[string]*deviceQueue),
+	}
+}
+
+// Run drops expired events and queues until ctx is cancelled.
+func (d *DeliveryQueues) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.expire(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// attach hands the device's queue to the client, retransmitting what it has not acknowledged.
+func (d *DeliveryQueues) attach(c *Client, deviceId string) *deviceQueue {
+	key := deviceKey{userId: c.userId, deviceId: deviceId}
+	var evicted *deviceQueue
+	d.mu.Lock()
+	devices := d.queues[c.userId]
+	if devices == nil {
+		devices = make(map[string]*deviceQueue)
+		d.queues[c.userId] = devices
+	}
+	queue, resumed := devices[deviceId]
+	if !resumed {
+		if len(devices) >= d.maxDevices {
+			evicted = leastRecentlyAttached(devices)
+			delete(devices, evicted.key.deviceId)
+		}
+		queue = &deviceQueue{
+			key:           key,
+			maxSize:       d.maxSize,
+			subscriptions: make(map[gocql.UUID]struct{}),
+		}
+		devices[deviceId] = queue
+	}
+	queue.attachedAt = time.Now()
+	d.mu.Unlock()
+
+	if evicted != nil {
+		evicted.evict()
+		d.hub.unsubscribe(evicted, evicted.conversationIds()...)
+	}
+	queue.attach(c, resumed)
+	return queue
+}
+
+func (d *DeliveryQueues) expire(now time.Time) {
+	var dropped []*deviceQueue
+	d.mu.Lock()
+	for userId, devices := range d.queues {
+		for deviceId, queue := range devices {
+			if queue.expire(now, d.maxAge) {
+				delete(devices, deviceId)
+				dropped = append(dropped, queue)
+			}
+		}
+		if len(devices) == 0 {
+			delete(d.queues, userId)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, queue := range dropped {
+		d.hub.unsubscribe(queue, queue.conversationIds()...)
+	}
+}
+
+// leastRecentlyAttached picks the queue to evict among the devices of a user.
+func leastRecentlyAttached(devices map[string]*deviceQueue) *deviceQueue {
+	var oldest *deviceQueue
+	for _, queue := range devices {
+		if oldest == nil || queue.attachedAt.Before(oldest.attachedAt) {
+			oldest = queue
+		}
+	}
+	return oldest
+}
+
+// deviceQueue is the hub subscriber of a device. The client attached to it is sent events in order,
+// as far as its send buffer allows; the rest waits in the queue and goes out as the client acknowledges.
+type deviceQueue struct {
+	key     deviceKey
+	maxSize int
+	// attachedAt is when a client last attached, guarded by the mutex of DeliveryQueues
+	attachedAt time.Time
+
+	mu            sync.Mutex
+	client        *Client
+	detachedAt    time.Time
+	evicted       bool
+	subscriptions map[gocql.UUID]struct{}
+	lastSeq       uint64 // last sequence number assigned
+	acked         uint64 // every event up to here was acknowledged or dropped
+	sentUpTo      uint64 // handed to the attached client up to here
+	pending       []pendingEvent
+}
+
+func (q *deviceQueue) attach(c *Client, resumed bool) {
+	q.mu.Lock()
+	previous := q.client
+	q.client = c
+	// whatever went to an earlier connection may not have arrived
+	q.sentUpTo = q.acked
+	c.mu.Lock()
+	for conversationId := range q.subscriptions {
+		c.subscriptions[conversationId] = struct{}{}
+	}
+	c.mu.Unlock()
+	c.reply(session{Type: "session", DeviceID: q.key.deviceId, Resumed: resumed, Acked: q.acked})
+	q.flushLocked()
+	q.mu.Unlock()
+
+	if previous != nil {
+		previous.close(closeReplaced)
+	}
+}
+
+// evict closes the connection of a device whose queue was dropped to make room for another device.
+func (q *deviceQueue) evict() {
+	q.mu.Lock()
+	client := q.client
+	q.client = nil
+	q.evicted = true
+	q.mu.Unlock()
+
+	if client != nil {
+		client.close(closeEvicted)
+	}
+}
+
+// detach keeps the queue collecting events for the device after the client went away.
+func (q *deviceQueue) detach(c *Client) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.client == c {
+		q.client = nil
+		q.detachedAt = time.Now()
+	}
+}
+
+func (q *deviceQueue) subscribe(hub *Hub, conversationId gocql.UUID) {
+	q.mu.Lock()
+	if q.evicted {
+		q.mu.Unlock()
+		return
+	}
+	q.subscriptions[conversationId] = struct{}{}
+	q.mu.Unlock()
+	hub.subscribe(q, conversationId)
+
+	// evicted meanwhile, the eviction may have unsubscribed before we subscribed
+	q.mu.Lock()
+	evicted := q.evicted
+	q.mu.Unlock()
+	if evicted {
+		hub.unsubscribe(q, conversationId)
+	}
+}
+
+func (q *deviceQueue) unsubscribe(hub *Hub, conversationId gocql.UUID) {
+	q.mu.Lock()
+	delete(q.subscriptions, conversationId)
+	q.mu.Unlock()
+	hub.unsubscribe(q, conversationId)
+}
+
+func (q *deviceQueue) conversationIds() []gocql.UUID {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	conversationIds := make([]gocql.UUID, 0, len(q.subscriptions))
+	for conversationId := range q.subscriptions {
+		conversationIds = append(conversationIds, conversationId)
+	}
+	return conversationIds
+}
+
+// ack drops every event up to seq and sends what was waiting for room in the client's buffer.
+func (q *deviceQueue) ack(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if seq > q.lastSeq {
+		return ErrInvalidAck
+	}
+	if seq <= q.acked {
+		// a late or repeated ack
+		return nil
+	}
+	q.acked = seq
+	// an earlier connection of the device may have received more than this one was sent
+	if seq > q.sentUpTo {
+		q.sentUpTo = seq
+	}
+	drop := 0
+	for drop < len(q.pending) && q.pending[drop].seq <= seq {
+		drop++
+	}
+	q.pending = q.pending[drop:]
+	q.flushLocked()
+	return nil
+}
+
+// deliver numbers and queues durable events. Ephemeral ones only go to a connected client.
+// The queue never reports itself slow, it drops its oldest events instead.
+func (q *deviceQueue) deliver(event Event, payload []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if event.ephemeral() {
+		if q.client != nil {
+			q.client.enqueue(payload)
+		}
+		return true
+	}
+
+	q.lastSeq++
+	frame, err := json.Marshal(sequenced{Type: "event", Seq: q.lastSeq, Event: payload})
+	if err != nil {
+		slog.Error("Failed to encode sequenced event", "type", event.Type, "error", err)
+		return true
+	}
+	q.pending = append(q.pending, pendingEvent{seq: q.lastSeq, frame: frame, queuedAt: time.Now()})
+	if overflow := len(q.pending) - q.maxSize; overflow > 0 {
+		// the device will see the gap in the sequence numbers and catch up over REST
+		q.dropLocked(overflow)
+	}
+	q.flushLocked()
+	return true
+}
+
+// closeSlow is never needed, deliver does not fail.
+func (q *deviceQueue) closeSlow() {}
+
+// expire drops events older than maxAge and reports whether the whole queue expired.
+func (q *deviceQueue) expire(now time.Time, maxAge time.Duration) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.client == nil && now.Sub(q.detachedAt) > maxAge {
+		return true
+	}
+	stale := 0
+	for stale < len(q.pending) && now.Sub(q.pending[stale].queuedAt) > maxAge {
+		stale++
+	}
+	q.dropLocked(stale)
+	return false
+}
+
+// dropLocked gives up on the n oldest events, they count as acknowledged.
+func (q *deviceQueue) dropLocked(n int) {
+	if n <= 0 {
+		return
+	}
+	last := q.pending[n-1].seq
+	q.pending = q.pending[n:]
+	if last > q.acked {
+		q.acked = last
+	}
+	if last > q.sentUpTo {
+		q.sentUpTo = last
+	}
+}
+
+// flushLocked sends the attached client the events it has not been sent yet, in order,
+// stopping when its buffer is full.
+func (q *deviceQueue) flushLocked() {
+	if q.client == nil {
+		return
+	}
+	for _, pending := range q.pending {
+		if pending.seq <= q.sentUpTo {
+			continue
+		}
+		if !q.client.enqueue(pending.frame) {
+			return
+		}
+		q.sentUpTo = pending.seq
+	}
+}
@@ -0,0 +1,87 @@
+package realtime
+
+import (
+	"encoding/json"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+)
+
+// Event types pushed to clients.
+const (
+	EventMessageCreated  = events.TypeMessageCreated
+	EventMessageUpdated  = events.TypeMessageUpdated
+	EventMessageDeleted  = events.TypeMessageDeleted
+	EventReactionAdded   = events.TypeReactionAdded
+	EventReactionRemoved = events.TypeReactionRemoved
+	EventPresenceChanged = events.TypePresenceChanged
+	EventTypingStarted   = events.TypeTypingStarted
+	EventTypingStopped   = events.TypeTypingStopped
+)
+
+// Event is a change in a conversation, as delivered to subscribed clients.
+type Event struct {
+	Type           string      `json:"type"`
+	ConversationID gocql.UUID  `json:"conversation_id"`
+	Data           interface{} `json:"data"`
+}
+
+// fromDomainEvent converts a domain event into what clients receive, false for events clients don't see.
+func fromDomainEvent(event events.Event) (Event, bool) {
+	var data interface{}
+	switch e := event.(type) {
+	case events.MessageCreated:
+		data = e.Message
+	case events.MessageUpdated:
+		data = e.Message
+	case events.MessageDeleted:
+		data = map[string]gocql.UUID{"id": e.MessageID}
+	case events.ReactionAdded:
+		data = e.Reaction
+	case events.ReactionRemoved:
+		data = e.Reaction
+	case events.PresenceChanged:
+		data = Presence{UserID: e.UserID, Status: e.Status, LastSeen: e.LastSeen}
+	case events.TypingStarted:
+		data = Typing{UserID: e.UserID, ConversationID: e.ConversationID, ExpiresAt: e.ExpiresAt}
+	case events.TypingStopped:
+		data = Typing{UserID: e.UserID, ConversationID: e.ConversationID}
+	default:
+		return Event{}, false
+	}
+	return Event{Type: event.Type(), ConversationID: event.Conversation(), Data: data}, true
+}
+
+// MessageID returns the id of the message a message.* event is about.
+// Data may be a models.Message or its JSON form, so it is read back through JSON.
+func (e Event) MessageID() (gocql.UUID, bool) {
+	switch e.Type {
+	case EventMessageCreated, EventMessageUpdated, EventMessageDeleted:
+	default:
+		return gocql.UUID{}, false
+	}
+
+	raw, ok := e.Data.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(e.Data); err != nil {
+			return gocql.UUID{}, false
+		}
+	}
+	var message struct {
+		ID gocql.UUID `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &message); err != nil || message.ID == (gocql.UUID{}) {
+		return gocql.UUID{}, false
+	}
+	return message.ID, true
+}
+
+// ephemeral reports whether the event only matters to clients connected right now, such as typing.
+func (e Event) ephemeral() bool {
+	switch e.Type {
+	case EventPresenceChanged, EventTypingStarted, EventTypingStopped:
+		return true
+	}
+	return false
+}
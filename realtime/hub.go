@@ -0,0 +1,102 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+)
+
+// subscriber is anything the hub can hand events to: WebSocket clients and streams.
+type subscriber interface {
+	// deliver queues the event without blocking, reporting false when the subscriber cannot keep up.
+	deliver(event Event, payload []byte) bool
+	closeSlow()
+}
+
+// Hub fans events out to the subscribers of their conversation.
+type Hub struct {
+	mu            sync.RWMutex
+	subscriptions map[gocql.UUID]map[subscriber]struct{}
+	// wakes cursor based consumers such as long polling
+	activity *activity
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscriptions: make(map[gocql.UUID]map[subscriber]struct{}),
+		activity:      newActivity(),
+	}
+}
+
+// HandleEvent is the backplane subscriber pushing events to the clients of the conversation.
+// Ephemeral events only reach the clients connected right now, they do not wake cursor based consumers.
+func (h *Hub) HandleEvent(ctx context.Context, event events.Event) error {
+	realtimeEvent, ok := fromDomainEvent(event)
+	switch {
+	case !ok:
+	case realtimeEvent.ephemeral():
+		h.broadcast(realtimeEvent)
+	default:
+		h.Publish(realtimeEvent)
+	}
+	return nil
+}
+
+// Publish delivers the event without blocking, subscribers that cannot keep up are disconnected.
+// Cursor based consumers watching the conversation are woken up.
+func (h *Hub) Publish(event Event) {
+	h.activity.notify(event.ConversationID)
+	h.broadcast(event)
+}
+
+// broadcast delivers the event to the connected subscribers only, for ephemeral events such as typing.
+func (h *Hub) broadcast(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode realtime event", "type", event.Type, "error", err)
+		return
+	}
+
+	h.mu.RLock()
+	var slow []subscriber
+	for sub := range h.subscriptions[event.ConversationID] {
+		if !sub.deliver(event, payload) {
+			slow = append(slow, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range slow {
+		slog.Warn("Disconnecting slow realtime subscriber", "conversation_id", event.ConversationID)
+		sub.closeSlow()
+	}
+}
+
+func (h *Hub) subscribe(sub subscriber, conversationIds ...gocql.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, conversationId := range conversationIds {
+		subs, ok := h.subscriptions[conversationId]
+		if !ok {
+			subs = make(map[subscriber]struct{})
+			h.subscriptions[conversationId] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+}
+
+func (h *Hub) unsubscribe(sub subscriber, conversationIds ...gocql.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, conversationId := range conversationIds {
+		subs := h.subscriptions[conversationId]
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(h.subscriptions, conversationId)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+)
+
+// Presence states.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// persistTimeout bounds saving last_seen once the connection that triggered it is gone.
+const persistTimeout = 5 * time.Second
+
+// Presence is what other users see of a user's connectivity.
+type Presence struct {
+	UserID   gocql.UUID `json:"user_id"`
+	Status   string     `json:"status"`
+	LastSeen time.Time  `json:"last_seen,omitempty"`
+}
+
+// Typing is the payload of typing events.
+type Typing struct {
+	UserID         gocql.UUID `json:"user_id"`
+	ConversationID gocql.UUID `json:"conversation_id"`
+	ExpiresAt      time.Time  `json:"expires_at,omitempty"`
+}
+
+// LastSeenStore persists when users were last seen, so it survives restarts and is known for offline users.
+type LastSeenStore interface {
+	SaveLastSeen(ctx context.Context, userId gocql.UUID, lastSeen time.Time) error
+	// GetLastSeen returns gocql.ErrNotFound for users never seen.
+	GetLastSeen(ctx context.Context, userId gocql.UUID) (time.Time, error)
+}
+
+// PresenceDirectory shares the presence of users across the instances of the service, so a user
+// connected to another instance is not taken for offline.
+type PresenceDirectory interface {
+	// SharePresence records the status of users connected to this instance, it holds for ttl unless shared again.
+	SharePresence(ctx context.Context, statuses map[gocql.UUID]string, ttl time.Duration) error
+	// ClearPresence records that the user has no connection left on this instance.
+	ClearPresence(ctx context.Context, userId gocql.UUID) error
+	// PresenceStatuses lists the status of the user on every instance they are connected to.
+	PresenceStatuses(ctx context.Context, userId gocql.UUID) ([]string, error)
+}
+
+type connectionState struct {
+	status    string
+	expiresAt time.Time
+}
+
+type typingKey struct {
+	userId         gocql.UUID
+	conversationId gocql.UUID
+}
+
+// PresenceTracker keeps presence and typing state in memory with a TTL: connections must keep
+// sending pongs or commands to stay online, typing has to be repeated to keep showing.
+// A user is online while any of their connections is, away when all of them are away. With a directory
+// the connections of every instance count, otherwise only the ones to this instance do.
+// Presence and typing changes go out through the backplane, so they reach clients on every instance.
+type PresenceTracker struct {
+	backplane events.Publisher
+	store     LastSeenStore
+	directory PresenceDirectory
+	ttl       time.Duration
+	typingTTL time.Duration
+
+	mu          sync.Mutex
+	connections map[gocql.UUID]map[*Client]*connectionState
+	lastSeen    map[gocql.UUID]time.Time
+	typing      map[typingKey]time.Time
+}
+
+// NewPresenceTracker creates a tracker, directory is nil when this is the only instance.
+func NewPresenceTracker(backplane events.Publisher, store LastSeenStore, directory PresenceDirectory, ttl time.Duration, typingTTL time.Duration) *PresenceTracker {
+	return &PresenceTracker{
+		backplane:   backplane,
+		store:       store,
+		directory:   directory,
+		ttl:         ttl,
+		typingTTL:   typingTTL,
+		connections: make(map[gocql.UUID]map[*Client]*connectionState),
+		lastSeen:    make(map[gocql.UUID]time.Time),
+		typing:      make(map[typingKey]time.Time),
+	}
+}
+
+// Run expires stale connections and typing indicators until ctx is cancelled. It also keeps sharing
+// the users connected here with the directory, well within the TTL so they never lapse in between.
+func (t *PresenceTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	share := time.NewTicker(max(t.ttl/3, time.Second))
+	defer share.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.expire(now)
+		case <-share.C:
+			t.shareAll(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Get returns the presence of a user, falling back to the stored last_seen when they are not connected.
+func (t *PresenceTracker) Get(ctx context.Context, userId gocql.UUID) (Presence, error) {
+	t.mu.Lock()
+	status := t.statusLocked(userId)
+	lastSeen := t.lastSeen[userId]
+	t.mu.Unlock()
+
+	status = t.overall(ctx, userId, status)
+	presence := Presence{UserID: userId, Status: status, LastSeen: lastSeen}
+	if status != PresenceOffline {
+		return presence, nil
+	}
+	stored, err := t.store.GetLastSeen(ctx, userId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return presence, nil
+	}
+	if err != nil {
+		return Presence{}, err
+	}
+	if stored.After(presence.LastSeen) {
+		presence.LastSeen = stored
+	}
+	return presence, nil
+}
+
+// IsOnline reports whether the user has a live connection to any instance, online or away.
+// When the directory cannot be reached only the connections to this instance count.
+func (t *PresenceTracker) IsOnline(ctx context.Context, userId gocql.UUID) bool {
+	t.mu.Lock()
+	status := t.statusLocked(userId)
+	t.mu.Unlock()
+	return t.overall(ctx, userId, status) != PresenceOffline
+}
+
+// connect registers a new connection as online.
+func (t *PresenceTracker) connect(c *Client) {
+	now := time.Now()
+	t.mu.Lock()
+	before := t.statusLocked(c.userId)
+	conns, ok := t.connections[c.userId]
+	if !ok {
+		conns = make(map[*Client]*connectionState)
+		t.connections[c.userId] = conns
+	}
+	conns[c] = &connectionState{status: PresenceOnline, expiresAt: now.Add(t.ttl)}
+	t.lastSeen[c.userId] = now
+	after := t.statusLocked(c.userId)
+	t.mu.Unlock()
+
+	t.persist(c.userId, now)
+	if before != after {
+		t.share(c.userId, after)
+		t.announce(c.userId, t.overall(context.Background(), c.userId, after), now)
+	}
+}
+
+// touch keeps the connection alive, called on every pong and command.
+func (t *PresenceTracker) touch(c *Client) {
+	now := time.Now()
+	t.mu.Lock()
+	state, ok := t.connections[c.userId][c]
+	if ok {
+		state.expiresAt = now.Add(t.ttl)
+		t.lastSeen[c.userId] = now
+	}
+	t.mu.Unlock()
+	if !ok {
+		// expired while the socket stayed open, it is back
+		t.connect(c)
+	}
+}
+
+// setStatus switches a connection between online and away.
+func (t *PresenceTracker) setStatus(c *Client, status string) {
+	now := time.Now()
+	t.mu.Lock()
+	state, ok := t.connections[c.userId][c]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	before := t.statusLocked(c.userId)
+	state.status = status
+	state.expiresAt = now.Add(t.ttl)
+	t.lastSeen[c.userId] = now
+	after := t.statusLocked(c.userId)
+	t.mu.Unlock()
+
+	if before != after {
+		t.share(c.userId, after)
+		t.announce(c.userId, t.overall(context.Background(), c.userId, after), now)
+	}
+}
+
+// disconnect removes a connection, the user goes offline with their last connection.
+func (t *PresenceTracker) disconnect(c *Client) {
+	t.mu.Lock()
+	if _, ok := t.connections[c.userId][c]; !ok {
+		// already expired
+		t.mu.Unlock()
+		return
+	}
+	before := t.statusLocked(c.userId)
+	t.removeLocked(c)
+	after := t.statusLocked(c.userId)
+	lastSeen := t.lastSeen[c.userId]
+	if after == PresenceOffline {
+		delete(t.lastSeen, c.userId)
+	}
+	t.mu.Unlock()
+
+	if after == PresenceOffline {
+		t.persist(c.userId, lastSeen)
+	}
+	if before != after {
+		t.share(c.userId, after)
+		t.announce(c.userId, t.overall(context.Background(), c.userId, after), lastSeen, c)
+	}
+}
+
+// setTyping starts or stops the typing indicator of the user in a conversation.
+func (t *PresenceTracker) setTyping(userId gocql.UUID, conversationId gocql.UUID, typing bool) {
+	key := typingKey{userId: userId, conversationId: conversationId}
+	t.mu.Lock()
+	_, wasTyping := t.typing[key]
+	expiresAt := time.Now().Add(t.typingTTL)
+	if typing {
+		t.typing[key] = expiresAt
+	} else {
+		delete(t.typing, key)
+	}
+	t.mu.Unlock()
+
+	switch {
+	case typing && !wasTyping:
+		t.signal(events.TypingStarted{UserID: userId, ConversationID: conversationId, ExpiresAt: expiresAt, At: time.Now()})
+	case !typing && wasTyping:
+		t.signal(events.TypingStopped{UserID: userId, ConversationID: conversationId, At: time.Now()})
+	}
+}
+
+func (t *PresenceTracker) expire(now time.Time) {
+	var stopped []typingKey
+	var stale []*Client
+	t.mu.Lock()
+	for key, expiresAt := range t.typing {
+		if now.After(expiresAt) {
+			delete(t.typing, key)
+			stopped = append(stopped, key)
+		}
+	}
+	for _, conns := range t.connections {
+		for c, state := range conns {
+			if now.After(state.expiresAt) {
+				stale = append(stale, c)
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	for _, key := range stopped {
+		t.signal(events.TypingStopped{UserID: key.userId, ConversationID: key.conversationId, At: now})
+	}
+	for _, c := range stale {
+		// the connection stopped answering, count it as gone even before the socket notices
+		t.disconnect(c)
+	}
+}
+
+func (t *PresenceTracker) statusLocked(userId gocql.UUID) string {
+	status := PresenceOffline
+	for _, state := range t.connections[userId] {
+		if state.status == PresenceOnline {
+			return PresenceOnline
+		}
+		status = PresenceAway
+	}
+	return status
+}
+
+func (t *PresenceTracker) removeLocked(c *Client) {
+	conns := t.connections[c.userId]
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(t.connections, c.userId)
+	}
+}
+
+// announce tells every conversation the user's connections subscribed to about the new status,
+// including the ones of a connection that just went away.
+func (t *PresenceTracker) announce(userId gocql.UUID, status string, lastSeen time.Time, gone ...*Client) {
+	t.mu.Lock()
+	clients := gone
+	for c := range t.connections[userId] {
+		clients = append(clients, c)
+	}
+	t.mu.Unlock()
+
+	conversations := make(map[gocql.UUID]struct{})
+	for _, c := range clients {
+		for _, conversationId := range c.conversationIds() {
+			conversations[conversationId] = struct{}{}
+		}
+	}
+
+	for conversationId := range conversations {
+		t.signal(events.PresenceChanged{UserID: userId, ConversationID: conversationId, Status: status, LastSeen: lastSeen})
+	}
+}
+
+// signal hands a presence or typing change to the backplane, which delivers it to the clients of
+// every instance. Like the changes themselves, a failure is only worth a log line.
+func (t *PresenceTracker) signal(event events.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	if err := t.backplane.Publish(ctx, event); err != nil {
+		slog.Error("Failed to signal realtime event", "type", event.Type(), "conversation_id", event.Conversation(), "error", err)
+	}
+}
+
+// share tells the directory about a change of the user's status on this instance.
+func (t *PresenceTracker) share(userId gocql.UUID, status string) {
+	if t.directory == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	var err error
+	if status == PresenceOffline {
+		err = t.directory.ClearPresence(ctx, userId)
+	} else {
+		err = t.directory.SharePresence(ctx, map[gocql.UUID]string{userId: status}, t.ttl)
+	}
+	if err != nil {
+		slog.Error("Failed to share presence", "user_id", userId, "error", err)
+	}
+}
+
+// shareAll renews the presence of every user connected to this instance.
+func (t *PresenceTracker) shareAll(ctx context.Context) {
+	if t.directory == nil {
+		return
+	}
+	t.mu.Lock()
+	statuses := make(map[gocql.UUID]string, len(t.connections))
+	for userId := range t.connections {
+		statuses[userId] = t.statusLocked(userId)
+	}
+	t.mu.Unlock()
+
+	if err := t.directory.SharePresence(ctx, statuses, t.ttl); err != nil {
+		slog.Error("Failed to share presence", "users", len(statuses), "error", err)
+	}
+}
+
+// overall combines the user's status on this instance with their status on the others: online if any
+// connection is, away if they are connected at all. When the directory cannot be reached only the
+// connections to this instance count.
+func (t *PresenceTracker) overall(ctx context.Context, userId gocql.UUID, status string) string {
+	if status == PresenceOnline || t.directory == nil {
+		return status
+	}
+	ctx, cancel := context.WithTimeout(ctx, persistTimeout)
+	defer cancel()
+	statuses, err := t.directory.PresenceStatuses(ctx, userId)
+	if err != nil {
+		slog.Error("Failed to look up shared presence", "user_id", userId, "error", err)
+		return status
+	}
+	for _, other := range statuses {
+		if other == PresenceOnline {
+			return PresenceOnline
+		}
+		status = PresenceAway
+	}
+	return status
+}
+
+func (t *PresenceTracker) persist(userId gocql.UUID, lastSeen time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	if err := t.store.SaveLastSeen(ctx, userId, lastSeen); err != nil {
+		slog.Error("Failed to save last seen", "user_id", userId, "error", err)
+	}
+}
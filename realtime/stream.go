@@ -0,0 +1,65 @@
+package realtime
+
+import (
+	"sync"
+
+	"github.com/gocql/gocql"
+)
+
+// Stream hands the events of a set of conversations to a single consumer through a bounded channel,
+// for transports that are not WebSockets such as server-sent events.
+type Stream struct {
+	hub             *Hub
+	conversationIds []gocql.UUID
+	events          chan Event
+	done            chan struct{}
+	closeOnce       sync.Once
+}
+
+// OpenStream subscribes to the given conversations, the stream must be closed once the consumer is done.
+func (h *Hub) OpenStream(bufferSize int, conversationIds ...gocql.UUID) *Stream {
+	stream := &Stream{
+		hub:             h,
+		conversationIds: conversationIds,
+		events:          make(chan Event, bufferSize),
+		done:            make(chan struct{}),
+	}
+	h.subscribe(stream, conversationIds...)
+	return stream
+}
+
+// Events yields the events in the order they were published.
+func (s *Stream) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed when the consumer fell too far behind and the stream was dropped.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close unsubscribes the stream from the hub.
+func (s *Stream) Close() {
+	s.hub.unsubscribe(s, s.conversationIds...)
+	s.closeSlow()
+}
+
+func (s *Stream) deliver(event Event, payload []byte) bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+	}
+	select {
+	case s.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Stream) closeSlow() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
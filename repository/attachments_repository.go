@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// AttachmentsRepository defines the interface for attachment metadata operations.
+type AttachmentsRepository interface {
+	CreateAttachment(ctx context.Context, attachment models.Attachment) (models.Attachment, error)
+	GetAttachment(ctx context.Context, id gocql.UUID) (models.Attachment, error)
+	LinkAttachment(ctx context.Context, id gocql.UUID, messageId gocql.UUID) error
+	ClaimAttachment(ctx context.Context, id gocql.UUID, messageId gocql.UUID) (bool, error)
+	ReleaseAttachment(ctx context.Context, id gocql.UUID, messageId gocql.UUID) error
+	UpdateProcessingResult(ctx context.Context, attachment models.Attachment) error
+	GetAttachmentsByMessage(ctx context.Context, messageId gocql.UUID) ([]models.Attachment, error)
+	GetPendingAttachments(ctx context.Context, createdBefore time.Time, limit int) ([]models.Attachment, error)
+}
+
+// pendingShard is the partition of pending_attachments.
+const pendingShard = 0
+
+// attachmentsRepository is the concrete implementation of AttachmentsRepository.
+type attachmentsRepository struct {
+	session *gocqlx.Session
+}
+
+// NewAttachmentsRepository creates a new instance of attachmentsRepository.
+func NewAttachmentsRepository(session *gocqlx.Session) AttachmentsRepository {
+	return &attachmentsRepository{session: session}
+}
+
+// CreateAttachment stores the metadata of an uploaded attachment, listing it as pending until it is processed.
+func (r *attachmentsRepository) CreateAttachment(ctx context.Context, attachment models.Attachment) (models.Attachment, error) {
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(r.session.Query(models.AttachmentTable.Insert()), attachment); err != nil {
+		return models.Attachment{}, err
+	}
+	if attachment.Status == models.AttachmentStatusPending {
+		pendingQuery := r.session.Query(models.PendingAttachmentTable.Insert())
+		if err := batch.BindMap(pendingQuery, qb.M{"shard": pendingShard, "id": attachment.ID}); err != nil {
+			return models.Attachment{}, err
+		}
+	}
+	if err := r.session.ExecuteBatch(batch); err != nil {
+		return models.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// GetAttachment retrieves the metadata of a single attachment.
+func (r *attachmentsRepository) GetAttachment(ctx context.Context, id gocql.UUID) (models.Attachment, error) {
+	query := qb.Select(models.AttachmentTable.Name()).
+		Columns(models.AttachmentTable.Metadata().Columns...).
+		Where(qb.Eq("id")).
+		Query(*r.session)
+
+	var attachment models.Attachment
+	if err := query.BindMap(qb.M{"id": id}).GetRelease(&attachment); err != nil {
+		return models.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// LinkAttachment attaches an uploaded attachment to a message.
+func (r *attachmentsRepository) LinkAttachment(ctx context.Context, id gocql.UUID, messageId gocql.UUID) error {
+	query := qb.Update(models.AttachmentTable.Name()).
+		Set("message_id").
+		Where(qb.Eq("id")).
+		Query(*r.session)
+	byMessageQuery := r.session.Query(models.AttachmentByMessageTable.Insert())
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindMap(query, qb.M{"id": id, "message_id": messageId}); err != nil {
+		return err
+	}
+	if err := batch.BindMap(byMessageQuery, qb.M{"id": id, "message_id": messageId}); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+// ClaimAttachment reserves an unlinked attachment for a message. It is a lightweight transaction,
+// so an attachment is claimed by a single message however many race for it, and only attachments
+// the scanners cleared can be claimed. It reports false when the attachment was not claimed.
+func (r *attachmentsRepository) ClaimAttachment(ctx context.Context, id gocql.UUID, messageId gocql.UUID) (bool, error) {
+	query := qb.Update(models.AttachmentTable.Name()).
+		Set("message_id").
+		Where(qb.Eq("id")).
+		If(qb.EqLit("message_id", "null"), qb.InLit("status", "('"+models.AttachmentStatusReady+"', '"+models.AttachmentStatusFailed+"')")).
+		Query(*r.session)
+
+	return query.BindMap(qb.M{"id": id, "message_id": messageId}).ExecCASRelease()
+}
+
+// ReleaseAttachment undoes a claim whose message could not be written.
+func (r *attachmentsRepository) ReleaseAttachment(ctx context.Context, id gocql.UUID, messageId gocql.UUID) error {
+	query := qb.Update(models.AttachmentTable.Name()).
+		SetLit("message_id", "null").
+		Where(qb.Eq("id")).
+		If(qb.EqNamed("message_id", "claimed_by")).
+		Query(*r.session)
+
+	_, err := query.BindMap(qb.M{"id": id, "claimed_by": messageId}).ExecCASRelease()
+	return err
+}
+
+// UpdateProcessingResult stores what the processing pipeline learned about an attachment, taking it off
+// the pending list.
+func (r *attachmentsRepository) UpdateProcessingResult(ctx context.Context, attachment models.Attachment) error {
+	query := qb.Update(models.AttachmentTable.Name()).
+		Set("content_type", "status", "width", "height", "thumbnail_key", "storage_key", "quarantine_reason").
+		Where(qb.Eq("id")).
+		Query(*r.session)
+	pendingQuery := r.session.Query(models.PendingAttachmentTable.Delete())
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(query, attachment); err != nil {
+		return err
+	}
+	if err := batch.BindMap(pendingQuery, qb.M{"shard": pendingShard, "id": attachment.ID}); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+// GetPendingAttachments retrieves up to limit attachments still waiting to be processed that were
+// uploaded before createdBefore, oldest first.
+func (r *attachmentsRepository) GetPendingAttachments(ctx context.Context, createdBefore time.Time, limit int) ([]models.Attachment, error) {
+	var ids []gocql.UUID
+	query := qb.Select(models.PendingAttachmentTable.Name()).
+		Columns("id").
+		Where(qb.Eq("shard"), qb.Lt("id")).
+		Limit(uint(limit)).
+		Query(*r.session)
+	err := query.BindMap(qb.M{"shard": pendingShard, "id": gocql.MaxTimeUUID(createdBefore)}).SelectRelease(&ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var attachments []models.Attachment
+	query = qb.Select(models.AttachmentTable.Name()).
+		Columns(models.AttachmentTable.Metadata().Columns...).
+		Where(qb.In("id")).
+		Query(*r.session)
+	if err := query.BindMap(qb.M{"id": ids}).SelectRelease(&attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// GetAttachmentsByMessage retrieves the metadata of every attachment linked to a message.
+func (r *attachmentsRepository) GetAttachmentsByMessage(ctx context.Context, messageId gocql.UUID) ([]models.Attachment, error) {
+	var ids []gocql.UUID
+	query := qb.Select(models.AttachmentByMessageTable.Name()).
+		Columns("id").
+		Where(qb.Eq("message_id")).
+		Query(*r.session)
+	if err := query.BindMap(qb.M{"message_id": messageId}).SelectRelease(&ids); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var attachments []models.Attachment
+	query = qb.Select(models.AttachmentTable.Name()).
+		Columns(models.AttachmentTable.Metadata().Columns...).
+		Where(qb.In("id")).
+		Query(*r.session)
+	if err := query.BindMap(qb.M{"id": ids}).SelectRelease(&attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// changeBatchSize bounds the rows written or deleted per statement, conversations can have many members.
+const changeBatchSize = 100
+
+// ChangesRepository defines the interface for the per user change log.
+type ChangesRepository interface {
+	AppendChanges(ctx context.Context, changes []models.Change) error
+	GetChanges(ctx context.Context, userId gocql.UUID, after gocql.UUID, limit int) ([]models.Change, error)
+	GetChangeKeys(ctx context.Context, userId gocql.UUID, after gocql.UUID, limit int) ([]models.Change, error)
+	DeleteChanges(ctx context.Context, userId gocql.UUID, ids []gocql.UUID) error
+}
+
+// changesRepository is the concrete implementation of ChangesRepository.
+type changesRepository struct {
+	session *gocqlx.Session
+}
+
+// NewChangesRepository creates a new instance of changesRepository.
+func NewChangesRepository(session *gocqlx.Session) ChangesRepository {
+	return &changesRepository{session: session}
+}
+
+// AppendChanges writes changes to the logs of their users.
+func (r *changesRepository) AppendChanges(ctx context.Context, changes []models.Change) error {
+	for start := 0; start < len(changes); start += changeBatchSize {
+		end := min(start+changeBatchSize, len(changes))
+		batch := r.session.NewBatch(gocql.LoggedBatch)
+		for _, change := range changes[start:end] {
+			if err := batch.BindStruct(r.session.Query(models.ChangeTable.Insert()), change); err != nil {
+				return err
+			}
+		}
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetChanges retrieves up to limit changes of a user made after the given change, oldest first.
+// A zero after starts at the beginning of the log.
+func (r *changesRepository) GetChanges(ctx context.Context, userId gocql.UUID, after gocql.UUID, limit int) ([]models.Change, error) {
+	builder := qb.Select(models.ChangeTable.Name()).
+		Columns(models.ChangeTable.Metadata().Columns...).
+		Where(qb.Eq("user_id"))
+	values := qb.M{"user_id": userId}
+	if after != (gocql.UUID{}) {
+		builder = builder.Where(qb.Gt("id"))
+		values["id"] = after
+	}
+	query := builder.OrderBy("id", qb.ASC).Limit(uint(limit)).Query(*r.session)
+
+	var changes []models.Change
+	if err := query.BindMap(values).SelectRelease(&changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// GetChangeKeys retrieves the id and entity of up to limit changes of a user made after the given change,
+// oldest first, for compaction. A zero after starts at the beginning of the log.
+func (r *changesRepository) GetChangeKeys(ctx context.Context, userId gocql.UUID, after gocql.UUID, limit int) ([]models.Change, error) {
+	builder := qb.Select(models.ChangeTable.Name()).
+		Columns("user_id", "id", "entity_type", "entity_id").
+		Where(qb.Eq("user_id"))
+	values := qb.M{"user_id": userId}
+	if after != (gocql.UUID{}) {
+		builder = builder.Where(qb.Gt("id"))
+		values["id"] = after
+	}
+	query := builder.OrderBy("id", qb.ASC).Limit(uint(limit)).Query(*r.session)
+
+	var changes []models.Change
+	if err := query.BindMap(values).SelectRelease(&changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// DeleteChanges removes changes from the log of a user.
+func (r *changesRepository) DeleteChanges(ctx context.Context, userId gocql.UUID, ids []gocql.UUID) error {
+	for start := 0; start < len(ids); start += changeBatchSize {
+		end := min(start+changeBatchSize, len(ids))
+		query := qb.Delete(models.ChangeTable.Name()).
+			Where(qb.Eq("user_id"), qb.In("id")).
+			Query(*r.session)
+		if err := query.BindMap(qb.M{"user_id": userId, "id": ids[start:end]}).ExecRelease(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
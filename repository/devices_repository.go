@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// DevicesRepository defines the interface for push token operations.
+type DevicesRepository interface {
+	SaveDeviceToken(ctx context.Context, device models.DeviceToken) error
+	GetDeviceTokens(ctx context.Context, userId gocql.UUID) ([]models.DeviceToken, error)
+	DeleteDeviceToken(ctx context.Context, userId gocql.UUID, token string) error
+}
+
+// devicesRepository is the concrete implementation of DevicesRepository.
+type devicesRepository struct {
+	session *gocqlx.Session
+}
+
+// NewDevicesRepository creates a new instance of devicesRepository.
+func NewDevicesRepository(session *gocqlx.Session) DevicesRepository {
+	return &devicesRepository{session: session}
+}
+
+// SaveDeviceToken registers the token for the user, taking it away from whoever had it before.
+func (r *devicesRepository) SaveDeviceToken(ctx context.Context, device models.DeviceToken) error {
+	var owner models.DeviceToken
+	ownerQuery := qb.Select(models.DeviceTokenOwnerTable.Name()).
+		Columns(models.DeviceTokenOwnerTable.Metadata().Columns...).
+		Where(qb.Eq("token")).
+		Query(*r.session)
+	err := ownerQuery.BindMap(qb.M{"token": device.Token}).GetRelease(&owner)
+	if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+		return err
+	}
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err == nil && owner.UserID != device.UserID {
+		previousQuery := qb.Delete(models.DeviceTokenTable.Name()).Where(qb.Eq("user_id"), qb.Eq("token")).Query(*r.session)
+		if err := batch.BindMap(previousQuery, qb.M{"user_id": owner.UserID, "token": device.Token}); err != nil {
+			return err
+		}
+	}
+	if err := batch.BindStruct(r.session.Query(models.DeviceTokenTable.Insert()), device); err != nil {
+		return err
+	}
+	if err := batch.BindMap(r.session.Query(models.DeviceTokenOwnerTable.Insert()), qb.M{"token": device.Token, "user_id": device.UserID}); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+// GetDeviceTokens retrieves the push tokens of every device of a user.
+func (r *devicesRepository) GetDeviceTokens(ctx context.Context, userId gocql.UUID) ([]models.DeviceToken, error) {
+	query := qb.Select(models.DeviceTokenTable.Name()).
+		Columns(models.DeviceTokenTable.Metadata().Columns...).
+		Where(qb.Eq("user_id")).
+		Query(*r.session)
+
+	var devices []models.DeviceToken
+	if err := query.BindMap(qb.M{"user_id": userId}).SelectRelease(&devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// DeleteDeviceToken removes a token of the user, the owner entry goes too unless the token moved on.
+func (r *devicesRepository) DeleteDeviceToken(ctx context.Context, userId gocql.UUID, token string) error {
+	query := qb.Delete(models.DeviceTokenTable.Name()).Where(qb.Eq("user_id"), qb.Eq("token")).Query(*r.session)
+	if err := query.BindMap(qb.M{"user_id": userId, "token": token}).ExecRelease(); err != nil {
+		return err
+	}
+
+	ownerQuery := qb.Delete(models.DeviceTokenOwnerTable.Name()).
+		Where(qb.Eq("token")).
+		If(qb.Eq("user_id")).
+		Query(*r.session)
+	_, err := ownerQuery.BindMap(qb.M{"token": token, "user_id": userId}).ExecCASRelease()
+	return err
+}
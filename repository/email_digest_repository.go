@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// EmailDigestRepository defines the interface for email digest settings operations.
+type EmailDigestRepository interface {
+	SaveDigestSettings(ctx context.Context, settings models.EmailDigestSettings) error
+	GetDigestSettings(ctx context.Context, userId gocql.UUID) (models.EmailDigestSettings, error)
+	GetDueDigests(ctx context.Context, shard int, after time.Time, until time.Time, limit int) ([]models.EmailDigestSchedule, error)
+	ScheduleDigest(ctx context.Context, settings models.EmailDigestSettings) error
+	UnscheduleDigest(ctx context.Context, schedule models.EmailDigestSchedule) error
+	ClaimDigest(ctx context.Context, settings models.EmailDigestSettings, next time.Time) (bool, error)
+}
+
+// emailDigestRepository is the concrete implementation of EmailDigestRepository.
+type emailDigestRepository struct {
+	session *gocqlx.Session
+}
+
+// NewEmailDigestRepository creates a new instance of emailDigestRepository.
+func NewEmailDigestRepository(session *gocqlx.Session) EmailDigestRepository {
+	return &emailDigestRepository{session: session}
+}
+
+// SaveDigestSettings stores the settings along with their next digest in the schedule. The row of the
+// digest they replace is left behind, the digest job drops it when it comes up.
+func (r *emailDigestRepository) SaveDigestSettings(ctx context.Context, settings models.EmailDigestSettings) error {
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(r.session.Query(models.EmailDigestSettingsTable.Insert()), settings); err != nil {
+		return err
+	}
+	if err := r.addSchedule(batch, settings); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+func (r *emailDigestRepository) GetDigestSettings(ctx context.Context, userId gocql.UUID) (models.EmailDigestSettings, error) {
+	query := qb.Select(models.EmailDigestSettingsTable.Name()).
+		Columns(models.EmailDigestSettingsTable.Metadata().Columns...).
+		Where(qb.Eq("user_id")).
+		Query(*r.session)
+
+	var settings models.EmailDigestSettings
+	if err := query.BindMap(qb.M{"user_id": userId}).GetRelease(&settings); err != nil {
+		return models.EmailDigestSettings{}, err
+	}
+	return settings, nil
+}
+
+// GetDueDigests retrieves the earliest digests of a shard due after the given time and up to until.
+// A zero after starts from the earliest digest of the shard.
+func (r *emailDigestRepository) GetDueDigests(ctx context.Context, shard int, after time.Time, until time.Time, limit int) ([]models.EmailDigestSchedule, error) {
+	builder := qb.Select(models.EmailDigestScheduleTable.Name()).
+		Columns(models.EmailDigestScheduleTable.Metadata().Columns...).
+		Where(qb.Eq("shard"), qb.LtOrEqNamed("due_at", "until"))
+	bind := qb.M{"shard": shard, "until": until}
+	if !after.IsZero() {
+		builder = builder.Where(qb.GtNamed("due_at", "after"))
+		bind["after"] = after
+	}
+	query := builder.Limit(uint(limit)).Query(*r.session)
+
+	var schedule []models.EmailDigestSchedule
+	if err := query.BindMap(bind).SelectRelease(&schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// ScheduleDigest adds the next digest of the settings to the schedule, if they have one.
+func (r *emailDigestRepository) ScheduleDigest(ctx context.Context, settings models.EmailDigestSettings) error {
+	next, ok := settings.NextDigestAt()
+	if !ok {
+		return nil
+	}
+	query := r.session.Query(models.EmailDigestScheduleTable.Insert()).BindStruct(newDigestSchedule(settings.UserID, next))
+	return query.ExecRelease()
+}
+
+func (r *emailDigestRepository) UnscheduleDigest(ctx context.Context, schedule models.EmailDigestSchedule) error {
+	query := r.session.Query(models.EmailDigestScheduleTable.Delete()).BindStruct(schedule)
+	return query.ExecRelease()
+}
+
+// ClaimDigest moves last_sent_at of the settings to next, reporting false when another instance already did,
+// so a digest goes out once however many instances run the job. The schedule follows once the claim applied,
+// should that fail the job finds the previous row and schedules the digest again.
+func (r *emailDigestRepository) ClaimDigest(ctx context.Context, settings models.EmailDigestSettings, next time.Time) (bool, error) {
+	query := qb.Update(models.EmailDigestSettingsTable.Name()).
+		Set("last_sent_at").
+		Where(qb.Eq("user_id")).
+		If(qb.EqNamed("last_sent_at", "previous_last_sent_at")).
+		Query(*r.session)
+	applied, err := query.BindMap(qb.M{"user_id": settings.UserID, "last_sent_at": next, "previous_last_sent_at": settings.LastSentAt}).ExecCASRelease()
+	if err != nil || !applied {
+		return applied, err
+	}
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if previous, ok := settings.NextDigestAt(); ok {
+		deleteQuery := r.session.Query(models.EmailDigestScheduleTable.Delete())
+		if err := batch.BindStruct(deleteQuery, newDigestSchedule(settings.UserID, previous)); err != nil {
+			return true, err
+		}
+	}
+	settings.LastSentAt = next
+	if err := r.addSchedule(batch, settings); err != nil {
+		return true, err
+	}
+	return true, r.session.ExecuteBatch(batch)
+}
+
+// addSchedule appends the schedule row of the next digest of the settings to a batch, if they have one.
+func (r *emailDigestRepository) addSchedule(batch *gocqlx.Batch, settings models.EmailDigestSettings) error {
+	next, ok := settings.NextDigestAt()
+	if !ok {
+		return nil
+	}
+	return batch.BindStruct(r.session.Query(models.EmailDigestScheduleTable.Insert()), newDigestSchedule(settings.UserID, next))
+}
+
+func newDigestSchedule(userId gocql.UUID, dueAt time.Time) models.EmailDigestSchedule {
+	return models.EmailDigestSchedule{Shard: models.DigestScheduleShard(userId), DueAt: dueAt, UserID: userId}
+}
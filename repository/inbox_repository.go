@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// InboxRepository defines the interface for the per user inbox.
+type InboxRepository interface {
+	UpsertEntries(ctx context.Context, userIds []gocql.UUID, entry models.InboxEntry) error
+	GetInbox(ctx context.Context, userId gocql.UUID) ([]models.InboxEntry, error)
+}
+
+// inboxRepository is the concrete implementation of InboxRepository.
+type inboxRepository struct {
+	session *gocqlx.Session
+}
+
+// NewInboxRepository creates a new instance of inboxRepository.
+func NewInboxRepository(session *gocqlx.Session) InboxRepository {
+	return &inboxRepository{session: session}
+}
+
+// UpsertEntries writes the entry into the inbox of every given user.
+// Writes are timestamped with the entry's activity time, so concurrent or replayed writes
+// resolve to the latest activity no matter the order they land in.
+func (r *inboxRepository) UpsertEntries(ctx context.Context, userIds []gocql.UUID, entry models.InboxEntry) error {
+	query := qb.Insert(models.InboxTable.Name()).
+		Columns(models.InboxTable.Metadata().Columns...).
+		Timestamp(entry.LastActivityAt).
+		Query(*r.session)
+	defer query.Release()
+
+	for _, userId := range userIds {
+		entry.UserID = userId
+		if err := query.BindStruct(entry).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetInbox retrieves every conversation in the user's inbox, in no particular order.
+func (r *inboxRepository) GetInbox(ctx context.Context, userId gocql.UUID) ([]models.InboxEntry, error) {
+	query := qb.Select(models.InboxTable.Name()).
+		Columns(models.InboxTable.Metadata().Columns...).
+		Where(qb.Eq("user_id")).
+		Query(*r.session)
+
+	var entries []models.InboxEntry
+	if err := query.BindMap(qb.M{"user_id": userId}).SelectRelease(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// IncomingWebhooksRepository defines the interface for incoming webhooks.
+type IncomingWebhooksRepository interface {
+	SaveIncomingWebhook(ctx context.Context, webhook models.IncomingWebhook) error
+	GetIncomingWebhook(ctx context.Context, id gocql.UUID) (models.IncomingWebhook, error)
+	GetIncomingWebhooksByConversation(ctx context.Context, conversationId gocql.UUID) ([]models.IncomingWebhook, error)
+}
+
+// incomingWebhooksRepository is the concrete implementation of IncomingWebhooksRepository.
+type incomingWebhooksRepository struct {
+	session *gocqlx.Session
+}
+
+// NewIncomingWebhooksRepository creates a new instance of incomingWebhooksRepository.
+func NewIncomingWebhooksRepository(session *gocqlx.Session) IncomingWebhooksRepository {
+	return &incomingWebhooksRepository{session: session}
+}
+
+// SaveIncomingWebhook creates or replaces a webhook and its per conversation copy together.
+func (r *incomingWebhooksRepository) SaveIncomingWebhook(ctx context.Context, webhook models.IncomingWebhook) error {
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(r.session.Query(models.IncomingWebhookTable.Insert()), webhook); err != nil {
+		return err
+	}
+	if err := batch.BindStruct(r.session.Query(models.IncomingWebhookByConversationTable.Insert()), webhook); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+// GetIncomingWebhook retrieves a single webhook by its ID.
+func (r *incomingWebhooksRepository) GetIncomingWebhook(ctx context.Context, id gocql.UUID) (models.IncomingWebhook, error) {
+	query := qb.Select(models.IncomingWebhookTable.Name()).
+		Columns(models.IncomingWebhookTable.Metadata().Columns...).
+		Where(qb.Eq("id")).
+		Query(*r.session)
+
+	var webhook models.IncomingWebhook
+	if err := query.BindMap(qb.M{"id": id}).GetRelease(&webhook); err != nil {
+		return models.IncomingWebhook{}, err
+	}
+	return webhook, nil
+}
+
+// GetIncomingWebhooksByConversation retrieves every webhook of a conversation, revoked ones included.
+func (r *incomingWebhooksRepository) GetIncomingWebhooksByConversation(ctx context.Context, conversationId gocql.UUID) ([]models.IncomingWebhook, error) {
+	query := qb.Select(models.IncomingWebhookByConversationTable.Name()).
+		Columns(models.IncomingWebhookByConversationTable.Metadata().Columns...).
+		Where(qb.Eq("conversation_id")).
+		Query(*r.session)
+
+	var webhooks []models.IncomingWebhook
+	if err := query.BindMap(qb.M{"conversation_id": conversationId}).SelectRelease(&webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
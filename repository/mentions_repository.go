@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// mentionBatchSize bounds the rows written or deleted per statement, @all mentions every member.
+const mentionBatchSize = 100
+
+// MentionsRepository defines the interface for the per user mention index.
+type MentionsRepository interface {
+	SaveMentions(ctx context.Context, mentions []models.Mention) error
+	DeleteMentions(ctx context.Context, messageId gocql.UUID, userIds []gocql.UUID) error
+	GetMentions(ctx context.Context, userId gocql.UUID, before gocql.UUID, limit int) ([]models.Mention, error)
+}
+
+// mentionsRepository is the concrete implementation of MentionsRepository.
+type mentionsRepository struct {
+	session *gocqlx.Session
+}
+
+// NewMentionsRepository creates a new instance of mentionsRepository.
+func NewMentionsRepository(session *gocqlx.Session) MentionsRepository {
+	return &mentionsRepository{session: session}
+}
+
+// SaveMentions records the mentions under their users, saving a mention again overwrites it.
+func (r *mentionsRepository) SaveMentions(ctx context.Context, mentions []models.Mention) error {
+	for start := 0; start < len(mentions); start += mentionBatchSize {
+		end := min(start+mentionBatchSize, len(mentions))
+		batch := r.session.NewBatch(gocql.LoggedBatch)
+		for _, mention := range mentions[start:end] {
+			if err := batch.BindStruct(r.session.Query(models.MentionTable.Insert()), mention); err != nil {
+				return err
+			}
+		}
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMentions removes the mentions of a message for the given users.
+func (r *mentionsRepository) DeleteMentions(ctx context.Context, messageId gocql.UUID, userIds []gocql.UUID) error {
+	for start := 0; start < len(userIds); start += mentionBatchSize {
+		end := min(start+mentionBatchSize, len(userIds))
+		batch := r.session.NewBatch(gocql.LoggedBatch)
+		for _, userId := range userIds[start:end] {
+			query := qb.Delete(models.MentionTable.Name()).Where(qb.Eq("user_id"), qb.Eq("message_id")).Query(*r.session)
+			if err := batch.BindMap(query, qb.M{"user_id": userId, "message_id": messageId}); err != nil {
+				return err
+			}
+		}
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMentions retrieves up to limit mentions of a user in messages older than the given one, newest first.
+// A zero before starts with the newest mention.
+func (r *mentionsRepository) GetMentions(ctx context.Context, userId gocql.UUID, before gocql.UUID, limit int) ([]models.Mention, error) {
+	builder := qb.Select(models.MentionTable.Name()).
+		Columns(models.MentionTable.Metadata().Columns...).
+		Where(qb.Eq("user_id"))
+	values := qb.M{"user_id": userId}
+	if before != (gocql.UUID{}) {
+		builder = builder.Where(qb.Lt("message_id"))
+		values["message_id"] = before
+	}
+	query := builder.Limit(uint(limit)).Query(*r.session)
+
+	var mentions []models.Mention
+	if err := query.BindMap(values).SelectRelease(&mentions); err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"sort"
 
 	"github.com/gocql/gocql"
 	"github.com/scylladb/gocqlx/v3"
@@ -11,14 +13,26 @@ import (
 
 // MessagesRepository defines the interface for message-related operations.
 type MessagesRepository interface {
-	CreateMessage(ctx context.Context, message models.Message) (models.Message, error)
-	UpdateMessage(ctx context.Context, messageId gocql.UUID, message models.Message) (models.Message, error)
-	DeleteMessage(ctx context.Context, messageId gocql.UUID) error
+	CreateMessage(ctx context.Context, message models.Message, outbox ...models.OutboxEntry) (models.Message, error)
+	UpdateMessage(ctx context.Context, messageId gocql.UUID, message models.Message, outbox ...models.OutboxEntry) (models.Message, error)
+	DeleteMessage(ctx context.Context, messageId gocql.UUID, outbox ...models.OutboxEntry) error
 	GetMessages(ctx context.Context) ([]models.Message, error)
 	GetMessage(ctx context.Context, id gocql.UUID) (models.Message, error)
 	GetMessagesByPagingState(ctx context.Context, pageSize int, pagingState []byte) ([]models.Message, []byte, error)
+	GetLatestMessage(ctx context.Context, conversationId gocql.UUID) (models.Message, error)
+	GetMessagesAfter(ctx context.Context, conversationId gocql.UUID, after gocql.UUID, limit int) ([]models.Message, error)
+	CountMessagesAfter(ctx context.Context, conversationId gocql.UUID, after gocql.UUID, excludeSenderId gocql.UUID, limit int) (int, error)
+	NextSequence(ctx context.Context, conversationId gocql.UUID) (int64, error)
+	GetLastSequence(ctx context.Context, conversationId gocql.UUID) (int64, error)
+	GetMessagesFromSequence(ctx context.Context, conversationId gocql.UUID, fromSeq int64, limit int) ([]models.Message, error)
 }
 
+// ErrSequenceContention is returned when too many writers raced for the next sequence number of a conversation.
+var ErrSequenceContention = errors.New("too much contention allocating a message sequence number")
+
+// maxSequenceAttempts bounds the compare and set rounds of NextSequence.
+const maxSequenceAttempts = 10
+
 // messagesRepository is the concrete implementation of MessagesRepository.
 type messagesRepository struct {
 	session *gocqlx.Session
@@ -29,34 +43,59 @@ func NewMessagesRepository(session *gocqlx.Session) MessagesRepository {
 	return &messagesRepository{session: session}
 }
 
-// CreateMessage inserts a new message into the database.
-func (r *messagesRepository) CreateMessage(ctx context.Context, message models.Message) (models.Message, error) {
-
-	q := r.session.Query(models.MessageTable.Insert()).BindStruct(message)
+// CreateMessage inserts a new message into the database, along with the outbox entries announcing it.
+func (r *messagesRepository) CreateMessage(ctx context.Context, message models.Message, outbox ...models.OutboxEntry) (models.Message, error) {
 
-	// query := qb.Insert(models.MessageTable.Name()).
-	// 	Columns(models.MessageTable.Metadata().Columns...).
-	// 	Query(*r.session)
-	// if err := query.BindStruct(message).ExecRelease(); err != nil {
-	// 	return models.Message{}, err
-	// }
+	// write the message and its per conversation copy together so they never drift apart
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(r.session.Query(models.MessageTable.Insert()), message); err != nil {
+		return models.Message{}, err
+	}
+	if err := batch.BindStruct(r.session.Query(models.MessageByConversationTable.Insert()), message); err != nil {
+		return models.Message{}, err
+	}
+	if message.Seq > 0 {
+		sequence := models.MessageSequence{ConversationID: message.ConversationID, Seq: message.Seq, ID: message.ID}
+		if err := batch.BindStruct(r.session.Query(models.MessageBySequenceTable.Insert()), sequence); err != nil {
+			return models.Message{}, err
+		}
+	}
+	if err := addOutboxEntries(r.session, batch, outbox); err != nil {
+		return models.Message{}, err
+	}
 
-	if err := q.ExecRelease(); err != nil {
+	if err := r.session.ExecuteBatch(batch); err != nil {
 		return models.Message{}, err
 	}
 
 	return message, nil
 }
 
-// UpdateMessage updates an existing message in the database.
-func (r *messagesRepository) UpdateMessage(ctx context.Context, id gocql.UUID, message models.Message) (models.Message, error) {
+// UpdateMessage updates the content of an existing message in the database, along with the outbox entries announcing it.
+// The conversation and sender are keys of the per conversation copy and never change, message has to carry the stored ones.
+func (r *messagesRepository) UpdateMessage(ctx context.Context, id gocql.UUID, message models.Message, outbox ...models.OutboxEntry) (models.Message, error) {
 
 	query := qb.Update(models.MessageTable.Name()).
-		Set("conversation_id", "sender_id", "body", "updated_at", "is_soft_deleted").
+		Set("body", "updated_at", "is_soft_deleted", "mentions").
 		Where(qb.Eq("id")).
 		Query(*r.session)
+	byConversationQuery := qb.Update(models.MessageByConversationTable.Name()).
+		Set("body", "updated_at", "is_soft_deleted", "mentions").
+		Where(qb.Eq("conversation_id"), qb.Eq("id")).
+		Query(*r.session)
 
-	err := query.BindStruct(message).ExecRelease()
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(query, message); err != nil {
+		return models.Message{}, err
+	}
+	if err := batch.BindStruct(byConversationQuery, message); err != nil {
+		return models.Message{}, err
+	}
+	if err := addOutboxEntries(r.session, batch, outbox); err != nil {
+		return models.Message{}, err
+	}
+
+	err := r.session.ExecuteBatch(batch)
 	if err != nil {
 		return models.Message{}, err
 	}
@@ -64,9 +103,39 @@ func (r *messagesRepository) UpdateMessage(ctx context.Context, id gocql.UUID, m
 
 }
 
-func (r *messagesRepository) DeleteMessage(ctx context.Context, id gocql.UUID) error {
+// DeleteMessage removes a message from the database, along with the outbox entries announcing it.
+func (r *messagesRepository) DeleteMessage(ctx context.Context, id gocql.UUID, outbox ...models.OutboxEntry) error {
+	// the per conversation copy can only be addressed through the conversation id
+	message, err := r.GetMessage(ctx, id)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
 	query := qb.Delete(models.MessageTable.Name()).Where(qb.Eq("id")).Query(*r.session)
-	err := query.BindMap(qb.M{"id": id}).ExecRelease()
+	byConversationQuery := qb.Delete(models.MessageByConversationTable.Name()).Where(qb.Eq("conversation_id"), qb.Eq("id")).Query(*r.session)
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindMap(query, qb.M{"id": id}); err != nil {
+		return err
+	}
+	if err := batch.BindMap(byConversationQuery, qb.M{"conversation_id": message.ConversationID, "id": id}); err != nil {
+		return err
+	}
+	// the sequence number is not reused, readers of the range see the gap of a deleted message
+	if message.Seq > 0 {
+		bySequenceQuery := qb.Delete(models.MessageBySequenceTable.Name()).Where(qb.Eq("conversation_id"), qb.Eq("seq")).Query(*r.session)
+		if err := batch.BindMap(bySequenceQuery, qb.M{"conversation_id": message.ConversationID, "seq": message.Seq}); err != nil {
+			return err
+		}
+	}
+	if err := addOutboxEntries(r.session, batch, outbox); err != nil {
+		return err
+	}
+
+	err = r.session.ExecuteBatch(batch)
 	if err != nil {
 		return err
 	}
@@ -79,14 +148,14 @@ func (r *messagesRepository) GetMessages(ctx context.Context) ([]models.Message,
 	var messages []models.Message
 
 	query := qb.Select(models.MessageTable.Name()).
-		Columns("id", "conversation_id", "sender_id", "created_at", "updated_at", "body", "is_soft_deleted").
+		Columns("id", "conversation_id", "sender_id", "created_at", "updated_at", "body", "is_soft_deleted", "seq").
 		Query(*r.session)
 
 	iter := query.Iter()
 	defer iter.Close()
 
 	var message models.Message
-	for iter.Scan(&message.ID, &message.ConversationID, &message.SenderId, &message.CreatedAt, &message.UpdatedAt, &message.Body, &message.IsSoftDeleted) {
+	for iter.Scan(&message.ID, &message.ConversationID, &message.SenderId, &message.CreatedAt, &message.UpdatedAt, &message.Body, &message.IsSoftDeleted, &message.Seq) {
 		messages = append(messages, message)
 	}
 
@@ -100,7 +169,7 @@ func (r *messagesRepository) GetMessages(ctx context.Context) ([]models.Message,
 // GetMessage retrieves a single message by its ID from the database.
 func (r *messagesRepository) GetMessage(ctx context.Context, id gocql.UUID) (models.Message, error) {
 	query := qb.Select(models.MessageTable.Name()).
-		Columns("id", "conversation_id", "sender_id", "created_at", "updated_at", "body", "is_soft_deleted").
+		Columns(models.MessageTable.Metadata().Columns...).
 		Where(qb.Eq("id")).
 		Query(*r.session)
 
@@ -115,14 +184,14 @@ func (r *messagesRepository) GetMessage(ctx context.Context, id gocql.UUID) (mod
 func (r *messagesRepository) GetMessagesByPagingState(ctx context.Context, pageSize int, pagingState []byte) ([]models.Message, []byte, error) {
 	var messages []models.Message
 	// here we build the query by applying paging to it
-	query := qb.Select(models.MessageTable.Name()).Columns("id", "conversation_id", "sender_id", "created_at", "updated_at", "body", "is_soft_deleted").Query(*r.session).PageSize(pageSize).PageState(pagingState)
+	query := qb.Select(models.MessageTable.Name()).Columns("id", "conversation_id", "sender_id", "created_at", "updated_at", "body", "is_soft_deleted", "seq").Query(*r.session).PageSize(pageSize).PageState(pagingState)
 	// here we get the iterator
 	iter := query.Iter()
 	defer iter.Close()
 	// Iterate over the results and scan into the slice
 	for {
 		var message models.Message
-		if !iter.Scan(&message.ID, &message.ConversationID, &message.SenderId, &message.CreatedAt, &message.UpdatedAt, &message.Body, &message.IsSoftDeleted) {
+		if !iter.Scan(&message.ID, &message.ConversationID, &message.SenderId, &message.CreatedAt, &message.UpdatedAt, &message.Body, &message.IsSoftDeleted, &message.Seq) {
 			break
 		} else {
 			messages = append(messages, message)
@@ -137,3 +206,164 @@ func (r *messagesRepository) GetMessagesByPagingState(ctx context.Context, pageS
 	return messages, nextPageState, nil
 
 }
+
+// GetLatestMessage retrieves the newest message of a conversation.
+func (r *messagesRepository) GetLatestMessage(ctx context.Context, conversationId gocql.UUID) (models.Message, error) {
+	query := qb.Select(models.MessageByConversationTable.Name()).
+		Columns(models.MessageByConversationTable.Metadata().Columns...).
+		Where(qb.Eq("conversation_id")).
+		OrderBy("id", qb.DESC).
+		Limit(1).
+		Query(*r.session)
+
+	var message models.Message
+	if err := query.BindMap(qb.M{"conversation_id": conversationId}).GetRelease(&message); err != nil {
+		return models.Message{}, err
+	}
+	return message, nil
+}
+
+// GetMessagesAfter retrieves up to limit messages of a conversation newer than the given timeuuid, oldest first.
+func (r *messagesRepository) GetMessagesAfter(ctx context.Context, conversationId gocql.UUID, after gocql.UUID, limit int) ([]models.Message, error) {
+	query := qb.Select(models.MessageByConversationTable.Name()).
+		Columns(models.MessageByConversationTable.Metadata().Columns...).
+		Where(qb.Eq("conversation_id"), qb.Gt("id")).
+		OrderBy("id", qb.ASC).
+		Limit(uint(limit)).
+		Query(*r.session)
+
+	var messages []models.Message
+	if err := query.BindMap(qb.M{"conversation_id": conversationId, "id": after}).SelectRelease(&messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// CountMessagesAfter counts the messages of a conversation newer than the given timeuuid, skipping the ones sent by excludeSenderId.
+// Counting stops at limit, so callers can render capped badges such as "99+" without scanning the whole conversation.
+func (r *messagesRepository) CountMessagesAfter(ctx context.Context, conversationId gocql.UUID, after gocql.UUID, excludeSenderId gocql.UUID, limit int) (int, error) {
+	builder := qb.Select(models.MessageByConversationTable.Name()).
+		Columns("sender_id", "is_soft_deleted").
+		Where(qb.Eq("conversation_id"))
+	values := qb.M{"conversation_id": conversationId}
+	if after != (gocql.UUID{}) {
+		builder = builder.Where(qb.Gt("id"))
+		values["id"] = after
+	}
+
+	iter := builder.Query(*r.session).BindMap(values).Iter()
+	defer iter.Close()
+
+	count := 0
+	var senderId gocql.UUID
+	var isSoftDeleted bool
+	for count < limit && iter.Scan(&senderId, &isSoftDeleted) {
+		if senderId != excludeSenderId && !isSoftDeleted {
+			count++
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// NextSequence allocates the next sequence number of a conversation. Numbers are handed out through
+// lightweight transactions, so concurrent writers on any node never get the same one. The transaction
+// cannot share the batch writing the message, so a number whose message then fails to be written is
+// lost for good, leaving a gap readers have to tolerate.
+func (r *messagesRepository) NextSequence(ctx context.Context, conversationId gocql.UUID) (int64, error) {
+	selectQuery := qb.Select(models.ConversationSequenceTable.Name()).
+		Columns("last_seq").
+		Where(qb.Eq("conversation_id"))
+	insertQuery := qb.Insert(models.ConversationSequenceTable.Name()).
+		Columns("conversation_id", "last_seq").
+		Unique()
+	updateQuery := qb.Update(models.ConversationSequenceTable.Name()).
+		Set("last_seq").
+		Where(qb.Eq("conversation_id")).
+		If(qb.EqNamed("last_seq", "current_seq"))
+
+	for attempt := 0; attempt < maxSequenceAttempts; attempt++ {
+		var lastSeq int64
+		err := selectQuery.Query(*r.session).
+			BindMap(qb.M{"conversation_id": conversationId}).
+			GetRelease(&lastSeq)
+		if errors.Is(err, gocql.ErrNotFound) {
+			applied, err := insertQuery.Query(*r.session).
+				BindMap(qb.M{"conversation_id": conversationId, "last_seq": int64(1)}).
+				ExecCASRelease()
+			if err != nil {
+				return 0, err
+			}
+			if applied {
+				return 1, nil
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		applied, err := updateQuery.Query(*r.session).
+			BindMap(qb.M{"conversation_id": conversationId, "last_seq": lastSeq + 1, "current_seq": lastSeq}).
+			ExecCASRelease()
+		if err != nil {
+			return 0, err
+		}
+		if applied {
+			return lastSeq + 1, nil
+		}
+	}
+	return 0, ErrSequenceContention
+}
+
+// GetLastSequence retrieves the last sequence number handed out in a conversation, 0 when none was.
+func (r *messagesRepository) GetLastSequence(ctx context.Context, conversationId gocql.UUID) (int64, error) {
+	query := qb.Select(models.ConversationSequenceTable.Name()).
+		Columns("last_seq").
+		Where(qb.Eq("conversation_id")).
+		Query(*r.session)
+
+	var lastSeq int64
+	err := query.BindMap(qb.M{"conversation_id": conversationId}).GetRelease(&lastSeq)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return 0, nil
+	}
+	return lastSeq, err
+}
+
+// GetMessagesFromSequence retrieves up to limit messages of a conversation starting at sequence number fromSeq, in sequence order.
+func (r *messagesRepository) GetMessagesFromSequence(ctx context.Context, conversationId gocql.UUID, fromSeq int64, limit int) ([]models.Message, error) {
+	sequenceQuery := qb.Select(models.MessageBySequenceTable.Name()).
+		Columns(models.MessageBySequenceTable.Metadata().Columns...).
+		Where(qb.Eq("conversation_id"), qb.GtOrEq("seq")).
+		OrderBy("seq", qb.ASC).
+		Limit(uint(limit)).
+		Query(*r.session)
+
+	var sequences []models.MessageSequence
+	if err := sequenceQuery.BindMap(qb.M{"conversation_id": conversationId, "seq": fromSeq}).SelectRelease(&sequences); err != nil {
+		return nil, err
+	}
+	if len(sequences) == 0 {
+		return []models.Message{}, nil
+	}
+
+	ids := make([]gocql.UUID, 0, len(sequences))
+	for _, sequence := range sequences {
+		ids = append(ids, sequence.ID)
+	}
+	query := qb.Select(models.MessageByConversationTable.Name()).
+		Columns(models.MessageByConversationTable.Metadata().Columns...).
+		Where(qb.Eq("conversation_id"), qb.In("id")).
+		Query(*r.session)
+
+	var messages []models.Message
+	if err := query.BindMap(qb.M{"conversation_id": conversationId, "id": ids}).SelectRelease(&messages); err != nil {
+		return nil, err
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Seq < messages[j].Seq })
+	return messages, nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// notificationBatchSize bounds the rows written per statement, a mention of @all notifies every member.
+const notificationBatchSize = 100
+
+// NotificationsRepository defines the interface for in-app notification operations.
+type NotificationsRepository interface {
+	SaveNotifications(ctx context.Context, notifications []models.Notification) error
+	GetNotifications(ctx context.Context, userId gocql.UUID, before gocql.UUID, limit int) ([]models.Notification, error)
+	GetNotificationsByIds(ctx context.Context, userId gocql.UUID, ids []gocql.UUID) ([]models.Notification, error)
+	MarkNotificationsRead(ctx context.Context, notifications []models.Notification) error
+}
+
+// notificationsRepository is the concrete implementation of NotificationsRepository.
+type notificationsRepository struct {
+	session *gocqlx.Session
+}
+
+// NewNotificationsRepository creates a new instance of notificationsRepository.
+func NewNotificationsRepository(session *gocqlx.Session) NotificationsRepository {
+	return &notificationsRepository{session: session}
+}
+
+// SaveNotifications writes notifications to the feeds of their users. Writing a notification again
+// leaves whether it was read as it is, a new notification reads as unread.
+func (r *notificationsRepository) SaveNotifications(ctx context.Context, notifications []models.Notification) error {
+	columns := make([]string, 0, len(models.NotificationTable.Metadata().Columns))
+	for _, column := range models.NotificationTable.Metadata().Columns {
+		if column != "is_read" {
+			columns = append(columns, column)
+		}
+	}
+	insert := qb.Insert(models.NotificationTable.Name()).Columns(columns...)
+
+	for start := 0; start < len(notifications); start += notificationBatchSize {
+		end := min(start+notificationBatchSize, len(notifications))
+		batch := r.session.NewBatch(gocql.LoggedBatch)
+		for _, notification := range notifications[start:end] {
+			if err := batch.BindStruct(insert.Query(*r.session), notification); err != nil {
+				return err
+			}
+		}
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNotifications retrieves up to limit notifications of a user older than the given one, newest first.
+// A zero before starts with the newest notification.
+func (r *notificationsRepository) GetNotifications(ctx context.Context, userId gocql.UUID, before gocql.UUID, limit int) ([]models.Notification, error) {
+	builder := qb.Select(models.NotificationTable.Name()).
+		Columns(models.NotificationTable.Metadata().Columns...).
+		Where(qb.Eq("user_id"))
+	values := qb.M{"user_id": userId}
+	if before != (gocql.UUID{}) {
+		builder = builder.Where(qb.Lt("id"))
+		values["id"] = before
+	}
+	query := builder.Limit(uint(limit)).Query(*r.session)
+
+	var notifications []models.Notification
+	if err := query.BindMap(values).SelectRelease(&notifications); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// GetNotificationsByIds retrieves the given notifications of a user, ids that do not exist are left out.
+func (r *notificationsRepository) GetNotificationsByIds(ctx context.Context, userId gocql.UUID, ids []gocql.UUID) ([]models.Notification, error) {
+	query := qb.Select(models.NotificationTable.Name()).
+		Columns(models.NotificationTable.Metadata().Columns...).
+		Where(qb.Eq("user_id"), qb.In("id")).
+		Query(*r.session)
+
+	var notifications []models.Notification
+	if err := query.BindMap(qb.M{"user_id": userId, "id": ids}).SelectRelease(&notifications); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// MarkNotificationsRead flags existing notifications as read. The flag is written with the time the row has
+// left to live, so it expires together with the rest of the notification instead of outliving it.
+func (r *notificationsRepository) MarkNotificationsRead(ctx context.Context, notifications []models.Notification) error {
+	now := time.Now()
+	for start := 0; start < len(notifications); start += notificationBatchSize {
+		end := min(start+notificationBatchSize, len(notifications))
+		batch := r.session.NewBatch(gocql.LoggedBatch)
+		for _, notification := range notifications[start:end] {
+			remaining := notification.CreatedAt.Add(models.NotificationRetention).Sub(now)
+			if remaining < time.Second {
+				continue
+			}
+			query := qb.Update(models.NotificationTable.Name()).
+				TTLNamed("ttl").
+				Set("is_read").
+				Where(qb.Eq("user_id"), qb.Eq("id")).
+				Query(*r.session)
+			values := qb.M{"user_id": notification.UserID, "id": notification.ID, "is_read": true, "ttl": int64(remaining / time.Second)}
+			if err := batch.BindMap(query, values); err != nil {
+				return err
+			}
+		}
+		if batch.Size() == 0 {
+			continue
+		}
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// OutboxRepository defines the interface for reading and settling outbox entries.
+// Entries are written by the repositories of the changes they describe, see addOutboxEntries.
+type OutboxRepository interface {
+	GetPending(ctx context.Context, shard int, bucket int64, after gocql.UUID, limit int) ([]models.OutboxEntry, error)
+	MarkDone(ctx context.Context, entry models.OutboxEntry) error
+	ScheduleRetry(ctx context.Context, entry models.OutboxEntry, nextAttemptAt time.Time, lastError string) error
+	DeadLetter(ctx context.Context, entry models.OutboxEntry, lastError string) error
+	GetCursor(ctx context.Context, shard int) (gocql.UUID, error)
+	SaveCursor(ctx context.Context, shard int, position gocql.UUID) error
+	AcquireLease(ctx context.Context, shard int, owner string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, shard int, owner string) error
+}
+
+// outboxRepository is the concrete implementation of OutboxRepository.
+type outboxRepository struct {
+	session *gocqlx.Session
+}
+
+// NewOutboxRepository creates a new instance of outboxRepository.
+func NewOutboxRepository(session *gocqlx.Session) OutboxRepository {
+	return &outboxRepository{session: session}
+}
+
+// GetPending retrieves the oldest undelivered entries of a bucket of a shard created after the given entry,
+// including the ones waiting for a retry. A zero after starts from the beginning of the bucket.
+func (r *outboxRepository) GetPending(ctx context.Context, shard int, bucket int64, after gocql.UUID, limit int) ([]models.OutboxEntry, error) {
+	builder := qb.Select(models.OutboxTable.Name()).
+		Columns(models.OutboxTable.Metadata().Columns...).
+		Where(qb.Eq("shard"), qb.Eq("bucket")).
+		Limit(uint(limit))
+	bind := qb.M{"shard": shard, "bucket": bucket}
+	if after != (gocql.UUID{}) {
+		builder = builder.Where(qb.Gt("id"))
+		bind["id"] = after
+	}
+
+	var entries []models.OutboxEntry
+	if err := builder.Query(*r.session).BindMap(bind).SelectRelease(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// MarkDone removes a delivered entry.
+func (r *outboxRepository) MarkDone(ctx context.Context, entry models.OutboxEntry) error {
+	query := qb.Delete(models.OutboxTable.Name()).
+		Where(qb.Eq("shard"), qb.Eq("bucket"), qb.Eq("id")).
+		Query(*r.session)
+	return query.BindMap(qb.M{"shard": entry.Shard, "bucket": entry.Bucket, "id": entry.ID}).ExecRelease()
+}
+
+// ScheduleRetry records a failed attempt along with the consumers that did handle the entry.
+// The update only applies while the entry exists, so it cannot resurrect an entry another
+// dispatcher delivered in the meantime.
+func (r *outboxRepository) ScheduleRetry(ctx context.Context, entry models.OutboxEntry, nextAttemptAt time.Time, lastError string) error {
+	query := qb.Update(models.OutboxTable.Name()).
+		Set("attempts", "next_attempt_at", "last_error", "delivered").
+		Where(qb.Eq("shard"), qb.Eq("bucket"), qb.Eq("id")).
+		Existing().
+		Query(*r.session)
+
+	_, err := query.BindMap(qb.M{
+		"shard":           entry.Shard,
+		"bucket":          entry.Bucket,
+		"id":              entry.ID,
+		"attempts":        entry.Attempts + 1,
+		"next_attempt_at": nextAttemptAt,
+		"last_error":      lastError,
+		"delivered":       entry.Delivered,
+	}).ExecCASRelease()
+	return err
+}
+
+// DeadLetter moves an entry that kept failing out of the outbox, so it stops holding back its shard.
+func (r *outboxRepository) DeadLetter(ctx context.Context, entry models.OutboxEntry, lastError string) error {
+	entry.Attempts++
+	entry.LastError = lastError
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(r.session.Query(models.OutboxDeadLetterTable.Insert()), entry); err != nil {
+		return err
+	}
+	if err := batch.BindStruct(r.session.Query(models.OutboxTable.Delete()), entry); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+// GetCursor returns the position up to which every entry of the shard is settled, zero when the shard
+// was never dispatched.
+func (r *outboxRepository) GetCursor(ctx context.Context, shard int) (gocql.UUID, error) {
+	query := qb.Select(models.OutboxCursorTable.Name()).
+		Columns("position").
+		Where(qb.Eq("shard")).
+		Query(*r.session)
+
+	var position gocql.UUID
+	err := query.BindMap(qb.M{"shard": shard}).GetRelease(&position)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return gocql.UUID{}, nil
+	}
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+	return position, nil
+}
+
+// SaveCursor moves the position the dispatcher resumes the shard from.
+func (r *outboxRepository) SaveCursor(ctx context.Context, shard int, position gocql.UUID) error {
+	return r.session.Query(models.OutboxCursorTable.Insert()).
+		BindStruct(models.OutboxCursor{Shard: shard, Position: position}).
+		ExecRelease()
+}
+
+// AcquireLease takes the lease on a shard when it is free, or renews it when owner already holds it,
+// and reports whether owner holds it for the next ttl. Both go through a lightweight transaction,
+// so a single owner holds a shard at a time.
+func (r *outboxRepository) AcquireLease(ctx context.Context, shard int, owner string, ttl time.Duration) (bool, error) {
+	take := qb.Insert(models.OutboxLeaseTable.Name()).
+		Columns(models.OutboxLeaseTable.Metadata().Columns...).
+		Unique().
+		TTL(ttl).
+		Query(*r.session)
+
+	var holder models.OutboxLease
+	applied, err := take.BindStruct(models.OutboxLease{Shard: shard, Owner: owner}).GetCASRelease(&holder)
+	if err != nil {
+		return false, err
+	}
+	if applied {
+		return true, nil
+	}
+	if holder.Owner != owner {
+		return false, nil
+	}
+
+	renew := qb.Update(models.OutboxLeaseTable.Name()).
+		TTL(ttl).
+		Set("owner").
+		Where(qb.Eq("shard")).
+		If(qb.Eq("owner")).
+		Query(*r.session)
+	return renew.BindMap(qb.M{"shard": shard, "owner": owner}).ExecCASRelease()
+}
+
+// ReleaseLease gives up the lease on a shard, if owner still holds it, so another dispatcher takes
+// the shard over without waiting for the lease to expire.
+func (r *outboxRepository) ReleaseLease(ctx context.Context, shard int, owner string) error {
+	query := qb.Delete(models.OutboxLeaseTable.Name()).
+		Where(qb.Eq("shard")).
+		If(qb.Eq("owner")).
+		Query(*r.session)
+	_, err := query.BindMap(qb.M{"shard": shard, "owner": owner}).ExecCASRelease()
+	return err
+}
+
+// writeOutboxEntries stores the entries of a change made by a lightweight transaction, which cannot share
+// a batch with them. They are written right after the transaction applied, a crash in between loses them.
+func writeOutboxEntries(session *gocqlx.Session, entries []models.OutboxEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	if err := addOutboxEntries(session, batch, entries); err != nil {
+		return err
+	}
+	return session.ExecuteBatch(batch)
+}
+
+// addOutboxEntries appends the entries to a batch, so they are stored if and only if the change is.
+func addOutboxEntries(session *gocqlx.Session, batch *gocqlx.Batch, entries []models.OutboxEntry) error {
+	for _, entry := range entries {
+		if err := batch.BindStruct(session.Query(models.OutboxTable.Insert()), entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
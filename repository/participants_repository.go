@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// ParticipantsRepository defines the interface for conversation membership operations.
+type ParticipantsRepository interface {
+	AddParticipant(ctx context.Context, participant models.Participant, outbox ...models.OutboxEntry) (bool, error)
+	GetParticipants(ctx context.Context, conversationId gocql.UUID) ([]models.Participant, error)
+	GetConversationIds(ctx context.Context, userId gocql.UUID) ([]gocql.UUID, error)
+	IsParticipant(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID) (bool, error)
+	HasParticipants(ctx context.Context, conversationId gocql.UUID) (bool, error)
+}
+
+// participantsRepository is the concrete implementation of ParticipantsRepository.
+type participantsRepository struct {
+	session *gocqlx.Session
+}
+
+// NewParticipantsRepository creates a new instance of participantsRepository.
+func NewParticipantsRepository(session *gocqlx.Session) ParticipantsRepository {
+	return &participantsRepository{session: session}
+}
+
+// AddParticipant adds a user to a conversation, re-adding an existing participant keeps the original join time.
+// It reports whether the user was newly added, the user's side of the membership and the outbox entries
+// are only written if so. Like the outbox entries they follow the transaction, a crash in between loses them.
+func (r *participantsRepository) AddParticipant(ctx context.Context, participant models.Participant, outbox ...models.OutboxEntry) (bool, error) {
+	query := qb.Insert(models.ParticipantTable.Name()).
+		Columns(models.ParticipantTable.Metadata().Columns...).
+		Unique().
+		Query(*r.session)
+
+	added, err := query.BindStruct(participant).ExecCASRelease()
+	if err != nil || !added {
+		return false, err
+	}
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(r.session.Query(models.ParticipantByUserTable.Insert()), participant); err != nil {
+		return true, err
+	}
+	if err := addOutboxEntries(r.session, batch, outbox); err != nil {
+		return true, err
+	}
+	return true, r.session.ExecuteBatch(batch)
+}
+
+// GetConversationIds retrieves the conversations the user takes part in.
+func (r *participantsRepository) GetConversationIds(ctx context.Context, userId gocql.UUID) ([]gocql.UUID, error) {
+	query := qb.Select(models.ParticipantByUserTable.Name()).
+		Columns(models.ParticipantByUserTable.Metadata().Columns...).
+		Where(qb.Eq("user_id")).
+		Query(*r.session)
+
+	var participations []models.Participant
+	if err := query.BindMap(qb.M{"user_id": userId}).SelectRelease(&participations); err != nil {
+		return nil, err
+	}
+	conversationIds := make([]gocql.UUID, 0, len(participations))
+	for _, participation := range participations {
+		conversationIds = append(conversationIds, participation.ConversationID)
+	}
+	return conversationIds, nil
+}
+
+// GetParticipants retrieves every participant of a conversation.
+func (r *participantsRepository) GetParticipants(ctx context.Context, conversationId gocql.UUID) ([]models.Participant, error) {
+	query := qb.Select(models.ParticipantTable.Name()).
+		Columns(models.ParticipantTable.Metadata().Columns...).
+		Where(qb.Eq("conversation_id")).
+		Query(*r.session)
+
+	var participants []models.Participant
+	if err := query.BindMap(qb.M{"conversation_id": conversationId}).SelectRelease(&participants); err != nil {
+		return nil, err
+	}
+	return participants, nil
+}
+
+// HasParticipants reports whether anyone takes part in the conversation yet.
+func (r *participantsRepository) HasParticipants(ctx context.Context, conversationId gocql.UUID) (bool, error) {
+	query := qb.Select(models.ParticipantTable.Name()).
+		Columns("user_id").
+		Where(qb.Eq("conversation_id")).
+		Limit(1).
+		Query(*r.session)
+
+	var participantId gocql.UUID
+	err := query.BindMap(qb.M{"conversation_id": conversationId}).GetRelease(&participantId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IsParticipant reports whether the user takes part in the conversation.
+func (r *participantsRepository) IsParticipant(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID) (bool, error) {
+	query := qb.Select(models.ParticipantTable.Name()).
+		Columns("user_id").
+		Where(qb.Eq("conversation_id"), qb.Eq("user_id")).
+		Query(*r.session)
+
+	var participantId gocql.UUID
+	err := query.BindMap(qb.M{"conversation_id": conversationId, "user_id": userId}).GetRelease(&participantId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// PresenceRepository defines the interface for the persisted part of presence.
+type PresenceRepository interface {
+	SaveLastSeen(ctx context.Context, userId gocql.UUID, lastSeen time.Time) error
+	GetLastSeen(ctx context.Context, userId gocql.UUID) (time.Time, error)
+}
+
+// presenceRepository is the concrete implementation of PresenceRepository.
+type presenceRepository struct {
+	session *gocqlx.Session
+}
+
+// NewPresenceRepository creates a new instance of presenceRepository.
+func NewPresenceRepository(session *gocqlx.Session) PresenceRepository {
+	return &presenceRepository{session: session}
+}
+
+// SaveLastSeen records when the user was last seen. The write is timestamped with lastSeen
+// so a late write from a slower node cannot move it backwards.
+func (r *presenceRepository) SaveLastSeen(ctx context.Context, userId gocql.UUID, lastSeen time.Time) error {
+	query := qb.Insert(models.LastSeenTable.Name()).
+		Columns(models.LastSeenTable.Metadata().Columns...).
+		Timestamp(lastSeen).
+		Query(*r.session)
+	return query.BindStruct(models.LastSeen{UserID: userId, LastSeen: lastSeen}).ExecRelease()
+}
+
+// GetLastSeen retrieves when the user was last seen.
+func (r *presenceRepository) GetLastSeen(ctx context.Context, userId gocql.UUID) (time.Time, error) {
+	query := qb.Select(models.LastSeenTable.Name()).
+		Columns(models.LastSeenTable.Metadata().Columns...).
+		Where(qb.Eq("user_id")).
+		Query(*r.session)
+
+	var lastSeen models.LastSeen
+	if err := query.BindMap(qb.M{"user_id": userId}).GetRelease(&lastSeen); err != nil {
+		return time.Time{}, err
+	}
+	return lastSeen.LastSeen, nil
+}
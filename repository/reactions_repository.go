@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// ReactionsRepository defines the interface for reaction-related operations.
+type ReactionsRepository interface {
+	AddReaction(ctx context.Context, reaction models.Reaction, outbox ...models.OutboxEntry) (bool, error)
+	RemoveReaction(ctx context.Context, messageId gocql.UUID, emoji string, userId gocql.UUID, outbox ...models.OutboxEntry) (bool, error)
+	GetReactionCounts(ctx context.Context, messageId gocql.UUID) (map[string]int64, error)
+	GetUserReactions(ctx context.Context, messageId gocql.UUID, userId gocql.UUID) (map[string]bool, error)
+	GetCountChecks(ctx context.Context, shard int) ([]models.ReactionCountCheck, error)
+	ClaimCountCheck(ctx context.Context, check models.ReactionCountCheck, now time.Time) (models.ReactionCountCheck, bool, error)
+	RepairReactionCounts(ctx context.Context, messageId gocql.UUID) error
+	DeleteCountCheck(ctx context.Context, check models.ReactionCountCheck) error
+}
+
+// reactionsRepository is the concrete implementation of ReactionsRepository.
+type reactionsRepository struct {
+	session *gocqlx.Session
+}
+
+// NewReactionsRepository creates a new instance of reactionsRepository.
+func NewReactionsRepository(session *gocqlx.Session) ReactionsRepository {
+	return &reactionsRepository{session: session}
+}
+
+// AddReaction stores the reaction and bumps the aggregate counter.
+// It reports false when the user had already reacted with the same emoji, in which case nothing changes
+// and the outbox entries are not written either.
+func (r *reactionsRepository) AddReaction(ctx context.Context, reaction models.Reaction, outbox ...models.OutboxEntry) (bool, error) {
+	if err := r.markCountChanged(reaction.MessageID); err != nil {
+		return false, err
+	}
+	query := qb.Insert(models.ReactionTable.Name()).
+		Columns(models.ReactionTable.Metadata().Columns...).
+		Unique().
+		Query(*r.session)
+
+	applied, err := query.BindStruct(reaction).ExecCASRelease()
+	if err != nil || !applied {
+		return false, err
+	}
+	if err := writeOutboxEntries(r.session, outbox); err != nil {
+		return true, err
+	}
+
+	// counters cannot share a batch with regular columns, the LWT above guards against double counting
+	if err := r.updateCount(reaction.MessageID, reaction.Emoji, 1); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// RemoveReaction deletes the user's reaction and decrements the aggregate counter.
+// It reports false when there was no such reaction, in which case the outbox entries are not written.
+func (r *reactionsRepository) RemoveReaction(ctx context.Context, messageId gocql.UUID, emoji string, userId gocql.UUID, outbox ...models.OutboxEntry) (bool, error) {
+	if err := r.markCountChanged(messageId); err != nil {
+		return false, err
+	}
+	query := qb.Delete(models.ReactionTable.Name()).
+		Where(qb.Eq("message_id"), qb.Eq("emoji"), qb.Eq("user_id")).
+		Existing().
+		Query(*r.session)
+
+	applied, err := query.BindMap(qb.M{"message_id": messageId, "emoji": emoji, "user_id": userId}).ExecCASRelease()
+	if err != nil || !applied {
+		return false, err
+	}
+	if err := writeOutboxEntries(r.session, outbox); err != nil {
+		return true, err
+	}
+
+	if err := r.updateCount(messageId, emoji, -1); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// markCountChanged records that the counters of a message are about to change. It is written ahead of
+// the reaction, so a counter update lost after the reaction changed is still checked by the repair.
+func (r *reactionsRepository) markCountChanged(messageId gocql.UUID) error {
+	query := r.session.Query(models.ReactionCountCheckTable.Insert()).BindStruct(models.NewReactionCountCheck(messageId, time.Now()))
+	return query.ExecRelease()
+}
+
+func (r *reactionsRepository) updateCount(messageId gocql.UUID, emoji string, delta int64) error {
+	query := qb.Update(models.ReactionCountTable.Name()).
+		Add("count").
+		Where(qb.Eq("message_id"), qb.Eq("emoji")).
+		Query(*r.session)
+
+	return query.BindMap(qb.M{"message_id": messageId, "emoji": emoji, "count": delta}).ExecRelease()
+}
+
+// GetReactionCounts returns the number of reactions per emoji for a message.
+func (r *reactionsRepository) GetReactionCounts(ctx context.Context, messageId gocql.UUID) (map[string]int64, error) {
+	query := qb.Select(models.ReactionCountTable.Name()).
+		Columns("emoji", "count").
+		Where(qb.Eq("message_id")).
+		Query(*r.session)
+
+	iter := query.BindMap(qb.M{"message_id": messageId}).Iter()
+	defer iter.Close()
+
+	counts := make(map[string]int64)
+	var emoji string
+	var count int64
+	for iter.Scan(&emoji, &count) {
+		// removed reactions leave zeroed counters behind
+		if count > 0 {
+			counts[emoji] = count
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// GetUserReactions returns the set of emojis the user reacted with on a message.
+func (r *reactionsRepository) GetUserReactions(ctx context.Context, messageId gocql.UUID, userId gocql.UUID) (map[string]bool, error) {
+	// filtering stays within a single partition so it is cheap
+	query := qb.Select(models.ReactionTable.Name()).
+		Columns("emoji").
+		Where(qb.Eq("message_id"), qb.Eq("user_id")).
+		AllowFiltering().
+		Query(*r.session)
+
+	iter := query.BindMap(qb.M{"message_id": messageId, "user_id": userId}).Iter()
+	defer iter.Close()
+
+	emojis := make(map[string]bool)
+	var emoji string
+	for iter.Scan(&emoji) {
+		emojis[emoji] = true
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return emojis, nil
+}
+
+// GetCountChecks retrieves the messages of a shard whose counters changed since they were last checked.
+func (r *reactionsRepository) GetCountChecks(ctx context.Context, shard int) ([]models.ReactionCountCheck, error) {
+	query := qb.Select(models.ReactionCountCheckTable.Name()).
+		Columns(models.ReactionCountCheckTable.Metadata().Columns...).
+		Where(qb.Eq("shard")).
+		Query(*r.session)
+
+	var checks []models.ReactionCountCheck
+	if err := query.BindMap(qb.M{"shard": shard}).SelectRelease(&checks); err != nil {
+		return nil, err
+	}
+	return checks, nil
+}
+
+// ClaimCountCheck takes a check over for a repair by stamping it with now, provided no reaction changed
+// since it was read. The stamp keeps other repairs away while it is recent, and tells the one holding it
+// whether a reaction changed in the meantime, see DeleteCountCheck.
+func (r *reactionsRepository) ClaimCountCheck(ctx context.Context, check models.ReactionCountCheck, now time.Time) (models.ReactionCountCheck, bool, error) {
+	claimed := models.NewReactionCountCheck(check.MessageID, now)
+	query := qb.Update(models.ReactionCountCheckTable.Name()).
+		SetNamed("changed_at", "now").
+		Where(qb.Eq("shard"), qb.Eq("message_id")).
+		If(qb.Eq("changed_at")).
+		Query(*r.session)
+
+	applied, err := query.BindStructMap(check, qb.M{"now": claimed.ChangedAt}).ExecCASRelease()
+	if err != nil || !applied {
+		return models.ReactionCountCheck{}, false, err
+	}
+	return claimed, true, nil
+}
+
+// RepairReactionCounts brings the counters of a message in line with its reaction rows, the rows are
+// what users reacted with. Concurrent reactions may throw the result off, they leave a check behind.
+func (r *reactionsRepository) RepairReactionCounts(ctx context.Context, messageId gocql.UUID) error {
+	// grouping selects the emoji ahead of the count
+	rowQuery := qb.Select(models.ReactionTable.Name()).
+		CountAll().
+		Where(qb.Eq("message_id")).
+		GroupBy("emoji").
+		Query(*r.session)
+
+	iter := rowQuery.BindMap(qb.M{"message_id": messageId}).Iter()
+	rows := make(map[string]int64)
+	var emoji string
+	var count int64
+	for iter.Scan(&emoji, &count) {
+		rows[emoji] = count
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	counterQuery := qb.Select(models.ReactionCountTable.Name()).
+		Columns("emoji", "count").
+		Where(qb.Eq("message_id")).
+		Query(*r.session)
+
+	iter = counterQuery.BindMap(qb.M{"message_id": messageId}).Iter()
+	counters := make(map[string]int64)
+	for iter.Scan(&emoji, &count) {
+		counters[emoji] = count
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	for emoji, count := range counters {
+		if _, ok := rows[emoji]; !ok && count != 0 {
+			rows[emoji] = 0
+		}
+	}
+	for emoji, count := range rows {
+		if delta := count - counters[emoji]; delta != 0 {
+			if err := r.updateCount(messageId, emoji, delta); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteCountCheck drops a check claimed by a repair, unless a reaction changed since the claim, in which
+// case it stays for the next repair.
+func (r *reactionsRepository) DeleteCountCheck(ctx context.Context, check models.ReactionCountCheck) error {
+	query := qb.Delete(models.ReactionCountCheckTable.Name()).
+		Where(qb.Eq("shard"), qb.Eq("message_id")).
+		If(qb.Eq("changed_at")).
+		Query(*r.session)
+
+	_, err := query.BindStruct(check).ExecCASRelease()
+	return err
+}
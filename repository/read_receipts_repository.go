@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// ReadReceiptsRepository defines the interface for read receipt operations.
+type ReadReceiptsRepository interface {
+	GetReceipt(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID) (models.ReadReceipt, error)
+	GetReceipts(ctx context.Context, conversationId gocql.UUID) ([]models.ReadReceipt, error)
+	SaveReceipt(ctx context.Context, receipt models.ReadReceipt, previous *models.ReadReceipt, outbox ...models.OutboxEntry) (bool, error)
+}
+
+// readReceiptsRepository is the concrete implementation of ReadReceiptsRepository.
+type readReceiptsRepository struct {
+	session *gocqlx.Session
+}
+
+// NewReadReceiptsRepository creates a new instance of readReceiptsRepository.
+func NewReadReceiptsRepository(session *gocqlx.Session) ReadReceiptsRepository {
+	return &readReceiptsRepository{session: session}
+}
+
+// GetReceipt retrieves the marks of one user in a conversation.
+func (r *readReceiptsRepository) GetReceipt(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID) (models.ReadReceipt, error) {
+	query := qb.Select(models.ReadReceiptTable.Name()).
+		Columns(models.ReadReceiptTable.Metadata().Columns...).
+		Where(qb.Eq("conversation_id"), qb.Eq("user_id")).
+		Query(*r.session)
+
+	var receipt models.ReadReceipt
+	if err := query.BindMap(qb.M{"conversation_id": conversationId, "user_id": userId}).GetRelease(&receipt); err != nil {
+		return models.ReadReceipt{}, err
+	}
+	return receipt, nil
+}
+
+// GetReceipts retrieves the marks of every user in a conversation.
+func (r *readReceiptsRepository) GetReceipts(ctx context.Context, conversationId gocql.UUID) ([]models.ReadReceipt, error) {
+	query := qb.Select(models.ReadReceiptTable.Name()).
+		Columns(models.ReadReceiptTable.Metadata().Columns...).
+		Where(qb.Eq("conversation_id")).
+		Query(*r.session)
+
+	var receipts []models.ReadReceipt
+	if err := query.BindMap(qb.M{"conversation_id": conversationId}).SelectRelease(&receipts); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+// SaveReceipt writes the receipt only if the stored marks still equal previous, or if no row exists when previous is nil.
+// It reports false when another writer got there first, callers are expected to re-read and retry.
+// The outbox entries are only written when the receipt was.
+func (r *readReceiptsRepository) SaveReceipt(ctx context.Context, receipt models.ReadReceipt, previous *models.ReadReceipt, outbox ...models.OutboxEntry) (bool, error) {
+	values := qb.M{
+		"conversation_id": receipt.ConversationID,
+		"user_id":         receipt.UserID,
+		"delivered_up_to": nullableUUID(receipt.DeliveredUpTo),
+		"delivered_at":    receipt.DeliveredAt,
+		"read_up_to":      nullableUUID(receipt.ReadUpTo),
+		"read_at":         receipt.ReadAt,
+	}
+
+	var query *gocqlx.Queryx
+	if previous == nil {
+		query = qb.Insert(models.ReadReceiptTable.Name()).
+			Columns(models.ReadReceiptTable.Metadata().Columns...).
+			Unique().
+			Query(*r.session)
+	} else {
+		query = qb.Update(models.ReadReceiptTable.Name()).
+			Set("delivered_up_to", "delivered_at", "read_up_to", "read_at").
+			Where(qb.Eq("conversation_id"), qb.Eq("user_id")).
+			If(qb.EqNamed("delivered_up_to", "previous_delivered_up_to"), qb.EqNamed("read_up_to", "previous_read_up_to")).
+			Query(*r.session)
+		values["previous_delivered_up_to"] = nullableUUID(previous.DeliveredUpTo)
+		values["previous_read_up_to"] = nullableUUID(previous.ReadUpTo)
+	}
+
+	applied, err := query.BindMap(values).ExecCASRelease()
+	if err != nil || !applied {
+		return false, err
+	}
+	return true, writeOutboxEntries(r.session, outbox)
+}
+
+// nullableUUID maps the zero UUID to null, timeuuid columns reject the all zero value.
+func nullableUUID(id gocql.UUID) interface{} {
+	if id == (gocql.UUID{}) {
+		return nil
+	}
+	return id
+}
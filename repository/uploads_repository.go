@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// UploadsRepository defines the interface for resumable upload bookkeeping.
+type UploadsRepository interface {
+	CreateUpload(ctx context.Context, upload models.ResumableUpload) (models.ResumableUpload, error)
+	GetUpload(ctx context.Context, id gocql.UUID) (models.ResumableUpload, error)
+	AppendChunk(ctx context.Context, id gocql.UUID, expectedOffset int64, newOffset int64) (bool, error)
+	SetAttachment(ctx context.Context, id gocql.UUID, attachmentId gocql.UUID) error
+	DeleteUpload(ctx context.Context, id gocql.UUID) error
+	GetUploads(ctx context.Context) ([]models.ResumableUpload, error)
+}
+
+// uploadsRepository is the concrete implementation of UploadsRepository.
+type uploadsRepository struct {
+	session *gocqlx.Session
+}
+
+// NewUploadsRepository creates a new instance of uploadsRepository.
+func NewUploadsRepository(session *gocqlx.Session) UploadsRepository {
+	return &uploadsRepository{session: session}
+}
+
+func (r *uploadsRepository) CreateUpload(ctx context.Context, upload models.ResumableUpload) (models.ResumableUpload, error) {
+	q := r.session.Query(models.ResumableUploadTable.Insert()).BindStruct(upload)
+	if err := q.ExecRelease(); err != nil {
+		return models.ResumableUpload{}, err
+	}
+	return upload, nil
+}
+
+func (r *uploadsRepository) GetUpload(ctx context.Context, id gocql.UUID) (models.ResumableUpload, error) {
+	query := qb.Select(models.ResumableUploadTable.Name()).
+		Columns(models.ResumableUploadTable.Metadata().Columns...).
+		Where(qb.Eq("id")).
+		Query(*r.session)
+
+	var upload models.ResumableUpload
+	if err := query.BindMap(qb.M{"id": id}).GetRelease(&upload); err != nil {
+		return models.ResumableUpload{}, err
+	}
+	return upload, nil
+}
+
+// AppendChunk records a chunk stored at expectedOffset and moves the offset to newOffset.
+// It reports false when the offset moved in the meantime, e.g. because of a concurrent PATCH.
+func (r *uploadsRepository) AppendChunk(ctx context.Context, id gocql.UUID, expectedOffset int64, newOffset int64) (bool, error) {
+	query := qb.Update(models.ResumableUploadTable.Name()).
+		Set("upload_offset").
+		AddNamed("chunk_offsets", "chunk").
+		Where(qb.Eq("id")).
+		If(qb.EqNamed("upload_offset", "expected_offset")).
+		Query(*r.session)
+
+	return query.BindMap(qb.M{
+		"id":              id,
+		"upload_offset":   newOffset,
+		"chunk":           []int64{expectedOffset},
+		"expected_offset": expectedOffset,
+	}).ExecCASRelease()
+}
+
+func (r *uploadsRepository) SetAttachment(ctx context.Context, id gocql.UUID, attachmentId gocql.UUID) error {
+	query := qb.Update(models.ResumableUploadTable.Name()).
+		Set("attachment_id").
+		Where(qb.Eq("id")).
+		Query(*r.session)
+
+	return query.BindMap(qb.M{"id": id, "attachment_id": attachmentId}).ExecRelease()
+}
+
+func (r *uploadsRepository) DeleteUpload(ctx context.Context, id gocql.UUID) error {
+	query := qb.Delete(models.ResumableUploadTable.Name()).Where(qb.Eq("id")).Query(*r.session)
+	return query.BindMap(qb.M{"id": id}).ExecRelease()
+}
+
+// GetUploads retrieves every tracked upload, the table only holds uploads that are in flight or recently finished.
+func (r *uploadsRepository) GetUploads(ctx context.Context) ([]models.ResumableUpload, error) {
+	query := qb.Select(models.ResumableUploadTable.Name()).
+		Columns(models.ResumableUploadTable.Metadata().Columns...).
+		Query(*r.session)
+
+	var uploads []models.ResumableUpload
+	if err := query.SelectRelease(&uploads); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
@@ -0,0 +1,383 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/qb"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// WebhooksRepository defines the interface for webhook subscriptions and their delivery log.
+type WebhooksRepository interface {
+	SaveWebhook(ctx context.Context, webhook models.Webhook) error
+	GetWebhook(ctx context.Context, id gocql.UUID) (models.Webhook, error)
+	GetWebhooksByOwner(ctx context.Context, ownerId gocql.UUID) ([]models.Webhook, error)
+	GetConversationWebhooks(ctx context.Context, conversationId gocql.UUID, participantIds []gocql.UUID) ([]models.Webhook, error)
+	UpdateWebhookHealth(ctx context.Context, webhook models.Webhook) error
+	DeleteWebhook(ctx context.Context, id gocql.UUID) error
+	SaveDelivery(ctx context.Context, delivery models.WebhookDelivery) (bool, error)
+	ClaimDelivery(ctx context.Context, delivery models.WebhookDelivery, until time.Time) (models.WebhookDelivery, bool, error)
+	UpdateDelivery(ctx context.Context, previous models.WebhookDelivery, delivery models.WebhookDelivery) error
+	GetDelivery(ctx context.Context, webhookId gocql.UUID, id gocql.UUID) (models.WebhookDelivery, error)
+	GetDeliveries(ctx context.Context, webhookId gocql.UUID, status string, limit int) ([]models.WebhookDelivery, error)
+	GetDueRetries(ctx context.Context, shard int, after time.Time, until time.Time, limit int) ([]models.WebhookRetry, error)
+	DeleteRetry(ctx context.Context, retry models.WebhookRetry) error
+}
+
+// maxInKeys bounds the keys of an IN query, longer lists are read in chunks.
+const maxInKeys = 100
+
+// webhooksRepository is the concrete implementation of WebhooksRepository.
+type webhooksRepository struct {
+	session *gocqlx.Session
+}
+
+// NewWebhooksRepository creates a new instance of webhooksRepository.
+func NewWebhooksRepository(session *gocqlx.Session) WebhooksRepository {
+	return &webhooksRepository{session: session}
+}
+
+// SaveWebhook creates or replaces a webhook along with its entries in the lookup tables,
+// dropping the ones of conversations it is no longer limited to.
+func (r *webhooksRepository) SaveWebhook(ctx context.Context, webhook models.Webhook) error {
+	previous, err := r.GetWebhook(ctx, webhook.ID)
+	if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+		return err
+	}
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	kept := make(map[gocql.UUID]bool, len(webhook.ConversationIDs))
+	for _, conversationId := range webhook.ConversationIDs {
+		kept[conversationId] = true
+	}
+	for _, conversationId := range previous.ConversationIDs {
+		if kept[conversationId] {
+			continue
+		}
+		deleteQuery := r.session.Query(models.WebhookByConversationTable.Delete())
+		if err := batch.BindMap(deleteQuery, qb.M{"conversation_id": conversationId, "webhook_id": webhook.ID}); err != nil {
+			return err
+		}
+	}
+	if err := batch.BindStruct(r.session.Query(models.WebhookTable.Insert()), webhook); err != nil {
+		return err
+	}
+	for _, conversationId := range webhook.ConversationIDs {
+		insertQuery := r.session.Query(models.WebhookByConversationTable.Insert())
+		if err := batch.BindMap(insertQuery, qb.M{"conversation_id": conversationId, "webhook_id": webhook.ID}); err != nil {
+			return err
+		}
+	}
+	ownership := models.WebhookOwnership{OwnerID: webhook.OwnerID, WebhookID: webhook.ID, AllConversations: len(webhook.ConversationIDs) == 0}
+	if err := batch.BindStruct(r.session.Query(models.WebhookByOwnerTable.Insert()), ownership); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+// GetWebhook retrieves a single webhook by its ID.
+func (r *webhooksRepository) GetWebhook(ctx context.Context, id gocql.UUID) (models.Webhook, error) {
+	query := qb.Select(models.WebhookTable.Name()).
+		Columns(models.WebhookTable.Metadata().Columns...).
+		Where(qb.Eq("id")).
+		Query(*r.session)
+
+	var webhook models.Webhook
+	if err := query.BindMap(qb.M{"id": id}).GetRelease(&webhook); err != nil {
+		return models.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// GetWebhooksByOwner retrieves the webhooks a user registered.
+func (r *webhooksRepository) GetWebhooksByOwner(ctx context.Context, ownerId gocql.UUID) ([]models.Webhook, error) {
+	query := qb.Select(models.WebhookByOwnerTable.Name()).
+		Columns(models.WebhookByOwnerTable.Metadata().Columns...).
+		Where(qb.Eq("owner_id")).
+		Query(*r.session)
+
+	var owned []models.WebhookOwnership
+	if err := query.BindMap(qb.M{"owner_id": ownerId}).SelectRelease(&owned); err != nil {
+		return nil, err
+	}
+	ids := make([]gocql.UUID, 0, len(owned))
+	for _, ownership := range owned {
+		ids = append(ids, ownership.WebhookID)
+	}
+	return r.getWebhooks(ids)
+}
+
+// GetConversationWebhooks retrieves the webhooks that may match events of a conversation: the ones
+// limited to it, and the ones of its participants covering all their conversations. Whether they
+// subscribed to the event type, and whether their owner takes part, is left to the caller.
+func (r *webhooksRepository) GetConversationWebhooks(ctx context.Context, conversationId gocql.UUID, participantIds []gocql.UUID) ([]models.Webhook, error) {
+	query := qb.Select(models.WebhookByConversationTable.Name()).
+		Columns("webhook_id").
+		Where(qb.Eq("conversation_id")).
+		Query(*r.session)
+
+	var limited []models.WebhookOwnership
+	if err := query.BindMap(qb.M{"conversation_id": conversationId}).SelectRelease(&limited); err != nil {
+		return nil, err
+	}
+	ids := make([]gocql.UUID, 0, len(limited))
+	for _, ownership := range limited {
+		ids = append(ids, ownership.WebhookID)
+	}
+
+	for start := 0; start < len(participantIds); start += maxInKeys {
+		chunk := participantIds[start:min(start+maxInKeys, len(participantIds))]
+		ownerQuery := qb.Select(models.WebhookByOwnerTable.Name()).
+			Columns(models.WebhookByOwnerTable.Metadata().Columns...).
+			Where(qb.In("owner_id")).
+			Query(*r.session)
+
+		var owned []models.WebhookOwnership
+		if err := ownerQuery.BindMap(qb.M{"owner_id": chunk}).SelectRelease(&owned); err != nil {
+			return nil, err
+		}
+		for _, ownership := range owned {
+			if ownership.AllConversations {
+				ids = append(ids, ownership.WebhookID)
+			}
+		}
+	}
+	return r.getWebhooks(ids)
+}
+
+// getWebhooks loads webhooks by id, skipping the ones deleted since they were looked up.
+func (r *webhooksRepository) getWebhooks(ids []gocql.UUID) ([]models.Webhook, error) {
+	webhooks := make([]models.Webhook, 0, len(ids))
+	for start := 0; start < len(ids); start += maxInKeys {
+		query := qb.Select(models.WebhookTable.Name()).
+			Columns(models.WebhookTable.Metadata().Columns...).
+			Where(qb.In("id")).
+			Query(*r.session)
+
+		var chunk []models.Webhook
+		if err := query.BindMap(qb.M{"id": ids[start:min(start+maxInKeys, len(ids))]}).SelectRelease(&chunk); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, chunk...)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhookHealth stores the failure count and enabled state without touching the subscription.
+// It only applies while the webhook exists so a concurrent delete is not undone.
+func (r *webhooksRepository) UpdateWebhookHealth(ctx context.Context, webhook models.Webhook) error {
+	query := qb.Update(models.WebhookTable.Name()).
+		Set("enabled", "consecutive_failures", "disabled_reason", "updated_at").
+		Where(qb.Eq("id")).
+		Existing().
+		Query(*r.session)
+
+	_, err := query.BindStruct(webhook).ExecCASRelease()
+	return err
+}
+
+// DeleteWebhook removes a webhook and its entries in the lookup tables, its delivery log expires on its own.
+func (r *webhooksRepository) DeleteWebhook(ctx context.Context, id gocql.UUID) error {
+	webhook, err := r.GetWebhook(ctx, id)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindMap(r.session.Query(models.WebhookTable.Delete()), qb.M{"id": id}); err != nil {
+		return err
+	}
+	for _, conversationId := range webhook.ConversationIDs {
+		deleteQuery := r.session.Query(models.WebhookByConversationTable.Delete())
+		if err := batch.BindMap(deleteQuery, qb.M{"conversation_id": conversationId, "webhook_id": id}); err != nil {
+			return err
+		}
+	}
+	if err := batch.BindMap(r.session.Query(models.WebhookByOwnerTable.Delete()), qb.M{"owner_id": webhook.OwnerID, "webhook_id": id}); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+// SaveDelivery logs a new delivery, scheduling its attempt while it is pending, and reports whether it
+// was new. A delivery logged before, for an event delivered again, is kept as it is.
+func (r *webhooksRepository) SaveDelivery(ctx context.Context, delivery models.WebhookDelivery) (bool, error) {
+	query := qb.Insert(models.WebhookDeliveryTable.Name()).
+		Columns(models.WebhookDeliveryTable.Metadata().Columns...).
+		Unique().
+		Query(*r.session)
+
+	var existing models.WebhookDelivery
+	applied, err := query.BindStruct(delivery).GetCASRelease(&existing)
+	if err != nil {
+		return false, err
+	}
+	if !applied {
+		if existing.Status != models.WebhookDeliveryPending {
+			return false, nil
+		}
+		// the lookup entries are written after the transaction, a crash in between leaves them out
+		delivery = existing
+	}
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := r.addDeliveryEntries(batch, delivery); err != nil {
+		return false, err
+	}
+	return applied, r.session.ExecuteBatch(batch)
+}
+
+// ClaimDelivery reserves the next attempt of a pending delivery for the caller by moving it to until,
+// and reports whether it did. The move is a lightweight transaction conditioned on the delivery still
+// being due when the caller read it, so of the instances sweeping the same retry only one attempts it.
+// Should the caller not record the outcome, the delivery is attempted again once until has passed.
+func (r *webhooksRepository) ClaimDelivery(ctx context.Context, delivery models.WebhookDelivery, until time.Time) (models.WebhookDelivery, bool, error) {
+	until = until.Truncate(time.Millisecond)
+	query := qb.Update(models.WebhookDeliveryTable.Name()).
+		SetNamed("next_attempt_at", "until").
+		Where(qb.Eq("webhook_id"), qb.Eq("id")).
+		If(qb.Eq("status"), qb.Eq("next_attempt_at")).
+		Query(*r.session)
+
+	applied, err := query.BindStructMap(delivery, qb.M{"until": until}).ExecCASRelease()
+	if err != nil || !applied {
+		return models.WebhookDelivery{}, false, err
+	}
+
+	claimed := delivery
+	claimed.NextAttemptAt = until
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if err := batch.BindStruct(r.session.Query(models.WebhookRetryTable.Delete()), models.NewWebhookRetry(delivery)); err != nil {
+		return models.WebhookDelivery{}, false, err
+	}
+	if err := batch.BindStruct(r.session.Query(models.WebhookRetryTable.Insert()), models.NewWebhookRetry(claimed)); err != nil {
+		return models.WebhookDelivery{}, false, err
+	}
+	if err := r.session.ExecuteBatch(batch); err != nil {
+		return models.WebhookDelivery{}, false, err
+	}
+	return claimed, true, nil
+}
+
+// UpdateDelivery records the outcome of an attempt, moving the delivery out of its previous status and retry slot.
+func (r *webhooksRepository) UpdateDelivery(ctx context.Context, previous models.WebhookDelivery, delivery models.WebhookDelivery) error {
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	if previous.Status != delivery.Status {
+		if err := batch.BindStruct(r.session.Query(models.WebhookDeliveryByStatusTable.Delete()), previous); err != nil {
+			return err
+		}
+	}
+	// a delete and an insert of the same row in one batch leave it deleted
+	stillScheduled := delivery.Status == models.WebhookDeliveryPending && delivery.NextAttemptAt.Equal(previous.NextAttemptAt)
+	if previous.Status == models.WebhookDeliveryPending && !stillScheduled {
+		if err := batch.BindStruct(r.session.Query(models.WebhookRetryTable.Delete()), models.NewWebhookRetry(previous)); err != nil {
+			return err
+		}
+	}
+	if err := r.addDelivery(batch, delivery); err != nil {
+		return err
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+// addDelivery appends the delivery and its lookup entries to a batch.
+func (r *webhooksRepository) addDelivery(batch *gocqlx.Batch, delivery models.WebhookDelivery) error {
+	if err := batch.BindStruct(r.session.Query(models.WebhookDeliveryTable.Insert()), delivery); err != nil {
+		return err
+	}
+	return r.addDeliveryEntries(batch, delivery)
+}
+
+// addDeliveryEntries appends the entries of a delivery in the status index and the retry schedule to a batch.
+func (r *webhooksRepository) addDeliveryEntries(batch *gocqlx.Batch, delivery models.WebhookDelivery) error {
+	if err := batch.BindStruct(r.session.Query(models.WebhookDeliveryByStatusTable.Insert()), delivery); err != nil {
+		return err
+	}
+	if delivery.Status != models.WebhookDeliveryPending {
+		return nil
+	}
+	return batch.BindStruct(r.session.Query(models.WebhookRetryTable.Insert()), models.NewWebhookRetry(delivery))
+}
+
+func (r *webhooksRepository) GetDelivery(ctx context.Context, webhookId gocql.UUID, id gocql.UUID) (models.WebhookDelivery, error) {
+	query := qb.Select(models.WebhookDeliveryTable.Name()).
+		Columns(models.WebhookDeliveryTable.Metadata().Columns...).
+		Where(qb.Eq("webhook_id"), qb.Eq("id")).
+		Query(*r.session)
+
+	var delivery models.WebhookDelivery
+	if err := query.BindMap(qb.M{"webhook_id": webhookId, "id": id}).GetRelease(&delivery); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// GetDeliveries retrieves the most recent deliveries of a webhook, newest first,
+// only the ones in the given status unless it is empty.
+func (r *webhooksRepository) GetDeliveries(ctx context.Context, webhookId gocql.UUID, status string, limit int) ([]models.WebhookDelivery, error) {
+	builder := qb.Select(models.WebhookDeliveryTable.Name()).
+		Columns(models.WebhookDeliveryTable.Metadata().Columns...).
+		Where(qb.Eq("webhook_id"))
+	bind := qb.M{"webhook_id": webhookId}
+
+	if status != "" {
+		idQuery := qb.Select(models.WebhookDeliveryByStatusTable.Name()).
+			Columns("id").
+			Where(qb.Eq("webhook_id"), qb.Eq("status")).
+			Limit(uint(limit)).
+			Query(*r.session)
+
+		var matching []models.WebhookDelivery
+		if err := idQuery.BindMap(qb.M{"webhook_id": webhookId, "status": status}).SelectRelease(&matching); err != nil {
+			return nil, err
+		}
+		if len(matching) == 0 {
+			return []models.WebhookDelivery{}, nil
+		}
+		ids := make([]gocql.UUID, 0, len(matching))
+		for _, delivery := range matching {
+			ids = append(ids, delivery.ID)
+		}
+		builder = builder.Where(qb.In("id"))
+		bind["id"] = ids
+	}
+	query := builder.Limit(uint(limit)).Query(*r.session)
+
+	var deliveries []models.WebhookDelivery
+	if err := query.BindMap(bind).SelectRelease(&deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetDueRetries retrieves the earliest attempts of a shard due after the given time and up to until.
+// A zero after starts from the earliest attempt of the shard.
+func (r *webhooksRepository) GetDueRetries(ctx context.Context, shard int, after time.Time, until time.Time, limit int) ([]models.WebhookRetry, error) {
+	builder := qb.Select(models.WebhookRetryTable.Name()).
+		Columns(models.WebhookRetryTable.Metadata().Columns...).
+		Where(qb.Eq("shard"), qb.LtOrEqNamed("next_attempt_at", "until"))
+	bind := qb.M{"shard": shard, "until": until}
+	if !after.IsZero() {
+		builder = builder.Where(qb.GtNamed("next_attempt_at", "after"))
+		bind["after"] = after
+	}
+	query := builder.Limit(uint(limit)).Query(*r.session)
+
+	var retries []models.WebhookRetry
+	if err := query.BindMap(bind).SelectRelease(&retries); err != nil {
+		return nil, err
+	}
+	return retries, nil
+}
+
+// DeleteRetry drops a retry whose delivery is gone or no longer pending.
+func (r *webhooksRepository) DeleteRetry(ctx context.Context, retry models.WebhookRetry) error {
+	query := r.session.Query(models.WebhookRetryTable.Delete()).BindStruct(retry)
+	return query.ExecRelease()
+}
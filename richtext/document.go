@@ -0,0 +1,233 @@
+package richtext
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Node types of the canonical structure.
+const (
+	NodeParagraph     = "paragraph"
+	NodeCodeBlock     = "code_block"
+	NodeBlockquote    = "blockquote"
+	NodeList          = "list"
+	NodeListItem      = "list_item"
+	NodeText          = "text"
+	NodeStrong        = "strong"
+	NodeEmphasis      = "emphasis"
+	NodeStrikethrough = "strikethrough"
+	NodeCode          = "code"
+	NodeLink          = "link"
+	NodeLineBreak     = "line_break"
+)
+
+// maxDepth bounds how deeply blocks and inline formatting can nest.
+const maxDepth = 8
+
+// ErrDisallowed is returned for constructs outside the supported subset, such as raw HTML or images.
+var ErrDisallowed = errors.New("unsupported formatting")
+
+// Node is an element of a parsed body. Text is set on text, code and code_block nodes, URL on links,
+// Language on code blocks that name one and Start on ordered lists.
+type Node struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Language string `json:"language,omitempty"`
+	Ordered  bool   `json:"ordered,omitempty"`
+	Start    int    `json:"start,omitempty"`
+	Children []Node `json:"children,omitempty"`
+}
+
+// Document is a message body parsed into blocks.
+type Document struct {
+	Blocks []Node `json:"blocks"`
+}
+
+var (
+	fencePattern       = regexp.MustCompile("^ {0,3}(```+)\\s*([A-Za-z0-9_+#.-]*)\\s*$")
+	headingPattern     = regexp.MustCompile(`^ {0,3}#{1,6}(\s|$)`)
+	unorderedPattern   = regexp.MustCompile(`^( {0,3})([-*+])\s+`)
+	orderedPattern     = regexp.MustCompile(`^( {0,3})(\d{1,9})[.)]\s+`)
+	blockquotePattern  = regexp.MustCompile(`^ {0,3}> ?`)
+	languageUnsafeChar = regexp.MustCompile(`[^A-Za-z0-9_+#.-]`)
+)
+
+// Parse turns a body written in the supported Markdown subset into its canonical structure:
+// paragraphs, fenced code blocks, block quotes and lists, with bold, italics, strikethrough, inline code
+// and links inside them. Bare URLs become links. Raw HTML, images, headings and links to anything but
+// http, https and mailto are rejected with ErrDisallowed. Every other character is kept as text.
+func Parse(body string) (Document, error) {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	body = strings.ReplaceAll(body, "\r", "\n")
+	blocks, err := parseBlocks(strings.Split(body, "\n"), 0)
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{Blocks: blocks}, nil
+}
+
+// ParseOrPlain parses the body, falling back to PlainText for bodies stored before formatting was
+// checked that do not parse.
+func ParseOrPlain(body string) Document {
+	doc, err := Parse(body)
+	if err != nil {
+		return PlainText(body)
+	}
+	return doc
+}
+
+// PlainText wraps a body in a document without interpreting it.
+func PlainText(body string) Document {
+	var blocks []Node
+	for _, paragraph := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n\n") {
+		if strings.TrimSpace(paragraph) == "" {
+			continue
+		}
+		blocks = append(blocks, Node{Type: NodeParagraph, Children: textWithBreaks(paragraph)})
+	}
+	return Document{Blocks: blocks}
+}
+
+func parseBlocks(lines []string, depth int) ([]Node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: blocks are nested too deeply", ErrDisallowed)
+	}
+
+	var blocks []Node
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case fencePattern.MatchString(line):
+			match := fencePattern.FindStringSubmatch(line)
+			fence := match[1]
+			var code []string
+			i++
+			for i < len(lines) && strings.TrimSpace(lines[i]) != fence {
+				code = append(code, lines[i])
+				i++
+			}
+			// an unterminated fence runs to the end of the body
+			i++
+			blocks = append(blocks, Node{
+				Type:     NodeCodeBlock,
+				Text:     strings.Join(code, "\n"),
+				Language: languageUnsafeChar.ReplaceAllString(match[2], ""),
+			})
+
+		case headingPattern.MatchString(line):
+			return nil, fmt.Errorf("%w: headings are not supported", ErrDisallowed)
+
+		case blockquotePattern.MatchString(line):
+			var quoted []string
+			for i < len(lines) && blockquotePattern.MatchString(lines[i]) {
+				quoted = append(quoted, blockquotePattern.ReplaceAllString(lines[i], ""))
+				i++
+			}
+			children, err := parseBlocks(quoted, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, Node{Type: NodeBlockquote, Children: children})
+
+		case unorderedPattern.MatchString(line) || orderedPattern.MatchString(line):
+			list, next, err := parseList(lines, i, depth)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, list)
+			i = next
+
+		default:
+			start := i
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]) {
+				i++
+			}
+			children, err := parseInline(strings.Join(lines[start:i], "\n"), depth, false)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, Node{Type: NodeParagraph, Children: children})
+		}
+	}
+	return blocks, nil
+}
+
+// startsBlock reports whether the line opens a block other than a paragraph, ending the paragraph before it.
+func startsBlock(line string) bool {
+	return fencePattern.MatchString(line) ||
+		headingPattern.MatchString(line) ||
+		blockquotePattern.MatchString(line) ||
+		unorderedPattern.MatchString(line) ||
+		orderedPattern.MatchString(line)
+}
+
+// parseList reads the items of the list starting at lines[start]. Lines indented past the marker
+// continue the item, so items can hold nested lists. It returns the list and the line after it.
+func parseList(lines []string, start int, depth int) (Node, int, error) {
+	ordered := orderedPattern.MatchString(lines[start])
+	list := Node{Type: NodeList, Ordered: ordered}
+	if ordered {
+		// lists starting at 1 leave Start out
+		if n, _ := strconv.Atoi(orderedPattern.FindStringSubmatch(lines[start])[2]); n != 1 {
+			list.Start = n
+		}
+	}
+
+	i := start
+	for i < len(lines) {
+		var marker []int
+		if ordered {
+			marker = orderedPattern.FindStringIndex(lines[i])
+		} else {
+			marker = unorderedPattern.FindStringIndex(lines[i])
+		}
+		if marker == nil {
+			break
+		}
+		indent := marker[1]
+		content := []string{lines[i][indent:]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			trimmed := strings.TrimLeft(line, " ")
+			if trimmed == "" || len(line)-len(trimmed) < min(indent, 2) {
+				break
+			}
+			content = append(content, line[min(len(line)-len(trimmed), indent):])
+			i++
+		}
+
+		children, err := parseBlocks(content, depth+1)
+		if err != nil {
+			return Node{}, 0, err
+		}
+		// a single paragraph item is kept tight, clients render it without paragraph spacing
+		if len(children) > 0 && children[0].Type == NodeParagraph {
+			rest := children[1:]
+			children = append(append([]Node{}, children[0].Children...), rest...)
+		}
+		list.Children = append(list.Children, Node{Type: NodeListItem, Children: children})
+	}
+	return list, i, nil
+}
+
+// textWithBreaks turns newlines of plain text into line breaks.
+func textWithBreaks(text string) []Node {
+	var nodes []Node
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			nodes = append(nodes, Node{Type: NodeLineBreak})
+		}
+		if line != "" {
+			nodes = append(nodes, Node{Type: NodeText, Text: line})
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,82 @@
+package richtext
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// HTML renders the document with a fixed set of tags. Text is always escaped and link targets were
+// restricted to http, https and mailto when parsing, so the output is safe to embed as is.
+func (d Document) HTML() string {
+	var b strings.Builder
+	writeNodes(&b, d.Blocks)
+	return b.String()
+}
+
+func writeNodes(b *strings.Builder, nodes []Node) {
+	for _, node := range nodes {
+		writeNode(b, node)
+	}
+}
+
+func writeNode(b *strings.Builder, node Node) {
+	switch node.Type {
+	case NodeParagraph:
+		b.WriteString("<p>")
+		writeNodes(b, node.Children)
+		b.WriteString("</p>")
+	case NodeCodeBlock:
+		b.WriteString("<pre><code")
+		if node.Language != "" {
+			b.WriteString(` class="language-` + html.EscapeString(node.Language) + `"`)
+		}
+		b.WriteString(">")
+		b.WriteString(html.EscapeString(node.Text))
+		b.WriteString("</code></pre>")
+	case NodeBlockquote:
+		b.WriteString("<blockquote>")
+		writeNodes(b, node.Children)
+		b.WriteString("</blockquote>")
+	case NodeList:
+		tag := "ul"
+		if node.Ordered {
+			tag = "ol"
+		}
+		b.WriteString("<" + tag)
+		if node.Start != 0 {
+			b.WriteString(` start="` + strconv.Itoa(node.Start) + `"`)
+		}
+		b.WriteString(">")
+		writeNodes(b, node.Children)
+		b.WriteString("</" + tag + ">")
+	case NodeListItem:
+		b.WriteString("<li>")
+		writeNodes(b, node.Children)
+		b.WriteString("</li>")
+	case NodeText:
+		b.WriteString(html.EscapeString(node.Text))
+	case NodeStrong:
+		b.WriteString("<strong>")
+		writeNodes(b, node.Children)
+		b.WriteString("</strong>")
+	case NodeEmphasis:
+		b.WriteString("<em>")
+		writeNodes(b, node.Children)
+		b.WriteString("</em>")
+	case NodeStrikethrough:
+		b.WriteString("<del>")
+		writeNodes(b, node.Children)
+		b.WriteString("</del>")
+	case NodeCode:
+		b.WriteString("<code>")
+		b.WriteString(html.EscapeString(node.Text))
+		b.WriteString("</code>")
+	case NodeLink:
+		b.WriteString(`<a href="` + html.EscapeString(node.URL) + `" rel="nofollow noopener noreferrer" target="_blank">`)
+		writeNodes(b, node.Children)
+		b.WriteString("</a>")
+	case NodeLineBreak:
+		b.WriteString("<br>")
+	}
+}
@@ -0,0 +1,131 @@
+package richtext
+
+import (
+	"sort"
+	"unicode"
+)
+
+// delimiterKey identifies closing delimiter runs by character and length.
+type delimiterKey struct {
+	delimiter rune
+	n         int
+}
+
+// inlineIndex holds the positions the inline parser looks up while matching openers, collected up front
+// in a few passes over the text. An opener that is never closed then costs a lookup instead of a scan to
+// the end of the text, which made bodies full of stray *, _, ~ or [ take quadratic time.
+type inlineIndex struct {
+	codeRuns map[int][]int          // start of every backtick run, by run length
+	closers  map[delimiterKey][]int // delimiter runs that can close a span, outside code spans
+	brackets map[int]int            // [ to its matching ]
+	parens   map[int]int            // ( to its matching ) on the same line
+	spaces   []int                  // whitespace
+	tagEnds  []int                  // >
+	urlEnds  []int                  // whitespace, < and >, where a bare URL stops
+}
+
+func newInlineIndex(text []rune) *inlineIndex {
+	idx := &inlineIndex{
+		codeRuns: make(map[int][]int),
+		closers:  make(map[delimiterKey][]int),
+		brackets: make(map[int]int),
+		parens:   make(map[int]int),
+	}
+
+	for i, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			idx.spaces = append(idx.spaces, i)
+			idx.urlEnds = append(idx.urlEnds, i)
+		case r == '>':
+			idx.tagEnds = append(idx.tagEnds, i)
+			idx.urlEnds = append(idx.urlEnds, i)
+		case r == '<':
+			idx.urlEnds = append(idx.urlEnds, i)
+		}
+	}
+
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		run := runLength(text, i, '`')
+		idx.codeRuns[run] = append(idx.codeRuns[run], i)
+		i += run
+	}
+
+	// code spans and escaped characters never close a span, and a closer has to follow text rather than a space
+	for i := 0; i < len(text); {
+		switch r := text[i]; r {
+		case '\\':
+			i += 2
+		case '`':
+			run := runLength(text, i, '`')
+			if end := idx.codeEnd(i+run, run); end >= 0 {
+				i = end + run
+			} else {
+				i += run
+			}
+		case '*', '_', '~':
+			run := runLength(text, i, r)
+			closes := i > 0 && !unicode.IsSpace(text[i-1])
+			// an underscore does not close inside a word such as snake_case
+			if closes && r == '_' && i+run < len(text) && isWordRune(text[i+run]) {
+				closes = false
+			}
+			if closes {
+				key := delimiterKey{delimiter: r, n: run}
+				idx.closers[key] = append(idx.closers[key], i)
+			}
+			i += run
+		default:
+			i++
+		}
+	}
+
+	// brackets nest, escaped ones are skipped, and link targets do not continue on the next line
+	var openBrackets, openParens []int
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '\n':
+			openParens = openParens[:0]
+		case '[':
+			openBrackets = append(openBrackets, i)
+		case ']':
+			if n := len(openBrackets); n > 0 {
+				idx.brackets[openBrackets[n-1]] = i
+				openBrackets = openBrackets[:n-1]
+			}
+		case '(':
+			openParens = append(openParens, i)
+		case ')':
+			if n := len(openParens); n > 0 {
+				idx.parens[openParens[n-1]] = i
+				openParens = openParens[:n-1]
+			}
+		}
+	}
+	return idx
+}
+
+// codeEnd finds the run of exactly n backticks closing a code span opened before start.
+func (idx *inlineIndex) codeEnd(start int, n int) int {
+	return nextPosition(idx.codeRuns[n], start)
+}
+
+// closer finds the run of exactly n delimiters closing the span opened before start.
+func (idx *inlineIndex) closer(start int, delimiter rune, n int) int {
+	return nextPosition(idx.closers[delimiterKey{delimiter: delimiter, n: n}], start)
+}
+
+// nextPosition returns the first of the ascending positions at or after start, -1 when there is none.
+func nextPosition(positions []int, start int) int {
+	i := sort.SearchInts(positions, start)
+	if i == len(positions) {
+		return -1
+	}
+	return positions[i]
+}
@@ -0,0 +1,314 @@
+package richtext
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// htmlPattern matches what a browser would take for a tag or a comment.
+	htmlPattern = regexp.MustCompile(`^<(?:/?[A-Za-z][A-Za-z0-9-]*(?:\s[^<>]*)?/?>|!--)`)
+	// autolinkPattern matches <https://...> style links.
+	autolinkPattern = regexp.MustCompile(`^<((?:https?|mailto):[^\s<>]+)>`)
+	// bareURLPattern matches URLs written without any markup.
+	bareURLPattern = regexp.MustCompile(`^(?:https?://|www\.)[^\s<>]+`)
+)
+
+// allowedSchemes are the link targets clients may open.
+var allowedSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// inlineParser turns the text of a paragraph into inline nodes.
+type inlineParser struct {
+	text   []rune
+	idx    *inlineIndex
+	depth  int
+	inLink bool
+	nodes  []Node
+	buf    strings.Builder
+}
+
+func parseInline(text string, depth int, inLink bool) ([]Node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: formatting is nested too deeply", ErrDisallowed)
+	}
+	runes := []rune(text)
+	p := &inlineParser{text: runes, idx: newInlineIndex(runes), depth: depth, inLink: inLink}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.nodes, nil
+}
+
+func (p *inlineParser) parse() error {
+	for i := 0; i < len(p.text); {
+		r := p.text[i]
+		switch {
+		case r == '\\' && i+1 < len(p.text) && isASCIIPunct(p.text[i+1]):
+			p.buf.WriteRune(p.text[i+1])
+			i += 2
+
+		case r == '\n':
+			p.flush()
+			p.nodes = append(p.nodes, Node{Type: NodeLineBreak})
+			i++
+
+		case r == '`':
+			run := runLength(p.text, i, '`')
+			end := p.idx.codeEnd(i+run, run)
+			if end < 0 {
+				p.buf.WriteString(strings.Repeat("`", run))
+				i += run
+				continue
+			}
+			p.flush()
+			p.nodes = append(p.nodes, Node{Type: NodeCode, Text: trimCode(string(p.text[i+run : end]))})
+			i = end + run
+
+		case r == '!' && i+1 < len(p.text) && p.text[i+1] == '[' && p.isLinkSyntax(i+1):
+			return fmt.Errorf("%w: images are not supported, upload them as attachments", ErrDisallowed)
+
+		case r == '<':
+			rest := p.tagWindow(i)
+			if match := autolinkPattern.FindStringSubmatch(rest); match != nil && !p.inLink {
+				link, err := newLink(match[1], []Node{{Type: NodeText, Text: match[1]}})
+				if err != nil {
+					return err
+				}
+				p.flush()
+				p.nodes = append(p.nodes, link)
+				i += utf8.RuneCountInString(match[0])
+				continue
+			}
+			if htmlPattern.MatchString(rest) {
+				return fmt.Errorf("%w: raw HTML is not supported", ErrDisallowed)
+			}
+			p.buf.WriteRune(r)
+			i++
+
+		case r == '[' && !p.inLink:
+			next, ok, err := p.link(i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				p.buf.WriteRune(r)
+				i++
+				continue
+			}
+			i = next
+
+		case r == '*' || r == '_' || r == '~':
+			next, ok, err := p.delimited(i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				run := runLength(p.text, i, r)
+				p.buf.WriteString(strings.Repeat(string(r), run))
+				i += run
+				continue
+			}
+			i = next
+
+		case (hasPrefix(p.text[i:], "http") || hasPrefix(p.text[i:], "www.")) && !p.inLink && (i == 0 || !isWordRune(p.text[i-1])):
+			match := bareURLPattern.FindString(p.urlWindow(i))
+			if match == "" {
+				p.buf.WriteRune(r)
+				i++
+				continue
+			}
+			match = trimURL(match)
+			target := match
+			if strings.HasPrefix(target, "www.") {
+				target = "https://" + target
+			}
+			link, err := newLink(target, []Node{{Type: NodeText, Text: match}})
+			if err != nil {
+				return err
+			}
+			p.flush()
+			p.nodes = append(p.nodes, link)
+			i += utf8.RuneCountInString(match)
+
+		default:
+			p.buf.WriteRune(r)
+			i++
+		}
+	}
+	p.flush()
+	return nil
+}
+
+// flush turns the pending characters into a text node.
+func (p *inlineParser) flush() {
+	if p.buf.Len() == 0 {
+		return
+	}
+	p.nodes = append(p.nodes, Node{Type: NodeText, Text: p.buf.String()})
+	p.buf.Reset()
+}
+
+// tagWindow returns the text from the < at i up to the next >, all an autolink or a tag can span.
+func (p *inlineParser) tagWindow(i int) string {
+	end := nextPosition(p.idx.tagEnds, i)
+	if end < 0 {
+		// enough to tell the start of a comment
+		return string(p.text[i:min(i+4, len(p.text))])
+	}
+	return string(p.text[i : end+1])
+}
+
+// urlWindow returns the text from i up to the next space, < or >, all a bare URL can span.
+func (p *inlineParser) urlWindow(i int) string {
+	end := nextPosition(p.idx.urlEnds, i)
+	if end < 0 {
+		end = len(p.text)
+	}
+	return string(p.text[i:end])
+}
+
+// isLinkSyntax reports whether the bracket at i starts [text](url).
+func (p *inlineParser) isLinkSyntax(i int) bool {
+	_, _, ok := p.linkBounds(i)
+	return ok
+}
+
+// linkBounds finds the closing bracket and parenthesis of [text](url) starting at i.
+// The url is a single word, without any whitespace.
+func (p *inlineParser) linkBounds(i int) (int, int, bool) {
+	closing, ok := p.idx.brackets[i]
+	if !ok || closing+1 >= len(p.text) || p.text[closing+1] != '(' {
+		return 0, 0, false
+	}
+	end, ok := p.idx.parens[closing+1]
+	if !ok || end == closing+2 {
+		return 0, 0, false
+	}
+	if space := nextPosition(p.idx.spaces, closing+2); space >= 0 && space < end {
+		return 0, 0, false
+	}
+	return closing, end, true
+}
+
+// link parses [text](url) at i. It reports false when the brackets are not a link.
+func (p *inlineParser) link(i int) (int, bool, error) {
+	closing, end, ok := p.linkBounds(i)
+	if !ok {
+		return 0, false, nil
+	}
+	target := string(p.text[closing+2 : end])
+
+	children, err := parseInline(string(p.text[i+1:closing]), p.depth+1, true)
+	if err != nil {
+		return 0, false, err
+	}
+	link, err := newLink(target, children)
+	if err != nil {
+		return 0, false, err
+	}
+	p.flush()
+	p.nodes = append(p.nodes, link)
+	return end + 1, true, nil
+}
+
+// delimited parses *emphasis*, _emphasis_, **strong**, __strong__ and ~~strikethrough~~ at i.
+// It reports false when the delimiter run is not closed.
+func (p *inlineParser) delimited(i int) (int, bool, error) {
+	r := p.text[i]
+	run := runLength(p.text, i, r)
+	var nodeType string
+	switch {
+	case r == '~' && run == 2:
+		nodeType = NodeStrikethrough
+	case r != '~' && run == 1:
+		nodeType = NodeEmphasis
+	case r != '~' && run == 2:
+		nodeType = NodeStrong
+	default:
+		return 0, false, nil
+	}
+
+	start := i + run
+	// an opener is followed by text, and an underscore does not open inside a word such as snake_case
+	if start >= len(p.text) || unicode.IsSpace(p.text[start]) {
+		return 0, false, nil
+	}
+	if r == '_' && i > 0 && isWordRune(p.text[i-1]) {
+		return 0, false, nil
+	}
+	end := p.idx.closer(start, r, run)
+	if end < 0 {
+		return 0, false, nil
+	}
+
+	children, err := parseInline(string(p.text[start:end]), p.depth+1, p.inLink)
+	if err != nil {
+		return 0, false, err
+	}
+	p.flush()
+	p.nodes = append(p.nodes, Node{Type: nodeType, Children: children})
+	return end + run, true, nil
+}
+
+// newLink builds a link node, rejecting targets other than absolute http, https and mailto URLs.
+func newLink(target string, children []Node) (Node, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || !allowedSchemes[strings.ToLower(parsed.Scheme)] {
+		return Node{}, fmt.Errorf("%w: links must be http, https or mailto URLs", ErrDisallowed)
+	}
+	if parsed.Scheme != "mailto" && parsed.Host == "" {
+		return Node{}, fmt.Errorf("%w: links must be http, https or mailto URLs", ErrDisallowed)
+	}
+	return Node{Type: NodeLink, URL: parsed.String(), Children: children}, nil
+}
+
+func hasPrefix(text []rune, prefix string) bool {
+	if len(text) < len(prefix) {
+		return false
+	}
+	return string(text[:len(prefix)]) == prefix
+}
+
+func runLength(text []rune, start int, r rune) int {
+	n := 0
+	for start+n < len(text) && text[start+n] == r {
+		n++
+	}
+	return n
+}
+
+// trimCode drops one space on each side of a code span, so “ `x` “ can hold backticks.
+func trimCode(code string) string {
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+		return code[1 : len(code)-1]
+	}
+	return code
+}
+
+// trimURL leaves trailing punctuation out of a bare URL, along with closing parentheses it did not open.
+func trimURL(match string) string {
+	for len(match) > 0 {
+		last := match[len(match)-1]
+		switch {
+		case strings.IndexByte(".,:;!?'\"*_~", last) >= 0:
+			match = match[:len(match)-1]
+		case last == ')' && strings.Count(match, "(") < strings.Count(match, ")"):
+			match = match[:len(match)-1]
+		default:
+			return match
+		}
+	}
+	return match
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isASCIIPunct(r rune) bool {
+	return r < utf8.RuneSelf && unicode.IsPunct(r) || strings.ContainsRune("$+<=>^`|~", r)
+}
@@ -7,17 +7,36 @@ import (
 	"github.com/yaninyzwitty/messaging-service/middleware"
 )
 
-func NewRouter(controller *controller.MessageController) http.Handler {
+func NewRouter(
+	controller *controller.MessageController,
+	reactionController *controller.ReactionController,
+	receiptController *controller.ReadReceiptController,
+	conversationController *controller.ConversationController,
+	inboxController *controller.InboxController,
+	attachmentController *controller.AttachmentController,
+	uploadController *controller.UploadController,
+	realtimeController *controller.RealtimeController,
+	eventsController *controller.EventsController,
+	syncController *controller.SyncController,
+	webhookController *controller.WebhookController,
+	incomingWebhookController *controller.IncomingWebhookController,
+	changesController *controller.ChangesController,
+	deviceController *controller.DeviceController,
+	emailDigestController *controller.EmailDigestController,
+	notificationController *controller.NotificationController,
+) http.Handler {
 	router := http.NewServeMux()
 
 	// define middlewares
 	loggingMiddleware := middleware.LoggingMiddleware
 	corsMiddleware := middleware.CorsMiddleware
+	authMiddleware := middleware.AuthMiddleware
 
 	// create a middleware chain
 	middlewareChain := middleware.ChainMiddlewares(
 		loggingMiddleware,
 		corsMiddleware,
+		authMiddleware,
 	)
 
 	// Define routes and wrap them with the middleware stack
@@ -39,6 +58,135 @@ func NewRouter(controller *controller.MessageController) http.Handler {
 	router.HandleFunc("DELETE /messages/{id}", func(w http.ResponseWriter, r *http.Request) {
 		middlewareChain(http.HandlerFunc(controller.DeleteMessage)).ServeHTTP(w, r)
 	})
+	router.HandleFunc("PUT /messages/{id}/reactions/{emoji}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(reactionController.AddReaction)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("DELETE /messages/{id}/reactions/{emoji}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(reactionController.RemoveReaction)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /messages/{id}/readers", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(receiptController.GetReaders)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /conversations/{id}/messages", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(controller.GetConversationMessages)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("PUT /conversations/{id}/delivered", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(receiptController.MarkDelivered)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("PUT /conversations/{id}/read", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(receiptController.MarkRead)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /conversations/{id}/participants", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(conversationController.GetParticipants)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("PUT /conversations/{id}/participants/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(conversationController.AddParticipant)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /users/{id}/inbox", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(inboxController.GetInbox)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /attachments", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(attachmentController.UploadAttachments)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /attachments/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(attachmentController.GetAttachment)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /attachments/{id}/content", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(attachmentController.DownloadAttachment)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /attachments/{id}/thumbnail", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(attachmentController.DownloadThumbnail)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /messages/{id}/attachments", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(attachmentController.UploadMessageAttachments)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("OPTIONS /uploads", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(uploadController.Options)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /uploads", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(uploadController.CreateUpload)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("HEAD /uploads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(uploadController.GetUploadOffset)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("PATCH /uploads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(uploadController.PatchUpload)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("DELETE /uploads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(uploadController.TerminateUpload)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /conversations/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(eventsController.StreamConversationEvents)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /sync", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(syncController.Sync)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /sync/changes", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(changesController.GetChanges)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /webhooks", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(webhookController.CreateWebhook)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /webhooks", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(webhookController.GetWebhooks)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(webhookController.GetWebhook)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("PUT /webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(webhookController.UpdateWebhook)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("DELETE /webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(webhookController.DeleteWebhook)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /webhooks/{id}/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(webhookController.GetDeliveries)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /conversations/{id}/incoming-webhooks", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(incomingWebhookController.CreateIncomingWebhook)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /conversations/{id}/incoming-webhooks", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(incomingWebhookController.GetIncomingWebhooks)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("DELETE /conversations/{id}/incoming-webhooks/{webhook_id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(incomingWebhookController.RevokeIncomingWebhook)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /hooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(incomingWebhookController.PostMessage)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /users/{id}/presence", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(realtimeController.GetPresence)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /devices", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(deviceController.RegisterDevice)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /devices", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(deviceController.GetDevices)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("DELETE /devices/{token}", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(deviceController.UnregisterDevice)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /settings/email-digest", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(emailDigestController.GetDigestSettings)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("PUT /settings/email-digest", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(emailDigestController.UpdateDigestSettings)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /settings/email-digest/confirm", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(emailDigestController.ConfirmDigestEmail)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /users/{id}/notifications", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(notificationController.GetNotifications)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("POST /users/{id}/notifications/read", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(notificationController.MarkNotificationsRead)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /users/{id}/mentions", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(controller.GetMentions)).ServeHTTP(w, r)
+	})
+	router.HandleFunc("GET /ws", func(w http.ResponseWriter, r *http.Request) {
+		middlewareChain(http.HandlerFunc(realtimeController.ServeWebSocket)).ServeHTTP(w, r)
+	})
 	return router
 
 }
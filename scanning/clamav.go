@@ -0,0 +1,106 @@
+package scanning
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of the INSTREAM chunks, well below clamd's default StreamMaxLength.
+const clamdChunkSize = 64 << 10
+
+// ClamAVScanner streams files to a clamd daemon using the INSTREAM command.
+type ClamAVScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamAVScanner talks to clamd at address, network is "tcp" or "unix".
+func NewClamAVScanner(network, address string, timeout time.Duration) *ClamAVScanner {
+	return &ClamAVScanner{network: network, address: address, timeout: timeout}
+}
+
+func (s *ClamAVScanner) Scan(ctx context.Context, file File) (Result, error) {
+	content, err := file.Open()
+	if err != nil {
+		return Result{}, err
+	}
+	defer content.Close()
+
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	// the z prefix makes clamd use null terminated commands and replies
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, err
+	}
+	if err := writeChunks(conn, content); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return Result{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// writeChunks frames the content as INSTREAM chunks, each prefixed with its length, followed by an empty chunk.
+func writeChunks(w io.Writer, r io.Reader) error {
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, werr := w.Write(size); werr != nil {
+				return werr
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamdReply understands "stream: OK", "stream: <signature> FOUND" and "<message> ERROR".
+func parseClamdReply(reply string) (Result, error) {
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return Result{Clean: true}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return Result{Clean: false, Reason: "malware detected: " + signature}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		// oversized streams are rejected by policy, they can never be scanned
+		if strings.Contains(reply, "size limit exceeded") {
+			return Result{Clean: false, Reason: "file exceeds the scanner size limit"}, nil
+		}
+		return Result{}, fmt.Errorf("clamd error: %s", reply)
+	default:
+		return Result{}, fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+}
@@ -0,0 +1,189 @@
+package scanning
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd accepts INSTREAM sessions on a local port, keeps what was streamed and answers with reply.
+type fakeClamd struct {
+	listener net.Listener
+	reply    string
+	received chan []byte
+}
+
+func newFakeClamd(t *testing.T, reply string) *fakeClamd {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	clamd := &fakeClamd{listener: listener, reply: reply, received: make(chan []byte, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go clamd.serve(t)
+	return clamd
+}
+
+func (c *fakeClamd) serve(t *testing.T) {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+		reader := bufio.NewReader(conn)
+		command, err := reader.ReadString(0)
+		if err != nil || command != "zINSTREAM\x00" {
+			t.Errorf("command = %q (%v), want zINSTREAM", command, err)
+			conn.Close()
+			continue
+		}
+		var content bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+				t.Errorf("reading chunk size: %v", err)
+				break
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&content, reader, int64(size)); err != nil {
+				t.Errorf("reading chunk: %v", err)
+				break
+			}
+		}
+		c.received <- content.Bytes()
+		conn.Write([]byte(c.reply + "\x00"))
+		conn.Close()
+	}
+}
+
+func fileOf(name string, content []byte) File {
+	return File{
+		Name: name,
+		Size: int64(len(content)),
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(content)), nil },
+	}
+}
+
+func TestWriteChunks(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		size   int
+		chunks []int
+	}{
+		{"empty", 0, nil},
+		{"one chunk", 10, []int{10}},
+		{"exactly one chunk", clamdChunkSize, []int{clamdChunkSize}},
+		{"several chunks", 2*clamdChunkSize + 10, []int{clamdChunkSize, clamdChunkSize, 10}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte("x"), tc.size)
+			var framed bytes.Buffer
+			if err := writeChunks(&framed, bytes.NewReader(content)); err != nil {
+				t.Fatalf("writeChunks: %v", err)
+			}
+
+			var chunks []int
+			var streamed []byte
+			for {
+				var size uint32
+				if err := binary.Read(&framed, binary.BigEndian, &size); err != nil {
+					t.Fatalf("stream ended without the terminating empty chunk: %v", err)
+				}
+				if size == 0 {
+					break
+				}
+				chunks = append(chunks, int(size))
+				streamed = append(streamed, framed.Next(int(size))...)
+			}
+			if framed.Len() != 0 {
+				t.Errorf("%d bytes written after the terminating chunk", framed.Len())
+			}
+			if len(chunks) != len(tc.chunks) {
+				t.Fatalf("chunks = %v, want %v", chunks, tc.chunks)
+			}
+			for i := range chunks {
+				if chunks[i] != tc.chunks[i] {
+					t.Fatalf("chunks = %v, want %v", chunks, tc.chunks)
+				}
+			}
+			if !bytes.Equal(streamed, content) {
+				t.Error("streamed content differs from the file")
+			}
+		})
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	for _, tc := range []struct {
+		reply   string
+		clean   bool
+		reason  string
+		wantErr bool
+	}{
+		{reply: "stream: OK", clean: true},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", reason: "malware detected: Win.Test.EICAR_HDB-1"},
+		// oversized streams are a verdict, retrying them cannot help
+		{reply: "INSTREAM size limit exceeded. ERROR", reason: "file exceeds the scanner size limit"},
+		{reply: "Can't allocate memory ERROR", wantErr: true},
+		{reply: "UNKNOWN COMMAND", wantErr: true},
+		{reply: "", wantErr: true},
+	} {
+		result, err := parseClamdReply(tc.reply)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseClamdReply(%q) error = %v, want error %v", tc.reply, err, tc.wantErr)
+			continue
+		}
+		if result.Clean != tc.clean || result.Reason != tc.reason {
+			t.Errorf("parseClamdReply(%q) = %+v, want clean %v reason %q", tc.reply, result, tc.clean, tc.reason)
+		}
+	}
+}
+
+func TestClamAVScannerScan(t *testing.T) {
+	content := []byte(strings.Repeat("attachment ", clamdChunkSize/5))
+	for _, tc := range []struct {
+		name    string
+		reply   string
+		clean   bool
+		wantErr bool
+	}{
+		{name: "clean", reply: "stream: OK", clean: true},
+		{name: "infected", reply: "stream: Eicar-Signature FOUND"},
+		{name: "clamd error", reply: "Can't allocate memory ERROR", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clamd := newFakeClamd(t, tc.reply)
+			scanner := NewClamAVScanner("tcp", clamd.listener.Addr().String(), time.Second)
+			result, err := scanner.Scan(context.Background(), fileOf("notes.txt", content))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Scan error = %v, want error %v", err, tc.wantErr)
+			}
+			if result.Clean != tc.clean {
+				t.Errorf("Scan = %+v, want clean %v", result, tc.clean)
+			}
+			if received := <-clamd.received; !bytes.Equal(received, content) {
+				t.Errorf("clamd received %d bytes, want the %d of the file", len(received), len(content))
+			}
+		})
+	}
+
+	// an unreachable daemon is an error, not a verdict
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	if _, err := NewClamAVScanner("tcp", address, time.Second).Scan(context.Background(), fileOf("notes.txt", content)); err == nil {
+		t.Error("Scan against a closed port succeeded")
+	}
+}
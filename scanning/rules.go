@@ -0,0 +1,96 @@
+package scanning
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// executableSignatures are magic bytes of files we never pass around, whatever their name says.
+var executableSignatures = []struct {
+	magic []byte
+	name  string
+}{
+	{[]byte("MZ"), "windows executable"},
+	{[]byte("\x7fELF"), "ELF executable"},
+	{[]byte("\xfe\xed\xfa\xce"), "Mach-O executable"},
+	{[]byte("\xfe\xed\xfa\xcf"), "Mach-O executable"},
+	{[]byte("\xce\xfa\xed\xfe"), "Mach-O executable"},
+	{[]byte("\xcf\xfa\xed\xfe"), "Mach-O executable"},
+	{[]byte("\xca\xfe\xba\xbe"), "Mach-O universal binary or java class"},
+	{[]byte("#!"), "script"},
+}
+
+// expectedTypes maps extensions to the media type their content has to sniff as.
+var expectedTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".pdf":  "application/pdf",
+	".zip":  "application/zip",
+}
+
+// RulesScanner rejects files based on static rules: size, extension and magic bytes.
+type RulesScanner struct {
+	maxSize           int64
+	blockedExtensions map[string]bool
+}
+
+// NewRulesScanner builds a scanner rejecting files larger than maxSize or ending in one of blockedExtensions.
+func NewRulesScanner(maxSize int64, blockedExtensions []string) *RulesScanner {
+	blocked := make(map[string]bool, len(blockedExtensions))
+	for _, extension := range blockedExtensions {
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		if extension == "" {
+			continue
+		}
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		blocked[extension] = true
+	}
+	return &RulesScanner{maxSize: maxSize, blockedExtensions: blocked}
+}
+
+func (s *RulesScanner) Scan(ctx context.Context, file File) (Result, error) {
+	if s.maxSize > 0 && file.Size > s.maxSize {
+		return Result{Reason: fmt.Sprintf("file is larger than %d bytes", s.maxSize)}, nil
+	}
+
+	extension := strings.ToLower(path.Ext(file.Name))
+	if s.blockedExtensions[extension] {
+		return Result{Reason: "file extension " + extension + " is not allowed"}, nil
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return Result{}, err
+	}
+	defer content.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return Result{}, err
+	}
+	head = head[:n]
+
+	for _, signature := range executableSignatures {
+		if bytes.HasPrefix(head, signature.magic) {
+			return Result{Reason: "content is a " + signature.name}, nil
+		}
+	}
+
+	// a file claiming to be e.g. a picture has to look like one
+	if expected, ok := expectedTypes[extension]; ok {
+		if sniffed := http.DetectContentType(head); !strings.HasPrefix(sniffed, expected) {
+			return Result{Reason: fmt.Sprintf("content does not match the %s extension", extension)}, nil
+		}
+	}
+	return Result{Clean: true}, nil
+}
@@ -0,0 +1,61 @@
+package scanning
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRulesScanner(t *testing.T) {
+	scanner := NewRulesScanner(1024, []string{"exe", ".BAT", " js ", ""})
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	for _, tc := range []struct {
+		name   string
+		file   File
+		clean  bool
+		reason string
+	}{
+		{name: "plain text", file: fileOf("notes.txt", []byte("hello")), clean: true},
+		{name: "matching image", file: fileOf("photo.png", png), clean: true},
+		{name: "extension in other case", file: fileOf("photo.PNG", png), clean: true},
+		{name: "too large", file: fileOf("big.txt", []byte(strings.Repeat("x", 1025))), reason: "file is larger than 1024 bytes"},
+		{name: "blocked extension", file: fileOf("setup.exe", []byte("hello")), reason: "file extension .exe is not allowed"},
+		{name: "blocked extension in other case", file: fileOf("run.Bat", []byte("hello")), reason: "file extension .bat is not allowed"},
+		{name: "blocked extension with spaces", file: fileOf("app.js", []byte("hello")), reason: "file extension .js is not allowed"},
+		{name: "renamed windows executable", file: fileOf("report.txt", []byte("MZ\x90\x00")), reason: "content is a windows executable"},
+		{name: "renamed ELF executable", file: fileOf("song.mp3", []byte("\x7fELF\x02\x01")), reason: "content is a ELF executable"},
+		{name: "script", file: fileOf("readme", []byte("#!/bin/sh\nrm -rf /")), reason: "content is a script"},
+		{name: "image that is not one", file: fileOf("photo.jpg", []byte("<html>hi</html>")), reason: "content does not match the .jpg extension"},
+		{name: "empty file", file: fileOf("empty.txt", nil), clean: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := scanner.Scan(context.Background(), tc.file)
+			if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if result.Clean != tc.clean || result.Reason != tc.reason {
+				t.Errorf("Scan = %+v, want clean %v reason %q", result, tc.clean, tc.reason)
+			}
+		})
+	}
+}
+
+func TestChainStopsAtTheFirstRejection(t *testing.T) {
+	rejecting := NewRulesScanner(0, []string{"exe"})
+	called := false
+	chain := Chain{rejecting, scannerFunc(func(ctx context.Context, file File) (Result, error) {
+		called = true
+		return Result{Clean: true}, nil
+	})}
+	result, err := chain.Scan(context.Background(), fileOf("setup.exe", []byte("hello")))
+	if err != nil || result.Clean {
+		t.Errorf("Scan = %+v, %v, want the file rejected", result, err)
+	}
+	if called {
+		t.Error("the scanner after the rejecting one was called")
+	}
+}
+
+type scannerFunc func(ctx context.Context, file File) (Result, error)
+
+func (f scannerFunc) Scan(ctx context.Context, file File) (Result, error) { return f(ctx, file) }
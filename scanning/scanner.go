@@ -0,0 +1,38 @@
+package scanning
+
+import (
+	"context"
+	"io"
+)
+
+// File is an uploaded file handed to a scanner. Open may be called more than once.
+type File struct {
+	Name string
+	Size int64
+	Open func() (io.ReadCloser, error)
+}
+
+// Result is the verdict of a scan, Reason explains why a file was not clean.
+type Result struct {
+	Clean  bool
+	Reason string
+}
+
+// Scanner inspects uploaded files before they are shared.
+// An error means the file could not be scanned, which is different from a file that failed the scan.
+type Scanner interface {
+	Scan(ctx context.Context, file File) (Result, error)
+}
+
+// Chain runs scanners one after the other and stops at the first one that rejects the file.
+type Chain []Scanner
+
+func (c Chain) Scan(ctx context.Context, file File) (Result, error) {
+	for _, scanner := range c {
+		result, err := scanner.Scan(ctx, file)
+		if err != nil || !result.Clean {
+			return result, err
+		}
+	}
+	return Result{Clean: true}, nil
+}
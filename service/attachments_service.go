@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+	"github.com/yaninyzwitty/messaging-service/storage"
+)
+
+var (
+	ErrNoThumbnail            = errors.New("attachment has no thumbnail")
+	ErrAttachmentPending      = errors.New("attachment is still being scanned")
+	ErrAttachmentQuarantined  = errors.New("attachment has been quarantined")
+	ErrNotMessageSender       = errors.New("only the sender can attach files to a message")
+	ErrAttachmentUnavailable  = errors.New("attachment does not exist, belongs to another user or is already linked")
+	ErrAttachmentForbidden    = errors.New("attachment is only available to its uploader and the participants of its conversation")
+	ErrAttachmentSizeMismatch = errors.New("attachment size does not match the uploaded content")
+)
+
+// Upload describes a file received from a client.
+type Upload struct {
+	ID          gocql.UUID // zero for a new id, set when retries must land on the same attachment
+	Filename    string
+	ContentType string
+	Size        int64 // -1 when unknown
+	Content     io.Reader
+}
+
+// AttachmentProcessor picks up attachments once their content is stored.
+type AttachmentProcessor interface {
+	Enqueue(attachment models.Attachment) error
+}
+
+type AttachmentsService interface {
+	UploadAttachment(ctx context.Context, uploaderId gocql.UUID, upload Upload) (models.Attachment, error)
+	UploadMessageAttachment(ctx context.Context, messageId gocql.UUID, uploaderId gocql.UUID, upload Upload) (models.Attachment, error)
+	GetAttachment(ctx context.Context, viewerId gocql.UUID, id gocql.UUID) (models.Attachment, error)
+	OpenAttachment(ctx context.Context, viewerId gocql.UUID, id gocql.UUID) (models.Attachment, io.ReadCloser, error)
+	OpenThumbnail(ctx context.Context, viewerId gocql.UUID, id gocql.UUID) (io.ReadCloser, error)
+	GetMessageAttachments(ctx context.Context, messageId gocql.UUID) ([]models.Attachment, error)
+}
+
+type attachmentService struct {
+	repo            repository.AttachmentsRepository
+	messageRepo     repository.MessagesRepository
+	participantRepo repository.ParticipantsRepository
+	store           storage.BlobStore
+	processor       AttachmentProcessor
+}
+
+func NewAttachmentsService(repo repository.AttachmentsRepository, messageRepo repository.MessagesRepository, participantRepo repository.ParticipantsRepository, store storage.BlobStore, processor AttachmentProcessor) AttachmentsService {
+	return &attachmentService{repo: repo, messageRepo: messageRepo, participantRepo: participantRepo, store: store, processor: processor}
+}
+
+// UploadAttachment stores an attachment that is not linked to any message yet,
+// it can be linked later by passing its id when creating a message.
+func (s *attachmentService) UploadAttachment(ctx context.Context, uploaderId gocql.UUID, upload Upload) (models.Attachment, error) {
+	id := upload.ID
+	if id == (gocql.UUID{}) {
+		id = gocql.TimeUUID()
+	}
+	attachment := models.Attachment{
+		ID:          id,
+		UploaderID:  uploaderId,
+		Filename:    sanitizeFilename(upload.Filename),
+		ContentType: upload.ContentType,
+		StorageKey:  "attachments/" + id.String(),
+		CreatedAt:   time.Now(),
+		Status:      models.AttachmentStatusPending,
+	}
+	if attachment.ContentType == "" {
+		attachment.ContentType = "application/octet-stream"
+	}
+
+	// hash and count while streaming so the content is only read once
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(upload.Content, hash)}
+	if err := s.store.Put(ctx, attachment.StorageKey, counter, upload.Size, attachment.ContentType); err != nil {
+		return models.Attachment{}, err
+	}
+	if upload.Size >= 0 && counter.n != upload.Size {
+		s.store.Delete(ctx, attachment.StorageKey)
+		return models.Attachment{}, ErrAttachmentSizeMismatch
+	}
+	attachment.Size = counter.n
+	attachment.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	attachment, err := s.repo.CreateAttachment(ctx, attachment)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	// a full queue is not fatal, the processor sweeps up attachments left pending
+	if err := s.processor.Enqueue(attachment); err != nil {
+		slog.Warn("Failed to schedule attachment processing, leaving it to the sweep", "attachment_id", attachment.ID, "error", err)
+	}
+	return attachment, nil
+}
+
+// UploadMessageAttachment stores an attachment and links it to an existing message of the uploader.
+func (s *attachmentService) UploadMessageAttachment(ctx context.Context, messageId gocql.UUID, uploaderId gocql.UUID, upload Upload) (models.Attachment, error) {
+	message, err := s.messageRepo.GetMessage(ctx, messageId)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	if message.SenderId != uploaderId {
+		return models.Attachment{}, ErrNotMessageSender
+	}
+
+	attachment, err := s.UploadAttachment(ctx, uploaderId, upload)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	if err := s.repo.LinkAttachment(ctx, attachment.ID, messageId); err != nil {
+		return models.Attachment{}, err
+	}
+	attachment.MessageID = messageId
+	return attachment, nil
+}
+
+func (s *attachmentService) GetAttachment(ctx context.Context, viewerId gocql.UUID, id gocql.UUID) (models.Attachment, error) {
+	attachment, err := s.visibleAttachment(ctx, viewerId, id)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	return presentAttachment(attachment), nil
+}
+
+// OpenAttachment returns the attachment metadata along with its content, callers must close the content.
+func (s *attachmentService) OpenAttachment(ctx context.Context, viewerId gocql.UUID, id gocql.UUID) (models.Attachment, io.ReadCloser, error) {
+	attachment, err := s.visibleAttachment(ctx, viewerId, id)
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+	// content is only shared once the scanners have cleared it
+	switch attachment.Status {
+	case models.AttachmentStatusPending:
+		return models.Attachment{}, nil, ErrAttachmentPending
+	case models.AttachmentStatusQuarantined:
+		return models.Attachment{}, nil, ErrAttachmentQuarantined
+	}
+	content, err := s.store.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+	return attachment, content, nil
+}
+
+// OpenThumbnail returns the JPEG thumbnail of an image attachment, callers must close it.
+func (s *attachmentService) OpenThumbnail(ctx context.Context, viewerId gocql.UUID, id gocql.UUID) (io.ReadCloser, error) {
+	attachment, err := s.visibleAttachment(ctx, viewerId, id)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.ThumbnailKey == "" {
+		return nil, ErrNoThumbnail
+	}
+	return s.store.Get(ctx, attachment.ThumbnailKey)
+}
+
+// visibleAttachment loads an attachment the viewer may see: one they uploaded, or one linked to a message
+// of a conversation they take part in.
+func (s *attachmentService) visibleAttachment(ctx context.Context, viewerId gocql.UUID, id gocql.UUID) (models.Attachment, error) {
+	attachment, err := s.repo.GetAttachment(ctx, id)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	if attachment.UploaderID == viewerId {
+		return attachment, nil
+	}
+	if attachment.MessageID == (gocql.UUID{}) {
+		return models.Attachment{}, ErrAttachmentForbidden
+	}
+	message, err := s.messageRepo.GetMessage(ctx, attachment.MessageID)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return models.Attachment{}, ErrAttachmentForbidden
+	}
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	member, err := s.participantRepo.IsParticipant(ctx, message.ConversationID, viewerId)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	if !member {
+		return models.Attachment{}, ErrAttachmentForbidden
+	}
+	return attachment, nil
+}
+
+// GetMessageAttachments lists the attachments of a message in upload order, leaving out quarantined ones.
+func (s *attachmentService) GetMessageAttachments(ctx context.Context, messageId gocql.UUID) ([]models.Attachment, error) {
+	return loadAttachments(ctx, s.repo, messageId)
+}
+
+func loadAttachments(ctx context.Context, repo repository.AttachmentsRepository, messageId gocql.UUID) ([]models.Attachment, error) {
+	stored, err := repo.GetAttachmentsByMessage(ctx, messageId)
+	if err != nil {
+		return nil, err
+	}
+	attachments := make([]models.Attachment, 0, len(stored))
+	for _, attachment := range stored {
+		if attachment.Status != models.AttachmentStatusQuarantined {
+			attachments = append(attachments, attachment)
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].CreatedAt.Before(attachments[j].CreatedAt)
+	})
+	for i := range attachments {
+		attachments[i] = presentAttachment(attachments[i])
+	}
+	return attachments, nil
+}
+
+// presentAttachment fills in the fields clients use to fetch derived content.
+func presentAttachment(attachment models.Attachment) models.Attachment {
+	if attachment.ThumbnailKey != "" {
+		attachment.ThumbnailURL = "/attachments/" + attachment.ID.String() + "/thumbnail"
+	}
+	return attachment
+}
+
+// sanitizeFilename keeps the base name of the client supplied file name, dropping any directories.
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	return name
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
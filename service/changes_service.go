@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+// compactPageSize bounds the change keys read at once while compacting a log.
+const compactPageSize = 500
+
+var ErrInvalidChangeCursor = errors.New("invalid change cursor")
+
+// ChangesService keeps a change log per user so offline clients can catch up on everything that
+// changed in their conversations, deletions included.
+type ChangesService interface {
+	// HandleEvent records the change for the participants of the conversation. It is fed from the outbox,
+	// a retried event is recorded again and the duplicate dropped by the next compaction.
+	HandleEvent(ctx context.Context, event events.Event) error
+	GetChanges(ctx context.Context, userId gocql.UUID, since string, limit int) (models.ChangeSet, error)
+	// Compact drops the entries superseded by a newer change of the same entity, for the logs written to since the last run.
+	Compact(ctx context.Context) error
+}
+
+type changesService struct {
+	repo            repository.ChangesRepository
+	participantRepo repository.ParticipantsRepository
+
+	// users whose logs grew since the last compaction, kept in memory: after a restart a log
+	// is only compacted again once it changes
+	mu    sync.Mutex
+	dirty map[gocql.UUID]struct{}
+}
+
+func NewChangesService(repo repository.ChangesRepository, participantRepo repository.ParticipantsRepository) ChangesService {
+	return &changesService{repo: repo, participantRepo: participantRepo, dirty: make(map[gocql.UUID]struct{})}
+}
+
+func (s *changesService) HandleEvent(ctx context.Context, event events.Event) error {
+	change, ok, err := newChange(event)
+	if err != nil || !ok {
+		return err
+	}
+
+	participants, err := s.participantRepo.GetParticipants(ctx, event.Conversation())
+	if err != nil {
+		return err
+	}
+	changes := make([]models.Change, 0, len(participants))
+	for _, participant := range participants {
+		change.UserID = participant.UserID
+		changes = append(changes, change)
+	}
+	if err := s.repo.AppendChanges(ctx, changes); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, participant := range participants {
+		s.dirty[participant.UserID] = struct{}{}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// GetChanges returns the changes after the since cursor, oldest first. An empty cursor starts at the
+// beginning of the log; each entity appears with its latest state or a tombstone.
+func (s *changesService) GetChanges(ctx context.Context, userId gocql.UUID, since string, limit int) (models.ChangeSet, error) {
+	var after gocql.UUID
+	if since != "" {
+		cursor, err := gocql.ParseUUID(since)
+		if err != nil || cursor.Version() != 1 {
+			return models.ChangeSet{}, ErrInvalidChangeCursor
+		}
+		after = cursor
+	}
+
+	changeSet := models.ChangeSet{Cursor: since}
+	// entries this old may have expired, the client cannot tell what it missed
+	if after != (gocql.UUID{}) && time.Since(after.Time()) > models.ChangeRetention {
+		changeSet.Reset = true
+		after = gocql.UUID{}
+	}
+
+	changes, err := s.repo.GetChanges(ctx, userId, after, limit)
+	if err != nil {
+		return models.ChangeSet{}, err
+	}
+	for i := range changes {
+		if len(changes[i].Data) > 0 {
+			changes[i].Entity = changes[i].Data
+		}
+	}
+	if changes == nil {
+		changes = []models.Change{}
+	}
+	changeSet.Changes = changes
+	changeSet.HasMore = len(changes) == limit
+	if len(changes) > 0 {
+		changeSet.Cursor = changes[len(changes)-1].ID.String()
+	}
+	return changeSet, nil
+}
+
+func (s *changesService) Compact(ctx context.Context) error {
+	s.mu.Lock()
+	dirty := s.dirty
+	s.dirty = make(map[gocql.UUID]struct{})
+	s.mu.Unlock()
+
+	var errs []error
+	for userId := range dirty {
+		if err := s.compactUser(ctx, userId); err != nil {
+			errs = append(errs, err)
+			// try again on the next run
+			s.mu.Lock()
+			s.dirty[userId] = struct{}{}
+			s.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// compactUser keeps the newest change of every entity. A client with any cursor still sees the latest
+// state of everything that changed after it, since the newest change is never older than the ones dropped.
+// The log is read a page at a time, superseded changes are deleted as they are found.
+func (s *changesService) compactUser(ctx context.Context, userId gocql.UUID) error {
+	latest := make(map[string]gocql.UUID)
+	var after gocql.UUID
+	for {
+		keys, err := s.repo.GetChangeKeys(ctx, userId, after, compactPageSize)
+		if err != nil {
+			return err
+		}
+
+		var superseded []gocql.UUID
+		// oldest first, so every earlier change of a key is superseded by the next one
+		for _, change := range keys {
+			if previous, ok := latest[change.ChangeKey()]; ok {
+				superseded = append(superseded, previous)
+			}
+			latest[change.ChangeKey()] = change.ID
+		}
+		if len(superseded) > 0 {
+			if err := s.repo.DeleteChanges(ctx, userId, superseded); err != nil {
+				return err
+			}
+		}
+		if len(keys) < compactPageSize {
+			return nil
+		}
+		after = keys[len(keys)-1].ID
+	}
+}
+
+// newChange describes the entity a domain event changed, false for events not synced.
+func newChange(event events.Event) (models.Change, bool, error) {
+	change := models.Change{
+		ID:             gocql.TimeUUID(),
+		ConversationID: event.Conversation(),
+		Op:             models.ChangeOpUpsert,
+		ChangedAt:      event.OccurredAt(),
+	}
+	var entity interface{}
+	switch e := event.(type) {
+	case events.MessageCreated:
+		change.EntityType, change.EntityID, entity = models.ChangeEntityMessage, e.Message.ID.String(), e.Message
+	case events.MessageUpdated:
+		change.EntityType, change.EntityID, entity = models.ChangeEntityMessage, e.Message.ID.String(), e.Message
+	case events.MessageDeleted:
+		change.EntityType, change.EntityID = models.ChangeEntityMessage, e.MessageID.String()
+		change.Op = models.ChangeOpDelete
+	case events.ReactionAdded:
+		change.EntityType, change.EntityID, entity = models.ChangeEntityReaction, reactionEntityId(e.Reaction), e.Reaction
+	case events.ReactionRemoved:
+		change.EntityType, change.EntityID = models.ChangeEntityReaction, reactionEntityId(e.Reaction)
+		change.Op = models.ChangeOpDelete
+	case events.ReadReceiptAdvanced:
+		change.EntityType, change.EntityID, entity = models.ChangeEntityReadMarker, e.Receipt.ConversationID.String()+"/"+e.Receipt.UserID.String(), e.Receipt
+	default:
+		return models.Change{}, false, nil
+	}
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+
+	if entity != nil {
+		data, err := json.Marshal(entity)
+		if err != nil {
+			return models.Change{}, false, err
+		}
+		change.Data = data
+	}
+	return change, true, nil
+}
+
+// reactionEntityId identifies a reaction, a user reacts at most once per emoji.
+func reactionEntityId(reaction models.Reaction) string {
+	return reaction.MessageID.String() + "/" + reaction.UserID.String() + "/" + reaction.Emoji
+}
+
+// RunChangeLogCompactor compacts the change logs every interval until ctx is cancelled.
+func RunChangeLogCompactor(ctx context.Context, changes ChangesService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := changes.Compact(ctx); err != nil {
+				slog.Error("Failed to compact change logs", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+var ErrNotConversationMember = errors.New("only participants can add people to the conversation")
+
+type ConversationsService interface {
+	AddParticipant(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, invitedBy gocql.UUID) (models.Participant, error)
+	GetParticipants(ctx context.Context, conversationId gocql.UUID) ([]models.Participant, error)
+	IsParticipant(ctx context.Context, userId gocql.UUID, conversationId gocql.UUID) (bool, error)
+	GetConversationIds(ctx context.Context, userId gocql.UUID) ([]gocql.UUID, error)
+}
+
+type conversationService struct {
+	participantRepo repository.ParticipantsRepository
+	inboxRepo       repository.InboxRepository
+	messageRepo     repository.MessagesRepository
+	publisher       events.Publisher
+}
+
+func NewConversationsService(participantRepo repository.ParticipantsRepository, inboxRepo repository.InboxRepository, messageRepo repository.MessagesRepository, publisher events.Publisher) ConversationsService {
+	return &conversationService{participantRepo: participantRepo, inboxRepo: inboxRepo, messageRepo: messageRepo, publisher: publisher}
+}
+
+// AddParticipant adds the user to the conversation and surfaces the conversation in their inbox
+// straight away when it already has messages. invitedBy is who added them, they have to take part in
+// the conversation already, unless nobody does yet and they are starting it, in which case they join too.
+func (s *conversationService) AddParticipant(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, invitedBy gocql.UUID) (models.Participant, error) {
+	member, err := s.participantRepo.IsParticipant(ctx, conversationId, invitedBy)
+	if err != nil {
+		return models.Participant{}, err
+	}
+	if !member {
+		started, err := s.participantRepo.HasParticipants(ctx, conversationId)
+		if err != nil {
+			return models.Participant{}, err
+		}
+		if started {
+			return models.Participant{}, ErrNotConversationMember
+		}
+		if invitedBy == userId {
+			return s.join(ctx, conversationId, userId, gocql.UUID{})
+		}
+		if _, err := s.join(ctx, conversationId, invitedBy, gocql.UUID{}); err != nil {
+			return models.Participant{}, err
+		}
+	}
+	return s.join(ctx, conversationId, userId, invitedBy)
+}
+
+// join adds a single participant, announcing them when they are new.
+func (s *conversationService) join(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, invitedBy gocql.UUID) (models.Participant, error) {
+	participant := models.Participant{
+		ConversationID: conversationId,
+		UserID:         userId,
+		JoinedAt:       time.Now(),
+	}
+	event := events.ParticipantAdded{Participant: participant, InvitedBy: invitedBy}
+	outbox, err := newOutboxEntry(event)
+	if err != nil {
+		return models.Participant{}, err
+	}
+	added, err := s.participantRepo.AddParticipant(ctx, participant, outbox)
+	if err != nil {
+		return models.Participant{}, err
+	}
+	if added {
+		publishEvent(ctx, s.publisher, event)
+	}
+
+	latest, err := s.messageRepo.GetLatestMessage(ctx, conversationId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return participant, nil
+	}
+	if err != nil {
+		return models.Participant{}, err
+	}
+	if err := s.inboxRepo.UpsertEntries(ctx, []gocql.UUID{userId}, newInboxEntry(latest)); err != nil {
+		return models.Participant{}, err
+	}
+	return participant, nil
+}
+
+func (s *conversationService) GetParticipants(ctx context.Context, conversationId gocql.UUID) ([]models.Participant, error) {
+	return s.participantRepo.GetParticipants(ctx, conversationId)
+}
+
+// GetConversationIds lists the conversations the user takes part in, including the ones without messages yet.
+func (s *conversationService) GetConversationIds(ctx context.Context, userId gocql.UUID) ([]gocql.UUID, error) {
+	return s.participantRepo.GetConversationIds(ctx, userId)
+}
+
+func (s *conversationService) IsParticipant(ctx context.Context, userId gocql.UUID, conversationId gocql.UUID) (bool, error) {
+	return s.participantRepo.IsParticipant(ctx, conversationId, userId)
+}
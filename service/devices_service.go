@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+// maxDeviceTokenLength is well above the tokens FCM and APNs issue.
+const maxDeviceTokenLength = 4096
+
+var (
+	ErrInvalidDeviceToken = errors.New("device token must be a non empty string of at most 4096 characters")
+	ErrInvalidPlatform    = errors.New("platform must be fcm or apns")
+)
+
+type DevicesService interface {
+	RegisterDevice(ctx context.Context, userId gocql.UUID, device models.DeviceToken) (models.DeviceToken, error)
+	GetDevices(ctx context.Context, userId gocql.UUID) ([]models.DeviceToken, error)
+	UnregisterDevice(ctx context.Context, userId gocql.UUID, token string) error
+}
+
+type devicesService struct {
+	repo repository.DevicesRepository
+}
+
+func NewDevicesService(repo repository.DevicesRepository) DevicesService {
+	return &devicesService{repo: repo}
+}
+
+// RegisterDevice stores the push token of one of the user's devices, registering it again refreshes it.
+func (s *devicesService) RegisterDevice(ctx context.Context, userId gocql.UUID, device models.DeviceToken) (models.DeviceToken, error) {
+	if device.Token == "" || len(device.Token) > maxDeviceTokenLength {
+		return models.DeviceToken{}, ErrInvalidDeviceToken
+	}
+	if device.Platform != models.PlatformFCM && device.Platform != models.PlatformAPNs {
+		return models.DeviceToken{}, ErrInvalidPlatform
+	}
+	device.UserID = userId
+	device.RegisteredAt = time.Now()
+	if err := s.repo.SaveDeviceToken(ctx, device); err != nil {
+		return models.DeviceToken{}, err
+	}
+	return device, nil
+}
+
+func (s *devicesService) GetDevices(ctx context.Context, userId gocql.UUID) ([]models.DeviceToken, error) {
+	devices, err := s.repo.GetDeviceTokens(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if devices == nil {
+		devices = []models.DeviceToken{}
+	}
+	return devices, nil
+}
+
+func (s *devicesService) UnregisterDevice(ctx context.Context, userId gocql.UUID, token string) error {
+	return s.repo.DeleteDeviceToken(ctx, userId, token)
+}
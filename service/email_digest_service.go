@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/digest"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+var (
+	ErrInvalidEmail           = errors.New("email must be a valid address")
+	ErrInvalidDigestFrequency = errors.New("frequency must be hourly, daily or weekly")
+	ErrInvalidDigestToken     = errors.New("invalid or expired confirmation token")
+	ErrDigestsUnavailable     = errors.New("email digests are not available")
+)
+
+type EmailDigestService interface {
+	GetDigestSettings(ctx context.Context, userId gocql.UUID) (models.EmailDigestSettings, error)
+	UpdateDigestSettings(ctx context.Context, userId gocql.UUID, settings models.EmailDigestSettings) (models.EmailDigestSettings, error)
+	ConfirmDigestEmail(ctx context.Context, userId gocql.UUID, token string) (models.EmailDigestSettings, error)
+}
+
+type emailDigestService struct {
+	repo repository.EmailDigestRepository
+	// mailer sends the confirmation emails, it is nil when no SMTP server is configured
+	mailer digest.Mailer
+}
+
+func NewEmailDigestService(repo repository.EmailDigestRepository, mailer digest.Mailer) EmailDigestService {
+	return &emailDigestService{repo: repo, mailer: mailer}
+}
+
+// GetDigestSettings returns the user's settings, users that never opted in get disabled daily digests.
+func (s *emailDigestService) GetDigestSettings(ctx context.Context, userId gocql.UUID) (models.EmailDigestSettings, error) {
+	settings, err := s.repo.GetDigestSettings(ctx, userId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return models.EmailDigestSettings{UserID: userId, Frequency: models.DigestDaily}, nil
+	}
+	if err != nil {
+		return models.EmailDigestSettings{}, err
+	}
+	return settings, nil
+}
+
+// UpdateDigestSettings stores the address and frequency of the user's digests. Opting in with an address
+// that is not confirmed yet mails it a confirmation token, digests only start once it is confirmed.
+// Digests taking effect start the digest period over, so the first one does not reach back to
+// messages from before the opt in.
+func (s *emailDigestService) UpdateDigestSettings(ctx context.Context, userId gocql.UUID, settings models.EmailDigestSettings) (models.EmailDigestSettings, error) {
+	if _, ok := models.DigestPeriods[settings.Frequency]; !ok {
+		return models.EmailDigestSettings{}, ErrInvalidDigestFrequency
+	}
+	if settings.Email != "" || settings.Enabled {
+		address, err := mail.ParseAddress(settings.Email)
+		if err != nil || address.Address != settings.Email {
+			return models.EmailDigestSettings{}, ErrInvalidEmail
+		}
+	}
+
+	existing, err := s.GetDigestSettings(ctx, userId)
+	if err != nil {
+		return models.EmailDigestSettings{}, err
+	}
+	now := time.Now()
+	settings.UserID = userId
+	settings.LastSentAt = existing.LastSentAt
+	// a confirmation holds for the address it was sent to
+	if settings.Email == existing.Email {
+		settings.Confirmed = existing.Confirmed
+		settings.ConfirmationHash = existing.ConfirmationHash
+	}
+	if settings.Enabled && !settings.Confirmed && (!existing.Enabled || settings.Email != existing.Email) {
+		// mailed before saving, so a failure leaves the previous token in place rather than one nobody received
+		hash, err := s.sendConfirmation(ctx, settings.Email)
+		if err != nil {
+			return models.EmailDigestSettings{}, err
+		}
+		settings.ConfirmationHash = hash
+	}
+	if settings.Enabled && settings.Confirmed && !(existing.Enabled && existing.Confirmed) {
+		settings.LastSentAt = now
+	}
+	settings.UpdatedAt = now
+	if err := s.repo.SaveDigestSettings(ctx, settings); err != nil {
+		return models.EmailDigestSettings{}, err
+	}
+	return settings, nil
+}
+
+// ConfirmDigestEmail confirms the address of the user's digests with the token mailed to it.
+func (s *emailDigestService) ConfirmDigestEmail(ctx context.Context, userId gocql.UUID, token string) (models.EmailDigestSettings, error) {
+	settings, err := s.repo.GetDigestSettings(ctx, userId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return models.EmailDigestSettings{}, ErrInvalidDigestToken
+	}
+	if err != nil {
+		return models.EmailDigestSettings{}, err
+	}
+	if settings.ConfirmationHash == "" || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(settings.ConfirmationHash)) != 1 {
+		return models.EmailDigestSettings{}, ErrInvalidDigestToken
+	}
+
+	now := time.Now()
+	settings.Confirmed = true
+	settings.ConfirmationHash = ""
+	if settings.Enabled {
+		settings.LastSentAt = now
+	}
+	settings.UpdatedAt = now
+	if err := s.repo.SaveDigestSettings(ctx, settings); err != nil {
+		return models.EmailDigestSettings{}, err
+	}
+	return settings, nil
+}
+
+// sendConfirmation mails a new confirmation token to the address and returns its hash.
+func (s *emailDigestService) sendConfirmation(ctx context.Context, address string) (string, error) {
+	if s.mailer == nil {
+		return "", ErrDigestsUnavailable
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+	email, err := digest.ConfirmationEmail(address, token)
+	if err != nil {
+		return "", err
+	}
+	if err := s.mailer.Send(ctx, email); err != nil {
+		return "", err
+	}
+	return hashToken(token), nil
+}
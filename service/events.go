@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// publishEvent announces a change that is already stored. Failing subscribers are logged and not
+// reported to the caller, retrying the request would repeat a write that succeeded.
+func publishEvent(ctx context.Context, publisher events.Publisher, event events.Event) {
+	if err := publisher.Publish(ctx, event); err != nil {
+		slog.Error("Failed to publish event", "type", event.Type(), "conversation_id", event.Conversation(), "error", err)
+	}
+}
+
+// newOutboxEntry encodes the event for the outbox, to be written in the same batch as the change.
+func newOutboxEntry(event events.Event) (models.OutboxEntry, error) {
+	payload, err := events.Encode(event)
+	if err != nil {
+		return models.OutboxEntry{}, err
+	}
+	now := time.Now()
+	id := gocql.TimeUUID()
+	return models.OutboxEntry{
+		Shard:          models.OutboxShard(event.Conversation()),
+		Bucket:         models.OutboxBucket(id.Time()),
+		ID:             id,
+		ConversationID: event.Conversation(),
+		EventType:      event.Type(),
+		Payload:        payload,
+		CreatedAt:      now,
+		NextAttemptAt:  now,
+	}, nil
+}
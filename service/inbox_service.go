@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+const (
+	// maxUnreadCount caps how many messages are counted per conversation, clients render anything above as "99+".
+	maxUnreadCount = 100
+	// previewLength is the number of characters of the latest message kept in the inbox.
+	previewLength = 100
+)
+
+type InboxService interface {
+	GetInbox(ctx context.Context, userId gocql.UUID, limit int) ([]models.InboxEntry, error)
+}
+
+type inboxService struct {
+	repo        repository.InboxRepository
+	receiptRepo repository.ReadReceiptsRepository
+	messageRepo repository.MessagesRepository
+}
+
+func NewInboxService(repo repository.InboxRepository, receiptRepo repository.ReadReceiptsRepository, messageRepo repository.MessagesRepository) InboxService {
+	return &inboxService{repo: repo, receiptRepo: receiptRepo, messageRepo: messageRepo}
+}
+
+// GetInbox returns the user's conversations, most recently active first, with unread counts
+// derived from the user's read mark in each conversation.
+func (s *inboxService) GetInbox(ctx context.Context, userId gocql.UUID, limit int) ([]models.InboxEntry, error) {
+	entries, err := s.repo.GetInbox(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastActivityAt.After(entries[j].LastActivityAt)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		// the user's own message is never unread, and everything up to it has been seen
+		if entry.LastSenderID == userId {
+			continue
+		}
+
+		var readUpTo gocql.UUID
+		receipt, err := s.receiptRepo.GetReceipt(ctx, entry.ConversationID, userId)
+		switch {
+		case err == nil:
+			readUpTo = receipt.ReadUpTo
+		case !errors.Is(err, gocql.ErrNotFound):
+			return nil, err
+		}
+
+		entry.UnreadCount, err = s.messageRepo.CountMessagesAfter(ctx, entry.ConversationID, readUpTo, userId, maxUnreadCount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// newInboxEntry denormalizes a message into the inbox row of its conversation.
+func newInboxEntry(message models.Message) models.InboxEntry {
+	return models.InboxEntry{
+		ConversationID:     message.ConversationID,
+		LastMessageID:      message.ID,
+		LastSenderID:       message.SenderId,
+		LastMessagePreview: truncatePreview(message.Body),
+		LastActivityAt:     message.CreatedAt,
+	}
+}
+
+// truncatePreview keeps the first previewLength characters of a message body.
+func truncatePreview(body string) string {
+	preview := []rune(body)
+	if len(preview) > previewLength {
+		preview = preview[:previewLength]
+	}
+	return string(preview)
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/ratelimit"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+const (
+	// maxIncomingMessageRunes bounds the text a tool can post in one message.
+	maxIncomingMessageRunes = 4000
+	maxBotNameRunes         = 80
+)
+
+var (
+	ErrInvalidWebhookToken      = errors.New("invalid webhook token")
+	ErrWebhookRevoked           = errors.New("webhook has been revoked")
+	ErrInvalidIncomingText      = errors.New("text must be between 1 and 4000 characters")
+	ErrInvalidBotName           = errors.New("name must be between 1 and 80 characters")
+	ErrWebhookNotInConversation = errors.New("webhook does not belong to the conversation")
+)
+
+// RateLimitError is returned when a caller has to slow down.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return "rate limit exceeded, retry in " + e.RetryAfter.Round(time.Second).String()
+}
+
+type IncomingWebhooksService interface {
+	CreateIncomingWebhook(ctx context.Context, creatorId gocql.UUID, conversationId gocql.UUID, name string) (models.IncomingWebhook, error)
+	GetIncomingWebhooks(ctx context.Context, callerId gocql.UUID, conversationId gocql.UUID) ([]models.IncomingWebhook, error)
+	RevokeIncomingWebhook(ctx context.Context, callerId gocql.UUID, conversationId gocql.UUID, id gocql.UUID) error
+	PostMessage(ctx context.Context, id gocql.UUID, token string, text string) (models.Message, error)
+}
+
+type incomingWebhookService struct {
+	repo            repository.IncomingWebhooksRepository
+	participantRepo repository.ParticipantsRepository
+	messageService  MessagesService
+	limiter         ratelimit.RateLimiter
+}
+
+// NewIncomingWebhooksService creates the service, limiter is keyed by webhook.
+func NewIncomingWebhooksService(repo repository.IncomingWebhooksRepository, participantRepo repository.ParticipantsRepository, messageService MessagesService, limiter ratelimit.RateLimiter) IncomingWebhooksService {
+	return &incomingWebhookService{repo: repo, participantRepo: participantRepo, messageService: messageService, limiter: limiter}
+}
+
+// CreateIncomingWebhook creates a webhook posting into the conversation under a new bot identity.
+// The token is only returned here, only its hash is stored.
+func (s *incomingWebhookService) CreateIncomingWebhook(ctx context.Context, creatorId gocql.UUID, conversationId gocql.UUID, name string) (models.IncomingWebhook, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxBotNameRunes {
+		return models.IncomingWebhook{}, ErrInvalidBotName
+	}
+	if err := s.requireParticipant(ctx, creatorId, conversationId); err != nil {
+		return models.IncomingWebhook{}, err
+	}
+
+	token, err := newWebhookSecret()
+	if err != nil {
+		return models.IncomingWebhook{}, err
+	}
+	webhook := models.IncomingWebhook{
+		ID:             gocql.TimeUUID(),
+		ConversationID: conversationId,
+		CreatorID:      creatorId,
+		Name:           name,
+		BotID:          gocql.TimeUUID(),
+		TokenHash:      hashToken(token),
+		CreatedAt:      time.Now(),
+	}
+	if err := s.repo.SaveIncomingWebhook(ctx, webhook); err != nil {
+		return models.IncomingWebhook{}, err
+	}
+	webhook.Token = token
+	webhook.URL = "/hooks/" + webhook.ID.String() + "?token=" + token
+	return webhook, nil
+}
+
+func (s *incomingWebhookService) GetIncomingWebhooks(ctx context.Context, callerId gocql.UUID, conversationId gocql.UUID) ([]models.IncomingWebhook, error) {
+	if err := s.requireParticipant(ctx, callerId, conversationId); err != nil {
+		return nil, err
+	}
+	return s.repo.GetIncomingWebhooksByConversation(ctx, conversationId)
+}
+
+// RevokeIncomingWebhook stops the webhook from posting, any participant of the conversation may revoke it.
+func (s *incomingWebhookService) RevokeIncomingWebhook(ctx context.Context, callerId gocql.UUID, conversationId gocql.UUID, id gocql.UUID) error {
+	if err := s.requireParticipant(ctx, callerId, conversationId); err != nil {
+		return err
+	}
+	webhook, err := s.repo.GetIncomingWebhook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if webhook.ConversationID != conversationId {
+		return ErrWebhookNotInConversation
+	}
+	if webhook.Revoked {
+		return nil
+	}
+	webhook.Revoked = true
+	webhook.RevokedAt = time.Now()
+	return s.repo.SaveIncomingWebhook(ctx, webhook)
+}
+
+// PostMessage creates a message from the webhook's bot after checking the token.
+func (s *incomingWebhookService) PostMessage(ctx context.Context, id gocql.UUID, token string, text string) (models.Message, error) {
+	webhook, err := s.repo.GetIncomingWebhook(ctx, id)
+	if errors.Is(err, gocql.ErrNotFound) {
+		// unknown ids look like bad tokens, so ids cannot be probed
+		return models.Message{}, ErrInvalidWebhookToken
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(webhook.TokenHash)) != 1 {
+		return models.Message{}, ErrInvalidWebhookToken
+	}
+	if webhook.Revoked {
+		return models.Message{}, ErrWebhookRevoked
+	}
+	// limited after the token check, so requests with bad tokens cannot use up a webhook's allowance
+	ok, wait, err := s.limiter.Allow(ctx, webhook.ID.String())
+	if err != nil {
+		return models.Message{}, err
+	}
+	if !ok {
+		return models.Message{}, &RateLimitError{RetryAfter: wait}
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" || utf8.RuneCountInString(text) > maxIncomingMessageRunes {
+		return models.Message{}, ErrInvalidIncomingText
+	}
+	return s.messageService.CreateMessage(ctx, models.Message{
+		ID:             gocql.TimeUUID(),
+		ConversationID: webhook.ConversationID,
+		SenderId:       webhook.BotID,
+		CreatedAt:      time.Now(),
+		Body:           text,
+		SentByBot:      true,
+	})
+}
+
+func (s *incomingWebhookService) requireParticipant(ctx context.Context, userId gocql.UUID, conversationId gocql.UUID) error {
+	member, err := s.participantRepo.IsParticipant(ctx, conversationId, userId)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return ErrNotParticipant
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
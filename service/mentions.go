@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+)
+
+// maxMentions bounds the distinct mentions a message can make.
+const maxMentions = 50
+
+var (
+	ErrInvalidMention  = errors.New("mentioned users must take part in the conversation")
+	ErrTooManyMentions = errors.New("a message can mention at most 50 users")
+
+	ErrInvalidMentionCursor = errors.New("invalid mention cursor")
+)
+
+// mentionPattern matches @all, @here and @<user id>. The @ has to start a word, so email addresses
+// are not mistaken for mentions, and @allison is not @all.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@(all|here|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})\b`)
+
+// Presence tells whether a user is connected, @here only mentions those that are.
+type Presence interface {
+	IsOnline(ctx context.Context, userId gocql.UUID) bool
+}
+
+// parseMentions lists the distinct mentions of a body in the order they first appear, user ids in
+// canonical form.
+func parseMentions(body string) []string {
+	var mentions []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		mention := strings.ToLower(match[1])
+		if mention != models.MentionAll && mention != models.MentionHere {
+			userId, err := gocql.ParseUUID(mention)
+			if err != nil {
+				continue
+			}
+			mention = userId.String()
+		}
+		if !seen[mention] {
+			seen[mention] = true
+			mentions = append(mentions, mention)
+		}
+	}
+	return mentions
+}
+
+// resolveMentions works out who the mentions of a message address. Mentioned users have to take part in
+// the conversation, @all stands for every participant and @here for those connected right now.
+// The sender is never mentioned by their own message.
+func (s *messageService) resolveMentions(ctx context.Context, message models.Message, mentions []string) ([]models.Mention, error) {
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+	if len(mentions) > maxMentions {
+		return nil, ErrTooManyMentions
+	}
+
+	participants, err := s.participantRepo.GetParticipants(ctx, message.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[gocql.UUID]bool, len(participants))
+	for _, participant := range participants {
+		members[participant.UserID] = true
+	}
+
+	kinds := make(map[gocql.UUID]string)
+	var order []gocql.UUID
+	add := func(userId gocql.UUID, kind string) {
+		if _, ok := kinds[userId]; ok || userId == message.SenderId {
+			return
+		}
+		kinds[userId] = kind
+		order = append(order, userId)
+	}
+	// a direct mention wins over being included by @here, which wins over @all
+	var here, all bool
+	for _, mention := range mentions {
+		switch mention {
+		case models.MentionAll:
+			all = true
+		case models.MentionHere:
+			here = true
+		default:
+			userId, err := gocql.ParseUUID(mention)
+			if err != nil || (!members[userId] && userId != message.SenderId) {
+				return nil, ErrInvalidMention
+			}
+			add(userId, models.MentionKindUser)
+		}
+	}
+	for _, participant := range participants {
+		if here && s.presence.IsOnline(ctx, participant.UserID) {
+			add(participant.UserID, models.MentionKindHere)
+		}
+		if all {
+			add(participant.UserID, models.MentionKindAll)
+		}
+	}
+
+	resolved := make([]models.Mention, 0, len(order))
+	for _, userId := range order {
+		resolved = append(resolved, models.Mention{
+			UserID:         userId,
+			MessageID:      message.ID,
+			ConversationID: message.ConversationID,
+			SenderID:       message.SenderId,
+			Kind:           kinds[userId],
+			CreatedAt:      message.CreatedAt,
+		})
+	}
+	return resolved, nil
+}
+
+// mentionedUsers is everyone the mentions of a stored message may have reached. @here is not
+// reproducible later on, so it counts every participant, as @all does.
+func (s *messageService) mentionedUsers(ctx context.Context, message models.Message) ([]gocql.UUID, error) {
+	var userIds []gocql.UUID
+	everyone := false
+	for _, mention := range message.Mentions {
+		switch mention {
+		case models.MentionAll, models.MentionHere:
+			everyone = true
+		default:
+			if userId, err := gocql.ParseUUID(mention); err == nil {
+				userIds = append(userIds, userId)
+			}
+		}
+	}
+	if !everyone {
+		return userIds, nil
+	}
+
+	participants, err := s.participantRepo.GetParticipants(ctx, message.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	userIds = userIds[:0]
+	for _, participant := range participants {
+		userIds = append(userIds, participant.UserID)
+	}
+	return userIds, nil
+}
+
+// mentionUserIds lists the users of resolved mentions.
+func mentionUserIds(mentions []models.Mention) []gocql.UUID {
+	userIds := make([]gocql.UUID, 0, len(mentions))
+	for _, mention := range mentions {
+		userIds = append(userIds, mention.UserID)
+	}
+	return userIds
+}
+
+// subtractUsers returns the users of a that are not in b.
+func subtractUsers(a []gocql.UUID, b []gocql.UUID) []gocql.UUID {
+	exclude := make(map[gocql.UUID]bool, len(b))
+	for _, userId := range b {
+		exclude[userId] = true
+	}
+	var rest []gocql.UUID
+	for _, userId := range a {
+		if !exclude[userId] {
+			rest = append(rest, userId)
+		}
+	}
+	return rest
+}
@@ -2,49 +2,408 @@ package service
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
 	"github.com/yaninyzwitty/messaging-service/models"
 	"github.com/yaninyzwitty/messaging-service/repository"
+	"github.com/yaninyzwitty/messaging-service/richtext"
 )
 
+var (
+	// ErrInvalidReplyTarget is returned when a message replies to a message outside its conversation.
+	ErrInvalidReplyTarget = errors.New("reply_to_id must be a message of the same conversation")
+	// ErrNotMessageAuthor is returned when someone other than the sender edits or deletes a message.
+	ErrNotMessageAuthor = errors.New("only the sender can edit or delete a message")
+	// ErrBodyTooLong is returned for message bodies over maxBodyLength characters.
+	ErrBodyTooLong = errors.New("message body must be at most 8000 characters")
+)
+
+// maxBodyLength bounds the characters of a message body, every read with ?format= parses it again.
+const maxBodyLength = 8000
+
 type MessagesService interface {
 	CreateMessage(ctx context.Context, message models.Message) (models.Message, error)
 	GetMessages(ctx context.Context) ([]models.Message, error)
 	GetMessage(ctx context.Context, messageId gocql.UUID) (models.Message, error)
-	DeleteMessage(ctx context.Context, messageId gocql.UUID) error
-	UpdateMessage(ctx context.Context, messageId gocql.UUID, message models.Message) (models.Message, error)
+	DeleteMessage(ctx context.Context, deleterId gocql.UUID, messageId gocql.UUID) error
+	UpdateMessage(ctx context.Context, editorId gocql.UUID, messageId gocql.UUID, message models.Message) (models.Message, error)
 	GetMessagesByPagingState(ctx context.Context, pageSize int, pagingState []byte) ([]models.Message, []byte, error)
+	GetMessagesAfter(ctx context.Context, conversationId gocql.UUID, after gocql.UUID, limit int) ([]models.Message, error)
+	GetMessagesFromSequence(ctx context.Context, userId gocql.UUID, conversationId gocql.UUID, fromSeq int64, limit int) ([]models.Message, int64, error)
+	GetMentions(ctx context.Context, userId gocql.UUID, before string, limit int) ([]models.Mention, error)
 }
 
 type messageService struct {
-	repo repository.MessagesRepository
+	repo            repository.MessagesRepository
+	participantRepo repository.ParticipantsRepository
+	inboxRepo       repository.InboxRepository
+	attachmentRepo  repository.AttachmentsRepository
+	mentionRepo     repository.MentionsRepository
+	presence        Presence
+	publisher       events.Publisher
 }
 
-func NewMessagesService(repo repository.MessagesRepository) MessagesService {
-	return &messageService{repo: repo}
+func NewMessagesService(repo repository.MessagesRepository, participantRepo repository.ParticipantsRepository, inboxRepo repository.InboxRepository, attachmentRepo repository.AttachmentsRepository, mentionRepo repository.MentionsRepository, presence Presence, publisher events.Publisher) MessagesService {
+	return &messageService{repo: repo, participantRepo: participantRepo, inboxRepo: inboxRepo, attachmentRepo: attachmentRepo, mentionRepo: mentionRepo, presence: presence, publisher: publisher}
 }
 
+// CreateMessage stores a message from a participant of its conversation, the sender has to be the
+// authenticated caller. Bots post through their incoming webhook, which checks who created it instead.
 func (s *messageService) CreateMessage(ctx context.Context, message models.Message) (models.Message, error) {
-	return s.repo.CreateMessage(ctx, message)
+	if !message.SentByBot {
+		member, err := s.participantRepo.IsParticipant(ctx, message.ConversationID, message.SenderId)
+		if err != nil {
+			return models.Message{}, err
+		}
+		if !member {
+			return models.Message{}, ErrNotParticipant
+		}
+	}
+
+	// attachments are uploaded ahead of the message, only the sender's unlinked uploads the scanners cleared can be used.
+	// The sender is the authenticated caller, so nobody can claim the uploads of someone else
+	attachments := make([]models.Attachment, 0, len(message.AttachmentIDs))
+	for _, attachmentId := range message.AttachmentIDs {
+		attachment, err := s.attachmentRepo.GetAttachment(ctx, attachmentId)
+		if errors.Is(err, gocql.ErrNotFound) {
+			return models.Message{}, ErrAttachmentUnavailable
+		}
+		if err != nil {
+			return models.Message{}, err
+		}
+		if attachment.UploaderID != message.SenderId || attachment.MessageID != (gocql.UUID{}) {
+			return models.Message{}, ErrAttachmentUnavailable
+		}
+		switch attachment.Status {
+		case models.AttachmentStatusPending:
+			return models.Message{}, ErrAttachmentPending
+		case models.AttachmentStatusQuarantined:
+			return models.Message{}, ErrAttachmentUnavailable
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if message.ReplyToID != (gocql.UUID{}) {
+		parent, err := s.repo.GetMessage(ctx, message.ReplyToID)
+		if errors.Is(err, gocql.ErrNotFound) {
+			return models.Message{}, ErrInvalidReplyTarget
+		}
+		if err != nil {
+			return models.Message{}, err
+		}
+		if parent.ConversationID != message.ConversationID {
+			return models.Message{}, ErrInvalidReplyTarget
+		}
+	}
+
+	if err := checkBody(message.Body); err != nil {
+		return models.Message{}, err
+	}
+	message.Mentions = parseMentions(message.Body)
+	mentions, err := s.resolveMentions(ctx, message, message.Mentions)
+	if err != nil {
+		return models.Message{}, err
+	}
+	mentioned := mentionUserIds(mentions)
+
+	// the checks above can race with another message, claiming settles which one gets each attachment
+	if err := s.claimAttachments(ctx, message.ID, attachments); err != nil {
+		return models.Message{}, err
+	}
+
+	// numbered only once the request is known to be valid, a rejected message must not leave a gap
+	seq, err := s.repo.NextSequence(ctx, message.ConversationID)
+	if err != nil {
+		s.releaseAttachments(ctx, message.ID, attachments)
+		return models.Message{}, err
+	}
+	message.Seq = seq
+
+	outbox, err := newOutboxEntry(events.MessageCreated{Message: message, Mentioned: mentioned, At: message.CreatedAt})
+	if err != nil {
+		s.releaseAttachments(ctx, message.ID, attachments)
+		return models.Message{}, err
+	}
+	createdMessage, err := s.repo.CreateMessage(ctx, message, outbox)
+	if err != nil {
+		s.releaseAttachments(ctx, message.ID, attachments)
+		return models.Message{}, err
+	}
+
+	for i := range attachments {
+		if err := s.attachmentRepo.LinkAttachment(ctx, attachments[i].ID, createdMessage.ID); err != nil {
+			return models.Message{}, err
+		}
+		attachments[i].MessageID = createdMessage.ID
+	}
+	if len(attachments) > 0 {
+		createdMessage.Attachments = attachments
+	}
+
+	// the message is stored at this point, a failing inbox update must not make the client retry and post it twice
+	if err := s.updateInboxes(ctx, createdMessage); err != nil {
+		slog.Error("Failed to update inboxes", "message_id", createdMessage.ID, "error", err)
+	}
+	if err := s.mentionRepo.SaveMentions(ctx, mentions); err != nil {
+		slog.Error("Failed to record mentions", "message_id", createdMessage.ID, "error", err)
+	}
+	publishEvent(ctx, s.publisher, events.MessageCreated{Message: createdMessage, Mentioned: mentioned, At: createdMessage.CreatedAt})
+	return createdMessage, nil
+}
+
+// updateInboxes fans the message out to the inbox of every participant.
+func (s *messageService) updateInboxes(ctx context.Context, message models.Message) error {
+	participants, err := s.participantRepo.GetParticipants(ctx, message.ConversationID)
+	if err != nil {
+		return err
+	}
+	userIds := make([]gocql.UUID, 0, len(participants))
+	for _, participant := range participants {
+		userIds = append(userIds, participant.UserID)
+	}
+	return s.inboxRepo.UpsertEntries(ctx, userIds, newInboxEntry(message))
 }
 
 func (s *messageService) GetMessages(ctx context.Context) ([]models.Message, error) {
-	return s.repo.GetMessages(ctx)
+	messages, err := s.repo.GetMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.withAttachments(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
 }
 
 func (s *messageService) GetMessage(ctx context.Context, messageId gocql.UUID) (models.Message, error) {
-	return s.repo.GetMessage(ctx, messageId)
+	message, err := s.repo.GetMessage(ctx, messageId)
+	if err != nil {
+		return models.Message{}, err
+	}
+	message.Attachments, err = loadAttachments(ctx, s.attachmentRepo, messageId)
+	if err != nil {
+		return models.Message{}, err
+	}
+	return message, nil
 }
 
-func (s *messageService) DeleteMessage(ctx context.Context, messageId gocql.UUID) error {
-	return s.repo.DeleteMessage(ctx, messageId)
+// DeleteMessage removes a message, only its sender can delete it.
+func (s *messageService) DeleteMessage(ctx context.Context, deleterId gocql.UUID, messageId gocql.UUID) error {
+	// look the message up first, subscribers are grouped by conversation
+	message, err := s.repo.GetMessage(ctx, messageId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if message.SenderId != deleterId {
+		return ErrNotMessageAuthor
+	}
+	deleted := events.MessageDeleted{
+		MessageID:      messageId,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderId,
+		At:             time.Now(),
+	}
+	outbox, err := newOutboxEntry(deleted)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteMessage(ctx, messageId, outbox); err != nil {
+		return err
+	}
+	if err := s.forgetMentions(ctx, message, nil); err != nil {
+		slog.Error("Failed to remove mentions", "message_id", messageId, "error", err)
+	}
+	publishEvent(ctx, s.publisher, deleted)
+	return nil
 }
 
-func (s *messageService) UpdateMessage(ctx context.Context, messageId gocql.UUID, message models.Message) (models.Message, error) {
-	return s.repo.UpdateMessage(ctx, messageId, message)
+// UpdateMessage replaces the body of a message, only its sender can edit it.
+func (s *messageService) UpdateMessage(ctx context.Context, editorId gocql.UUID, messageId gocql.UUID, message models.Message) (models.Message, error) {
+	existing, err := s.repo.GetMessage(ctx, messageId)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if existing.SenderId != editorId {
+		return models.Message{}, ErrNotMessageAuthor
+	}
+	// the author, the conversation, the position in it and what it replies to never change
+	message.ConversationID = existing.ConversationID
+	message.SenderId = existing.SenderId
+	message.CreatedAt = existing.CreatedAt
+	message.Seq = existing.Seq
+	message.ReplyToID = existing.ReplyToID
+
+	if err := checkBody(message.Body); err != nil {
+		return models.Message{}, err
+	}
+
+	// mentions are resolved against the stored message, only users mentioned for the first time are told
+	message.Mentions = parseMentions(message.Body)
+	mentions, err := s.resolveMentions(ctx, message, message.Mentions)
+	if err != nil {
+		return models.Message{}, err
+	}
+	previous, err := s.mentionedUsers(ctx, existing)
+	if err != nil {
+		return models.Message{}, err
+	}
+	mentioned := subtractUsers(mentionUserIds(mentions), previous)
+
+	outbox, err := newOutboxEntry(events.MessageUpdated{Message: message, Mentioned: mentioned, At: message.UpdatedAt})
+	if err != nil {
+		return models.Message{}, err
+	}
+	updatedMessage, err := s.repo.UpdateMessage(ctx, messageId, message, outbox)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if err := s.forgetMentions(ctx, existing, mentions); err != nil {
+		slog.Error("Failed to remove mentions", "message_id", messageId, "error", err)
+	}
+	if err := s.mentionRepo.SaveMentions(ctx, mentions); err != nil {
+		slog.Error("Failed to record mentions", "message_id", messageId, "error", err)
+	}
+	publishEvent(ctx, s.publisher, events.MessageUpdated{Message: updatedMessage, Mentioned: mentioned, At: updatedMessage.UpdatedAt})
+	return updatedMessage, nil
 }
 
 func (s *messageService) GetMessagesByPagingState(ctx context.Context, pageSize int, pagingState []byte) ([]models.Message, []byte, error) {
-	return s.repo.GetMessagesByPagingState(ctx, pageSize, pagingState)
+	messages, nextPagingState, err := s.repo.GetMessagesByPagingState(ctx, pageSize, pagingState)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.withAttachments(ctx, messages); err != nil {
+		return nil, nil, err
+	}
+	return messages, nextPagingState, nil
+}
+
+// GetMessagesAfter returns the messages of a conversation posted after the given message, oldest first.
+func (s *messageService) GetMessagesAfter(ctx context.Context, conversationId gocql.UUID, after gocql.UUID, limit int) ([]models.Message, error) {
+	messages, err := s.repo.GetMessagesAfter(ctx, conversationId, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.withAttachments(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetMessagesFromSequence returns the messages of a conversation from sequence number fromSeq on, so a member
+// that noticed a gap in the numbers can fill it, along with the last number handed out when the read started.
+// Not every gap fills: deleted messages and messages whose write failed after they were numbered leave their
+// numbers out for good, so a number below the last one that is still missing here is not worth waiting for.
+func (s *messageService) GetMessagesFromSequence(ctx context.Context, userId gocql.UUID, conversationId gocql.UUID, fromSeq int64, limit int) ([]models.Message, int64, error) {
+	member, err := s.participantRepo.IsParticipant(ctx, conversationId, userId)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !member {
+		return nil, 0, ErrNotParticipant
+	}
+
+	// read first, the messages read next then cover every number up to it that will ever be stored,
+	// except those still being written
+	lastSeq, err := s.repo.GetLastSequence(ctx, conversationId)
+	if err != nil {
+		return nil, 0, err
+	}
+	messages, err := s.repo.GetMessagesFromSequence(ctx, conversationId, fromSeq, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.withAttachments(ctx, messages); err != nil {
+		return nil, 0, err
+	}
+	return messages, lastSeq, nil
+}
+
+// GetMentions returns the mentions of the user, newest first, in messages older than the before cursor.
+// An empty cursor starts with the newest mention.
+func (s *messageService) GetMentions(ctx context.Context, userId gocql.UUID, before string, limit int) ([]models.Mention, error) {
+	var cursor gocql.UUID
+	if before != "" {
+		parsed, err := gocql.ParseUUID(before)
+		if err != nil || parsed.Version() != 1 {
+			return nil, ErrInvalidMentionCursor
+		}
+		cursor = parsed
+	}
+	mentions, err := s.mentionRepo.GetMentions(ctx, userId, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	if mentions == nil {
+		mentions = []models.Mention{}
+	}
+	return mentions, nil
+}
+
+// forgetMentions removes the mentions of a stored message, except for the users still mentioned by kept.
+func (s *messageService) forgetMentions(ctx context.Context, message models.Message, kept []models.Mention) error {
+	userIds, err := s.mentionedUsers(ctx, message)
+	if err != nil {
+		return err
+	}
+	removed := subtractUsers(userIds, mentionUserIds(kept))
+	if len(removed) == 0 {
+		return nil
+	}
+	return s.mentionRepo.DeleteMentions(ctx, message.ID, removed)
+}
+
+// checkBody bounds the length of a body and checks it against the supported Markdown subset,
+// clients render it the same way.
+func checkBody(body string) error {
+	if utf8.RuneCountInString(body) > maxBodyLength {
+		return ErrBodyTooLong
+	}
+	_, err := richtext.Parse(body)
+	return err
+}
+
+// claimAttachments claims every attachment for the message, releasing the ones already claimed when
+// one of them was taken by another message or is no longer clean.
+func (s *messageService) claimAttachments(ctx context.Context, messageId gocql.UUID, attachments []models.Attachment) error {
+	for i, attachment := range attachments {
+		claimed, err := s.attachmentRepo.ClaimAttachment(ctx, attachment.ID, messageId)
+		if err == nil && !claimed {
+			err = ErrAttachmentUnavailable
+		}
+		if err != nil {
+			s.releaseAttachments(ctx, messageId, attachments[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseAttachments gives back the attachments claimed for a message that was not written.
+func (s *messageService) releaseAttachments(ctx context.Context, messageId gocql.UUID, attachments []models.Attachment) {
+	for _, attachment := range attachments {
+		if err := s.attachmentRepo.ReleaseAttachment(ctx, attachment.ID, messageId); err != nil {
+			slog.Error("Failed to release attachment", "attachment_id", attachment.ID, "message_id", messageId, "error", err)
+		}
+	}
+}
+
+// withAttachments fills in the attachment references of every message.
+func (s *messageService) withAttachments(ctx context.Context, messages []models.Message) error {
+	for i := range messages {
+		attachments, err := loadAttachments(ctx, s.attachmentRepo, messages[i].ID)
+		if err != nil {
+			return err
+		}
+		messages[i].Attachments = attachments
+	}
+	return nil
 }
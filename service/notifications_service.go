@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/outbox"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+const (
+	// maxMarkReadIds bounds the notifications marked read by id in one request.
+	maxMarkReadIds = 100
+	// markAllReadPageSize is how many notifications are read per round when marking all of them read.
+	markAllReadPageSize = 500
+)
+
+var (
+	ErrInvalidNotificationCursor = errors.New("invalid notification cursor")
+	ErrTooManyNotificationIds    = errors.New("at most 100 notification ids can be marked read at once")
+)
+
+// NotificationsService keeps the in-app notification feed of every user, generated from message events.
+type NotificationsService interface {
+	// HandleEvent turns mentions, replies, reactions and invites into notifications. It is fed from the outbox,
+	// which retries until the notifications are saved.
+	HandleEvent(ctx context.Context, event events.Event) error
+	GetNotifications(ctx context.Context, userId gocql.UUID, before string, limit int) (models.NotificationPage, error)
+	MarkRead(ctx context.Context, userId gocql.UUID, ids []gocql.UUID) (int, error)
+	MarkAllRead(ctx context.Context, userId gocql.UUID) (int, error)
+}
+
+type notificationsService struct {
+	repo            repository.NotificationsRepository
+	messageRepo     repository.MessagesRepository
+	participantRepo repository.ParticipantsRepository
+}
+
+func NewNotificationsService(repo repository.NotificationsRepository, messageRepo repository.MessagesRepository, participantRepo repository.ParticipantsRepository) NotificationsService {
+	return &notificationsService{repo: repo, messageRepo: messageRepo, participantRepo: participantRepo}
+}
+
+func (s *notificationsService) HandleEvent(ctx context.Context, event events.Event) error {
+	notifications, err := s.notificationsFor(ctx, event)
+	if err != nil || len(notifications) == 0 {
+		return err
+	}
+	return s.repo.SaveNotifications(ctx, notifications)
+}
+
+// notificationsFor works out who the event concerns. Nobody is notified about their own actions, nor about
+// conversations they no longer take part in. Mentions were resolved against the participants already.
+func (s *notificationsService) notificationsFor(ctx context.Context, event events.Event) ([]models.Notification, error) {
+	var notifications []models.Notification
+	var notification models.Notification
+	switch e := event.(type) {
+	case events.MessageCreated:
+		notifications = mentionNotifications(e.Message, e.Mentioned)
+		if e.Message.ReplyToID == (gocql.UUID{}) {
+			break
+		}
+		parent, err := s.messageRepo.GetMessage(ctx, e.Message.ReplyToID)
+		if errors.Is(err, gocql.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// being mentioned in the reply already says as much
+		if parent.IsSoftDeleted || containsUser(e.Mentioned, parent.SenderId) {
+			break
+		}
+		notification = models.Notification{
+			UserID:    parent.SenderId,
+			Type:      models.NotificationReply,
+			MessageID: e.Message.ID,
+			ActorID:   e.Message.SenderId,
+			Preview:   truncatePreview(e.Message.Body),
+		}
+	case events.MessageUpdated:
+		notifications = mentionNotifications(e.Message, e.Mentioned)
+	case events.ReactionAdded:
+		notification = models.Notification{
+			UserID:    e.MessageSenderID,
+			Type:      models.NotificationReaction,
+			MessageID: e.Reaction.MessageID,
+			ActorID:   e.Reaction.UserID,
+			Preview:   e.Reaction.Emoji,
+		}
+	case events.ParticipantAdded:
+		if e.InvitedBy == (gocql.UUID{}) {
+			break
+		}
+		notification = models.Notification{
+			UserID:  e.Participant.UserID,
+			Type:    models.NotificationInvite,
+			ActorID: e.InvitedBy,
+		}
+	default:
+		return nil, nil
+	}
+
+	if notification.UserID != (gocql.UUID{}) && notification.UserID != notification.ActorID {
+		member, err := s.participantRepo.IsParticipant(ctx, event.Conversation(), notification.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if member {
+			notifications = append(notifications, notification)
+		}
+	}
+
+	// a redelivered entry writes the same notifications again, rather than notifying twice
+	entryId, fromOutbox := outbox.EntryIDFromContext(ctx)
+	createdAt := notificationTime(event.OccurredAt())
+	for i := range notifications {
+		if fromOutbox {
+			notifications[i].ID = helpers.DerivedTimeUUID(entryId, notifications[i].UserID.String(), notifications[i].Type)
+		} else {
+			notifications[i].ID = gocql.TimeUUID()
+		}
+		notifications[i].ConversationID = event.Conversation()
+		notifications[i].CreatedAt = createdAt
+	}
+	return notifications, nil
+}
+
+// mentionNotifications tells the mentioned users about the message.
+func mentionNotifications(message models.Message, mentioned []gocql.UUID) []models.Notification {
+	notifications := make([]models.Notification, 0, len(mentioned))
+	for _, userId := range mentioned {
+		if userId == message.SenderId {
+			continue
+		}
+		notifications = append(notifications, models.Notification{
+			UserID:    userId,
+			Type:      models.NotificationMention,
+			MessageID: message.ID,
+			ActorID:   message.SenderId,
+			Preview:   truncatePreview(message.Body),
+		})
+	}
+	return notifications
+}
+
+func containsUser(userIds []gocql.UUID, userId gocql.UUID) bool {
+	for _, id := range userIds {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNotifications returns a page of the user's notifications, newest first, starting after the before
+// cursor. An empty cursor starts with the newest notification.
+func (s *notificationsService) GetNotifications(ctx context.Context, userId gocql.UUID, before string, limit int) (models.NotificationPage, error) {
+	var cursor gocql.UUID
+	if before != "" {
+		parsed, err := gocql.ParseUUID(before)
+		if err != nil || parsed.Version() != 1 {
+			return models.NotificationPage{}, ErrInvalidNotificationCursor
+		}
+		cursor = parsed
+	}
+
+	notifications, err := s.repo.GetNotifications(ctx, userId, cursor, limit)
+	if err != nil {
+		return models.NotificationPage{}, err
+	}
+	page := models.NotificationPage{Notifications: notifications}
+	if page.Notifications == nil {
+		page.Notifications = []models.Notification{}
+	}
+	if len(notifications) == limit {
+		page.NextCursor = notifications[len(notifications)-1].ID.String()
+	}
+	return page, nil
+}
+
+// MarkRead marks the given notifications of the user read and reports how many were unread.
+// Ids that do not exist, or belong to someone else, are ignored.
+func (s *notificationsService) MarkRead(ctx context.Context, userId gocql.UUID, ids []gocql.UUID) (int, error) {
+	if len(ids) > maxMarkReadIds {
+		return 0, ErrTooManyNotificationIds
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	notifications, err := s.repo.GetNotificationsByIds(ctx, userId, ids)
+	if err != nil {
+		return 0, err
+	}
+	return s.markUnread(ctx, notifications)
+}
+
+// MarkAllRead marks every notification of the user read and reports how many were unread.
+func (s *notificationsService) MarkAllRead(ctx context.Context, userId gocql.UUID) (int, error) {
+	marked := 0
+	var before gocql.UUID
+	for {
+		notifications, err := s.repo.GetNotifications(ctx, userId, before, markAllReadPageSize)
+		if err != nil {
+			return marked, err
+		}
+		count, err := s.markUnread(ctx, notifications)
+		marked += count
+		if err != nil || len(notifications) < markAllReadPageSize {
+			return marked, err
+		}
+		before = notifications[len(notifications)-1].ID
+	}
+}
+
+func (s *notificationsService) markUnread(ctx context.Context, notifications []models.Notification) (int, error) {
+	unread := make([]models.Notification, 0, len(notifications))
+	for _, notification := range notifications {
+		if !notification.IsRead {
+			unread = append(unread, notification)
+		}
+	}
+	if len(unread) == 0 {
+		return 0, nil
+	}
+	if err := s.repo.MarkNotificationsRead(ctx, unread); err != nil {
+		return 0, err
+	}
+	return len(unread), nil
+}
+
+// notificationTime is when a notification happened, events without a time are stamped on arrival.
+func notificationTime(at time.Time) time.Time {
+	if at.IsZero() {
+		return time.Now()
+	}
+	return at
+}
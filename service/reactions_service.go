@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+const (
+	// maxEmojiRunes bounds the length of a reaction, long enough for ZWJ sequences and skin tones.
+	maxEmojiRunes = 16
+	// reactionCountSettleTime is how long the counters of a message have to be left alone before the
+	// repair checks them, longer than a reaction takes to be written.
+	reactionCountSettleTime = time.Minute
+)
+
+var ErrInvalidEmoji = errors.New("invalid emoji")
+
+type ReactionsService interface {
+	AddReaction(ctx context.Context, messageId gocql.UUID, emoji string, userId gocql.UUID) error
+	RemoveReaction(ctx context.Context, messageId gocql.UUID, emoji string, userId gocql.UUID) error
+	GetReactionSummaries(ctx context.Context, messageId gocql.UUID, userId gocql.UUID) ([]models.ReactionSummary, error)
+	// RepairCounts corrects the counters of the messages reacted to lately from their reaction rows.
+	RepairCounts(ctx context.Context) error
+}
+
+type reactionService struct {
+	repo        repository.ReactionsRepository
+	messageRepo repository.MessagesRepository
+	publisher   events.Publisher
+}
+
+func NewReactionsService(repo repository.ReactionsRepository, messageRepo repository.MessagesRepository, publisher events.Publisher) ReactionsService {
+	return &reactionService{repo: repo, messageRepo: messageRepo, publisher: publisher}
+}
+
+func (s *reactionService) AddReaction(ctx context.Context, messageId gocql.UUID, emoji string, userId gocql.UUID) error {
+	if err := validateEmoji(emoji); err != nil {
+		return err
+	}
+	// make sure we are not reacting to a message that does not exist
+	message, err := s.messageRepo.GetMessage(ctx, messageId)
+	if err != nil {
+		return err
+	}
+
+	reaction := models.Reaction{
+		MessageID: messageId,
+		Emoji:     emoji,
+		UserID:    userId,
+		CreatedAt: time.Now(),
+	}
+	event := events.ReactionAdded{
+		Reaction:        reaction,
+		ConversationID:  message.ConversationID,
+		MessageSenderID: message.SenderId,
+	}
+	outbox, err := newOutboxEntry(event)
+	if err != nil {
+		return err
+	}
+	added, err := s.repo.AddReaction(ctx, reaction, outbox)
+	if err != nil {
+		return err
+	}
+	if added {
+		publishEvent(ctx, s.publisher, event)
+	}
+	return nil
+}
+
+func (s *reactionService) RemoveReaction(ctx context.Context, messageId gocql.UUID, emoji string, userId gocql.UUID) error {
+	if err := validateEmoji(emoji); err != nil {
+		return err
+	}
+	message, err := s.messageRepo.GetMessage(ctx, messageId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	event := events.ReactionRemoved{
+		Reaction: models.Reaction{
+			MessageID: messageId,
+			Emoji:     emoji,
+			UserID:    userId,
+		},
+		ConversationID: message.ConversationID,
+		At:             time.Now(),
+	}
+	outbox, err := newOutboxEntry(event)
+	if err != nil {
+		return err
+	}
+	removed, err := s.repo.RemoveReaction(ctx, messageId, emoji, userId, outbox)
+	if err != nil {
+		return err
+	}
+	if removed {
+		publishEvent(ctx, s.publisher, event)
+	}
+	return nil
+}
+
+// GetReactionSummaries aggregates the reactions on a message, flagging the ones left by userId.
+// Pass an empty UUID for anonymous callers.
+func (s *reactionService) GetReactionSummaries(ctx context.Context, messageId gocql.UUID, userId gocql.UUID) ([]models.ReactionSummary, error) {
+	counts, err := s.repo.GetReactionCounts(ctx, messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	mine := map[string]bool{}
+	if userId != (gocql.UUID{}) {
+		mine, err = s.repo.GetUserReactions(ctx, messageId, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	summaries := make([]models.ReactionSummary, 0, len(counts))
+	for emoji, count := range counts {
+		summaries = append(summaries, models.ReactionSummary{
+			Emoji:       emoji,
+			Count:       count,
+			ReactedByMe: mine[emoji],
+		})
+	}
+	// most popular first, ties broken by emoji so the order is stable
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Count != summaries[j].Count {
+			return summaries[i].Count > summaries[j].Count
+		}
+		return summaries[i].Emoji < summaries[j].Emoji
+	})
+	return summaries, nil
+}
+
+func (s *reactionService) RepairCounts(ctx context.Context) error {
+	var errs []error
+	for shard := 0; shard < models.ReactionCountCheckShards; shard++ {
+		if err := s.repairShard(ctx, shard); err != nil {
+			errs = append(errs, fmt.Errorf("reaction count check shard %d: %w", shard, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// repairShard checks the settled messages of a shard. Each check is claimed first, so instances running
+// the repair side by side do not correct the same counters twice.
+func (s *reactionService) repairShard(ctx context.Context, shard int) error {
+	checks, err := s.repo.GetCountChecks(ctx, shard)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, check := range checks {
+		if now.Sub(check.ChangedAt) < reactionCountSettleTime {
+			continue
+		}
+		claimed, ok, err := s.repo.ClaimCountCheck(ctx, check, now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.repo.RepairReactionCounts(ctx, check.MessageID); err != nil {
+			// the claim runs out and the check is tried again
+			slog.Error("Failed to repair reaction counts", "message_id", check.MessageID, "error", err)
+			continue
+		}
+		if err := s.repo.DeleteCountCheck(ctx, claimed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunReactionCountRepair repairs the reaction counters every interval until ctx is cancelled.
+func RunReactionCountRepair(ctx context.Context, reactions ReactionsService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := reactions.RepairCounts(ctx); err != nil {
+				slog.Error("Failed to repair reaction counts", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func validateEmoji(emoji string) error {
+	if emoji == "" || !utf8.ValidString(emoji) || utf8.RuneCountInString(emoji) > maxEmojiRunes {
+		return ErrInvalidEmoji
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return ErrInvalidEmoji
+		}
+		// plain ascii is only allowed as the base of keycap sequences
+		if r < utf8.RuneSelf && !strings.ContainsRune("0123456789#*", r) {
+			return ErrInvalidEmoji
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+// maxReceiptAttempts bounds the compare-and-set loop when several devices move the same marks concurrently.
+const maxReceiptAttempts = 5
+
+var (
+	ErrMessageNotInConversation = errors.New("message does not belong to the conversation")
+	ErrReceiptContention        = errors.New("too many concurrent read receipt updates")
+)
+
+type ReadReceiptsService interface {
+	MarkDelivered(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, messageId gocql.UUID) (models.ReadReceipt, error)
+	MarkRead(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, messageId gocql.UUID) (models.ReadReceipt, error)
+	GetReaders(ctx context.Context, userId gocql.UUID, messageId gocql.UUID) ([]models.Reader, error)
+	ApplyDeliveryStatus(ctx context.Context, userId gocql.UUID, messages []models.Message) error
+}
+
+type readReceiptService struct {
+	repo            repository.ReadReceiptsRepository
+	messageRepo     repository.MessagesRepository
+	participantRepo repository.ParticipantsRepository
+	publisher       events.Publisher
+}
+
+func NewReadReceiptsService(repo repository.ReadReceiptsRepository, messageRepo repository.MessagesRepository, participantRepo repository.ParticipantsRepository, publisher events.Publisher) ReadReceiptsService {
+	return &readReceiptService{repo: repo, messageRepo: messageRepo, participantRepo: participantRepo, publisher: publisher}
+}
+
+func (s *readReceiptService) MarkDelivered(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, messageId gocql.UUID) (models.ReadReceipt, error) {
+	return s.advance(ctx, conversationId, userId, messageId, false)
+}
+
+func (s *readReceiptService) MarkRead(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, messageId gocql.UUID) (models.ReadReceipt, error) {
+	return s.advance(ctx, conversationId, userId, messageId, true)
+}
+
+// advance moves the user's marks forward to messageId, marks never move backwards.
+// Reading a message implies it was delivered, so the read mark drags the delivered mark along.
+func (s *readReceiptService) advance(ctx context.Context, conversationId gocql.UUID, userId gocql.UUID, messageId gocql.UUID, read bool) (models.ReadReceipt, error) {
+	message, err := s.messageRepo.GetMessage(ctx, messageId)
+	if err != nil {
+		return models.ReadReceipt{}, err
+	}
+	if message.ConversationID != conversationId {
+		return models.ReadReceipt{}, ErrMessageNotInConversation
+	}
+
+	for attempt := 0; attempt < maxReceiptAttempts; attempt++ {
+		var previous *models.ReadReceipt
+		current, err := s.repo.GetReceipt(ctx, conversationId, userId)
+		switch {
+		case errors.Is(err, gocql.ErrNotFound):
+			current = models.ReadReceipt{ConversationID: conversationId, UserID: userId}
+		case err != nil:
+			return models.ReadReceipt{}, err
+		default:
+			existing := current
+			previous = &existing
+		}
+
+		next := current
+		now := time.Now()
+		changed := false
+		if !current.HasDelivered(messageId) {
+			next.DeliveredUpTo, next.DeliveredAt = messageId, now
+			changed = true
+		}
+		if read && !current.HasRead(messageId) {
+			next.ReadUpTo, next.ReadAt = messageId, now
+			changed = true
+		}
+		if !changed {
+			return current, nil
+		}
+
+		event := events.ReadReceiptAdvanced{Receipt: next, At: now}
+		outbox, err := newOutboxEntry(event)
+		if err != nil {
+			return models.ReadReceipt{}, err
+		}
+		applied, err := s.repo.SaveReceipt(ctx, next, previous, outbox)
+		if err != nil {
+			return models.ReadReceipt{}, err
+		}
+		if applied {
+			publishEvent(ctx, s.publisher, event)
+			return next, nil
+		}
+	}
+	return models.ReadReceipt{}, ErrReceiptContention
+}
+
+// GetReaders lists the users, other than the sender, whose read mark covers the message.
+// Only participants of the message's conversation may see who read it.
+func (s *readReceiptService) GetReaders(ctx context.Context, userId gocql.UUID, messageId gocql.UUID) ([]models.Reader, error) {
+	message, err := s.messageRepo.GetMessage(ctx, messageId)
+	if err != nil {
+		return nil, err
+	}
+	member, err := s.participantRepo.IsParticipant(ctx, message.ConversationID, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, ErrNotParticipant
+	}
+	receipts, err := s.repo.GetReceipts(ctx, message.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	readers := []models.Reader{}
+	for _, receipt := range receipts {
+		if receipt.UserID == message.SenderId || !receipt.HasRead(messageId) {
+			continue
+		}
+		readers = append(readers, models.Reader{UserID: receipt.UserID, ReadAt: receipt.ReadAt})
+	}
+	return readers, nil
+}
+
+// ApplyDeliveryStatus fills in the delivery status of the messages sent by userId.
+// A message is reported as far as its furthest recipient got, the readers endpoint has the per user details.
+func (s *readReceiptService) ApplyDeliveryStatus(ctx context.Context, userId gocql.UUID, messages []models.Message) error {
+	receiptsByConversation := make(map[gocql.UUID][]models.ReadReceipt)
+	for i := range messages {
+		message := &messages[i]
+		if message.SenderId != userId {
+			continue
+		}
+
+		receipts, ok := receiptsByConversation[message.ConversationID]
+		if !ok {
+			var err error
+			receipts, err = s.repo.GetReceipts(ctx, message.ConversationID)
+			if err != nil {
+				return err
+			}
+			receiptsByConversation[message.ConversationID] = receipts
+		}
+
+		message.DeliveryStatus = models.DeliveryStatusSent
+		for _, receipt := range receipts {
+			if receipt.UserID == userId {
+				continue
+			}
+			if receipt.HasRead(message.ID) {
+				message.DeliveryStatus = models.DeliveryStatusRead
+				break
+			}
+			if receipt.HasDelivered(message.ID) {
+				message.DeliveryStatus = models.DeliveryStatusDelivered
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+	"github.com/yaninyzwitty/messaging-service/storage"
+)
+
+var (
+	ErrUploadTooLarge     = errors.New("upload exceeds the maximum size")
+	ErrUploadForbidden    = errors.New("upload belongs to another user")
+	ErrUploadExpired      = errors.New("upload has expired")
+	ErrUploadOffset       = errors.New("upload offset does not match")
+	ErrUploadChunkTooLong = errors.New("chunk extends past the upload length")
+)
+
+// UploadsService implements resumable uploads as described by the tus 1.0 protocol.
+type UploadsService interface {
+	CreateUpload(ctx context.Context, uploaderId gocql.UUID, length int64, filename string, contentType string) (models.ResumableUpload, error)
+	GetUpload(ctx context.Context, id gocql.UUID, uploaderId gocql.UUID) (models.ResumableUpload, error)
+	AppendChunk(ctx context.Context, id gocql.UUID, uploaderId gocql.UUID, offset int64, chunk io.Reader) (models.ResumableUpload, error)
+	TerminateUpload(ctx context.Context, id gocql.UUID, uploaderId gocql.UUID) error
+	PurgeExpiredUploads(ctx context.Context) error
+	MaxSize() int64
+}
+
+type uploadService struct {
+	repo              repository.UploadsRepository
+	store             storage.BlobStore
+	attachmentService AttachmentsService
+	maxSize           int64
+	expiry            time.Duration
+}
+
+func NewUploadsService(repo repository.UploadsRepository, store storage.BlobStore, attachmentService AttachmentsService, maxSize int64, expiry time.Duration) UploadsService {
+	return &uploadService{repo: repo, store: store, attachmentService: attachmentService, maxSize: maxSize, expiry: expiry}
+}
+
+func (s *uploadService) MaxSize() int64 {
+	return s.maxSize
+}
+
+func (s *uploadService) CreateUpload(ctx context.Context, uploaderId gocql.UUID, length int64, filename string, contentType string) (models.ResumableUpload, error) {
+	if length > s.maxSize {
+		return models.ResumableUpload{}, ErrUploadTooLarge
+	}
+	now := time.Now()
+	return s.repo.CreateUpload(ctx, models.ResumableUpload{
+		ID:          gocql.TimeUUID(),
+		UploaderID:  uploaderId,
+		Length:      length,
+		Filename:    filename,
+		ContentType: contentType,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.expiry),
+	})
+}
+
+func (s *uploadService) GetUpload(ctx context.Context, id gocql.UUID, uploaderId gocql.UUID) (models.ResumableUpload, error) {
+	upload, err := s.repo.GetUpload(ctx, id)
+	if err != nil {
+		return models.ResumableUpload{}, err
+	}
+	if upload.UploaderID != uploaderId {
+		return models.ResumableUpload{}, ErrUploadForbidden
+	}
+	if !upload.IsFinalized() && time.Now().After(upload.ExpiresAt) {
+		return models.ResumableUpload{}, ErrUploadExpired
+	}
+	return upload, nil
+}
+
+// AppendChunk stores the bytes of a PATCH request at the given offset.
+// Whatever arrived before the client went away is kept, so the client can resume from there.
+// Once the last byte is in, the chunks are assembled into an attachment.
+func (s *uploadService) AppendChunk(ctx context.Context, id gocql.UUID, uploaderId gocql.UUID, offset int64, chunk io.Reader) (models.ResumableUpload, error) {
+	upload, err := s.GetUpload(ctx, id, uploaderId)
+	if err != nil {
+		return models.ResumableUpload{}, err
+	}
+	if offset != upload.Offset {
+		return models.ResumableUpload{}, ErrUploadOffset
+	}
+
+	if !upload.IsComplete() {
+		upload, err = s.storeChunk(ctx, upload, chunk)
+		if err != nil {
+			return models.ResumableUpload{}, err
+		}
+	}
+
+	// an empty PATCH on a complete upload retries a finalization that failed earlier
+	if upload.IsComplete() && !upload.IsFinalized() {
+		return s.finalize(ctx, upload)
+	}
+	return upload, nil
+}
+
+func (s *uploadService) storeChunk(ctx context.Context, upload models.ResumableUpload, chunk io.Reader) (models.ResumableUpload, error) {
+	remaining := upload.Length - upload.Offset
+
+	// spool first: the request body may break off halfway and the received part is still worth keeping
+	spooled, err := os.CreateTemp("", "tus-chunk-*")
+	if err != nil {
+		return models.ResumableUpload{}, err
+	}
+	defer func() {
+		spooled.Close()
+		os.Remove(spooled.Name())
+	}()
+	size, readErr := io.Copy(spooled, io.LimitReader(chunk, remaining+1))
+	if size > remaining {
+		return models.ResumableUpload{}, ErrUploadChunkTooLong
+	}
+	if size == 0 {
+		return upload, readErr
+	}
+	if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+		return models.ResumableUpload{}, err
+	}
+
+	key := chunkKey(upload.ID, upload.Offset)
+	if err := s.store.Put(ctx, key, spooled, size, "application/offset+octet-stream"); err != nil {
+		return models.ResumableUpload{}, err
+	}
+	applied, err := s.repo.AppendChunk(ctx, upload.ID, upload.Offset, upload.Offset+size)
+	if err != nil || !applied {
+		// another request won the race for this offset, our copy of the chunk is orphaned
+		if deleteErr := s.store.Delete(ctx, key); deleteErr != nil {
+			slog.Warn("Failed to delete orphaned upload chunk", "upload_id", upload.ID, "error", deleteErr)
+		}
+		if err != nil {
+			return models.ResumableUpload{}, err
+		}
+		return models.ResumableUpload{}, ErrUploadOffset
+	}
+
+	upload.ChunkOffsets = append(upload.ChunkOffsets, upload.Offset)
+	upload.Offset += size
+	return upload, readErr
+}
+
+// finalize concatenates the chunks into an attachment and drops the chunks.
+func (s *uploadService) finalize(ctx context.Context, upload models.ResumableUpload) (models.ResumableUpload, error) {
+	// the attachment takes the id of the upload, so a retry after a failure to record it finds
+	// the attachment stored by the earlier attempt instead of creating a second one
+	attachment, err := s.attachmentService.GetAttachment(ctx, upload.UploaderID, upload.ID)
+	if err == nil {
+		return s.recordAttachment(ctx, upload, attachment.ID)
+	}
+	if !errors.Is(err, gocql.ErrNotFound) {
+		return models.ResumableUpload{}, err
+	}
+
+	content := &chunkReader{ctx: ctx, store: s.store, upload: upload}
+	defer content.Close()
+
+	attachment, err = s.attachmentService.UploadAttachment(ctx, upload.UploaderID, Upload{
+		ID:          upload.ID,
+		Filename:    upload.Filename,
+		ContentType: upload.ContentType,
+		Size:        upload.Length,
+		Content:     content,
+	})
+	if err != nil {
+		return models.ResumableUpload{}, fmt.Errorf("failed to finalize upload: %w", err)
+	}
+	return s.recordAttachment(ctx, upload, attachment.ID)
+}
+
+// recordAttachment links the finalized upload to its attachment and drops the chunks.
+func (s *uploadService) recordAttachment(ctx context.Context, upload models.ResumableUpload, attachmentId gocql.UUID) (models.ResumableUpload, error) {
+	if err := s.repo.SetAttachment(ctx, upload.ID, attachmentId); err != nil {
+		return models.ResumableUpload{}, err
+	}
+	upload.AttachmentID = attachmentId
+
+	s.deleteChunks(ctx, upload)
+	return upload, nil
+}
+
+func (s *uploadService) TerminateUpload(ctx context.Context, id gocql.UUID, uploaderId gocql.UUID) error {
+	upload, err := s.repo.GetUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+	if upload.UploaderID != uploaderId {
+		return ErrUploadForbidden
+	}
+	s.deleteChunks(ctx, upload)
+	return s.repo.DeleteUpload(ctx, id)
+}
+
+// PurgeExpiredUploads discards incomplete uploads past their expiry along with their chunks,
+// and forgets finalized uploads once they expire since their attachment lives on by itself.
+func (s *uploadService) PurgeExpiredUploads(ctx context.Context) error {
+	uploads, err := s.repo.GetUploads(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, upload := range uploads {
+		if now.Before(upload.ExpiresAt) {
+			continue
+		}
+		if !upload.IsFinalized() {
+			s.deleteChunks(ctx, upload)
+		}
+		if err := s.repo.DeleteUpload(ctx, upload.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *uploadService) deleteChunks(ctx context.Context, upload models.ResumableUpload) {
+	for _, offset := range upload.ChunkOffsets {
+		if err := s.store.Delete(ctx, chunkKey(upload.ID, offset)); err != nil {
+			slog.Warn("Failed to delete upload chunk", "upload_id", upload.ID, "offset", offset, "error", err)
+		}
+	}
+}
+
+// RunUploadPurger purges expired uploads every interval until ctx is cancelled.
+func RunUploadPurger(ctx context.Context, uploads UploadsService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := uploads.PurgeExpiredUploads(ctx); err != nil {
+				slog.Error("Failed to purge expired uploads", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func chunkKey(uploadId gocql.UUID, offset int64) string {
+	return fmt.Sprintf("uploads/%s/%020d", uploadId, offset)
+}
+
+// chunkReader reads the chunks of an upload back to back, opening each one lazily.
+type chunkReader struct {
+	ctx     context.Context
+	store   storage.BlobStore
+	upload  models.ResumableUpload
+	next    int
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.next >= len(r.upload.ChunkOffsets) {
+				return 0, io.EOF
+			}
+			chunk, err := r.store.Get(r.ctx, chunkKey(r.upload.ID, r.upload.ChunkOffsets[r.next]))
+			if err != nil {
+				return 0, err
+			}
+			r.current = chunk
+			r.next++
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/repository"
+	"github.com/yaninyzwitty/messaging-service/webhooks"
+)
+
+// minWebhookSecretLength keeps caller chosen secrets from being guessable.
+const minWebhookSecretLength = 16
+
+var (
+	ErrInvalidWebhookURL   = errors.New("webhook url must be an absolute http or https url")
+	ErrWebhookURLForbidden = webhooks.ErrForbiddenAddress
+	ErrInvalidEventTypes   = errors.New("event types must be a non empty list of known event types")
+	ErrWebhookSecretLength = errors.New("webhook secret must be at least 16 characters")
+	ErrWebhookForbidden    = errors.New("webhook belongs to another user")
+	ErrNotParticipant      = errors.New("not a participant of the conversation")
+)
+
+type WebhooksService interface {
+	CreateWebhook(ctx context.Context, ownerId gocql.UUID, webhook models.Webhook) (models.Webhook, error)
+	GetWebhook(ctx context.Context, ownerId gocql.UUID, id gocql.UUID) (models.Webhook, error)
+	GetWebhooks(ctx context.Context, ownerId gocql.UUID) ([]models.Webhook, error)
+	UpdateWebhook(ctx context.Context, ownerId gocql.UUID, id gocql.UUID, webhook models.Webhook) (models.Webhook, error)
+	DeleteWebhook(ctx context.Context, ownerId gocql.UUID, id gocql.UUID) error
+	GetDeliveries(ctx context.Context, ownerId gocql.UUID, id gocql.UUID, status string, limit int) ([]models.WebhookDelivery, error)
+}
+
+type webhookService struct {
+	repo            repository.WebhooksRepository
+	participantRepo repository.ParticipantsRepository
+}
+
+func NewWebhooksService(repo repository.WebhooksRepository, participantRepo repository.ParticipantsRepository) WebhooksService {
+	return &webhookService{repo: repo, participantRepo: participantRepo}
+}
+
+// CreateWebhook registers a webhook for the owner. A secret is generated when none is given,
+// it is only ever returned here.
+func (s *webhookService) CreateWebhook(ctx context.Context, ownerId gocql.UUID, webhook models.Webhook) (models.Webhook, error) {
+	if err := s.validate(ctx, ownerId, webhook); err != nil {
+		return models.Webhook{}, err
+	}
+	if webhook.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			return models.Webhook{}, err
+		}
+		webhook.Secret = secret
+	}
+
+	now := time.Now()
+	webhook.ID = gocql.TimeUUID()
+	webhook.OwnerID = ownerId
+	webhook.Enabled = true
+	webhook.ConsecutiveFailures = 0
+	webhook.DisabledReason = ""
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+	if err := s.repo.SaveWebhook(ctx, webhook); err != nil {
+		return models.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (s *webhookService) GetWebhook(ctx context.Context, ownerId gocql.UUID, id gocql.UUID) (models.Webhook, error) {
+	webhook, err := s.ownedWebhook(ctx, ownerId, id)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (s *webhookService) GetWebhooks(ctx context.Context, ownerId gocql.UUID) ([]models.Webhook, error) {
+	webhooks, err := s.repo.GetWebhooksByOwner(ctx, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the subscription of a webhook. Enabling a disabled webhook clears its failures,
+// the secret is only rotated when a new one is given.
+func (s *webhookService) UpdateWebhook(ctx context.Context, ownerId gocql.UUID, id gocql.UUID, webhook models.Webhook) (models.Webhook, error) {
+	existing, err := s.ownedWebhook(ctx, ownerId, id)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	if err := s.validate(ctx, ownerId, webhook); err != nil {
+		return models.Webhook{}, err
+	}
+
+	existing.URL = webhook.URL
+	existing.EventTypes = webhook.EventTypes
+	existing.ConversationIDs = webhook.ConversationIDs
+	if webhook.Secret != "" {
+		existing.Secret = webhook.Secret
+	}
+	if webhook.Enabled && !existing.Enabled {
+		existing.ConsecutiveFailures = 0
+		existing.DisabledReason = ""
+	}
+	existing.Enabled = webhook.Enabled
+	existing.UpdatedAt = time.Now()
+	if err := s.repo.SaveWebhook(ctx, existing); err != nil {
+		return models.Webhook{}, err
+	}
+	existing.Secret = ""
+	return existing, nil
+}
+
+func (s *webhookService) DeleteWebhook(ctx context.Context, ownerId gocql.UUID, id gocql.UUID) error {
+	if _, err := s.ownedWebhook(ctx, ownerId, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteWebhook(ctx, id)
+}
+
+// GetDeliveries lists the latest deliveries of a webhook, optionally only the ones in a status,
+// e.g. models.WebhookDeliveryDeadLetter for the dead letters.
+func (s *webhookService) GetDeliveries(ctx context.Context, ownerId gocql.UUID, id gocql.UUID, status string, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := s.ownedWebhook(ctx, ownerId, id); err != nil {
+		return nil, err
+	}
+	return s.repo.GetDeliveries(ctx, id, status, limit)
+}
+
+func (s *webhookService) ownedWebhook(ctx context.Context, ownerId gocql.UUID, id gocql.UUID) (models.Webhook, error) {
+	webhook, err := s.repo.GetWebhook(ctx, id)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	if webhook.OwnerID != ownerId {
+		return models.Webhook{}, ErrWebhookForbidden
+	}
+	return webhook, nil
+}
+
+// validate checks the subscription. The url has to resolve to public addresses and the owner can only
+// filter on conversations they take part in.
+func (s *webhookService) validate(ctx context.Context, ownerId gocql.UUID, webhook models.Webhook) error {
+	endpoint, err := url.Parse(webhook.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	if err := webhooks.CheckHost(ctx, endpoint.Hostname()); err != nil {
+		if errors.Is(err, webhooks.ErrForbiddenAddress) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidWebhookURL, err)
+	}
+	if len(webhook.EventTypes) == 0 {
+		return ErrInvalidEventTypes
+	}
+	for _, eventType := range webhook.EventTypes {
+		if !events.IsKnownType(eventType) {
+			return ErrInvalidEventTypes
+		}
+	}
+	if webhook.Secret != "" && len(webhook.Secret) < minWebhookSecretLength {
+		return ErrWebhookSecretLength
+	}
+	for _, conversationId := range webhook.ConversationIDs {
+		member, err := s.participantRepo.IsParticipant(ctx, conversationId, ownerId)
+		if err != nil {
+			return err
+		}
+		if !member {
+			return ErrNotParticipant
+		}
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
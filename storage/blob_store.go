@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore persists opaque binary objects under slash separated keys.
+type BlobStore interface {
+	// Put stores the content of r under key, replacing any existing object.
+	// size is the exact number of bytes r yields, or -1 when unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key, callers must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key, deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,48 @@
+package webhooks
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"10.0.0.1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"172.16.0.1", false},
+		{"192.0.0.8", false},
+		{"192.168.1.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:93.184.216.34", true},
+		// NAT64 addresses lead to the embedded IPv4 address
+		{"64:ff9b::a9fe:a9fe", false},
+		{"64:ff9b::10.0.0.1", false},
+		{"64:ff9b::93.184.216.34", true},
+		{"64:ff9b:1::1", false},
+		{"2001:db8::1", false},
+		{"2002:7f00:1::", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+	} {
+		if got := IsPublicIP(net.ParseIP(tc.ip)); got != tc.public {
+			t.Errorf("IsPublicIP(%s) = %v, want %v", tc.ip, got, tc.public)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/yaninyzwitty/messaging-service/events"
+	"github.com/yaninyzwitty/messaging-service/helpers"
+	"github.com/yaninyzwitty/messaging-service/models"
+	"github.com/yaninyzwitty/messaging-service/outbox"
+	"github.com/yaninyzwitty/messaging-service/repository"
+)
+
+const (
+	// baseBackoff and maxBackoff bound the delay before retrying a failed delivery, see helpers.Backoff.
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	// retryPageSize is how many due retries of a shard are read at a time.
+	retryPageSize = 100
+	// maxErrorBody is how much of a failed response is kept in the delivery log.
+	maxErrorBody = 512
+	// minClaim is the shortest an attempt holds a delivery, see Dispatcher.claimFor.
+	minClaim = time.Minute
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Payload is the body posted to webhooks.
+type Payload struct {
+	ID             gocql.UUID   `json:"id"` //the same for every webhook receiving the event
+	Type           string       `json:"type"`
+	ConversationID gocql.UUID   `json:"conversation_id"`
+	OccurredAt     time.Time    `json:"occurred_at"`
+	Data           events.Event `json:"data"`
+}
+
+// Sign computes the signature header of a delivery: the unix timestamp and the hex HMAC-SHA256
+// of "timestamp.body" under the webhook secret. Receivers recompute it and reject old timestamps.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type job struct {
+	webhookId gocql.UUID
+	delivery  models.WebhookDelivery
+}
+
+// Dispatcher delivers events to the matching webhooks. New deliveries are logged as pending and queued
+// right away, failed ones are retried with exponential backoff by a sweep over the pending deliveries,
+// which also picks up whatever was left when the process stopped. Every instance sweeps, an attempt
+// first claims its delivery so only one of them posts it.
+type Dispatcher struct {
+	repo            repository.WebhooksRepository
+	participantRepo repository.ParticipantsRepository
+	client          *http.Client
+	workers         int
+	maxAttempts     int
+	disableAfter    int
+	sweepInterval   time.Duration
+	claimFor        time.Duration //how long an attempt holds its delivery, longer than the request may take
+	jobs            chan job
+	stop            chan struct{}
+	wg              sync.WaitGroup
+
+	mu       sync.Mutex
+	inFlight map[gocql.UUID]bool
+}
+
+func NewDispatcher(repo repository.WebhooksRepository, participantRepo repository.ParticipantsRepository, workers int, queueSize int, timeout time.Duration, maxAttempts int, disableAfter int, sweepInterval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		repo:            repo,
+		participantRepo: participantRepo,
+		client:          newClient(timeout),
+		workers:         max(workers, 1),
+		maxAttempts:     max(maxAttempts, 1),
+		disableAfter:    disableAfter,
+		sweepInterval:   sweepInterval,
+		claimFor:        max(2*timeout, minClaim),
+		jobs:            make(chan job, queueSize),
+		stop:            make(chan struct{}),
+		inFlight:        make(map[gocql.UUID]bool),
+	}
+}
+
+// Start launches the workers and the retry sweep, they run until Stop is called or ctx is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for {
+				select {
+				case j := <-d.jobs:
+					d.attempt(ctx, j)
+				case <-d.stop:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.sweepInterval)
+		defer ticker.Stop()
+		for {
+			d.sweep(ctx)
+			select {
+			case <-ticker.C:
+			case <-d.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the attempts in progress, queued deliveries stay pending and are retried on the next start.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// HandleEvent is the outbox subscriber logging a delivery for every webhook the event matches. The ids
+// of the payload and the deliveries derive from the outbox entry, so a redelivered entry finds its
+// deliveries already logged and does not post them twice.
+func (d *Dispatcher) HandleEvent(ctx context.Context, event events.Event) error {
+	eventId, ok := outbox.EntryIDFromContext(ctx)
+	if !ok {
+		eventId = gocql.TimeUUID()
+	}
+
+	// owners only hear about conversations they still take part in
+	participants, err := d.participantRepo.GetParticipants(ctx, event.Conversation())
+	if err != nil {
+		return err
+	}
+	members := make(map[gocql.UUID]bool, len(participants))
+	participantIds := make([]gocql.UUID, 0, len(participants))
+	for _, participant := range participants {
+		members[participant.UserID] = true
+		participantIds = append(participantIds, participant.UserID)
+	}
+	webhooks, err := d.repo.GetConversationWebhooks(ctx, event.Conversation(), participantIds)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	now := time.Now()
+	payload := Payload{
+		ID:             eventId,
+		Type:           event.Type(),
+		ConversationID: event.Conversation(),
+		OccurredAt:     event.OccurredAt(),
+		Data:           event,
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Enabled || !webhook.Matches(event.Type(), event.Conversation()) || !members[webhook.OwnerID] {
+			continue
+		}
+
+		if body == nil {
+			if body, err = json.Marshal(payload); err != nil {
+				return err
+			}
+		}
+		delivery := models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			ID:            helpers.DerivedTimeUUID(eventId, webhook.ID.String()),
+			EventID:       payload.ID,
+			EventType:     payload.Type,
+			Payload:       body,
+			Status:        models.WebhookDeliveryPending,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+			NextAttemptAt: now,
+		}
+		created, err := d.repo.SaveDelivery(ctx, delivery)
+		if err != nil {
+			return err
+		}
+		if created {
+			d.enqueue(webhook.ID, delivery)
+		}
+	}
+	return nil
+}
+
+// enqueue hands a delivery to the workers unless it is already being attempted.
+// A full queue is not an error, the delivery stays pending until the next sweep.
+func (d *Dispatcher) enqueue(webhookId gocql.UUID, delivery models.WebhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inFlight[delivery.ID] {
+		return
+	}
+	select {
+	case d.jobs <- job{webhookId: webhookId, delivery: delivery}:
+		d.inFlight[delivery.ID] = true
+	default:
+	}
+}
+
+func (d *Dispatcher) done(deliveryId gocql.UUID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inFlight, deliveryId)
+}
+
+// sweep queues the pending deliveries of enabled webhooks whose next attempt is due.
+func (d *Dispatcher) sweep(ctx context.Context) {
+	now := time.Now()
+	// whether each webhook seen during this sweep is enabled
+	enabled := make(map[gocql.UUID]bool)
+	for shard := 0; shard < models.WebhookRetryShards; shard++ {
+		if err := d.sweepShard(ctx, shard, now, enabled); err != nil {
+			slog.Error("Failed to sweep webhook retries", "shard", shard, "error", err)
+		}
+	}
+}
+
+// sweepShard pages through the due retries of a shard. Retries due at the same millisecond as the
+// last of a page may be passed over, they are still due on the next sweep.
+func (d *Dispatcher) sweepShard(ctx context.Context, shard int, now time.Time, enabled map[gocql.UUID]bool) error {
+	var after time.Time
+	for {
+		retries, err := d.repo.GetDueRetries(ctx, shard, after, now, retryPageSize)
+		if err != nil {
+			return err
+		}
+		for _, retry := range retries {
+			if err := d.retry(ctx, retry, enabled); err != nil {
+				slog.Error("Failed to queue webhook retry", "webhook_id", retry.WebhookID, "delivery_id", retry.ID, "error", err)
+			}
+		}
+		if len(retries) < retryPageSize {
+			return nil
+		}
+		after = retries[len(retries)-1].NextAttemptAt
+	}
+}
+
+// retry queues the delivery of a due retry. Retries of deleted webhooks and of deliveries that moved on
+// are dropped, the ones of disabled webhooks wait for the webhook to be enabled again.
+func (d *Dispatcher) retry(ctx context.Context, retry models.WebhookRetry, enabled map[gocql.UUID]bool) error {
+	isEnabled, seen := enabled[retry.WebhookID]
+	if !seen {
+		webhook, err := d.repo.GetWebhook(ctx, retry.WebhookID)
+		if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+			return err
+		}
+		if errors.Is(err, gocql.ErrNotFound) {
+			return d.repo.DeleteRetry(ctx, retry)
+		}
+		isEnabled = webhook.Enabled
+		enabled[retry.WebhookID] = isEnabled
+	}
+	if !isEnabled {
+		return nil
+	}
+
+	delivery, err := d.repo.GetDelivery(ctx, retry.WebhookID, retry.ID)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return d.repo.DeleteRetry(ctx, retry)
+	}
+	if err != nil {
+		return err
+	}
+	if delivery.Status != models.WebhookDeliveryPending || !delivery.NextAttemptAt.Equal(retry.NextAttemptAt) {
+		return d.repo.DeleteRetry(ctx, retry)
+	}
+	d.enqueue(retry.WebhookID, delivery)
+	return nil
+}
+
+// attempt posts one delivery and records the outcome on the delivery and the webhook.
+func (d *Dispatcher) attempt(ctx context.Context, j job) {
+	defer d.done(j.delivery.ID)
+
+	webhook, err := d.repo.GetWebhook(ctx, j.webhookId)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to load webhook", "webhook_id", j.webhookId, "error", err)
+		return
+	}
+	if !webhook.Enabled {
+		// stays pending, re-enabling the webhook resumes it
+		return
+	}
+
+	previous, claimed, err := d.repo.ClaimDelivery(ctx, j.delivery, time.Now().Add(d.claimFor))
+	if err != nil {
+		slog.Error("Failed to claim webhook delivery", "webhook_id", webhook.ID, "delivery_id", j.delivery.ID, "error", err)
+		return
+	}
+	if !claimed {
+		// attempted by another instance, or by this one since it was queued
+		return
+	}
+	delivery := previous
+	status, err := d.post(ctx, webhook, delivery)
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.UpdatedAt = now
+
+	healthChanged := false
+	if err == nil {
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.LastError = ""
+		if webhook.ConsecutiveFailures > 0 {
+			webhook.ConsecutiveFailures = 0
+			healthChanged = true
+		}
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.maxAttempts {
+			delivery.Status = models.WebhookDeliveryDeadLetter
+		} else {
+			delivery.NextAttemptAt = now.Add(helpers.Backoff(delivery.Attempts, baseBackoff, maxBackoff))
+		}
+		webhook.ConsecutiveFailures++
+		healthChanged = true
+		if d.disableAfter > 0 && webhook.ConsecutiveFailures >= d.disableAfter {
+			webhook.Enabled = false
+			webhook.DisabledReason = fmt.Sprintf("disabled after %d consecutive failed deliveries, last error: %s", webhook.ConsecutiveFailures, err)
+			slog.Warn("Disabling failing webhook", "webhook_id", webhook.ID, "failures", webhook.ConsecutiveFailures)
+		}
+	}
+
+	if err := d.repo.UpdateDelivery(ctx, previous, delivery); err != nil {
+		slog.Error("Failed to record webhook delivery", "webhook_id", webhook.ID, "delivery_id", delivery.ID, "error", err)
+	}
+	if healthChanged {
+		webhook.UpdatedAt = now
+		if err := d.repo.UpdateWebhookHealth(ctx, webhook); err != nil {
+			slog.Error("Failed to record webhook health", "webhook_id", webhook.ID, "error", err)
+		}
+	}
+}
+
+// post sends the signed payload, any status outside 2xx counts as a failure.
+func (d *Dispatcher) post(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "messaging-service-webhooks")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID.String())
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		if body = bytes.TrimSpace(body); len(body) > 0 {
+			return resp.StatusCode, fmt.Errorf("endpoint responded %s: %s", resp.Status, body)
+		}
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	// drain so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	return resp.StatusCode, nil
+}